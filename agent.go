@@ -0,0 +1,305 @@
+// agent.go
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Agent forwarding limits
+const (
+	agentFlushInterval = 5 * time.Second
+	agentBatchSize     = 500   // results per upload
+	agentBufferMax     = 50000 // results kept while the aggregator is unreachable
+	agentMaxBackoff    = time.Minute
+	agentUploadTimeout = 30 * time.Second
+)
+
+// agentResult is a check result as uploaded by an agent, stamped with the time
+// the check ran rather than the time it was delivered
+type agentResult struct {
+	Service      string      `json:"service"`
+	Time         time.Time   `json:"time"`
+	Healthy      bool        `json:"healthy"`
+	ResponseTime int64       `json:"response_time_ms"`
+	Error        string      `json:"error,omitempty"`
+	ErrorInfo    *CheckError `json:"error_info,omitempty"`
+	Warnings     []string    `json:"warnings,omitempty"`
+	Ping         *PingStats  `json:"ping,omitempty"`
+}
+
+// agentBatch is the body of an upload to the aggregator. Services describes
+// every service the results refer to, so the aggregator can register them.
+type agentBatch struct {
+	Services []Service     `json:"services"`
+	Results  []agentResult `json:"results"`
+}
+
+// Forwarder buffers an agent's check results and uploads them to the aggregator.
+// The buffer is written to disk so results survive both connectivity loss and
+// agent restarts, and is replayed oldest first once the aggregator is reachable.
+type Forwarder struct {
+	url     string // aggregator base URL
+	token   string
+	path    string // buffer file, or "" to keep results in memory only
+	checker *HealthChecker
+	targets TargetPolicy // hosts assigned services may probe
+
+	protocol string       // http, or grpc to upload over a stream
+	stream   *agentStream // the open gRPC upload stream, if any
+
+	// Assigned services checking the same target as a local one, already reported
+	duplicates map[string]bool
+
+	pending []agentResult
+	dropped int64 // results discarded because the buffer was full
+	online  bool
+	dirty   bool // pending changed since the last save
+	wake    chan struct{}
+	mu      sync.Mutex
+}
+
+// NewForwarder creates a forwarder and loads results buffered by a previous run
+func NewForwarder(aggregator, token, path string, checker *HealthChecker) (*Forwarder, error) {
+	f := &Forwarder{
+		url:     strings.TrimSuffix(aggregator, "/"),
+		token:   token,
+		path:    path,
+		checker: checker,
+		online:  true,
+		wake:    make(chan struct{}, 1),
+	}
+	if path == "" {
+		return f, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &f.pending); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(f.pending) > 0 {
+		slog.Info("agent loaded buffered results", "results", len(f.pending), "path", path)
+	}
+	return f, nil
+}
+
+// Add buffers a result for upload. It is set as the checker's onResult hook.
+func (f *Forwarder) Add(svc Service, result CheckResult, at time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.pending = append(f.pending, agentResult{
+		Service:      svc.Name,
+		Time:         at,
+		Healthy:      result.Healthy,
+		ResponseTime: result.ResponseTime,
+		Error:        result.Error,
+		ErrorInfo:    result.ErrorInfo,
+		Warnings:     result.Warnings,
+		Ping:         result.Ping,
+	})
+	if over := len(f.pending) - agentBufferMax; over > 0 {
+		if f.dropped == 0 {
+			slog.Warn("agent buffer full, dropping the oldest results", "limit", agentBufferMax)
+		}
+		f.pending = append(f.pending[:0:0], f.pending[over:]...)
+		f.dropped += int64(over)
+	}
+	f.dirty = true
+	if len(f.pending) >= agentBatchSize {
+		select {
+		case f.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Run uploads buffered results until the process exits. While the aggregator is
+// unreachable it backs off, keeping results on disk; when it is busy it waits as
+// long as the aggregator asks. Flushes are jittered so many agents started
+// together don't upload in lockstep.
+func (f *Forwarder) Run() {
+	backoff := agentFlushInterval
+	for {
+		retryAfter, err := f.flush()
+		f.save()
+
+		wait := jitter(agentFlushInterval)
+		switch {
+		case retryAfter > 0:
+			wait = jitter(retryAfter)
+		case err != nil:
+			wait = jitter(backoff)
+			backoff = min(2*backoff, agentMaxBackoff)
+		default:
+			backoff = agentFlushInterval
+		}
+		select {
+		case <-f.wake:
+		case <-time.After(wait):
+		}
+	}
+}
+
+// jitter spreads d by up to 20% either way
+func jitter(d time.Duration) time.Duration {
+	return d + time.Duration((rand.Float64()*0.4-0.2)*float64(d))
+}
+
+// flush uploads batches until the buffer is empty or an upload fails. When the
+// aggregator asks the agent to back off, flush returns how long to wait.
+func (f *Forwarder) flush() (time.Duration, error) {
+	for {
+		f.mu.Lock()
+		batch := f.pending[:min(len(f.pending), agentBatchSize)]
+		dropped := f.dropped
+		f.mu.Unlock()
+		if len(batch) == 0 {
+			return 0, nil
+		}
+
+		retryAfter, err := f.upload(batch)
+		f.mu.Lock()
+		if retryAfter > 0 {
+			slog.Info("aggregator busy", "retry_in", retryAfter.String(), "buffered", len(f.pending))
+			f.mu.Unlock()
+			return retryAfter, err
+		}
+		if err != nil {
+			if f.online {
+				slog.Warn("aggregator unreachable, buffering results", "error", err)
+			}
+			f.online = false
+			f.mu.Unlock()
+			return 0, err
+		}
+		if !f.online {
+			slog.Info("aggregator reachable again, replaying buffered results", "buffered", len(f.pending))
+		}
+		f.online = true
+		// Results dropped from the front during the upload were part of the batch
+		sent := max(0, len(batch)-int(f.dropped-dropped))
+		f.pending = append(f.pending[:0:0], f.pending[sent:]...)
+		f.dirty = true
+		f.mu.Unlock()
+	}
+}
+
+// upload sends one gzip-compressed batch along with the definitions of the
+// services it covers, as an HTTP request or on the gRPC stream. A throttled
+// upload returns the wait the aggregator asked for.
+func (f *Forwarder) upload(results []agentResult) (time.Duration, error) {
+	batch := agentBatch{Results: results}
+	seen := make(map[string]bool)
+	for _, r := range results {
+		if seen[r.Service] {
+			continue
+		}
+		seen[r.Service] = true
+		if svc, ok := f.checker.GetService(r.Service); ok {
+			batch.Services = append(batch.Services, redactor.RedactService(svc))
+		}
+	}
+	sort.Slice(batch.Services, func(i, j int) bool { return batch.Services[i].Name < batch.Services[j].Name })
+	if f.protocol == "grpc" {
+		return f.uploadStream(batch)
+	}
+
+	var body bytes.Buffer
+	zw := gzip.NewWriter(&body)
+	if err := json.NewEncoder(zw).Encode(batch); err != nil {
+		return 0, err
+	}
+	if err := zw.Close(); err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), agentUploadTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.url+"/api/v1/agents/results", &body)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("Authorization", "Bearer "+f.token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return 0, nil
+	}
+
+	var e struct {
+		Error string `json:"error"`
+	}
+	json.NewDecoder(resp.Body).Decode(&e)
+	err = fmt.Errorf("aggregator returned %d: %s", resp.StatusCode, e.Error)
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		seconds, convErr := strconv.Atoi(resp.Header.Get("Retry-After"))
+		if convErr != nil || seconds <= 0 {
+			seconds = int(agentFlushInterval / time.Second)
+		}
+		return min(time.Duration(seconds)*time.Second, agentMaxBackoff), err
+	}
+	return 0, err
+}
+
+// save writes the buffer to disk if it changed
+func (f *Forwarder) save() {
+	f.mu.Lock()
+	if f.path == "" || !f.dirty {
+		f.mu.Unlock()
+		return
+	}
+	data, err := json.Marshal(f.pending)
+	f.dirty = false
+	f.mu.Unlock()
+
+	if err == nil {
+		err = writeFileAtomic(f.path, data)
+	}
+	if err != nil {
+		slog.Warn("saving agent buffer", "path", f.path, "error", err)
+	}
+}
+
+// WriteMetrics writes agent buffer metrics
+func (f *Forwarder) WriteMetrics(m *metricWriter) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	online := 0
+	if f.online {
+		online = 1
+	}
+	m.family("agent_aggregator_up", "gauge", "Whether the last upload to the aggregator succeeded (1) or not (0)")
+	m.sample("agent_aggregator_up", float64(online))
+
+	m.family("agent_buffered_results", "gauge", "Check results waiting to be uploaded")
+	m.sample("agent_buffered_results", float64(len(f.pending)))
+
+	m.family("agent_dropped_results_total", "counter", "Check results discarded because the buffer was full")
+	m.sample("agent_dropped_results_total", float64(f.dropped))
+}
@@ -0,0 +1,569 @@
+// agentstream.go
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Agents started with -agent-protocol grpc upload over a single long-lived
+// gRPC stream instead of one HTTP request per batch. The messages are encoded
+// by hand, as grpc.go does for the health protocol:
+//
+//	service AgentIngest {
+//	  rpc Upload(stream UploadBatch) returns (stream UploadReply);
+//	}
+//	message UploadBatch {
+//	  repeated bytes services = 1; // Service definitions, JSON encoded as in /api/v1/agents/results
+//	  repeated Result results = 2;
+//	}
+//	message Result {
+//	  string service = 1;
+//	  int64 time_unix_nano = 2;
+//	  bool healthy = 3;
+//	  int64 response_time_ms = 4;
+//	  string error = 5;
+//	  repeated string warnings = 6;
+//	  Ping ping = 7;
+//	  ErrorInfo error_info = 8;
+//	}
+//	message Ping {
+//	  int64 sent = 1; int64 received = 2;
+//	  double loss_percent = 3; double rtt_min_ms = 4; double rtt_avg_ms = 5; double rtt_max_ms = 6;
+//	}
+//	message ErrorInfo { string category = 1; string code = 2; string message = 3; bool retryable = 4; }
+//	message UploadReply { int64 accepted = 1; int64 rejected = 2; int64 retry_after_seconds = 3; }
+//
+// Every batch is gzip compressed and answered by one reply. A batch that
+// arrives while the aggregator is busy is answered with retry_after_seconds
+// and nothing accepted, and the agent keeps it until then.
+const agentStreamPath = "/healthchecker.v1.AgentIngest/Upload"
+
+// agentProtocols are the ways an agent can upload its results
+var agentProtocols = []string{"http", "grpc"}
+
+// gRPC status codes the aggregator answers with
+const (
+	grpcOK                = 0
+	grpcInvalidArgument   = 3
+	grpcResourceExhausted = 8
+	grpcUnimplemented     = 12
+	grpcUnauthenticated   = 16
+)
+
+// errUploadTooLarge is an upload beyond agentBodyMax once decompressed
+var errUploadTooLarge = errors.New("upload too large")
+
+// uploadReply answers one batch of a stream
+type uploadReply struct {
+	accepted   int
+	rejected   int
+	retryAfter int // seconds, when the batch was refused because the aggregator is busy
+}
+
+// Protobuf wire types
+const (
+	protoVarint  = 0
+	protoFixed64 = 1
+	protoBytes   = 2
+	protoFixed32 = 5
+)
+
+func appendProtoTag(b []byte, field, wire int) []byte {
+	return binary.AppendUvarint(b, uint64(field)<<3|uint64(wire))
+}
+
+// appendProtoVarint appends an integer or bool field, leaving out zero as proto3 does
+func appendProtoVarint(b []byte, field int, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	return binary.AppendUvarint(appendProtoTag(b, field, protoVarint), v)
+}
+
+func appendProtoBool(b []byte, field int, v bool) []byte {
+	if !v {
+		return b
+	}
+	return appendProtoVarint(b, field, 1)
+}
+
+func appendProtoDouble(b []byte, field int, v float64) []byte {
+	if v == 0 {
+		return b
+	}
+	return binary.LittleEndian.AppendUint64(appendProtoTag(b, field, protoFixed64), math.Float64bits(v))
+}
+
+// appendProtoBytes appends a string, bytes or embedded message field. Repeated
+// fields keep empty values, so they're appended whatever their length.
+func appendProtoBytes(b []byte, field int, v []byte) []byte {
+	b = binary.AppendUvarint(appendProtoTag(b, field, protoBytes), uint64(len(v)))
+	return append(b, v...)
+}
+
+func appendProtoString(b []byte, field int, v string) []byte {
+	if v == "" {
+		return b
+	}
+	return appendProtoBytes(b, field, []byte(v))
+}
+
+// protoField is one field of a decoded message: v holds varint and fixed
+// values, data the contents of length-delimited ones
+type protoField struct {
+	num  int
+	v    uint64
+	data []byte
+}
+
+// forEachProtoField calls fn with every field of msg in order
+func forEachProtoField(msg []byte, fn func(protoField) error) error {
+	for len(msg) > 0 {
+		tag, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return errors.New("malformed protobuf message")
+		}
+		msg = msg[n:]
+		f := protoField{num: int(tag >> 3)}
+		switch tag & 7 {
+		case protoVarint:
+			f.v, n = binary.Uvarint(msg)
+			if n <= 0 {
+				return errors.New("malformed protobuf message")
+			}
+			msg = msg[n:]
+		case protoFixed64:
+			if len(msg) < 8 {
+				return errors.New("malformed protobuf message")
+			}
+			f.v, msg = binary.LittleEndian.Uint64(msg), msg[8:]
+		case protoFixed32:
+			if len(msg) < 4 {
+				return errors.New("malformed protobuf message")
+			}
+			f.v, msg = uint64(binary.LittleEndian.Uint32(msg)), msg[4:]
+		case protoBytes:
+			l, n := binary.Uvarint(msg)
+			if n <= 0 || uint64(len(msg)-n) < l {
+				return errors.New("malformed protobuf message")
+			}
+			f.data, msg = msg[n:n+int(l)], msg[n+int(l):]
+		default:
+			return fmt.Errorf("unexpected wire type %d in protobuf message", tag&7)
+		}
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeUploadBatch encodes a batch as an UploadBatch message
+func encodeUploadBatch(batch agentBatch) ([]byte, error) {
+	var b []byte
+	for _, svc := range batch.Services {
+		data, err := json.Marshal(svc)
+		if err != nil {
+			return nil, err
+		}
+		b = appendProtoBytes(b, 1, data)
+	}
+	for _, r := range batch.Results {
+		var m []byte
+		m = appendProtoString(m, 1, r.Service)
+		m = appendProtoVarint(m, 2, uint64(r.Time.UnixNano()))
+		m = appendProtoBool(m, 3, r.Healthy)
+		m = appendProtoVarint(m, 4, uint64(r.ResponseTime))
+		m = appendProtoString(m, 5, r.Error)
+		for _, warning := range r.Warnings {
+			m = appendProtoBytes(m, 6, []byte(warning))
+		}
+		if p := r.Ping; p != nil {
+			var ping []byte
+			ping = appendProtoVarint(ping, 1, uint64(p.Sent))
+			ping = appendProtoVarint(ping, 2, uint64(p.Received))
+			ping = appendProtoDouble(ping, 3, p.LossPercent)
+			ping = appendProtoDouble(ping, 4, p.RTTMinMs)
+			ping = appendProtoDouble(ping, 5, p.RTTAvgMs)
+			ping = appendProtoDouble(ping, 6, p.RTTMaxMs)
+			m = appendProtoBytes(m, 7, ping)
+		}
+		if e := r.ErrorInfo; e != nil {
+			var info []byte
+			info = appendProtoString(info, 1, e.Category)
+			info = appendProtoString(info, 2, e.Code)
+			info = appendProtoString(info, 3, e.Message)
+			info = appendProtoBool(info, 4, e.Retryable)
+			m = appendProtoBytes(m, 8, info)
+		}
+		b = appendProtoBytes(b, 2, m)
+	}
+	return b, nil
+}
+
+// decodeUploadBatch decodes an UploadBatch message
+func decodeUploadBatch(msg []byte) (agentBatch, error) {
+	var batch agentBatch
+	err := forEachProtoField(msg, func(f protoField) error {
+		switch f.num {
+		case 1:
+			var svc Service
+			if err := json.Unmarshal(f.data, &svc); err != nil {
+				return fmt.Errorf("service: %w", err)
+			}
+			batch.Services = append(batch.Services, svc)
+		case 2:
+			r, err := decodeAgentResult(f.data)
+			if err != nil {
+				return err
+			}
+			batch.Results = append(batch.Results, r)
+		}
+		return nil
+	})
+	return batch, err
+}
+
+// decodeAgentResult decodes a Result message
+func decodeAgentResult(msg []byte) (agentResult, error) {
+	var r agentResult
+	err := forEachProtoField(msg, func(f protoField) error {
+		switch f.num {
+		case 1:
+			r.Service = string(f.data)
+		case 2:
+			r.Time = time.Unix(0, int64(f.v))
+		case 3:
+			r.Healthy = f.v != 0
+		case 4:
+			r.ResponseTime = int64(f.v)
+		case 5:
+			r.Error = string(f.data)
+		case 6:
+			r.Warnings = append(r.Warnings, string(f.data))
+		case 7:
+			r.Ping = &PingStats{}
+			return forEachProtoField(f.data, func(f protoField) error {
+				switch f.num {
+				case 1:
+					r.Ping.Sent = int(f.v)
+				case 2:
+					r.Ping.Received = int(f.v)
+				case 3:
+					r.Ping.LossPercent = math.Float64frombits(f.v)
+				case 4:
+					r.Ping.RTTMinMs = math.Float64frombits(f.v)
+				case 5:
+					r.Ping.RTTAvgMs = math.Float64frombits(f.v)
+				case 6:
+					r.Ping.RTTMaxMs = math.Float64frombits(f.v)
+				}
+				return nil
+			})
+		case 8:
+			r.ErrorInfo = &CheckError{}
+			return forEachProtoField(f.data, func(f protoField) error {
+				switch f.num {
+				case 1:
+					r.ErrorInfo.Category = string(f.data)
+				case 2:
+					r.ErrorInfo.Code = string(f.data)
+				case 3:
+					r.ErrorInfo.Message = string(f.data)
+				case 4:
+					r.ErrorInfo.Retryable = f.v != 0
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	return r, err
+}
+
+func encodeUploadReply(reply uploadReply) []byte {
+	var b []byte
+	b = appendProtoVarint(b, 1, uint64(reply.accepted))
+	b = appendProtoVarint(b, 2, uint64(reply.rejected))
+	return appendProtoVarint(b, 3, uint64(reply.retryAfter))
+}
+
+func decodeUploadReply(msg []byte) (uploadReply, error) {
+	var reply uploadReply
+	err := forEachProtoField(msg, func(f protoField) error {
+		switch f.num {
+		case 1:
+			reply.accepted = int(f.v)
+		case 2:
+			reply.rejected = int(f.v)
+		case 3:
+			reply.retryAfter = int(f.v)
+		}
+		return nil
+	})
+	return reply, err
+}
+
+// grpcFrame prefixes a message with the gRPC compressed flag and its length,
+// gzip compressing it when compress is set
+func grpcFrame(msg []byte, compress bool) ([]byte, error) {
+	flag := byte(0)
+	if compress {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(msg); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		msg, flag = buf.Bytes(), 1
+	}
+	frame := make([]byte, 5, 5+len(msg))
+	frame[0] = flag
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	return append(frame, msg...), nil
+}
+
+// readGRPCMessage reads the next length-prefixed message from a stream,
+// decompressing it if it's flagged as gzip compressed. It returns io.EOF when
+// the stream ends between messages, and errUploadTooLarge when the message
+// is larger than limit, compressed or not.
+func readGRPCMessage(r io.Reader, limit int) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(prefix[1:])
+	if uint64(size) > uint64(limit) {
+		return nil, errUploadTooLarge
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	switch prefix[0] {
+	case 0:
+		return msg, nil
+	case 1:
+		zr, err := gzip.NewReader(bytes.NewReader(msg))
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(io.LimitReader(zr, int64(limit)+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(data) > limit {
+			return nil, errUploadTooLarge
+		}
+		return data, nil
+	}
+	return nil, fmt.Errorf("unknown gRPC message flag %d", prefix[0])
+}
+
+// writeGRPCStatus sets the status a gRPC call ends with. Before anything is
+// written it makes a trailers-only response; after, the status goes in the trailers.
+func writeGRPCStatus(w http.ResponseWriter, code int, message string, started bool) {
+	prefix := ""
+	if started {
+		prefix = http.TrailerPrefix
+	}
+	w.Header().Set(prefix+"Grpc-Status", strconv.Itoa(code))
+	if message != "" {
+		w.Header().Set(prefix+"Grpc-Message", url.PathEscape(message))
+	}
+	if !started {
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// StreamHandler accepts batches of results from an agent over a gRPC stream.
+// Each batch takes an ingest slot while it's applied, as an HTTP upload does,
+// and is answered before the next is read, so a busy aggregator slows its
+// agents down through HTTP/2 flow control as well as retry_after_seconds.
+func (a *Aggregator) StreamHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Grpc-Accept-Encoding", "gzip")
+	if r.ProtoMajor != 2 {
+		writeJSON(w, http.StatusHTTPVersionNotSupported, map[string]string{"error": "gRPC needs HTTP/2"})
+		return
+	}
+	agent, ok := a.authenticate(r)
+	if !ok {
+		writeGRPCStatus(w, grpcUnauthenticated, "agent token required", false)
+		return
+	}
+	switch r.Header.Get("Grpc-Encoding") {
+	case "", "identity", "gzip":
+	default:
+		writeGRPCStatus(w, grpcUnimplemented, "unsupported grpc-encoding", false)
+		return
+	}
+
+	// HTTP/2 lets the replies be written while the request body is still read
+	rc := http.NewResponseController(w)
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	code, message := grpcOK, ""
+	for {
+		msg, err := readGRPCMessage(r.Body, agentBodyMax)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			code, message = grpcInvalidArgument, err.Error()
+			if errors.Is(err, errUploadTooLarge) {
+				code = grpcResourceExhausted
+			}
+			break
+		}
+		batch, err := decodeUploadBatch(msg)
+		if err != nil {
+			code, message = grpcInvalidArgument, err.Error()
+			break
+		}
+
+		var reply uploadReply
+		if a.admit() {
+			reply.accepted = a.ingestBatch(agent, batch)
+			reply.rejected = len(batch.Results) - reply.accepted
+			<-a.ingest
+		} else {
+			reply.retryAfter = agentThrottleRetryAfter
+		}
+		frame, _ := grpcFrame(encodeUploadReply(reply), false)
+		if _, err := w.Write(frame); err != nil {
+			return
+		}
+		rc.Flush()
+	}
+	writeGRPCStatus(w, code, message, true)
+}
+
+// agentStream is an agent's open Upload call: batches go out on the request
+// body and their replies come back on the response body
+type agentStream struct {
+	body   *io.PipeWriter
+	resp   *http.Response
+	cancel context.CancelFunc
+}
+
+// openStream starts an Upload call to the aggregator, over h2c for an http://
+// aggregator URL and TLS for https://
+func (f *Forwarder) openStream() (*agentStream, error) {
+	u, err := url.Parse(f.url)
+	if err != nil {
+		return nil, err
+	}
+	client := grpcPlaintextClient
+	if u.Scheme == "https" {
+		client = grpcTLSClient
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pr, pw := io.Pipe()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.url+agentStreamPath, pr)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	req.Header.Set("Grpc-Encoding", "gzip")
+	req.Header.Set("Authorization", "Bearer "+f.token)
+
+	timer := time.AfterFunc(agentUploadTimeout, cancel)
+	resp, err := client.Do(req)
+	timer.Stop()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	s := &agentStream{body: pw, resp: resp, cancel: cancel}
+	if resp.StatusCode != http.StatusOK {
+		s.close()
+		return nil, fmt.Errorf("aggregator returned %d", resp.StatusCode)
+	}
+	if code := resp.Header.Get("Grpc-Status"); code != "" {
+		// A trailers-only response: the call was refused
+		s.close()
+		return nil, grpcStatusError(code, resp.Header.Get("Grpc-Message"))
+	}
+	return s, nil
+}
+
+// send uploads one encoded UploadBatch and waits for its reply
+func (s *agentStream) send(msg []byte) (uploadReply, error) {
+	timer := time.AfterFunc(agentUploadTimeout, s.cancel)
+	defer timer.Stop()
+
+	frame, err := grpcFrame(msg, true)
+	if err != nil {
+		return uploadReply{}, err
+	}
+	if _, err := s.body.Write(frame); err != nil {
+		return uploadReply{}, err
+	}
+	reply, err := readGRPCMessage(s.resp.Body, 64<<10)
+	if err == io.EOF {
+		// The aggregator ended the call, and says why in the trailers
+		return uploadReply{}, grpcStatusError(s.resp.Trailer.Get("Grpc-Status"), s.resp.Trailer.Get("Grpc-Message"))
+	}
+	if err != nil {
+		return uploadReply{}, err
+	}
+	return decodeUploadReply(reply)
+}
+
+func (s *agentStream) close() {
+	s.body.Close()
+	s.cancel()
+	s.resp.Body.Close()
+}
+
+// grpcStatusError describes the status a call ended with
+func grpcStatusError(code, message string) error {
+	if message, err := url.PathUnescape(message); err == nil && message != "" {
+		return fmt.Errorf("aggregator ended the upload stream with gRPC status %s: %s", code, message)
+	}
+	return fmt.Errorf("aggregator ended the upload stream with gRPC status %s", code)
+}
+
+// uploadStream sends one batch on the gRPC stream, opening it first if needed.
+// The stream is closed on any error and opened again by the next upload. Only
+// Run uploads, so the stream needs no lock.
+func (f *Forwarder) uploadStream(batch agentBatch) (time.Duration, error) {
+	msg, err := encodeUploadBatch(batch)
+	if err != nil {
+		return 0, err
+	}
+	if f.stream == nil {
+		if f.stream, err = f.openStream(); err != nil {
+			return 0, err
+		}
+	}
+	reply, err := f.stream.send(msg)
+	if err != nil {
+		f.stream.close()
+		f.stream = nil
+		return 0, err
+	}
+	if reply.retryAfter > 0 {
+		return min(time.Duration(reply.retryAfter)*time.Second, agentMaxBackoff), errors.New("aggregator busy")
+	}
+	return 0, nil
+}
@@ -0,0 +1,88 @@
+// agentstream_test.go
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestUploadBatchRoundTrip(t *testing.T) {
+	batch := agentBatch{
+		Services: []Service{{Name: "api", URL: "https://api.example.com/health", Labels: map[string]string{"team": "core"}}},
+		Results: []agentResult{
+			{Service: "api", Time: time.Unix(1791961103, 123456789), Healthy: true, ResponseTime: 42, Warnings: []string{"", "slow"}},
+			{Service: "api", Time: time.Unix(1791961108, 0), Error: "HTTP 503",
+				ErrorInfo: &CheckError{Category: ErrCategoryHTTPStatus, Code: "http_503", Message: "HTTP 503", Retryable: true},
+				Ping:      &PingStats{Sent: 3, Received: 2, LossPercent: 33.3, RTTMinMs: 1.5, RTTAvgMs: 2.25, RTTMaxMs: 3}},
+		},
+	}
+	msg, err := encodeUploadBatch(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := decodeUploadBatch(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Services) != 1 || got.Services[0].Name != "api" || got.Services[0].Labels["team"] != "core" {
+		t.Errorf("services = %+v", got.Services)
+	}
+	for i := range batch.Results {
+		want, have := batch.Results[i], got.Results[i]
+		if !want.Time.Equal(have.Time) {
+			t.Errorf("result %d: time = %v, want %v", i, have.Time, want.Time)
+		}
+		want.Time, have.Time = time.Time{}, time.Time{}
+		if !reflect.DeepEqual(have, want) {
+			t.Errorf("result %d = %+v, want %+v", i, have, want)
+		}
+	}
+}
+
+func TestUploadReplyRoundTrip(t *testing.T) {
+	for _, reply := range []uploadReply{{}, {accepted: 500}, {accepted: 3, rejected: 2}, {retryAfter: 5}} {
+		got, err := decodeUploadReply(encodeUploadReply(reply))
+		if err != nil || got != reply {
+			t.Errorf("decodeUploadReply(encodeUploadReply(%+v)) = %+v, %v", reply, got, err)
+		}
+	}
+}
+
+func TestReadGRPCMessage(t *testing.T) {
+	plain, _ := grpcFrame([]byte("hello"), false)
+	compressed, _ := grpcFrame(bytes.Repeat([]byte("a"), 100), true)
+	stream := bytes.NewReader(append(plain, compressed...))
+
+	if msg, err := readGRPCMessage(stream, 100); err != nil || string(msg) != "hello" {
+		t.Errorf("plain message = %q, %v", msg, err)
+	}
+	if msg, err := readGRPCMessage(stream, 100); err != nil || len(msg) != 100 {
+		t.Errorf("compressed message = %d bytes, %v", len(msg), err)
+	}
+	if _, err := readGRPCMessage(stream, 100); err != io.EOF {
+		t.Errorf("after the last message: %v, want io.EOF", err)
+	}
+
+	// The limit applies to the decompressed size too
+	if _, err := readGRPCMessage(bytes.NewReader(compressed), 99); !errors.Is(err, errUploadTooLarge) {
+		t.Errorf("compressed message over the limit: %v, want errUploadTooLarge", err)
+	}
+	if _, err := readGRPCMessage(bytes.NewReader(plain), 4); !errors.Is(err, errUploadTooLarge) {
+		t.Errorf("message over the limit: %v, want errUploadTooLarge", err)
+	}
+	if _, err := readGRPCMessage(bytes.NewReader(plain[:7]), 100); err != io.ErrUnexpectedEOF {
+		t.Errorf("truncated message: %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestDecodeUploadBatchMalformed(t *testing.T) {
+	for _, msg := range [][]byte{{0x12}, {0x12, 0x05, 0x0a}, {0x13}} {
+		if _, err := decodeUploadBatch(msg); err == nil {
+			t.Errorf("decodeUploadBatch(%x) succeeded", msg)
+		}
+	}
+}
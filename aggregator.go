@@ -0,0 +1,295 @@
+// aggregator.go
+package main
+
+import (
+	"compress/gzip"
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Aggregator ingest limits
+const (
+	agentBodyMax            = 8 << 20 // decompressed size of an upload
+	agentMaxConcurrent      = 8       // uploads processed at once; others are asked to retry
+	agentThrottleRetryAfter = 5       // seconds throttled agents are asked to wait
+)
+
+// AgentConfig authorizes a remote probe agent to upload results
+type AgentConfig struct {
+	Name   string            `json:"name"`
+	Token  string            `json:"token"`
+	Labels map[string]string `json:"labels,omitempty"` // added to every service the agent checks, e.g. region
+}
+
+// validateAgent checks a single agent definition; prefix is prepended to field names
+func validateAgent(a AgentConfig, prefix string) []ValidationError {
+	var errs []ValidationError
+	if strings.TrimSpace(a.Name) == "" {
+		errs = append(errs, ValidationError{Field: prefix + "name", Message: "name is required"})
+	} else if strings.ContainsAny(a.Name, "@/?#") {
+		errs = append(errs, ValidationError{Field: prefix + "name", Message: "must not contain '@', '/', '?' or '#'"})
+	}
+	if len(a.Token) < 16 {
+		errs = append(errs, ValidationError{Field: prefix + "token", Message: "must be at least 16 characters"})
+	}
+	return errs
+}
+
+// remoteServiceName is the name an agent's service is monitored under centrally
+func remoteServiceName(service, agent string) string {
+	return service + "@" + agent
+}
+
+// Aggregator receives check results uploaded by remote agents and feeds them into
+// the checker as services of type agent
+type Aggregator struct {
+	checker     *HealthChecker
+	agents      []AgentConfig
+	assignments []Assignment
+	ingest      chan struct{} // one slot per upload being processed
+	lastSeen    map[string]time.Time
+	received    map[string]int64 // results accepted per agent
+	throttled   int64
+	mu          sync.Mutex
+}
+
+// NewAggregator creates an aggregator accepting uploads from the configured agents
+// and serving them their assignments
+func NewAggregator(checker *HealthChecker, agents []AgentConfig, assignments []Assignment) *Aggregator {
+	return &Aggregator{
+		checker:     checker,
+		agents:      agents,
+		assignments: assignments,
+		ingest:      make(chan struct{}, agentMaxConcurrent),
+		lastSeen:    make(map[string]time.Time),
+		received:    make(map[string]int64),
+	}
+}
+
+// authenticate returns the agent whose token the request carries
+func (a *Aggregator) authenticate(r *http.Request) (AgentConfig, bool) {
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return AgentConfig{}, false
+	}
+	for _, agent := range a.agents {
+		if subtle.ConstantTimeCompare([]byte(got), []byte(agent.Token)) == 1 {
+			return agent, true
+		}
+	}
+	return AgentConfig{}, false
+}
+
+// remoteService turns a service definition from an agent into the one monitored
+// centrally: renamed after the agent, labelled with it and never checked locally
+func remoteService(agent AgentConfig, svc Service) Service {
+	labels := make(map[string]string, len(svc.Labels)+len(agent.Labels)+1)
+	for k, v := range svc.Labels {
+		labels[k] = v
+	}
+	for k, v := range agent.Labels {
+		labels[k] = v
+	}
+	labels["agent"] = agent.Name
+
+	svc.Name = remoteServiceName(svc.Name, agent.Name)
+	svc.Type = "agent"
+	svc.Labels = labels
+	svc.Paused = false
+	return svc
+}
+
+// register adds or updates the central copy of an agent's service. Services of
+// the same name that aren't fed by an agent are left alone.
+func (a *Aggregator) register(svc Service) bool {
+	existing, ok := a.checker.GetService(svc.Name)
+	switch {
+	case !ok:
+		return a.checker.AddService(svc) == nil
+	case existing.Type != "agent":
+		return false
+	case !reflect.DeepEqual(existing, svc):
+		return a.checker.UpdateService(svc) == nil
+	}
+	return true
+}
+
+// ResultsHandler accepts a batch of results from an agent, optionally gzip
+// compressed. Results are applied in the order they were checked, so a batch
+// replayed after an outage fills history with its original timestamps. When too
+// many uploads are in flight the agent is told to retry later and keeps the batch.
+func (a *Aggregator) ResultsHandler(w http.ResponseWriter, r *http.Request) {
+	agent, ok := a.authenticate(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="agent"`)
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "agent token required"})
+		return
+	}
+
+	if !a.admit() {
+		w.Header().Set("Retry-After", strconv.Itoa(agentThrottleRetryAfter))
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "aggregator busy"})
+		return
+	}
+	defer func() { <-a.ingest }()
+
+	var body io.Reader = r.Body
+	switch r.Header.Get("Content-Encoding") {
+	case "":
+	case "gzip":
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		defer zr.Close()
+		body = zr
+	default:
+		writeJSON(w, http.StatusUnsupportedMediaType, map[string]string{"error": "unsupported Content-Encoding"})
+		return
+	}
+
+	data, err := io.ReadAll(io.LimitReader(body, agentBodyMax+1))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if len(data) > agentBodyMax {
+		writeJSON(w, http.StatusRequestEntityTooLarge, map[string]string{"error": errUploadTooLarge.Error()})
+		return
+	}
+	var batch agentBatch
+	if err := json.Unmarshal(data, &batch); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	accepted := a.ingestBatch(agent, batch)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"agent":    agent.Name,
+		"accepted": accepted,
+		"rejected": len(batch.Results) - accepted,
+	})
+}
+
+// admit takes an ingest slot for an upload, counting it as throttled when
+// none is free. The slot is given back by receiving from a.ingest.
+func (a *Aggregator) admit() bool {
+	select {
+	case a.ingest <- struct{}{}:
+		return true
+	default:
+		a.mu.Lock()
+		a.throttled++
+		a.mu.Unlock()
+		return false
+	}
+}
+
+// ingestBatch registers the services of an agent's batch and applies its
+// results in the order they were checked. It returns how many results were accepted.
+func (a *Aggregator) ingestBatch(agent AgentConfig, batch agentBatch) int {
+	registered := make(map[string]bool)
+	for _, svc := range batch.Services {
+		remote := remoteService(agent, svc)
+		if parent, ok := a.checker.GetService(svc.Name); ok && parent.Type == "merged" {
+			// Counted once through the merged service instead
+			remote.Labels[mergedIntoLabel] = svc.Name
+			remote.Weight = 0
+			remote.SLO = 0
+		}
+		if errs := validateService(remote, ""); len(errs) > 0 {
+			slog.Warn("agent service rejected", "agent", agent.Name, "service", svc.Name, "error", errs[0])
+			continue
+		}
+		if a.register(remote) {
+			registered[svc.Name] = true
+		} else {
+			slog.Warn("agent service conflicts with another", "agent", agent.Name, "service", svc.Name, "conflicts_with", remote.Name)
+		}
+	}
+
+	sort.SliceStable(batch.Results, func(i, j int) bool { return batch.Results[i].Time.Before(batch.Results[j].Time) })
+	accepted := 0
+	for _, res := range batch.Results {
+		if !registered[res.Service] {
+			continue
+		}
+		a.checker.updateStatusAt(remoteServiceName(res.Service, agent.Name), CheckResult{
+			Healthy:      res.Healthy,
+			ResponseTime: res.ResponseTime,
+			Error:        res.Error,
+			ErrorInfo:    res.ErrorInfo,
+			Warnings:     res.Warnings,
+			Ping:         res.Ping,
+		}, res.Time)
+		accepted++
+	}
+
+	a.mu.Lock()
+	a.lastSeen[agent.Name] = time.Now()
+	a.received[agent.Name] += int64(accepted)
+	a.mu.Unlock()
+	return accepted
+}
+
+// ListHandler lists the configured agents and when each last uploaded
+func (a *Aggregator) ListHandler(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	type agentInfo struct {
+		Name     string            `json:"name"`
+		Labels   map[string]string `json:"labels,omitempty"`
+		LastSeen *time.Time        `json:"last_seen,omitempty"`
+	}
+	list := []agentInfo{}
+	for _, agent := range a.agents {
+		info := agentInfo{Name: agent.Name, Labels: agent.Labels}
+		if seen, ok := a.lastSeen[agent.Name]; ok {
+			info.LastSeen = &seen
+		}
+		list = append(list, info)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	writeJSON(w, http.StatusOK, list)
+}
+
+// WriteMetrics writes agent ingest metrics
+func (a *Aggregator) WriteMetrics(m *metricWriter) {
+	if len(a.agents) == 0 {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	names := make([]string, 0, len(a.agents))
+	for _, agent := range a.agents {
+		names = append(names, agent.Name)
+	}
+	sort.Strings(names)
+
+	m.family("aggregator_results_total", "counter", "Check results accepted from each agent")
+	for _, name := range names {
+		m.sample("aggregator_results_total", float64(a.received[name]), "agent", name)
+	}
+
+	m.family("aggregator_agent_last_seen_timestamp_seconds", "gauge", "When each agent last uploaded")
+	for _, name := range names {
+		if seen, ok := a.lastSeen[name]; ok {
+			m.sample("aggregator_agent_last_seen_timestamp_seconds", float64(seen.Unix()), "agent", name)
+		}
+	}
+
+	m.family("aggregator_uploads_throttled_total", "counter", "Agent uploads refused because too many were in flight")
+	m.sample("aggregator_uploads_throttled_total", float64(a.throttled))
+}
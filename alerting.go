@@ -0,0 +1,272 @@
+// alerting.go
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// Alert states
+const (
+	AlertDown      = "down"
+	AlertRecovered = "recovered"
+	AlertWarning   = "warning"
+)
+
+// Alert describes a service changing between healthy and unhealthy, or a
+// warning about it that needs a look without opening an incident
+type Alert struct {
+	Service      string            `json:"service"`
+	URL          string            `json:"url"`
+	State        string            `json:"state"`             // down, recovered or warning
+	Reason       string            `json:"reason,omitempty"`  // warning alerts only: the event type, e.g. cert_changed
+	Warning      string            `json:"warning,omitempty"` // warning alerts only: what happened
+	Error        string            `json:"error,omitempty"`
+	ErrorInfo    *CheckError       `json:"error_info,omitempty"`
+	DNSError     string            `json:"dns_error,omitempty"` // kind of DNS failure that opened the incident
+	ResponseTime int64             `json:"response_time_ms"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	Tags         []string          `json:"tags,omitempty"`
+	IncidentID   string            `json:"incident_id,omitempty"`
+	TraceID      string            `json:"trace_id,omitempty"`   // of the check behind the alert, to look up on the target
+	RequestID    string            `json:"request_id,omitempty"` // sent with that check, with propagation on
+	Reminder     int               `json:"reminder,omitempty"`   // number of this reminder of a service still down
+	StartedAt    time.Time         `json:"started_at"`           // when the service went down
+	At           time.Time         `json:"at"`
+}
+
+// Alerter is told about every state change of a service. Alert is called with
+// the checker's lock held, so it must not block or call back into the checker.
+type Alerter interface {
+	Alert(svc Service, alert Alert)
+}
+
+// AlertingConfig routes alerts to notifiers
+type AlertingConfig struct {
+	// Notifiers every alert is sent to, unless a service names its own
+	Notifiers []string `json:"notifiers,omitempty"`
+
+	// Resend down alerts this often while a service stays down; unset alerts
+	// once per incident
+	RenotifyInterval Duration `json:"renotify_interval,omitempty"`
+
+	// Notifiers for incidents opened by a kind of DNS failure, e.g. nxdomain,
+	// instead of the service's or the defaults
+	DNSRoutes map[string][]string `json:"dns_routes,omitempty"`
+
+	// Language of alerts from notifiers that don't set their own: en, de or ja; default en
+	Language string `json:"language,omitempty"`
+}
+
+// AddAlerter registers an alerter for state changes
+func (hc *HealthChecker) AddAlerter(a Alerter) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	hc.alerters = append(hc.alerters, a)
+}
+
+// alert passes a state change to the alerters. Down alerts are held back while
+// the service is silenced or flapping, and a recovery is only sent when its
+// down alert was. Warnings are dropped while the service is silenced. It
+// reports whether the alert was sent. Must be called with hc.mu held.
+func (hc *HealthChecker) alert(status *HealthStatus, alert Alert, downSent bool) bool {
+	if len(hc.alerters) == 0 {
+		return false
+	}
+	if alert.State == AlertRecovered && !downSent {
+		return false
+	}
+	if alert.State == AlertDown {
+		if status.Flapping {
+			slog.Info("down alert held back", "service", status.Name, "reason", "flapping")
+			return false
+		}
+		if silenced := hc.silencedUntil(status, alert.At); !silenced.IsZero() {
+			slog.Info("down alert held back", "service", status.Name, "reason", "silenced", "silenced_until", silenced.Format(time.RFC3339))
+			return false
+		}
+	}
+	if alert.State == AlertWarning {
+		if silenced := hc.silencedUntil(status, alert.At); !silenced.IsZero() {
+			slog.Info("warning alert dropped", "service", status.Name, "reason", "silenced", "warning", alert.Warning)
+			return false
+		}
+	}
+
+	svc := hc.services[status.Name]
+	for _, a := range hc.alerters {
+		a.Alert(svc, alert)
+	}
+	return true
+}
+
+// silencedUntil returns when the latest one-off or scheduled silence covering a
+// service at t ends, or the zero time when none does. Must be called with hc.mu held.
+func (hc *HealthChecker) silencedUntil(status *HealthStatus, t time.Time) time.Time {
+	silenced := hc.silences.Silenced(status.Name, status.Labels, status.Tags, t)
+	if scheduled := hc.scheduleSilenced(status.Name, status, t); scheduled.After(silenced) {
+		silenced = scheduled
+	}
+	return silenced
+}
+
+// warn sends a warning alert about a service, such as its certificate changing.
+// Warnings open no incident and aren't resolved, so they're sent once. Must be
+// called with hc.mu held.
+func (hc *HealthChecker) warn(status *HealthStatus, reason, message string, at time.Time) bool {
+	alert := Alert{
+		Service:      status.Name,
+		URL:          status.URL,
+		State:        AlertWarning,
+		Reason:       reason,
+		Warning:      message,
+		ResponseTime: status.ResponseTime,
+		Labels:       status.Labels,
+		Tags:         status.Tags,
+		StartedAt:    at,
+		At:           at,
+	}
+	if status.Incident != nil {
+		alert.IncidentID = status.Incident.ID
+	}
+	return hc.alert(status, alert, false)
+}
+
+// releaseHeld sends the down alert of an incident that opened while the
+// service was flapping or silenced, as soon as it no longer is, whatever the
+// renotify interval. Acknowledged incidents stay quiet. It returns the updated
+// incident when the alert was sent. Must be called with hc.mu held.
+func (hc *HealthChecker) releaseHeld(status *HealthStatus, now time.Time) (*Incident, bool) {
+	incident := status.Incident
+	// Checked here as well as in alert so a long silence doesn't log on every check
+	if incident.AckedBy != "" || status.Flapping || !hc.silencedUntil(status, now).IsZero() {
+		return nil, false
+	}
+	if !hc.alert(status, newAlert(status, AlertDown, incident, now), false) {
+		return nil, false
+	}
+	updated := *incident
+	updated.Alerted, updated.NotifiedAt = true, &now
+	return &updated, true
+}
+
+// remind resends the down alert of a service still down once its renotify
+// interval has passed since the last one. Acknowledged incidents get no
+// reminders. It returns the updated incident when an alert was sent. Must be
+// called with hc.mu held.
+func (hc *HealthChecker) remind(status *HealthStatus, now time.Time) (*Incident, bool) {
+	interval := time.Duration(hc.services[status.Name].RenotifyInterval)
+	if interval == 0 {
+		interval = hc.renotifyInterval
+	}
+	incident := status.Incident
+	if interval <= 0 || incident.AckedBy != "" {
+		return nil, false
+	}
+	last := incident.StartedAt
+	if incident.NotifiedAt != nil {
+		last = *incident.NotifiedAt
+	}
+	if now.Sub(last) < interval || status.Flapping || !hc.silencedUntil(status, now).IsZero() {
+		return nil, false
+	}
+
+	alert := newAlert(status, AlertDown, incident, now)
+	alert.Reminder = incident.Reminders + 1
+	if !hc.alert(status, alert, false) {
+		return nil, false
+	}
+	updated := *incident
+	updated.NotifiedAt, updated.Reminders = &now, alert.Reminder
+	return &updated, true
+}
+
+// newAlert fills in an alert from a service's status
+func newAlert(status *HealthStatus, state string, incident *Incident, at time.Time) Alert {
+	return Alert{
+		Service:      status.Name,
+		URL:          status.URL,
+		State:        state,
+		Error:        status.Error,
+		ErrorInfo:    status.ErrorInfo,
+		DNSError:     incident.DNSError,
+		ResponseTime: status.ResponseTime,
+		Labels:       status.Labels,
+		Tags:         status.Tags,
+		IncidentID:   incident.ID,
+		TraceID:      status.TraceID,
+		RequestID:    status.RequestID,
+		StartedAt:    incident.StartedAt,
+		At:           at,
+	}
+}
+
+// alertNotification formats an alert for notifiers in lang. Webhooks also get
+// the alert itself as structured JSON.
+func alertNotification(a Alert, lang string) Notification {
+	var text strings.Builder
+	line := func(format string, args ...interface{}) {
+		text.WriteString(translate(lang, format, args...) + "\n")
+	}
+	subject := fmt.Sprintf("[%s] %s", translate(lang, strings.ToUpper(a.State)), a.Service)
+	switch {
+	case a.Reminder > 0:
+		subject += translate(lang, " (still down)")
+		line("%s is still down after %s.", a.Service, a.At.Sub(a.StartedAt).Round(time.Second))
+		line("Error: %s", a.Error)
+	case a.State == AlertDown:
+		line("%s is down.", a.Service)
+		line("Error: %s", a.Error)
+	case a.State == AlertWarning:
+		line("%s: %s", a.Service, a.Warning)
+	default:
+		line("%s has recovered after %s.", a.Service, a.At.Sub(a.StartedAt).Round(time.Second))
+	}
+	if a.DNSError != "" {
+		line("DNS failure: %s", a.DNSError)
+	}
+	line("URL: %s", a.URL)
+	line("Response time: %dms", a.ResponseTime)
+	if a.IncidentID != "" { // warnings may come without one
+		line("Incident: %s", a.IncidentID)
+	}
+	return Notification{
+		Subject:  subject,
+		Text:     strings.TrimSuffix(text.String(), "\n"),
+		Alert:    &a,
+		Language: lang,
+	}
+}
+
+// outboxAlerter queues alerts for delivery through the service's notifiers, or
+// the default ones when the service names none. Incidents opened by a DNS
+// failure with a route of its own go to that route's notifiers instead.
+type outboxAlerter struct {
+	outbox    *Outbox
+	notifiers []string
+	dnsRoutes map[string][]string
+	languages map[string]string // notifier name to the language of its alerts
+}
+
+func (o *outboxAlerter) Alert(svc Service, alert Alert) {
+	notifiers := o.dnsRoutes[alert.DNSError]
+	if len(notifiers) == 0 {
+		notifiers = svc.Notifiers
+	}
+	if len(notifiers) == 0 {
+		notifiers = o.notifiers
+	}
+	if len(notifiers) == 0 {
+		return
+	}
+	for _, name := range notifiers {
+		o.outbox.Enqueue(name, alertNotification(alert, o.languages[name]))
+	}
+	state := alert.State
+	if alert.Reminder > 0 {
+		state = fmt.Sprintf("still down (reminder %d)", alert.Reminder)
+	}
+	slog.Warn("alert", "service", alert.Service, "state", state, "notifiers", strings.Join(notifiers, ","))
+}
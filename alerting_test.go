@@ -0,0 +1,130 @@
+// alerting_test.go
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordingAlerter keeps every alert it's given
+type recordingAlerter struct {
+	alerts []Alert
+}
+
+func (r *recordingAlerter) Alert(svc Service, alert Alert) {
+	r.alerts = append(r.alerts, alert)
+}
+
+// since returns the alerts recorded after the first n
+func (r *recordingAlerter) since(n int) []Alert {
+	return r.alerts[n:]
+}
+
+var (
+	passing = CheckResult{Healthy: true, ResponseTime: 10}
+	failing = CheckResult{Healthy: false, Error: "HTTP 503"}
+)
+
+func TestHeldDownAlertSentOnceFlappingStops(t *testing.T) {
+	svc := Service{Name: "api", URL: "https://api.example.com", FlapThreshold: 2, FlapWindow: Duration(10 * time.Minute)}
+	hc := NewHealthChecker([]Service{svc})
+	alerts := &recordingAlerter{}
+	hc.AddAlerter(alerts)
+
+	t0 := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	hc.updateStatusAt("api", passing, t0)
+	hc.updateStatusAt("api", failing, t0.Add(time.Minute))
+	hc.updateStatusAt("api", passing, t0.Add(2*time.Minute))
+
+	// The third change starts flapping and holds back the down alert
+	before := len(alerts.alerts)
+	hc.updateStatusAt("api", failing, t0.Add(3*time.Minute))
+	hc.updateStatusAt("api", failing, t0.Add(4*time.Minute))
+	if got := alerts.since(before); len(got) != 0 {
+		t.Fatalf("alerts while flapping = %+v, want none", got)
+	}
+
+	// Once the changes leave the window the service settles, still down
+	hc.updateStatusAt("api", failing, t0.Add(15*time.Minute))
+	hc.updateStatusAt("api", failing, t0.Add(16*time.Minute))
+	hc.updateStatusAt("api", failing, t0.Add(90*time.Minute))
+	got := alerts.since(before)
+	if len(got) != 1 || got[0].State != AlertDown || got[0].Reminder != 0 {
+		t.Fatalf("alerts after flapping stopped = %+v, want one down alert", got)
+	}
+	if !got[0].At.Equal(t0.Add(15 * time.Minute)) {
+		t.Errorf("down alert sent at %v, want as soon as flapping stopped", got[0].At)
+	}
+
+	// The recovery follows, now that the down alert went out
+	hc.updateStatusAt("api", passing, t0.Add(91*time.Minute))
+	if got := alerts.since(before + 1); len(got) != 1 || got[0].State != AlertRecovered {
+		t.Errorf("alerts on recovery = %+v, want one recovered alert", got)
+	}
+}
+
+func TestRemindersFollowRenotifyInterval(t *testing.T) {
+	svc := Service{Name: "api", URL: "https://api.example.com", RenotifyInterval: Duration(time.Hour)}
+	hc := NewHealthChecker([]Service{svc})
+	alerts := &recordingAlerter{}
+	hc.AddAlerter(alerts)
+
+	t0 := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	hc.updateStatusAt("api", failing, t0)
+	hc.updateStatusAt("api", failing, t0.Add(59*time.Minute))
+	hc.updateStatusAt("api", failing, t0.Add(61*time.Minute))
+	hc.updateStatusAt("api", failing, t0.Add(62*time.Minute))
+	hc.updateStatusAt("api", failing, t0.Add(122*time.Minute))
+
+	var reminders []int
+	for _, a := range alerts.alerts {
+		reminders = append(reminders, a.Reminder)
+	}
+	if len(reminders) != 3 || reminders[0] != 0 || reminders[1] != 1 || reminders[2] != 2 {
+		t.Errorf("reminders = %v, want the alert and then reminders 1 and 2", reminders)
+	}
+}
+
+func TestCertificateChangeSendsWarning(t *testing.T) {
+	svc := Service{Name: "api", URL: "https://api.example.com"}
+	hc := NewHealthChecker([]Service{svc})
+	alerts := &recordingAlerter{}
+	hc.AddAlerter(alerts)
+
+	withCert := func(issuer, spki string) CheckResult {
+		result := passing
+		result.TLS = &TLSInfo{Issuer: issuer, SPKISHA256: spki, NotAfter: time.Now().Add(90 * 24 * time.Hour)}
+		return result
+	}
+	t0 := time.Now()
+	hc.updateStatusAt("api", withCert("CN=R10", "aaaa"), t0)
+	hc.updateStatusAt("api", withCert("CN=R10", "aaaa"), t0.Add(time.Minute))
+	if len(alerts.alerts) != 0 {
+		t.Fatalf("alerts for an unchanged certificate = %+v", alerts.alerts)
+	}
+
+	hc.updateStatusAt("api", withCert("CN=Evil CA", "bbbb"), t0.Add(2*time.Minute))
+	if len(alerts.alerts) != 1 {
+		t.Fatalf("alerts after the certificate changed = %+v, want one warning", alerts.alerts)
+	}
+	got := alerts.alerts[0]
+	want := "certificate changed: issuer CN=R10 -> CN=Evil CA, SPKI SHA-256 aaaa -> bbbb"
+	if got.State != AlertWarning || got.Reason != EventCertChanged || got.Warning != want {
+		t.Errorf("warning = %+v, want %q", got, want)
+	}
+	n := alertNotification(got, "en")
+	if n.Subject != "[WARNING] api" || !strings.HasPrefix(n.Text, "api: "+want+"\n") || strings.Contains(n.Text, "Incident") {
+		t.Errorf("notification = %q, %q", n.Subject, n.Text)
+	}
+
+	sel, err := parseSelector("service=api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hc.silences.Add(sel, time.Hour, "rotation", "test")
+	hc.updateStatusAt("api", withCert("CN=R11", "cccc"), t0.Add(3*time.Minute))
+	if len(alerts.alerts) != 1 {
+		t.Errorf("alerts for a silenced service = %+v, want the warning dropped", alerts.alerts[1:])
+	}
+}
@@ -0,0 +1,50 @@
+// api.go
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+// maxRequestBody bounds the size of request bodies accepted by the API
+const maxRequestBody = 1 << 20
+
+// writeJSON encodes v as the response body with the given status code
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// ValidateConfigHandler checks a candidate config without applying it, so CI can
+// gate config changes against the schema of a running instance
+func ValidateConfigHandler(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBody))
+	if err != nil {
+		writeJSON(w, http.StatusRequestEntityTooLarge, map[string]interface{}{
+			"valid":  false,
+			"errors": []ValidationError{{Message: err.Error()}},
+		})
+		return
+	}
+
+	// Unknown fields only warn when a config loads; validation is strict about them
+	cfg, errs, unknown := parseConfig(data)
+	if errs = append(errs, unknown...); len(errs) > 0 {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{
+			"valid":  false,
+			"errors": errs,
+		})
+		return
+	}
+
+	resp := map[string]interface{}{
+		"valid":    true,
+		"services": len(cfg.Services),
+	}
+	if duplicates := findDuplicates(cfg.Services); len(duplicates) > 0 {
+		resp["duplicates"] = duplicates
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
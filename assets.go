@@ -0,0 +1,66 @@
+// assets.go
+package main
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// The pages the checker serves are built into the binary, as are the config
+// schema and the SQLite migrations (see sqlite.go), so a deployment needs
+// nothing but the executable
+
+//go:embed web/dashboard.html
+var dashboardHTML string
+
+//go:embed web/detail.html
+var detailHTML string
+
+//go:embed web/wallboard.html
+var wallboardHTML string
+
+// starterConfig is what -init writes: a few services and the defaults most
+// configs start from
+//
+//go:embed config.example.json
+var starterConfig []byte
+
+// configSchema is the JSON Schema of the config file. Configs are checked
+// against it when loaded and validated, and -init writes it next to the
+// starter config for editors. TestConfigSchemaUpToDate keeps it in step with
+// the config types.
+//
+//go:embed config.schema.json
+var configSchema []byte
+
+// writeStarterConfig writes starterConfig to path, refusing to replace a file
+// that's already there, and configSchema beside it
+func writeStarterConfig(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if errors.Is(err, fs.ErrExist) {
+		return fmt.Errorf("%s already exists; remove it or pass another -config path", path)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(starterConfig); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	// Replaced if it's there, as it describes this build's config
+	return writeFileAtomic(filepath.Join(filepath.Dir(path), "config.schema.json"), configSchema)
+}
+
+// ConfigSchemaHandler serves the JSON Schema configs are validated against
+func ConfigSchemaHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.Write(configSchema)
+}
@@ -0,0 +1,224 @@
+// assignments.go
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// agentConfigInterval is how often agents poll the aggregator for their assignment
+const agentConfigInterval = 30 * time.Second
+
+// Assignment gives a set of services to the agents it selects. An agent is
+// selected when it is listed in Agents (or Agents is empty) and carries every
+// label in Selector.
+type Assignment struct {
+	Selector map[string]string `json:"selector,omitempty"`
+	Agents   []string          `json:"agents,omitempty"`
+	Services []Service         `json:"services"`
+}
+
+// selects reports whether the assignment applies to an agent
+func (a Assignment) selects(agent AgentConfig) bool {
+	if len(a.Agents) > 0 && !containsString(a.Agents, agent.Name) {
+		return false
+	}
+	for k, v := range a.Selector {
+		if agent.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// assignedServices returns the services an agent should check, sorted by name
+func assignedServices(assignments []Assignment, agent AgentConfig) []Service {
+	services := []Service{}
+	for _, a := range assignments {
+		if a.selects(agent) {
+			services = append(services, a.Services...)
+		}
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].Name < services[j].Name })
+	return services
+}
+
+// validateAssignments checks that assignments name known agents and give no agent
+// two services with the same name
+func validateAssignments(assignments []Assignment, agents []AgentConfig) []ValidationError {
+	var errs []ValidationError
+	known := make(map[string]bool, len(agents))
+	for _, agent := range agents {
+		known[agent.Name] = true
+	}
+
+	for i, a := range assignments {
+		field := fmt.Sprintf("assignments[%d]", i)
+		for j, name := range a.Agents {
+			if !known[name] {
+				errs = append(errs, ValidationError{Field: fmt.Sprintf("%s.agents[%d]", field, j), Message: fmt.Sprintf("unknown agent %q", name)})
+			}
+		}
+		for j, svc := range a.Services {
+			errs = append(errs, validateService(svc, fmt.Sprintf("%s.services[%d].", field, j))...)
+			if svc.Type == "exec" {
+				errs = append(errs, ValidationError{Field: fmt.Sprintf("%s.services[%d].type", field, j), Message: "exec checks can't be assigned to agents"})
+			}
+		}
+	}
+
+	for _, agent := range agents {
+		seen := make(map[string]bool)
+		for _, svc := range assignedServices(assignments, agent) {
+			if seen[svc.Name] {
+				errs = append(errs, ValidationError{Field: "assignments", Message: fmt.Sprintf("agent %q is assigned service %q more than once", agent.Name, svc.Name)})
+			}
+			seen[svc.Name] = true
+		}
+	}
+	return errs
+}
+
+// agentAssignment is the response of the agent config endpoint
+type agentAssignment struct {
+	Agent    string    `json:"agent"`
+	Revision string    `json:"revision"`
+	Services []Service `json:"services"`
+}
+
+// ConfigHandler returns the services assigned to the requesting agent. The
+// revision doubles as an ETag, so polling agents get 304 until it changes.
+func (a *Aggregator) ConfigHandler(w http.ResponseWriter, r *http.Request) {
+	agent, ok := a.authenticate(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="agent"`)
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "agent token required"})
+		return
+	}
+
+	services := assignedServices(a.assignments, agent)
+	data, _ := json.Marshal(services)
+	sum := sha256.Sum256(data)
+	revision := hex.EncodeToString(sum[:8])
+
+	etag := `"` + revision + `"`
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeJSON(w, http.StatusOK, agentAssignment{Agent: agent.Name, Revision: revision, Services: services})
+}
+
+// RunAssignments polls the aggregator for this agent's assigned services and
+// applies them to the checker until the process exits. The last assignment stays
+// in force while the aggregator is unreachable.
+func (f *Forwarder) RunAssignments() {
+	assigned := make(map[string]bool)
+	etag := ""
+	for {
+		assignment, newTag, err := f.fetchAssignment(etag)
+		switch {
+		case err != nil:
+			slog.Warn("fetching assignment", "error", err)
+		case assignment != nil:
+			etag = newTag
+			f.applyAssignment(*assignment, assigned)
+		}
+		time.Sleep(jitter(agentConfigInterval))
+	}
+}
+
+// fetchAssignment requests the agent's assignment, returning nil when it hasn't
+// changed since etag
+func (f *Forwarder) fetchAssignment(etag string) (*agentAssignment, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), agentUploadTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.url+"/api/v1/agents/config", nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+f.token)
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return nil, etag, nil
+	case http.StatusOK:
+		var assignment agentAssignment
+		if err := json.NewDecoder(resp.Body).Decode(&assignment); err != nil {
+			return nil, "", err
+		}
+		return &assignment, resp.Header.Get("ETag"), nil
+	}
+	return nil, "", fmt.Errorf("aggregator returned %d", resp.StatusCode)
+}
+
+// applyAssignment adds, updates and removes assigned services so the checker
+// matches the assignment. assigned tracks which services came from assignments;
+// services from a local config are never touched.
+func (f *Forwarder) applyAssignment(assignment agentAssignment, assigned map[string]bool) {
+	wanted := make(map[string]bool, len(assignment.Services))
+	duplicates := make(map[string]bool)
+	for _, svc := range assignment.Services {
+		if errs := validateService(svc, ""); len(errs) > 0 {
+			slog.Warn("assigned service refused", "service", svc.Name, "error", errs[0])
+			continue
+		}
+		if svc.Type == "exec" {
+			slog.Warn("assigned service refused", "service", svc.Name, "error", errExecNotAllowed)
+			continue
+		}
+		if err := f.targets.Check(svc); err != nil {
+			slog.Warn("assigned service refused", "service", svc.Name, "error", err)
+			continue
+		}
+		existing, exists := f.checker.GetService(svc.Name)
+		if !exists {
+			if duplicate, dup := f.checker.duplicateOf(svc); dup {
+				if !f.duplicates[svc.Name] {
+					f.checker.resolveDuplicate("assigned", svc, duplicate)
+				}
+				duplicates[svc.Name] = true
+				continue
+			}
+		}
+		switch {
+		case !exists:
+			if f.checker.AddService(svc) != nil {
+				continue
+			}
+		case !assigned[svc.Name]:
+			slog.Warn("assigned service conflicts with a locally configured one", "service", svc.Name)
+			continue
+		case !reflect.DeepEqual(existing, svc):
+			f.checker.UpdateService(svc)
+		}
+		wanted[svc.Name] = true
+		assigned[svc.Name] = true
+	}
+
+	for name := range assigned {
+		if !wanted[name] {
+			f.checker.RemoveService(name)
+			delete(assigned, name)
+		}
+	}
+	f.duplicates = duplicates
+	slog.Info("applied assignment", "revision", assignment.Revision, "services", len(wanted))
+}
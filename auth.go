@@ -0,0 +1,33 @@
+// auth.go
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// requireOperator only runs next for requests carrying the operator token as a bearer
+// token. When no token is configured the endpoint is disabled.
+func requireOperator(token string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token == "" {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "operator token not configured"})
+			return
+		}
+
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="operator"`)
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "operator token required"})
+			return
+		}
+		next(w, r)
+	}
+}
+
+// rejectReadOnly answers every request with 403, replacing mutating endpoints
+// when the checker runs with -read-only
+func rejectReadOnly(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusForbidden, map[string]string{"error": "read-only mode: changes are disabled on this instance"})
+}
@@ -0,0 +1,71 @@
+// botchallenge.go
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// What an HTTP check does on a bot challenge: fail (default) or warn, which
+// passes the check with a warning for services behind a CDN that challenges
+// probes now and then
+var botChallengeModes = []string{"fail", "warn"}
+
+// botChallenge is a CDN's bot challenge or block page, served instead of the
+// service's own response
+type botChallenge struct {
+	vendor string // cloudflare, akamai or imperva
+	status int
+}
+
+func (e *botChallenge) Error() string {
+	return fmt.Sprintf("blocked by %s bot challenge (HTTP %d)", e.vendor, e.status)
+}
+
+// classifyBotChallenge returns the CDN whose bot challenge a check failed on, or ""
+func classifyBotChallenge(err error) string {
+	var challenge *botChallenge
+	if errors.As(err, &challenge) {
+		return challenge.vendor
+	}
+	return ""
+}
+
+// detectBotChallenge returns the CDN that served resp as a bot challenge or
+// block page, or "". Only the first bodyAssertMax bytes of the body are read.
+func detectBotChallenge(resp *http.Response) string {
+	switch resp.StatusCode {
+	case http.StatusForbidden, http.StatusTooManyRequests, http.StatusServiceUnavailable:
+	default:
+		return ""
+	}
+	if resp.Header.Get("Cf-Mitigated") == "challenge" {
+		return "cloudflare"
+	}
+
+	server := strings.ToLower(resp.Header.Get("Server"))
+	var markers []string
+	vendor := ""
+	switch {
+	case server == "cloudflare":
+		vendor, markers = "cloudflare", []string{"challenge-platform", "cf-chl", "Just a moment...", "Attention Required!"}
+	case strings.HasPrefix(server, "akamaighost"):
+		vendor, markers = "akamai", []string{"Access Denied", "errors.edgesuite.net"}
+	case resp.Header.Get("X-Iinfo") != "":
+		vendor, markers = "imperva", []string{"Incapsula incident ID", "_Incapsula_Resource"}
+	default:
+		return ""
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, bodyAssertMax))
+	for _, marker := range markers {
+		if bytes.Contains(body, []byte(marker)) {
+			return vendor
+		}
+	}
+	return ""
+}
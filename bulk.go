@@ -0,0 +1,114 @@
+// bulk.go
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+)
+
+// defaultSilenceDuration is how long a bulk silence lasts when no duration is given
+const defaultSilenceDuration = time.Hour
+
+// bulkResult reports the services a bulk operation applied to
+type bulkResult struct {
+	Selector string   `json:"selector"`
+	Services []string `json:"services"`
+	Silence  *Silence `json:"silence,omitempty"`
+	Ended    int      `json:"silences_ended,omitempty"`
+}
+
+// selectServices parses ?selector= and returns it with the matching services,
+// answering 400 itself when the selector is missing or invalid
+func (api *ServiceAPI) selectServices(w http.ResponseWriter, r *http.Request) (Selector, []Service, bool) {
+	sel, err := parseSelector(r.URL.Query().Get("selector"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return nil, nil, false
+	}
+	var matched []Service
+	for _, svc := range api.checker.Services() {
+		if sel.Matches(svc.Name, svc.Labels, svc.Tags) {
+			matched = append(matched, svc)
+		}
+	}
+	return sel, matched, true
+}
+
+// serviceNames returns the names of services, never nil
+func serviceNames(services []Service) []string {
+	names := make([]string, 0, len(services))
+	for _, svc := range services {
+		names = append(names, svc.Name)
+	}
+	return names
+}
+
+// BulkPauseHandler returns a handler that pauses or resumes every service
+// matching ?selector=, e.g. env=staging
+func (api *ServiceAPI) BulkPauseHandler(paused bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		api.mu.Lock()
+		defer api.mu.Unlock()
+
+		sel, matched, ok := api.selectServices(w, r)
+		if !ok {
+			return
+		}
+		for _, svc := range matched {
+			if err := api.checker.SetPaused(svc.Name, paused); err != nil {
+				writeServiceError(w, err)
+				return
+			}
+		}
+		if len(matched) > 0 {
+			api.persist()
+		}
+		writeJSON(w, http.StatusOK, bulkResult{Selector: sel.String(), Services: serviceNames(matched)})
+	}
+}
+
+// BulkSilenceHandler silences alerts for the services matching ?selector=. The
+// optional body sets the duration (default 1h), a comment and who is silencing.
+// The silence also covers services added later that match.
+func (api *ServiceAPI) BulkSilenceHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Duration Duration `json:"duration"`
+		Comment  string   `json:"comment"`
+		By       string   `json:"by"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody)).Decode(&body); err != nil && err != io.EOF {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	duration := time.Duration(body.Duration)
+	if duration < 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "duration must be positive"})
+		return
+	}
+	if duration == 0 {
+		duration = defaultSilenceDuration
+	}
+
+	sel, matched, ok := api.selectServices(w, r)
+	if !ok {
+		return
+	}
+	silence := api.checker.Silence(sel, duration, body.Comment, body.By)
+	writeJSON(w, http.StatusCreated, bulkResult{Selector: sel.String(), Services: serviceNames(matched), Silence: silence})
+}
+
+// BulkUnsilenceHandler ends the active silences created with ?selector=
+func (api *ServiceAPI) BulkUnsilenceHandler(w http.ResponseWriter, r *http.Request) {
+	sel, matched, ok := api.selectServices(w, r)
+	if !ok {
+		return
+	}
+	ended := api.checker.EndSilences(sel)
+	if ended == 0 {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "no active silence with selector " + sel.String()})
+		return
+	}
+	writeJSON(w, http.StatusOK, bulkResult{Selector: sel.String(), Services: serviceNames(matched), Ended: ended})
+}
@@ -0,0 +1,153 @@
+// calendar.go
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// calendarAhead is how far ahead scheduled silences are listed in the calendar feed
+const calendarAhead = 31 * 24 * time.Hour
+
+// calendarEvent is one VEVENT in the calendar feed
+type calendarEvent struct {
+	uid         string
+	start, end  time.Time
+	summary     string
+	description string
+	categories  string
+}
+
+// icalText escapes a value for an iCalendar TEXT property
+var icalText = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+// writeICalLine writes a content line, folded to 75 octets as RFC 5545 requires
+func writeICalLine(b *strings.Builder, line string) {
+	for len(line) > 75 {
+		cut := 75
+		for cut > 0 && line[cut]&0xC0 == 0x80 { // don't split a UTF-8 sequence
+			cut--
+		}
+		b.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+	}
+	b.WriteString(line + "\r\n")
+}
+
+// icalTime formats a time as an iCalendar UTC date-time
+func icalTime(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+// calendarEvents returns the incidents and upcoming silences of the services sel
+// matches, or of every service when sel is nil. Open incidents end at now.
+func (hc *HealthChecker) calendarEvents(sel Selector, now time.Time) []calendarEvent {
+	var events []calendarEvent
+	selected := make(map[string]bool)
+	for _, svc := range hc.Services() {
+		if sel != nil && !sel.Matches(svc.Name, svc.Labels, svc.Tags) {
+			continue
+		}
+		selected[svc.Name] = true
+		for _, inc := range hc.Incidents(svc.Name) {
+			e := calendarEvent{
+				uid:         inc.ID + "@sre-health-checker",
+				start:       inc.StartedAt,
+				end:         now,
+				summary:     "Incident: " + svc.Name + " (ongoing)",
+				description: inc.Error,
+				categories:  "INCIDENT",
+			}
+			if inc.ResolvedAt != nil {
+				e.end = *inc.ResolvedAt
+				e.summary = "Incident: " + svc.Name
+			}
+			if inc.AckedBy != "" {
+				e.description += "\nAcknowledged by " + inc.AckedBy
+			}
+			events = append(events, e)
+		}
+	}
+
+	for _, s := range hc.UpcomingSilences(calendarAhead) {
+		var services []string
+		for _, name := range s.Services {
+			if selected[name] {
+				services = append(services, name)
+			}
+		}
+		if len(services) == 0 {
+			continue
+		}
+		uid := s.ID
+		if uid == "" {
+			sum := sha256.Sum256([]byte(s.Selector + "|" + s.Schedule + "|" + icalTime(s.StartsAt)))
+			uid = hex.EncodeToString(sum[:8])
+		}
+		summary := "Maintenance: " + s.Selector
+		if s.Comment != "" {
+			summary = "Maintenance: " + s.Comment
+		}
+		events = append(events, calendarEvent{
+			uid:         "silence-" + uid + "@sre-health-checker",
+			start:       s.StartsAt,
+			end:         s.EndsAt,
+			summary:     summary,
+			description: "Alerts silenced for " + strings.Join(services, ", ") + "\nSelector: " + s.Selector,
+			categories:  "MAINTENANCE",
+		})
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].start.Before(events[j].start) })
+	return events
+}
+
+// renderCalendar formats events as an iCalendar document
+func renderCalendar(title string, events []calendarEvent, now time.Time) string {
+	var b strings.Builder
+	writeICalLine(&b, "BEGIN:VCALENDAR")
+	writeICalLine(&b, "VERSION:2.0")
+	writeICalLine(&b, "PRODID:-//sre-health-checker//calendar//EN")
+	writeICalLine(&b, "CALSCALE:GREGORIAN")
+	writeICalLine(&b, "X-WR-CALNAME:"+icalText.Replace(title))
+	for _, e := range events {
+		writeICalLine(&b, "BEGIN:VEVENT")
+		writeICalLine(&b, "UID:"+e.uid)
+		writeICalLine(&b, "DTSTAMP:"+icalTime(now))
+		writeICalLine(&b, "DTSTART:"+icalTime(e.start))
+		writeICalLine(&b, "DTEND:"+icalTime(e.end))
+		writeICalLine(&b, "SUMMARY:"+icalText.Replace(e.summary))
+		if e.description != "" {
+			writeICalLine(&b, "DESCRIPTION:"+icalText.Replace(e.description))
+		}
+		writeICalLine(&b, "CATEGORIES:"+e.categories)
+		writeICalLine(&b, "END:VEVENT")
+	}
+	writeICalLine(&b, "END:VCALENDAR")
+	return b.String()
+}
+
+// CalendarHandler serves an iCalendar feed of incidents and the silences
+// scheduled over the next 31 days, for subscribing from team calendars.
+// ?selector= limits it to matching services, e.g. team=billing.
+func (hc *HealthChecker) CalendarHandler(title string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := title
+		var sel Selector
+		if v := r.URL.Query().Get("selector"); v != "" {
+			var err error
+			if sel, err = parseSelector(v); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+			name = fmt.Sprintf("%s (%s)", title, sel)
+		}
+		now := time.Now()
+		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		w.Write([]byte(renderCalendar(name, hc.calendarEvents(sel, now), now)))
+	}
+}
@@ -0,0 +1,174 @@
+// canary.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// canaryDiffContext is how much of each body a body diff error quotes
+const canaryDiffContext = 40
+
+// CanaryConfig configures a canary check: the service's URL is the canary and
+// BaselineURL the current release, both sent the same request
+type CanaryConfig struct {
+	BaselineURL string `json:"baseline_url"`
+
+	// How much slower the canary may answer than the baseline; unset means latency isn't compared
+	MaxLatencyDelta Duration `json:"max_latency_delta,omitempty"`
+
+	// Fail when the response bodies differ, after removing matches of IgnoreBody
+	// from both, e.g. timestamps or request IDs
+	CompareBody bool     `json:"compare_body,omitempty"`
+	IgnoreBody  []string `json:"ignore_body,omitempty"`
+}
+
+// CanaryResult is what the last canary check saw from each target
+type CanaryResult struct {
+	BaselineStatus int   `json:"baseline_status"`
+	CanaryStatus   int   `json:"canary_status"`
+	BaselineMs     int64 `json:"baseline_ms"`
+	CanaryMs       int64 `json:"canary_ms"`
+	LatencyDeltaMs int64 `json:"latency_delta_ms"` // canary minus baseline
+	BodyDiffers    bool  `json:"body_differs,omitempty"`
+}
+
+// validate checks a canary definition; prefix is prepended to field names
+func (c CanaryConfig) validate(prefix string) []ValidationError {
+	var errs []ValidationError
+	add := func(name, format string, args ...interface{}) {
+		errs = append(errs, ValidationError{Field: prefix + name, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if u, err := url.Parse(c.BaselineURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		add("baseline_url", "must be an absolute http or https URL")
+	}
+	if c.MaxLatencyDelta < 0 {
+		add("max_latency_delta", "must not be negative")
+	}
+	if len(c.IgnoreBody) > 0 && !c.CompareBody {
+		add("ignore_body", "only used with compare_body")
+	}
+	for i, expr := range c.IgnoreBody {
+		if _, err := regexp.Compile(expr); err != nil {
+			add(fmt.Sprintf("ignore_body[%d]", i), "invalid regular expression: %v", err)
+		}
+	}
+	return errs
+}
+
+// canaryResponse is one target's answer to a canary check
+type canaryResponse struct {
+	status  int
+	body    []byte
+	elapsed time.Duration
+	err     error
+}
+
+// fetchCanaryTarget sends the service's request to target and reads the start of the body
+func fetchCanaryTarget(ctx context.Context, svc Service, target string) canaryResponse {
+	svc.URL = target
+	start := time.Now()
+	req, err := newCheckRequest(ctx, svc)
+	if err != nil {
+		return canaryResponse{err: err}
+	}
+	req, finish := traceRequest(req)
+	resp, err := probeClient(nil).Do(req)
+	finish(resp, err)
+	if err != nil {
+		return canaryResponse{err: err}
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, bodyAssertMax))
+	if err != nil {
+		return canaryResponse{err: fmt.Errorf("reading body: %w", err)}
+	}
+	return canaryResponse{status: resp.StatusCode, body: body, elapsed: time.Since(start)}
+}
+
+// probeCanary sends the same request to the baseline and the canary at once and
+// fails on a regression: a different status, a canary answer the service's
+// expected_status and body assertions reject, a canary more than
+// max_latency_delta slower, or with compare_body, a different body
+func probeCanary(ctx context.Context, svc Service, result *CheckResult) error {
+	if svc.Canary == nil {
+		return errors.New("canary checks need a canary block with baseline_url")
+	}
+
+	var baseline, canary canaryResponse
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() { defer wg.Done(); baseline = fetchCanaryTarget(ctx, svc, svc.Canary.BaselineURL) }()
+	go func() { defer wg.Done(); canary = fetchCanaryTarget(ctx, svc, svc.URL) }()
+	wg.Wait()
+
+	if baseline.err != nil {
+		return fmt.Errorf("baseline: %w", baseline.err)
+	}
+	if canary.err != nil {
+		return fmt.Errorf("canary: %w", canary.err)
+	}
+
+	delta := canary.elapsed - baseline.elapsed
+	result.Canary = &CanaryResult{
+		BaselineStatus: baseline.status,
+		CanaryStatus:   canary.status,
+		BaselineMs:     baseline.elapsed.Milliseconds(),
+		CanaryMs:       canary.elapsed.Milliseconds(),
+		LatencyDeltaMs: delta.Milliseconds(),
+	}
+
+	if canary.status != baseline.status {
+		return fmt.Errorf("status regression: baseline HTTP %d, canary HTTP %d", baseline.status, canary.status)
+	}
+	if !statusExpected(svc.ExpectedStatus, canary.status) {
+		return fmt.Errorf("HTTP %d from baseline and canary", canary.status)
+	}
+	if err := checkBody(svc, bytes.NewReader(canary.body)); err != nil {
+		return fmt.Errorf("canary: %w", err)
+	}
+	if maxDelta := time.Duration(svc.Canary.MaxLatencyDelta); maxDelta > 0 && delta > maxDelta {
+		return fmt.Errorf("latency regression: canary %dms, baseline %dms, more than %s slower",
+			result.Canary.CanaryMs, result.Canary.BaselineMs, maxDelta)
+	}
+	if svc.Canary.CompareBody {
+		if err := compareCanaryBodies(svc.Canary.IgnoreBody, baseline.body, canary.body); err != nil {
+			result.Canary.BodyDiffers = true
+			return err
+		}
+	}
+	return nil
+}
+
+// compareCanaryBodies reports where two bodies first differ once the ignored
+// patterns are removed from both
+func compareCanaryBodies(ignore []string, baseline, canary []byte) error {
+	for _, expr := range ignore {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return err
+		}
+		baseline = re.ReplaceAll(baseline, nil)
+		canary = re.ReplaceAll(canary, nil)
+	}
+	if bytes.Equal(baseline, canary) {
+		return nil
+	}
+
+	at := 0
+	for at < len(baseline) && at < len(canary) && baseline[at] == canary[at] {
+		at++
+	}
+	snippet := func(b []byte) []byte {
+		return b[at:min(len(b), at+canaryDiffContext)]
+	}
+	return fmt.Errorf("body differs from baseline at byte %d: baseline %q, canary %q", at, snippet(baseline), snippet(canary))
+}
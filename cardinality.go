@@ -0,0 +1,240 @@
+// cardinality.go
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// defaultMaxSeries is how many series a metric may have when metrics.max_series is unset
+const defaultMaxSeries = 10000
+
+// MetricsConfig keeps /metrics within what Prometheus can store when there
+// are thousands of services, such as discovered ones
+type MetricsConfig struct {
+	// Series a metric may have, default 10000; series past the limit are dropped
+	MaxSeries int `json:"max_series,omitempty"`
+
+	// Distinct values a label may take within a metric, e.g. {"service": 1000}
+	LabelLimits map[string]int `json:"label_limits,omitempty"`
+
+	// Service label to aggregate service metrics by, writing one series per
+	// group instead of per service; statuses per service stay in the API
+	GroupBy string `json:"group_by,omitempty"`
+}
+
+// Validate checks the metrics settings
+func (c MetricsConfig) Validate() []ValidationError {
+	var errs []ValidationError
+	add := func(name, format string, args ...interface{}) {
+		errs = append(errs, ValidationError{Field: "metrics." + name, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if c.MaxSeries < 0 {
+		add("max_series", "must not be negative")
+	}
+	for _, label := range slices.Sorted(maps.Keys(c.LabelLimits)) {
+		if c.LabelLimits[label] <= 0 {
+			add("label_limits."+label, "must be positive")
+		}
+	}
+	if c.GroupBy != "" && strings.TrimSpace(c.GroupBy) != c.GroupBy {
+		add("group_by", "must be a label name without spaces")
+	}
+	return errs
+}
+
+// cardinalityGuard enforces the series limits on every scrape and warns when
+// a metric starts or stops losing series
+type cardinalityGuard struct {
+	maxSeries   int
+	labelLimits map[string]int
+
+	mu      sync.Mutex
+	limited map[string]bool // metrics that lost series in the last scrape
+}
+
+func newCardinalityGuard(c MetricsConfig) *cardinalityGuard {
+	maxSeries := c.MaxSeries
+	if maxSeries == 0 {
+		maxSeries = defaultMaxSeries
+	}
+	return &cardinalityGuard{maxSeries: maxSeries, labelLimits: c.LabelLimits, limited: make(map[string]bool)}
+}
+
+// familyLimits is what a metricWriter tracks of the current family; series
+// are told apart by their labels other than le, so a histogram's buckets
+// count as one series
+type familyLimits struct {
+	series   map[string]bool            // series written
+	rejected map[string]bool            // series dropped
+	values   map[string]map[string]bool // distinct values by label
+}
+
+// admit reports whether a sample with labels fits within the limits of the
+// current family
+func (m *metricWriter) admit(labels []string) bool {
+	if len(labels) == 0 {
+		return true
+	}
+	f := &m.limits
+	var key strings.Builder
+	for i := 0; i+1 < len(labels); i += 2 {
+		if labels[i] != "le" {
+			key.WriteString(labels[i] + "=" + labels[i+1] + "\x00")
+		}
+	}
+	k := key.String()
+	if f.series[k] {
+		return true
+	}
+	if f.rejected[k] {
+		return false
+	}
+
+	fits := len(f.series) < m.guard.maxSeries
+	for i := 0; fits && i+1 < len(labels); i += 2 {
+		if limit, ok := m.guard.labelLimits[labels[i]]; ok && !f.values[labels[i]][labels[i+1]] && len(f.values[labels[i]]) >= limit {
+			fits = false
+		}
+	}
+	if !fits {
+		f.rejected[k] = true
+		m.dropped[m.current]++
+		return false
+	}
+	f.series[k] = true
+	for i := 0; i+1 < len(labels); i += 2 {
+		if _, ok := m.guard.labelLimits[labels[i]]; ok {
+			if f.values[labels[i]] == nil {
+				f.values[labels[i]] = make(map[string]bool)
+			}
+			f.values[labels[i]][labels[i+1]] = true
+		}
+	}
+	return true
+}
+
+// WriteMetrics writes how many series each metric lost to the limits in this
+// scrape, and logs the metrics that started or stopped losing series. It must
+// come after every other metric.
+func (g *cardinalityGuard) WriteMetrics(m *metricWriter) {
+	dropped := m.dropped
+	g.mu.Lock()
+	var over, within []string
+	total := 0
+	for _, name := range slices.Sorted(maps.Keys(dropped)) {
+		total += dropped[name]
+		if !g.limited[name] {
+			over = append(over, name)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(g.limited)) {
+		if dropped[name] == 0 {
+			within = append(within, name)
+		}
+	}
+	if len(over) > 0 {
+		slog.Warn("metrics over their series limits, dropping series", "metrics", strings.Join(over, ","), "dropped", total)
+	}
+	if len(within) > 0 {
+		slog.Info("metrics within their series limits again", "metrics", strings.Join(within, ","))
+	}
+	g.limited = make(map[string]bool, len(dropped))
+	for name := range dropped {
+		g.limited[name] = true
+	}
+	g.mu.Unlock()
+
+	m.family("metrics_series_dropped", "gauge", "Series of the metric left out of this scrape for exceeding metrics.max_series or metrics.label_limits")
+	for _, name := range slices.Sorted(maps.Keys(dropped)) {
+		m.sample("metrics_series_dropped", float64(dropped[name]), "metric", name)
+	}
+}
+
+// serviceStates are the states service_group_services counts services in
+var serviceStates = []string{"up", "down", "pending", "stale", "paused", "throttled"}
+
+// serviceState is the state a service is counted in by service_group_services
+func serviceState(status *HealthStatus) string {
+	switch {
+	case status.Paused:
+		return "paused"
+	case status.Pending:
+		return "pending"
+	case status.Stale:
+		return "stale"
+	case status.Throttled:
+		return "throttled"
+	case status.Healthy:
+		return "up"
+	default:
+		return "down"
+	}
+}
+
+// writeGroupMetrics writes service metrics per group of services sharing a
+// value of the hc.metricsGroupBy label; services without it are in group ""
+func (hc *HealthChecker) writeGroupMetrics(m *metricWriter) {
+	statuses := hc.GetStatuses()
+	totals := hc.Counts()
+
+	groups := make(map[string]map[string]*HealthStatus)
+	for name, status := range statuses {
+		group := status.Labels[hc.metricsGroupBy]
+		if groups[group] == nil {
+			groups[group] = make(map[string]*HealthStatus)
+		}
+		groups[group][name] = status
+	}
+	names := slices.Sorted(maps.Keys(groups))
+
+	m.family("service_group_services", "gauge", "Services in the group by state: up, down, pending, stale, paused or throttled")
+	for _, group := range names {
+		counts := make(map[string]int)
+		for _, status := range groups[group] {
+			counts[serviceState(status)]++
+		}
+		for _, state := range serviceStates {
+			m.sample("service_group_services", float64(counts[state]), "group", group, "state", state)
+		}
+	}
+
+	m.family("service_group_checks_total", "counter", "Checks run for services in the group, by result; failures during maintenance windows included")
+	for _, group := range names {
+		var checks, failures int64
+		for name := range groups[group] {
+			c := totals[name]
+			checks += c.Checks
+			failures += c.Failures + c.MaintenanceFailures
+		}
+		m.sample("service_group_checks_total", float64(checks-failures), "group", group, "result", "success")
+		m.sample("service_group_checks_total", float64(failures), "group", group, "result", "failure")
+	}
+
+	m.family("service_group_check_duration_seconds", "histogram", "How long checks of services in the group took")
+	for _, group := range names {
+		var h DurationHistogram
+		for name := range groups[group] {
+			d := totals[name].Duration
+			for i, n := range d.Buckets {
+				h.Buckets[i] += n
+			}
+			h.Count += d.Count
+			h.SumMs += d.SumMs
+		}
+		m.histogram("service_group_check_duration_seconds", checkDurationBuckets[:], h.Buckets[:], float64(h.SumMs)/1000, "group", group)
+	}
+
+	m.family("service_group_weighted_health", "gauge", "Weighted percentage (0-100) of the group's services that are up")
+	for _, group := range names {
+		m.sample("service_group_weighted_health", healthScore(groups[group]), "group", group)
+	}
+
+	m.family("service_weighted_health", "gauge", "Weighted percentage (0-100) of services that are up")
+	m.sample("service_weighted_health", healthScore(statuses))
+}
@@ -0,0 +1,913 @@
+// checker.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"math"
+	"runtime/debug"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Service represents a service to monitor
+type Service struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type,omitempty"` // http (default), tcp, dns, grpc, icmp, exec, postgres, mysql, redis, memcached, etcd, heartbeat, agent, merged
+	URL      string   `json:"url"`
+	Interval Duration `json:"interval"`
+	Timeout  Duration `json:"timeout"`
+
+	// Labels (e.g. team, env) and tags used for grouping and filtering
+	Labels map[string]string `json:"labels,omitempty"`
+	Tags   []string          `json:"tags,omitempty"`
+
+	// Mark the service flapping while it changes state more than FlapThreshold
+	// times within FlapWindow (default 10m); zero disables flap detection
+	FlapThreshold int      `json:"flap_threshold,omitempty"`
+	FlapWindow    Duration `json:"flap_window,omitempty"`
+
+	// Failed attempts retried within the same check before it counts as failed,
+	// waiting retry_backoff (default 1s) before the first retry and doubling it after
+	Retries      int      `json:"retries,omitempty"`
+	RetryBackoff Duration `json:"retry_backoff,omitempty"`
+
+	// Consecutive failed checks before a healthy service is marked unhealthy, and
+	// consecutive successful ones before it recovers; default 1
+	FailureThreshold int `json:"failure_threshold,omitempty"`
+	SuccessThreshold int `json:"success_threshold,omitempty"`
+
+	// Notifiers this service's down and recovery alerts are sent to, instead of
+	// the alerting section's defaults
+	Notifiers []string `json:"notifiers,omitempty"`
+
+	// Resend the down alert this often while the service stays down and its
+	// incident isn't acknowledged, instead of the alerting section's default
+	RenotifyInterval Duration `json:"renotify_interval,omitempty"`
+
+	// Warm-up after the service is added, updated or resumed: for grace_period,
+	// or grace_checks intervals if longer, failures are recorded but open no
+	// incident and send no alert
+	GracePeriod Duration `json:"grace_period,omitempty"`
+	GraceChecks int      `json:"grace_checks,omitempty"`
+
+	// Recurring windows when the service's alerts are muted, e.g. a weekly batch run
+	SilenceSchedules []SilenceSchedule `json:"silence_schedules,omitempty"`
+
+	// Which due checks run first when max_concurrent_checks are already running:
+	// critical, high, normal (default) or low
+	Priority string `json:"priority,omitempty"`
+
+	// Paused services keep their last status but are not checked
+	Paused bool `json:"paused,omitempty"`
+
+	// Business impact relative to other services in the health score (default 1).
+	// Zero leaves the service out of the score.
+	Weight float64 `json:"weight"`
+
+	// Availability objective in percent (e.g. 99.9), used for SLO burn in digests
+	SLO float64 `json:"slo,omitempty"`
+
+	// Thresholds for generated Prometheus alerting rules
+	AlertDownFor         Duration `json:"alert_down_for,omitempty"`         // default 2m
+	AlertLatencyWarning  Duration `json:"alert_latency_warning,omitempty"`  // default 5s
+	AlertLatencyCritical Duration `json:"alert_latency_critical,omitempty"` // default 10s
+
+	// Optional certificate pins for HTTPS checks; a mismatch fails the check
+	ExpectedIssuers []string `json:"expected_issuers,omitempty"`
+	PinnedSPKI      []string `json:"pinned_spki,omitempty"`
+
+	// Certificate expiry thresholds in days: within CertWarningDays (default 14) the
+	// check warns, within CertCriticalDays it fails
+	CertWarningDays  int `json:"cert_warning_days,omitempty"`
+	CertCriticalDays int `json:"cert_critical_days,omitempty"`
+
+	// Security header audit for HTTP checks; missing headers are reported as warnings
+	HeaderAudit     bool     `json:"header_audit,omitempty"`
+	RequiredHeaders []string `json:"required_headers,omitempty"` // overrides the default set
+
+	// Warn when the response Date header differs from local time by more than this
+	MaxClockSkew Duration `json:"max_clock_skew,omitempty"`
+
+	// HTTP checks: the request to send, GET with no body by default
+	Method  string            `json:"method,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"` // e.g. X-Api-Key; Host overrides the virtual host
+	Body    string            `json:"body,omitempty"`    // Content-Type defaults to application/json
+
+	// HTTP checks: fail (default) or warn when a CDN answers with a bot challenge;
+	// monitors allowed past it can send the CDN's bypass header in Headers
+	BotChallenge string `json:"bot_challenge,omitempty"`
+
+	// HTTP checks: fail (default), backoff or skip when the target throttles the
+	// check with 429, or 503 and Retry-After; see throttlingModes
+	Throttling string `json:"throttling,omitempty"`
+
+	// Credentials for HTTP checks behind an auth gateway
+	Auth *AuthConfig `json:"auth,omitempty"`
+
+	// Client certificate and CA bundle for https, grpcs and rediss checks (mTLS)
+	TLS *ClientTLSConfig `json:"tls,omitempty"`
+
+	// Status codes that count as healthy, e.g. [200, 401] or ["200-399"]; default 2xx.
+	// Redirects aren't followed when a 3xx code is expected.
+	ExpectedStatus []StatusRange `json:"expected_status,omitempty"`
+
+	// Response body assertions for HTTP checks, evaluated against the first 64KB of
+	// the body, so an error page served with 200 fails the check
+	BodyContains string `json:"body_contains,omitempty"` // substring that must appear
+	BodyRegex    string `json:"body_regex,omitempty"`    // RE2 expression that must match
+
+	// Assertions on values of a JSON response body, e.g. status must equal "ok"
+	JSONAssertions []JSONAssertion `json:"json_assertions,omitempty"`
+
+	// Log in before HTTP checks, reusing the session across checks
+	Login *LoginConfig `json:"login,omitempty"`
+
+	// GeoDNS/anycast validation for HTTP checks: resolve the host through each of
+	// these resolvers (host:port) and check every address returned
+	GeoResolvers []string `json:"geo_resolvers,omitempty"`
+
+	// HTTP checks: connect to this host or host:port instead of the URL's, keeping
+	// the URL's host for Host and SNI, e.g. to check one backend behind a shared
+	// virtual host before it joins the load balancer pool
+	ConnectTo string `json:"connect_to,omitempty"`
+
+	// HTTP checks: compare the version every instance serves, warning when they
+	// disagree for longer than a rollout should take
+	VersionSkew *VersionSkewConfig `json:"version_skew,omitempty"`
+
+	// Canary checks: the URL is the canary, compared against canary.baseline_url
+	Canary *CanaryConfig `json:"canary,omitempty"`
+
+	// DNS checks: the URL is the hostname to resolve
+	Resolver        string   `json:"resolver,omitempty"`         // host:port, default the system resolver
+	RecordType      string   `json:"record_type,omitempty"`      // A (default), AAAA, CNAME or TXT
+	ExpectedRecords []string `json:"expected_records,omitempty"` // values that must all be returned
+
+	// gRPC checks: the service to ask grpc.health.v1.Health about; empty means the whole server
+	GRPCService string `json:"grpc_service,omitempty"`
+
+	// Exec checks: the program and its arguments, run without a shell; exit code 0 is healthy
+	Command []string `json:"command,omitempty"`
+
+	// ICMP checks: the URL is the host to ping
+	PingCount     int     `json:"ping_count,omitempty"`      // echo requests per check, default 3
+	MaxPacketLoss float64 `json:"max_packet_loss,omitempty"` // percent; any loss below 100% only warns by default
+
+	// Merged services combine the results several agents report for the same service
+	MergeMode string `json:"merge_mode,omitempty"` // worst (default) or quorum
+	Quorum    int    `json:"quorum,omitempty"`     // failing probes needed to fail, default a majority
+}
+
+// HealthStatus represents the health status of a service
+type HealthStatus struct {
+	Name         string            `json:"name"`
+	URL          string            `json:"url"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	Tags         []string          `json:"tags,omitempty"`
+	Healthy      bool              `json:"healthy"`
+	ResponseTime int64             `json:"response_time_ms"`
+	LastChecked  time.Time         `json:"last_checked"`
+	Error        string            `json:"error,omitempty"`
+	ErrorInfo    *CheckError       `json:"error_info,omitempty"`      // the error as category, code, message and retryable
+	DNSError     string            `json:"dns_error,omitempty"`       // nxdomain, nodata, servfail, timeout or error
+	BotChallenge string            `json:"bot_challenge,omitempty"`   // CDN that answered the last check with a bot challenge
+	Throttled    bool              `json:"throttled,omitempty"`       // the target throttled the last check, with throttling set
+	RetryAt      *time.Time        `json:"throttled_until,omitempty"` // when the target's Retry-After lets it be checked again
+	TraceID      string            `json:"trace_id,omitempty"`        // trace of the last check, with tracing or propagation on
+	RequestID    string            `json:"request_id,omitempty"`      // request ID the last check sent, with propagation on
+	TLS          *TLSInfo          `json:"tls,omitempty"`
+	CertDays     *float64          `json:"tls_cert_days_remaining,omitempty"`
+	Ping         *PingStats        `json:"ping,omitempty"`
+	Canary       *CanaryResult     `json:"canary,omitempty"`
+	Warnings     []string          `json:"warnings,omitempty"`
+	ClockSkew    *float64          `json:"clock_skew_seconds,omitempty"`
+	DNS          *ResolvedHost     `json:"dns,omitempty"`                // with dns_watch on
+	Versions     map[string]string `json:"versions,omitempty"`           // by instance, with version_skew
+	SkewSince    *time.Time        `json:"version_skew_since,omitempty"` // since when instances disagree
+	Incident     *Incident         `json:"incident,omitempty"`
+	Paused       bool              `json:"paused,omitempty"`
+	Pending      bool              `json:"pending,omitempty"` // not checked yet
+	Stale        bool              `json:"stale,omitempty"`   // checks stopped reporting; last result may be outdated
+	Weight       float64           `json:"weight"`
+	Revision     uint64            `json:"revision"`                 // revision of the service's last change
+	Silenced     *time.Time        `json:"silenced_until,omitempty"` // alerts are muted until then
+	NextSilence  *UpcomingSilence  `json:"next_silence,omitempty"`   // next scheduled window within a week
+	Maintenance  bool              `json:"in_maintenance,omitempty"` // in a maintenance window
+	GraceUntil   *time.Time        `json:"grace_until,omitempty"`    // warming up; failures don't alert until then
+
+	// Raw check outcomes in a row, before failure_threshold and success_threshold apply
+	ConsecutiveFailures  int `json:"consecutive_failures"`
+	ConsecutiveSuccesses int `json:"consecutive_successes"`
+
+	// State changes within flap_window, for services with a flap_threshold
+	Flapping     bool        `json:"flapping,omitempty"`
+	StateChanges int         `json:"state_changes,omitempty"`
+	transitions  []time.Time // when each counted state change happened
+}
+
+// HealthChecker manages health checks for multiple services
+type HealthChecker struct {
+	services      map[string]Service
+	statuses      map[string]*HealthStatus
+	monitors      map[string]context.CancelFunc
+	monitorStarts map[string]time.Time
+	running       sync.WaitGroup  // monitor goroutines
+	ctx           context.Context // cancelled by Stop; monitors run under it
+	stop          context.CancelFunc
+	history       map[string][]CheckRecord
+	incidents     map[string][]*Incident // resolved incidents per service
+	counts        map[string]CheckCounts
+	events        *EventLog
+	silences      *SilenceStore
+	alerters      []Alerter
+	started       bool
+	startedAt     time.Time
+	ready         bool // every service has been checked at least once
+	// Startup checks still failing, with their errors; see RunStartupChecks
+	startupFailures map[string]string
+	revision        uint64
+	changes         chan struct{} // closed and replaced on every change
+	mu              sync.RWMutex
+
+	// Called after every local check, e.g. to upload results to an aggregator
+	onResult func(svc Service, result CheckResult, at time.Time)
+
+	// Resend down alerts this often while a service stays down, unless it sets
+	// its own renotify_interval; zero alerts once per incident
+	renotifyInterval time.Duration
+
+	// Limits how many checks run at once; nil runs every check when it's due
+	pool *checkPool
+
+	// Where results and events are also written, to survive restarts
+	store Store
+
+	// How discovered and assigned services checking the same target as a
+	// monitored one are handled: static, merge or error
+	duplicates string
+
+	// Label service metrics are aggregated by instead of written per service, if any
+	metricsGroupBy string
+
+	// Windows uptime is reported over by default, and their last counts
+	uptimeWindows []string
+	uptime        uptimeCache
+}
+
+var (
+	errServiceNotFound = errors.New("service not found")
+	errServiceExists   = errors.New("service already exists")
+)
+
+// NewHealthChecker creates a new health checker instance
+func NewHealthChecker(services []Service) *HealthChecker {
+	hc := &HealthChecker{
+		services:      make(map[string]Service),
+		statuses:      make(map[string]*HealthStatus),
+		monitors:      make(map[string]context.CancelFunc),
+		monitorStarts: make(map[string]time.Time),
+		history:       make(map[string][]CheckRecord),
+		incidents:     make(map[string][]*Incident),
+		counts:        make(map[string]CheckCounts),
+		events:        NewEventLog(),
+		silences:      NewSilenceStore(),
+		changes:       make(chan struct{}),
+		store:         memoryStore{},
+		uptimeWindows: defaultUptimeWindows,
+	}
+	hc.ctx, hc.stop = context.WithCancel(context.Background())
+
+	// Initialize status for each service
+	for _, svc := range services {
+		hc.services[svc.Name] = svc
+		hc.statuses[svc.Name] = &HealthStatus{Name: svc.Name, Pending: true}
+		syncServiceFields(hc.statuses[svc.Name], svc)
+	}
+
+	return hc
+}
+
+// syncServiceFields copies the descriptive fields of a service into its status
+func syncServiceFields(status *HealthStatus, svc Service) {
+	status.URL = redactor.Redact(svc.URL)
+	status.Labels = svc.Labels
+	status.Tags = svc.Tags
+	status.Paused = svc.Paused
+	status.Weight = svc.Weight
+}
+
+// Start begins monitoring all services
+func (hc *HealthChecker) Start() {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	hc.started = true
+	hc.startedAt = time.Now()
+	for _, svc := range hc.services {
+		hc.startMonitor(svc)
+	}
+	// Nothing to wait for without services, or with every status restored
+	if len(pendingServices(hc.statuses)) == 0 {
+		hc.ready = true
+	}
+	go hc.watchStale()
+}
+
+// Stop cancels every monitor and waits for them to return; checks still
+// running are abandoned and their results discarded. Services added or
+// resumed afterwards aren't monitored.
+func (hc *HealthChecker) Stop() {
+	hc.mu.Lock()
+	hc.started = false
+	hc.stop()
+	for name := range hc.monitors {
+		hc.stopMonitor(name)
+	}
+	hc.mu.Unlock()
+
+	hc.running.Wait()
+}
+
+// startMonitor launches the monitor goroutine for a service unless it is paused.
+// Services checked by agents, and merged ones, get no monitor, but their start is noted so they go
+// stale when the agent stops reporting. Must be called with hc.mu held.
+func (hc *HealthChecker) startMonitor(svc Service) {
+	if !hc.started || svc.Paused {
+		return
+	}
+	if svc.Type == "agent" || svc.Type == "merged" {
+		hc.monitorStarts[svc.Name] = time.Now()
+		return
+	}
+	ctx, cancel := context.WithCancel(hc.ctx)
+	hc.monitors[svc.Name] = cancel
+	hc.monitorStarts[svc.Name] = time.Now()
+	hc.running.Add(1)
+	go func() {
+		defer hc.running.Done()
+		hc.monitorService(ctx, svc)
+	}()
+}
+
+// stopMonitor cancels the monitor goroutine for a service, if any.
+// Must be called with hc.mu held.
+func (hc *HealthChecker) stopMonitor(name string) {
+	if cancel, ok := hc.monitors[name]; ok {
+		cancel()
+		delete(hc.monitors, name)
+	}
+	delete(hc.monitorStarts, name)
+}
+
+// Services returns the monitored services sorted by name
+func (hc *HealthChecker) Services() []Service {
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+
+	services := make([]Service, 0, len(hc.services))
+	for _, svc := range hc.services {
+		services = append(services, svc)
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].Name < services[j].Name })
+	return services
+}
+
+// GetService returns a monitored service by name
+func (hc *HealthChecker) GetService(name string) (Service, bool) {
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+
+	svc, ok := hc.services[name]
+	return svc, ok
+}
+
+// AddService starts monitoring a new service
+func (hc *HealthChecker) AddService(svc Service) error {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	if _, exists := hc.services[svc.Name]; exists {
+		return errServiceExists
+	}
+
+	hc.services[svc.Name] = svc
+	hc.statuses[svc.Name] = &HealthStatus{Name: svc.Name, Pending: true}
+	syncServiceFields(hc.statuses[svc.Name], svc)
+	hc.startMonitor(svc)
+
+	hc.changed(svc.Name)
+	slog.Info("service added", "service", svc.Name)
+	hc.events.Add(Event{Service: svc.Name, Type: EventConfigChanged, Message: "service added"})
+	return nil
+}
+
+// UpdateService replaces the definition of an existing service and restarts its monitor.
+// The last known status is kept until the next check.
+func (hc *HealthChecker) UpdateService(svc Service) error {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	if _, exists := hc.services[svc.Name]; !exists {
+		return errServiceNotFound
+	}
+
+	hc.stopMonitor(svc.Name)
+	hc.services[svc.Name] = svc
+	syncServiceFields(hc.statuses[svc.Name], svc)
+	hc.startMonitor(svc)
+
+	hc.changed(svc.Name)
+	slog.Info("service updated", "service", svc.Name)
+	hc.events.Add(Event{Service: svc.Name, Type: EventConfigChanged, Message: "service updated"})
+	return nil
+}
+
+// RemoveService stops monitoring a service and forgets its status
+func (hc *HealthChecker) RemoveService(name string) error {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	if _, exists := hc.services[name]; !exists {
+		return errServiceNotFound
+	}
+
+	hc.stopMonitor(name)
+	delete(hc.services, name)
+	delete(hc.statuses, name)
+	delete(hc.history, name)
+	delete(hc.incidents, name)
+	delete(hc.counts, name)
+
+	hc.changed(name)
+	slog.Info("service removed", "service", name)
+	hc.events.Add(Event{Service: name, Type: EventConfigChanged, Message: "service removed"})
+	return nil
+}
+
+// SetPaused pauses or resumes checks for a service
+func (hc *HealthChecker) SetPaused(name string, paused bool) error {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	svc, exists := hc.services[name]
+	if !exists {
+		return errServiceNotFound
+	}
+	if svc.Paused == paused {
+		return nil
+	}
+
+	svc.Paused = paused
+	hc.services[name] = svc
+	hc.statuses[name].Paused = paused
+	hc.changed(name)
+	if paused {
+		hc.stopMonitor(name)
+		slog.Info("service paused", "service", name)
+		hc.events.Add(Event{Service: name, Type: EventConfigChanged, Message: "checks paused"})
+	} else {
+		hc.startMonitor(svc)
+		slog.Info("service resumed", "service", name)
+		hc.events.Add(Event{Service: name, Type: EventConfigChanged, Message: "checks resumed"})
+	}
+	return nil
+}
+
+// monitorRestartDelay is how long a monitor waits before restarting after a panic
+const monitorRestartDelay = time.Second
+
+// monitorService continuously checks a single service until ctx is cancelled.
+// If it panics, the panic is logged and the monitor restarts.
+func (hc *HealthChecker) monitorService(ctx context.Context, svc Service) {
+	defer func() {
+		if r := recover(); r != nil {
+			hc.countPanic(svc.Name)
+			slog.Error("monitor panicked, restarting", "service", svc.Name, "panic", fmt.Sprint(r), "stack", string(debug.Stack()))
+			hc.running.Add(1)
+			go func() {
+				defer hc.running.Done()
+				select {
+				case <-ctx.Done():
+				case <-time.After(monitorRestartDelay):
+					hc.monitorService(ctx, svc)
+				}
+			}()
+		}
+	}()
+
+	ticker := time.NewTicker(time.Duration(svc.Interval))
+	defer ticker.Stop()
+
+	// Check immediately, unless the target is still throttling from before a
+	// restart
+	if wait := hc.throttledFor(svc.Name, time.Now()); wait > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		ticker.Reset(time.Duration(svc.Interval))
+	}
+	hc.checkService(ctx, svc)
+
+	for {
+		// A throttled target is left alone for as long as its Retry-After asks
+		if wait := hc.throttledFor(svc.Name, time.Now()); wait > time.Duration(svc.Interval) {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+			ticker.Reset(time.Duration(svc.Interval))
+			hc.checkService(ctx, svc)
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			hc.checkService(ctx, svc)
+		}
+	}
+}
+
+// CheckResult is the outcome of a single health check
+type CheckResult struct {
+	Healthy      bool
+	ResponseTime int64
+	Error        string
+	ErrorInfo    *CheckError // the error's category, code and whether it's retryable
+	TLS          *TLSInfo
+	Ping         *PingStats
+	Canary       *CanaryResult
+	Warnings     []string
+	ClockSkew    *float64
+	DNSError     string            // kind of DNS failure the check failed on, if any
+	BotChallenge string            // CDN that answered with a bot challenge, if any
+	Throttled    bool              // the target answered 429, or 503 with Retry-After
+	RetryAfter   time.Duration     // how long the target asked to wait, when throttled
+	TraceID      string            // when the check was traced or propagated its IDs
+	RequestID    string            // sent with the check's requests, with propagation on
+	Versions     map[string]string // by instance, with version_skew
+}
+
+// checkService performs a single health check, first waiting for a slot when the
+// number of checks at once is limited. Results are discarded if the monitor was
+// stopped while the check was running.
+func (hc *HealthChecker) checkService(monitorCtx context.Context, svc Service) {
+	probe, ok := probes[svc.Type]
+	if !ok {
+		message := fmt.Sprintf("unknown check type %q", svc.Type)
+		hc.updateStatus(svc.Name, CheckResult{Error: message,
+			ErrorInfo: &CheckError{Category: ErrCategoryInternal, Code: "unknown_type", Message: message}})
+		return
+	}
+
+	if hc.pool != nil {
+		release, err := hc.pool.acquire(monitorCtx, svc)
+		if err != nil {
+			return
+		}
+		defer release()
+	}
+
+	ctx, span := tracer.startCheck(monitorCtx, svc)
+	ctx, ids := startPropagation(ctx, svc, span)
+	var result CheckResult
+	var err error
+	attempts := 0
+	for {
+		result = CheckResult{}
+		err = hc.attemptCheck(ctx, probe, svc, &result)
+		attempt := attempts
+		attempts++
+		// A throttled target isn't retried, only checked again once its Retry-After is up
+		if err == nil || attempt >= svc.Retries || monitorCtx.Err() != nil || classifyThrottling(err) != nil {
+			break
+		}
+		delay := retryDelay(svc, attempt)
+		slog.Warn("check attempt failed, retrying", "service", svc.Name, "attempt", attempt+1, "attempts", svc.Retries+1,
+			"retry_in", delay.String(), "error", redactor.Redact(err.Error()))
+		hc.countRetry(svc.Name)
+		select {
+		case <-monitorCtx.Done():
+		case <-time.After(delay):
+		}
+	}
+
+	if monitorCtx.Err() != nil {
+		span.finishCheck(CheckResult{Error: "check cancelled"}, attempts)
+		return
+	}
+
+	if err != nil {
+		result.Error = redactor.Redact(err.Error())
+		result.ErrorInfo = classifyError(err, result.Error)
+		result.DNSError = classifyDNSError(err)
+		result.BotChallenge = classifyBotChallenge(err)
+		if throttled := classifyThrottling(err); throttled != nil {
+			result.Throttled, result.RetryAfter = true, throttled.retryAfter
+		}
+	} else {
+		result.Healthy = true
+	}
+	result.Warnings = redactor.RedactAll(result.Warnings)
+	result.TraceID = span.traceID()
+	if ids != nil {
+		result.TraceID, result.RequestID = ids.traceID(), ids.requestID()
+	}
+	span.finishCheck(result, attempts)
+
+	at := time.Now()
+	hc.updateStatusAt(svc.Name, result, at)
+	if hc.onResult != nil {
+		hc.onResult(svc, result, at)
+	}
+}
+
+// maxRetries caps the retries of a single check
+const maxRetries = 10
+
+// defaultRetryBackoff is the wait before the first retry when retry_backoff is unset
+const defaultRetryBackoff = time.Second
+
+// retryDelay returns the wait before retrying after the given attempt (0-based),
+// doubling with each retry
+func retryDelay(svc Service, attempt int) time.Duration {
+	delay := time.Duration(svc.RetryBackoff)
+	if delay == 0 {
+		delay = defaultRetryBackoff
+	}
+	return delay << attempt
+}
+
+// maxCheckDuration is the longest a check can take, with every retry timing out
+func maxCheckDuration(svc Service) time.Duration {
+	d := time.Duration(svc.Timeout) * time.Duration(svc.Retries+1)
+	for attempt := 0; attempt < svc.Retries; attempt++ {
+		d += retryDelay(svc, attempt)
+	}
+	return d
+}
+
+// attemptCheck runs one attempt of a check within the service's timeout
+func (hc *HealthChecker) attemptCheck(monitorCtx context.Context, probe probeFunc, svc Service, result *CheckResult) error {
+	ctx, cancel := context.WithTimeout(monitorCtx, time.Duration(svc.Timeout))
+	defer cancel()
+
+	start := time.Now()
+	err := hc.runProbe(ctx, probe, svc, result)
+	result.ResponseTime = time.Since(start).Milliseconds()
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		err = &checkTimeout{err: err}
+	}
+	if result.Ping != nil {
+		// A ping check takes as long as its requests are spread; report the RTT instead
+		result.ResponseTime = int64(math.Round(result.Ping.RTTAvgMs))
+	}
+	if result.Canary != nil {
+		// Both targets are requested at once; report the canary's own latency
+		result.ResponseTime = result.Canary.CanaryMs
+	}
+	return err
+}
+
+// runProbe calls a probe, turning a panic into a check error
+func (hc *HealthChecker) runProbe(ctx context.Context, probe probeFunc, svc Service, result *CheckResult) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			hc.countPanic(svc.Name)
+			slog.Warn("recovered probe panic", "service", svc.Name, "panic", fmt.Sprint(r), "stack", string(debug.Stack()))
+			err = fmt.Errorf("%w: %v", errProbePanicked, r)
+		}
+	}()
+	return probe(ctx, svc, result)
+}
+
+// countRetry records a retried check attempt for a service
+func (hc *HealthChecker) countRetry(name string) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	counts := hc.counts[name]
+	counts.Retries++
+	hc.counts[name] = counts
+}
+
+// countPanic records a recovered panic for a service
+func (hc *HealthChecker) countPanic(name string) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	counts := hc.counts[name]
+	counts.Panics++
+	hc.counts[name] = counts
+}
+
+// updateStatus updates the status of a service with a result from now
+func (hc *HealthChecker) updateStatus(name string, result CheckResult) {
+	hc.updateStatusAt(name, result, time.Now())
+}
+
+// updateStatusAt updates the status of a service with a result checked at the
+// given time. A result older than the current status, such as one an agent
+// buffered while offline, only goes into history and the check counts.
+func (hc *HealthChecker) updateStatusAt(name string, result CheckResult, at time.Time) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	hc.applyResult(name, result, at)
+}
+
+// applyResult is updateStatusAt with hc.mu held. A result for a member of a merged
+// service also re-evaluates the merged service, and only the merged service
+// tracks incidents.
+func (hc *HealthChecker) applyResult(name string, result CheckResult, at time.Time) {
+	if status, exists := hc.statuses[name]; exists {
+		maintenance := hc.inMaintenance(name, status, at)
+		if at.Before(status.LastChecked) {
+			hc.countCheck(name, result, maintenance)
+			hc.recordHistory(name, CheckRecord{Time: at, Healthy: result.Healthy, ResponseTime: result.ResponseTime,
+				Error: result.Error, Maintenance: maintenance, TraceID: result.TraceID, RequestID: result.RequestID})
+			return
+		}
+
+		raw := result
+		result, skipped := holdThrottled(hc.services[name], status, raw)
+		if !skipped {
+			status.countStreak(raw.Healthy)
+			result = holdTransition(hc.services[name], status, raw)
+		}
+		transition := !status.Pending && status.Healthy != result.Healthy
+		previous := "down"
+		if status.Pending {
+			previous = "pending"
+		} else if status.Healthy {
+			previous = "up"
+		}
+		hc.trackFlapping(hc.services[name], status, transition, at)
+		if transition {
+			counts := hc.counts[name]
+			counts.Transitions++
+			hc.counts[name] = counts
+		}
+
+		hc.trackVersionSkew(hc.services[name], status, &result, at)
+		if status.Pending || status.Stale || status.Healthy != result.Healthy || status.Error != result.Error ||
+			status.Throttled != result.Throttled || !slices.Equal(status.Warnings, result.Warnings) {
+			hc.changed(name)
+		}
+		hc.trackThrottling(status, raw, at)
+		status.Healthy = result.Healthy
+		status.ResponseTime = result.ResponseTime
+		status.LastChecked = at
+		status.Error = result.Error
+		status.ErrorInfo = result.ErrorInfo
+		if status.Error != "" && status.ErrorInfo == nil {
+			// From an agent that doesn't classify its errors
+			status.ErrorInfo = &CheckError{Category: ErrCategoryOther, Code: "error", Message: status.Error}
+		} else if status.ErrorInfo != nil && !slices.Contains(errorCategories, status.ErrorInfo.Category) {
+			// From an agent with categories this version doesn't know
+			info := *status.ErrorInfo
+			info.Category = ErrCategoryOther
+			status.ErrorInfo = &info
+		}
+		status.DNSError = result.DNSError
+		status.BotChallenge = result.BotChallenge
+		status.TraceID = result.TraceID
+		status.RequestID = result.RequestID
+		status.Warnings = result.Warnings
+		status.ClockSkew = result.ClockSkew
+		status.Ping = result.Ping
+		status.Canary = result.Canary
+		status.Pending = false
+		if status.Stale {
+			status.Stale = false
+			slog.Info("checks reporting again", "service", name)
+		}
+
+		if result.TLS != nil {
+			days := certDaysRemaining(result.TLS.NotAfter, at)
+			status.CertDays = &days
+			status.TLS = trackTLSChange(name, status.TLS, result.TLS, status.LastChecked)
+			if status.TLS.ChangedAt != nil && status.TLS.ChangedAt.Equal(status.LastChecked) {
+				message := fmt.Sprintf("certificate changed: issuer %s -> %s, SPKI SHA-256 %s -> %s",
+					status.TLS.PreviousIssuer, status.TLS.Issuer, status.TLS.PreviousSPKISHA256, status.TLS.SPKISHA256)
+				hc.events.Add(Event{Time: status.LastChecked, Service: name, Type: EventCertChanged, Message: message})
+				hc.warn(status, EventCertChanged, message, status.LastChecked)
+			}
+		}
+
+		// Counts and history keep every check's own outcome
+		hc.countCheck(name, raw, maintenance)
+		hc.recordHistory(name, CheckRecord{
+			Time:         status.LastChecked,
+			Healthy:      raw.Healthy,
+			ResponseTime: raw.ResponseTime,
+			Error:        raw.Error,
+			Maintenance:  maintenance,
+			TraceID:      raw.TraceID,
+			RequestID:    raw.RequestID,
+		})
+
+		// Passing checks are logged at debug level unless the service just came up
+		state := "down"
+		if result.Healthy {
+			state = "up"
+		}
+		attrs := []interface{}{"service", name, "latency_ms", result.ResponseTime, "state", state}
+		if previous != state {
+			attrs = append(attrs, "previous", previous)
+		}
+		if result.TraceID != "" {
+			attrs = append(attrs, "trace_id", result.TraceID)
+		}
+		if result.RequestID != "" {
+			attrs = append(attrs, "request_id", result.RequestID)
+		}
+		switch {
+		case !result.Healthy:
+			slog.Warn("check failed", append(attrs, "error", result.Error)...)
+		case previous != state:
+			slog.Info("check passed", attrs...)
+		default:
+			slog.Debug("check passed", attrs...)
+		}
+		for _, warning := range result.Warnings {
+			slog.Warn("check warning", "service", name, "warning", warning)
+		}
+
+		parent := status.Labels[mergedIntoLabel]
+		if parent == "" {
+			hc.trackIncident(status, status.LastChecked)
+		} else if _, ok := hc.statuses[parent]; ok {
+			hc.applyResult(parent, hc.mergeMembers(parent), at)
+		}
+
+		if hc.started && !hc.ready && len(pendingServices(hc.statuses)) == 0 {
+			hc.ready = true
+			slog.Info("all services checked", "since_start", time.Since(hc.startedAt).Round(time.Millisecond).String())
+		}
+	}
+}
+
+// countCheck adds a result to a service's check counts. Failures during a
+// maintenance window are counted apart, so they don't count against uptime.
+// Must be called with hc.mu held.
+func (hc *HealthChecker) countCheck(name string, result CheckResult, maintenance bool) {
+	counts := hc.counts[name]
+	counts.Checks++
+	counts.Duration.observe(result.ResponseTime)
+	switch {
+	case result.Healthy:
+	case maintenance:
+		counts.MaintenanceFailures++
+	default:
+		counts.Failures++
+	}
+	if result.BotChallenge != "" {
+		counts.BotChallenges++
+	}
+	if result.Throttled {
+		counts.Throttled++
+	}
+	if result.DNSError != "" {
+		// Copied on write, so snapshots from Counts never change
+		dns := maps.Clone(counts.DNSFailures)
+		if dns == nil {
+			dns = make(map[string]int64)
+		}
+		dns[result.DNSError]++
+		counts.DNSFailures = dns
+	}
+	hc.counts[name] = counts
+}
+
+// GetStatuses returns current status of all services
+func (hc *HealthChecker) GetStatuses() map[string]*HealthStatus {
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+
+	// Create a copy to avoid race conditions
+	now := time.Now()
+	result := make(map[string]*HealthStatus)
+	for k, v := range hc.statuses {
+		status := *v
+		if until := hc.silencedUntil(v, now); !until.IsZero() {
+			status.Silenced = &until
+		}
+		status.Maintenance = hc.inMaintenance(k, v, now)
+		if until := hc.graceUntil(k); now.Before(until) {
+			status.GraceUntil = &until
+		}
+		status.NextSilence = hc.nextScheduledSilence(k, v, now)
+		result[k] = &status
+	}
+	return result
+}
@@ -0,0 +1,153 @@
+// checkerrors.go
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os/exec"
+	"syscall"
+)
+
+// Categories of check failure, so automation can branch on the kind of failure
+// rather than parse the message
+const (
+	ErrCategoryDNS          = "dns"           // the target's name didn't resolve
+	ErrCategoryConnection   = "connection"    // refused, reset, unreachable or closed early
+	ErrCategoryTimeout      = "timeout"       // no answer within the check's timeout
+	ErrCategoryTLS          = "tls"           // handshake, verification, pins or expiry
+	ErrCategoryHTTPStatus   = "http_status"   // an unexpected status code
+	ErrCategoryThrottled    = "throttled"     // the target answered 429, or 503 with Retry-After, with throttling set
+	ErrCategoryAssertion    = "assertion"     // the response didn't match the body or JSON assertions
+	ErrCategoryBotChallenge = "bot_challenge" // a CDN answered instead of the service
+	ErrCategoryHeartbeat    = "heartbeat"     // a heartbeat service stopped pinging
+	ErrCategoryInternal     = "internal"      // the checker itself failed, e.g. a probe panicked
+	ErrCategoryOther        = "other"
+)
+
+// errorCategories are the values error_info.category may take
+var errorCategories = []string{ErrCategoryDNS, ErrCategoryConnection, ErrCategoryTimeout, ErrCategoryTLS, ErrCategoryHTTPStatus,
+	ErrCategoryThrottled, ErrCategoryAssertion, ErrCategoryBotChallenge, ErrCategoryHeartbeat, ErrCategoryInternal, ErrCategoryOther}
+
+// CheckError is the structured form of a failed check's error, next to the
+// error string kept for existing consumers
+type CheckError struct {
+	Category  string `json:"category"`
+	Code      string `json:"code"` // finer kind within the category, e.g. nxdomain, connection_refused or http_503
+	Message   string `json:"message"`
+	Retryable bool   `json:"retryable"` // likely to pass if checked again soon, as a timeout might
+}
+
+// httpStatusError is an HTTP response with a status code the check didn't expect
+type httpStatusError struct {
+	code int
+}
+
+func (e *httpStatusError) Error() string { return fmt.Sprintf("HTTP %d", e.code) }
+
+// assertionError is a response that failed a body or JSON assertion
+type assertionError struct {
+	code string // body, json or records
+	err  error
+}
+
+func (e *assertionError) Error() string { return e.err.Error() }
+func (e *assertionError) Unwrap() error { return e.err }
+
+// certError is a certificate that failed a pin or expiry check
+type certError struct {
+	code string // issuer_mismatch, pin_mismatch or expiring
+	msg  string
+}
+
+func (e *certError) Error() string { return e.msg }
+
+// heartbeatMissed is a heartbeat service that didn't ping in time
+type heartbeatMissed struct {
+	msg string
+}
+
+func (e *heartbeatMissed) Error() string { return e.msg }
+
+// checkTimeout is a probe error that happened because the check timed out
+type checkTimeout struct {
+	err error
+}
+
+func (e *checkTimeout) Error() string { return e.err.Error() }
+func (e *checkTimeout) Unwrap() error { return e.err }
+
+// errProbePanicked is wrapped by the error of a check whose probe panicked
+var errProbePanicked = errors.New("probe panicked")
+
+// classifyError describes a check error; message is its redacted text
+func classifyError(err error, message string) *CheckError {
+	e := &CheckError{Category: ErrCategoryOther, Code: "error", Message: message}
+
+	var (
+		statusErr  *httpStatusError
+		assertErr  *assertionError
+		certErr    *certError
+		missed     *heartbeatMissed
+		challenge  *botChallenge
+		throttled  *throttledError
+		timeout    *checkTimeout
+		unknownCA  x509.UnknownAuthorityError
+		hostname   x509.HostnameError
+		invalid    x509.CertificateInvalidError
+		tlsAlert   tls.AlertError
+		recordErr  tls.RecordHeaderError
+		netErr     net.Error
+		exitErr    *exec.ExitError
+		dnsFailure = classifyDNSError(err)
+	)
+	switch {
+	case errors.Is(err, errProbePanicked):
+		e.Category, e.Code = ErrCategoryInternal, "probe_panicked"
+	case dnsFailure != "":
+		e.Category, e.Code = ErrCategoryDNS, dnsFailure
+		e.Retryable = dnsFailure != DNSNXDomain && dnsFailure != DNSNoData
+	case errors.As(err, &challenge):
+		e.Category, e.Code = ErrCategoryBotChallenge, challenge.vendor
+	case errors.As(err, &throttled):
+		e.Category, e.Code = ErrCategoryThrottled, fmt.Sprintf("http_%d", throttled.status)
+		e.Retryable = true
+	case errors.As(err, &statusErr):
+		e.Category, e.Code = ErrCategoryHTTPStatus, fmt.Sprintf("http_%d", statusErr.code)
+		e.Retryable = statusErr.code >= 500 || statusErr.code == 429 || statusErr.code == 408
+	case errors.As(err, &assertErr):
+		e.Category, e.Code = ErrCategoryAssertion, assertErr.code
+	case errors.As(err, &certErr):
+		e.Category, e.Code = ErrCategoryTLS, certErr.code
+	case errors.As(err, &unknownCA):
+		e.Category, e.Code = ErrCategoryTLS, "unknown_authority"
+	case errors.As(err, &hostname):
+		e.Category, e.Code = ErrCategoryTLS, "hostname_mismatch"
+	case errors.As(err, &invalid):
+		e.Category, e.Code = ErrCategoryTLS, "certificate_invalid"
+		if invalid.Reason == x509.Expired {
+			e.Code = "certificate_expired"
+		}
+	case errors.As(err, &tlsAlert), errors.As(err, &recordErr):
+		e.Category, e.Code = ErrCategoryTLS, "handshake"
+	case errors.As(err, &missed):
+		e.Category, e.Code = ErrCategoryHeartbeat, "missed"
+		e.Retryable = true
+	case errors.As(err, &timeout), errors.As(err, &netErr) && netErr.Timeout():
+		e.Category, e.Code, e.Retryable = ErrCategoryTimeout, "timeout", true
+	case errors.Is(err, syscall.ECONNREFUSED):
+		e.Category, e.Code, e.Retryable = ErrCategoryConnection, "connection_refused", true
+	case errors.Is(err, syscall.ECONNRESET):
+		e.Category, e.Code, e.Retryable = ErrCategoryConnection, "connection_reset", true
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		e.Category, e.Code, e.Retryable = ErrCategoryConnection, "unreachable", true
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		e.Category, e.Code, e.Retryable = ErrCategoryConnection, "connection_closed", true
+	case errors.As(err, &exitErr):
+		e.Code = "exit_status"
+	}
+	return e
+}
@@ -0,0 +1,809 @@
+// config.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Duration is a time.Duration that reads and writes as a string like "30s"
+type Duration time.Duration
+
+// UnmarshalJSON accepts a duration string ("1m30s") or a number of seconds
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	switch value := v.(type) {
+	case string:
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*d = Duration(parsed)
+	case float64:
+		*d = Duration(value * float64(time.Second))
+	default:
+		return fmt.Errorf("invalid duration %s", data)
+	}
+	return nil
+}
+
+// MarshalJSON writes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// builtinDefaults are applied before the config file's own defaults section
+var builtinDefaults = Service{
+	Interval: Duration(30 * time.Second),
+	Timeout:  Duration(5 * time.Second),
+	Weight:   1,
+}
+
+// Config is the configuration file format
+type Config struct {
+	// Defaults holds settings applied to every service unless the service overrides them.
+	// Any Service field may be set here except name and url.
+	Defaults  Service          `json:"defaults"`
+	Services  []Service        `json:"services"`
+	Dashboard DashboardConfig  `json:"dashboard"`
+	Notifiers []NotifierConfig `json:"notifiers,omitempty"`
+	Digests   []DigestConfig   `json:"digests,omitempty"`
+	Alerting  AlertingConfig   `json:"alerting"`
+
+	// Keys that heartbeat senders and deploy tooling sign requests with
+	InboundKeys []InboundKey `json:"inbound_keys,omitempty"`
+
+	// Browse the local network for services to monitor
+	MDNS *MDNSConfig `json:"mdns,omitempty"`
+
+	// Watch a Kubernetes cluster for annotated objects to monitor
+	Kubernetes *KubernetesConfig `json:"kubernetes,omitempty"`
+
+	// Resolve every service's host in the background and flag address changes
+	DNSWatch *DNSWatchConfig `json:"dns_watch,omitempty"`
+
+	// Deployment gates, checked by CD pipelines before a rollout
+	Gates []GateConfig `json:"gates,omitempty"`
+
+	// Recurring windows muting alerts for the services their selectors match
+	SilenceSchedules []SilenceSchedule `json:"silence_schedules,omitempty"`
+
+	// Remote probe agents allowed to upload results, and the services pushed to them
+	Agents      []AgentConfig `json:"agents,omitempty"`
+	Assignments []Assignment  `json:"assignments,omitempty"`
+
+	// Where results, events, state and services added through the API are kept
+	Storage StorageConfig `json:"storage"`
+
+	// Windows uptime is reported over in /api/uptime and service_uptime_ratio;
+	// default 1h, 24h, 7d and 30d
+	UptimeWindows []string `json:"uptime_windows,omitempty"`
+
+	// How many checks may run at once; unset runs every check when it's due
+	MaxConcurrentChecks int `json:"max_concurrent_checks,omitempty"`
+
+	// Hosts checks may and may not be pointed at, enforced for configured,
+	// assigned, discovered and API-managed services
+	Targets TargetPolicy `json:"targets"`
+
+	// How services checking the same target as another are handled: static
+	// (default), merge or error
+	Duplicates string `json:"duplicates,omitempty"`
+
+	// Export a trace of every check to an OpenTelemetry collector
+	Tracing *TracingConfig `json:"tracing,omitempty"`
+
+	// Headers with IDs every HTTP check sends its target; off when unset
+	Propagation *PropagationConfig `json:"propagation,omitempty"`
+
+	// Series limits and aggregation for /metrics
+	Metrics MetricsConfig `json:"metrics"`
+
+	// Secret values to mask in logs, check errors and the API, in addition to the
+	// credentials configured above and common credential patterns
+	Redact []string `json:"redact,omitempty"`
+
+	rawDefaults json.RawMessage
+}
+
+// UnmarshalJSON decodes each service on top of the defaults, so fields a service doesn't
+// mention keep the default value while explicit values (even false or zero) override it.
+// The defaults are decoded afresh for every service so no slices or maps are shared.
+func (c *Config) UnmarshalJSON(data []byte) error {
+	raw := struct {
+		Defaults  json.RawMessage    `json:"defaults"`
+		Services  []json.RawMessage  `json:"services"`
+		Dashboard DashboardConfig    `json:"dashboard"`
+		Notifiers []NotifierConfig   `json:"notifiers"`
+		Digests   []DigestConfig     `json:"digests"`
+		Alerting  AlertingConfig     `json:"alerting"`
+		Inbound   []InboundKey       `json:"inbound_keys"`
+		MDNS      *MDNSConfig        `json:"mdns"`
+		K8s       *KubernetesConfig  `json:"kubernetes"`
+		DNSWatch  *DNSWatchConfig    `json:"dns_watch"`
+		Schedules []SilenceSchedule  `json:"silence_schedules"`
+		Gates     []GateConfig       `json:"gates"`
+		Agents    []AgentConfig      `json:"agents"`
+		Redact    []string           `json:"redact"`
+		MaxChecks int                `json:"max_concurrent_checks"`
+		Storage   StorageConfig      `json:"storage"`
+		Uptime    []string           `json:"uptime_windows"`
+		Targets   TargetPolicy       `json:"targets"`
+		Tracing   *TracingConfig     `json:"tracing"`
+		Propagate *PropagationConfig `json:"propagation"`
+		Dupes     string             `json:"duplicates"`
+		Metrics   MetricsConfig      `json:"metrics"`
+
+		Assignments []struct {
+			Selector map[string]string `json:"selector"`
+			Agents   []string          `json:"agents"`
+			Services []json.RawMessage `json:"services"`
+		} `json:"assignments"`
+	}{Dashboard: defaultDashboard}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	c.Dashboard = raw.Dashboard
+	c.Notifiers = raw.Notifiers
+	c.Digests = raw.Digests
+	c.Alerting = raw.Alerting
+	c.InboundKeys = raw.Inbound
+	c.MDNS = raw.MDNS
+	c.Kubernetes = raw.K8s
+	c.DNSWatch = raw.DNSWatch
+	c.SilenceSchedules = raw.Schedules
+	c.Gates = raw.Gates
+	c.Agents = raw.Agents
+	c.Redact = raw.Redact
+	c.MaxConcurrentChecks = raw.MaxChecks
+	c.Storage = raw.Storage
+	c.UptimeWindows = raw.Uptime
+	c.Targets = raw.Targets
+	c.Tracing = raw.Tracing
+	c.Propagation = raw.Propagate
+	c.Duplicates = raw.Dupes
+	c.Metrics = raw.Metrics
+
+	c.rawDefaults = raw.Defaults
+	var err error
+	if c.Defaults, err = c.decodeDefaults(); err != nil {
+		return err
+	}
+
+	c.Services = make([]Service, 0, len(raw.Services))
+	for i, rawSvc := range raw.Services {
+		svc, err := c.NewService(rawSvc)
+		if err != nil {
+			return fmt.Errorf("services[%d]: %w", i, err)
+		}
+		c.Services = append(c.Services, svc)
+	}
+
+	// Assigned services take the aggregator's defaults, so agents need none
+	c.Assignments = make([]Assignment, 0, len(raw.Assignments))
+	for i, rawAssignment := range raw.Assignments {
+		a := Assignment{Selector: rawAssignment.Selector, Agents: rawAssignment.Agents}
+		for j, rawSvc := range rawAssignment.Services {
+			svc, err := c.NewService(rawSvc)
+			if err != nil {
+				return fmt.Errorf("assignments[%d].services[%d]: %w", i, j, err)
+			}
+			a.Services = append(a.Services, svc)
+		}
+		c.Assignments = append(c.Assignments, a)
+	}
+	return nil
+}
+
+// decodeDefaults returns a fresh copy of the effective defaults
+func (c *Config) decodeDefaults() (Service, error) {
+	svc := builtinDefaults
+	if len(c.rawDefaults) > 0 {
+		if err := json.Unmarshal(c.rawDefaults, &svc); err != nil {
+			return svc, fmt.Errorf("defaults: %w", err)
+		}
+	}
+	return svc, nil
+}
+
+// NewService decodes a single service definition on top of the config's defaults
+func (c *Config) NewService(data []byte) (Service, error) {
+	svc, err := c.decodeDefaults()
+	if err != nil {
+		return svc, err
+	}
+	err = json.Unmarshal(data, &svc)
+	return svc, err
+}
+
+// ValidationError describes a single problem found in a config
+type ValidationError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e ValidationError) Error() string {
+	if e.Field == "" {
+		return e.Message
+	}
+	return e.Field + ": " + e.Message
+}
+
+// Validate checks the config for problems that would prevent it from running
+func (c *Config) Validate() []ValidationError {
+	var errs []ValidationError
+	seen := make(map[string]bool)
+
+	for i, svc := range c.Services {
+		field := fmt.Sprintf("services[%d]", i)
+		if seen[svc.Name] {
+			errs = append(errs, ValidationError{Field: field + ".name", Message: fmt.Sprintf("duplicate service name %q", svc.Name)})
+		}
+		seen[svc.Name] = true
+
+		errs = append(errs, validateService(svc, field+".")...)
+		errs = append(errs, c.Targets.ValidateService(svc, field+".")...)
+	}
+	if c.Duplicates != "" && !containsString(duplicateModes, c.Duplicates) {
+		errs = append(errs, ValidationError{Field: "duplicates", Message: "must be one of " + strings.Join(duplicateModes, ", ")})
+	}
+	if c.Duplicates == "error" {
+		errs = append(errs, validateDuplicates(c.Services)...)
+	}
+
+	notifiers := make(map[string]string) // name to type
+	for i, n := range c.Notifiers {
+		field := fmt.Sprintf("notifiers[%d]", i)
+		if notifiers[n.Name] != "" {
+			errs = append(errs, ValidationError{Field: field + ".name", Message: fmt.Sprintf("duplicate notifier name %q", n.Name)})
+		}
+		notifiers[n.Name] = n.Type
+		errs = append(errs, validateNotifier(n, field+".")...)
+	}
+
+	keys := make(map[string]bool)
+	for i, k := range c.InboundKeys {
+		field := fmt.Sprintf("inbound_keys[%d]", i)
+		if keys[k.Name] {
+			errs = append(errs, ValidationError{Field: field + ".name", Message: fmt.Sprintf("duplicate key name %q", k.Name)})
+		}
+		keys[k.Name] = true
+		errs = append(errs, validateInboundKey(k, field+".")...)
+	}
+
+	agents := make(map[string]bool)
+	tokens := make(map[string]bool)
+	for i, a := range c.Agents {
+		field := fmt.Sprintf("agents[%d]", i)
+		if agents[a.Name] {
+			errs = append(errs, ValidationError{Field: field + ".name", Message: fmt.Sprintf("duplicate agent name %q", a.Name)})
+		}
+		if tokens[a.Token] {
+			errs = append(errs, ValidationError{Field: field + ".token", Message: "token is shared with another agent"})
+		}
+		agents[a.Name] = true
+		tokens[a.Token] = true
+		errs = append(errs, validateAgent(a, field+".")...)
+	}
+	errs = append(errs, validateAssignments(c.Assignments, c.Agents)...)
+	errs = append(errs, c.Targets.Validate()...)
+	for i, a := range c.Assignments {
+		for j, svc := range a.Services {
+			errs = append(errs, c.Targets.ValidateService(svc, fmt.Sprintf("assignments[%d].services[%d].", i, j))...)
+		}
+	}
+
+	errs = append(errs, c.Storage.Validate()...)
+	errs = append(errs, c.Metrics.Validate()...)
+	if c.Tracing != nil {
+		errs = append(errs, c.Tracing.Validate()...)
+	}
+	if c.Propagation != nil {
+		errs = append(errs, c.Propagation.Validate()...)
+	}
+	for i, window := range c.UptimeWindows {
+		if _, err := parseWindow(window); err != nil {
+			errs = append(errs, ValidationError{Field: fmt.Sprintf("uptime_windows[%d]", i), Message: err.Error()})
+		}
+	}
+	if c.MaxConcurrentChecks < 0 {
+		errs = append(errs, ValidationError{Field: "max_concurrent_checks", Message: "must not be negative"})
+	}
+	if c.Alerting.RenotifyInterval < 0 {
+		errs = append(errs, ValidationError{Field: "alerting.renotify_interval", Message: "must not be negative"})
+	}
+	if !validLanguage(c.Alerting.Language) {
+		errs = append(errs, ValidationError{Field: "alerting.language", Message: "must be one of " + strings.Join(languages, ", ")})
+	}
+	for i, name := range c.Alerting.Notifiers {
+		if notifiers[name] == "" {
+			errs = append(errs, ValidationError{Field: fmt.Sprintf("alerting.notifiers[%d]", i), Message: fmt.Sprintf("unknown notifier %q", name)})
+		}
+	}
+	for _, kind := range slices.Sorted(maps.Keys(c.Alerting.DNSRoutes)) {
+		names := c.Alerting.DNSRoutes[kind]
+		field := "alerting.dns_routes." + kind
+		if !containsString(dnsFailureKinds, kind) {
+			errs = append(errs, ValidationError{Field: field, Message: "must be one of " + strings.Join(dnsFailureKinds, ", ")})
+		}
+		for i, name := range names {
+			if notifiers[name] == "" {
+				errs = append(errs, ValidationError{Field: fmt.Sprintf("%s[%d]", field, i), Message: fmt.Sprintf("unknown notifier %q", name)})
+			}
+		}
+	}
+	for i, svc := range c.Services {
+		errs = append(errs, c.validateNotifierRefs(svc, fmt.Sprintf("services[%d].", i))...)
+	}
+	for i, d := range c.Digests {
+		field := fmt.Sprintf("digests[%d]", i)
+		switch notifiers[d.Notifier] {
+		case "":
+			errs = append(errs, ValidationError{Field: field + ".notifier", Message: fmt.Sprintf("unknown notifier %q", d.Notifier)})
+		case "pagerduty":
+			errs = append(errs, ValidationError{Field: field + ".notifier", Message: "pagerduty notifiers only take alerts"})
+		}
+		errs = append(errs, d.Validate(field+".")...)
+	}
+	if c.MDNS != nil {
+		errs = append(errs, c.MDNS.Validate("mdns.")...)
+	}
+	if c.Kubernetes != nil {
+		errs = append(errs, c.Kubernetes.Validate()...)
+	}
+	if c.DNSWatch != nil {
+		errs = append(errs, c.DNSWatch.Validate()...)
+	}
+	gates := make(map[string]bool)
+	for i, g := range c.Gates {
+		field := fmt.Sprintf("gates[%d]", i)
+		if gates[g.Name] {
+			errs = append(errs, ValidationError{Field: field + ".name", Message: fmt.Sprintf("duplicate gate name %q", g.Name)})
+		}
+		gates[g.Name] = true
+		errs = append(errs, g.Validate(field+".")...)
+	}
+	for i, s := range c.SilenceSchedules {
+		errs = append(errs, s.Validate(fmt.Sprintf("silence_schedules[%d].", i), true)...)
+	}
+	return append(errs, c.Dashboard.Validate()...)
+}
+
+// validateNotifierRefs checks that the notifiers a service sends alerts to are
+// configured; prefix is prepended to field names
+func (c *Config) validateNotifierRefs(svc Service, prefix string) []ValidationError {
+	var errs []ValidationError
+	for i, name := range svc.Notifiers {
+		found := false
+		for _, n := range c.Notifiers {
+			found = found || n.Name == name
+		}
+		if !found {
+			errs = append(errs, ValidationError{Field: fmt.Sprintf("%snotifiers[%d]", prefix, i), Message: fmt.Sprintf("unknown notifier %q", name)})
+		}
+	}
+	return errs
+}
+
+// httpMethods are the methods an HTTP check can send
+var httpMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+
+// validateService checks a single service definition; prefix is prepended to field names
+func validateService(svc Service, prefix string) []ValidationError {
+	var errs []ValidationError
+	add := func(name, format string, args ...interface{}) {
+		errs = append(errs, ValidationError{Field: prefix + name, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if strings.TrimSpace(svc.Name) == "" {
+		add("name", "name is required")
+	} else if strings.ContainsAny(svc.Name, "/?#") {
+		add("name", "must not contain '/', '?' or '#'")
+	}
+
+	if _, ok := probes[svc.Type]; !ok && svc.Type != "agent" && svc.Type != "merged" {
+		add("type", "unknown check type %q", svc.Type)
+	}
+
+	if svc.URL == "" {
+		if svc.Type != "heartbeat" && svc.Type != "agent" && svc.Type != "merged" && svc.Type != "exec" {
+			add("url", "url is required")
+		}
+	} else if svc.Type == "" || svc.Type == "http" || svc.Type == "etcd" || svc.Type == "canary" {
+		if u, err := url.Parse(svc.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add("url", "must be an absolute http or https URL")
+		}
+	}
+
+	if svc.Login != nil {
+		if svc.Type != "" && svc.Type != "http" {
+			add("login", "only supported for http checks")
+		}
+		errs = append(errs, svc.Login.validate(prefix+"login.")...)
+	}
+
+	if svc.Method != "" || len(svc.Headers) > 0 || svc.Body != "" {
+		if svc.Type != "" && svc.Type != "http" && svc.Type != "canary" {
+			add("method", "method, headers and body are only supported for http and canary checks")
+		}
+		if svc.Method != "" && !containsString(httpMethods, svc.Method) {
+			add("method", "must be one of %s", strings.Join(httpMethods, ", "))
+		}
+		for name, value := range svc.Headers {
+			if name == "" || strings.ContainsAny(name, " :\t\r\n") {
+				add("headers", "invalid header name %q", name)
+			}
+			if strings.ContainsAny(value, "\r\n") {
+				add("headers."+name, "must not contain line breaks")
+			}
+		}
+	}
+
+	if svc.Auth != nil {
+		if svc.Type != "" && svc.Type != "http" && svc.Type != "canary" {
+			add("auth", "only supported for http and canary checks")
+		}
+		errs = append(errs, svc.Auth.validate(prefix+"auth.")...)
+	}
+	if svc.TLS != nil {
+		if scheme, ok := tlsSchemes[svc.Type]; !ok {
+			add("tls", "only supported for http, etcd, grpc and redis checks")
+		} else if u, err := url.Parse(svc.URL); err == nil && u.Scheme != scheme {
+			add("tls", "needs a %s:// URL", scheme)
+		}
+		errs = append(errs, svc.TLS.validate(prefix+"tls.")...)
+	}
+	if len(svc.ExpectedStatus) > 0 && svc.Type != "" && svc.Type != "http" && svc.Type != "canary" {
+		add("expected_status", "only supported for http and canary checks")
+	}
+	if (svc.BodyContains != "" || svc.BodyRegex != "") && svc.Type != "" && svc.Type != "http" && svc.Type != "canary" {
+		add("body_contains", "body assertions are only supported for http and canary checks")
+	}
+	if svc.BodyRegex != "" {
+		if _, err := regexp.Compile(svc.BodyRegex); err != nil {
+			add("body_regex", "invalid regular expression: %v", err)
+		}
+	}
+	if svc.BotChallenge != "" {
+		if svc.Type != "" && svc.Type != "http" && svc.Type != "canary" {
+			add("bot_challenge", "only supported for http and canary checks")
+		} else if !containsString(botChallengeModes, svc.BotChallenge) {
+			add("bot_challenge", "must be one of %s", strings.Join(botChallengeModes, ", "))
+		}
+	}
+	if svc.Throttling != "" {
+		if svc.Type != "" && svc.Type != "http" {
+			add("throttling", "only supported for http checks")
+		} else if !containsString(throttlingModes, svc.Throttling) {
+			add("throttling", "must be one of %s", strings.Join(throttlingModes, ", "))
+		}
+	}
+	if len(svc.JSONAssertions) > 0 && svc.Type != "" && svc.Type != "http" && svc.Type != "canary" {
+		add("json_assertions", "only supported for http and canary checks")
+	}
+	for i, a := range svc.JSONAssertions {
+		errs = append(errs, a.validate(fmt.Sprintf("%sjson_assertions[%d].", prefix, i))...)
+	}
+
+	if len(svc.GeoResolvers) > 0 {
+		if svc.Type != "" && svc.Type != "http" {
+			add("geo_resolvers", "only supported for http checks")
+		} else if u, err := url.Parse(svc.URL); err == nil && net.ParseIP(u.Hostname()) != nil {
+			add("geo_resolvers", "url must use a hostname, not an IP address")
+		}
+		for i, server := range svc.GeoResolvers {
+			if _, err := targetAddr(server, "53"); err != nil {
+				add(fmt.Sprintf("geo_resolvers[%d]", i), "must be host or host:port")
+			}
+		}
+	}
+
+	if svc.ConnectTo != "" {
+		if svc.Type != "" && svc.Type != "http" && svc.Type != "etcd" {
+			add("connect_to", "only supported for http and etcd checks")
+		} else if len(svc.GeoResolvers) > 0 {
+			add("connect_to", "can't be combined with geo_resolvers")
+		}
+		if _, err := targetAddr(svc.ConnectTo, "80"); err != nil || strings.Contains(svc.ConnectTo, "://") {
+			add("connect_to", "must be host or host:port")
+		}
+	}
+
+	if svc.VersionSkew != nil {
+		if svc.Type != "" && svc.Type != "http" {
+			add("version_skew", "only supported for http checks")
+		} else if len(svc.GeoResolvers) > 0 || svc.ConnectTo != "" {
+			add("version_skew", "can't be combined with geo_resolvers or connect_to")
+		}
+		errs = append(errs, svc.VersionSkew.validate(prefix+"version_skew.")...)
+	}
+
+	if svc.Canary != nil {
+		if svc.Type != "canary" {
+			add("canary", "only supported for canary checks")
+		}
+		errs = append(errs, svc.Canary.validate(prefix+"canary.")...)
+	} else if svc.Type == "canary" {
+		add("canary", "canary checks need a canary block with baseline_url")
+	}
+
+	if svc.CertWarningDays < 0 {
+		add("cert_warning_days", "must not be negative")
+	}
+	if svc.CertCriticalDays < 0 {
+		add("cert_critical_days", "must not be negative")
+	}
+
+	if svc.Type == "dns" {
+		if strings.ContainsAny(svc.URL, "/:") {
+			add("url", "must be a hostname to resolve")
+		}
+		if svc.RecordType != "" && !containsString(dnsRecordTypes, strings.ToUpper(svc.RecordType)) {
+			add("record_type", "must be one of %s", strings.Join(dnsRecordTypes, ", "))
+		}
+		if svc.Resolver != "" {
+			if _, err := targetAddr(svc.Resolver, "53"); err != nil {
+				add("resolver", "must be host or host:port")
+			}
+		}
+	}
+
+	if svc.Type == "grpc" {
+		if u, err := url.Parse(svc.URL); err != nil || (u.Scheme != "grpc" && u.Scheme != "grpcs") || u.Port() == "" {
+			add("url", "must be grpc://host:port or grpcs://host:port")
+		}
+	}
+
+	if svc.Type == "exec" && (len(svc.Command) == 0 || strings.TrimSpace(svc.Command[0]) == "") {
+		add("command", "command is required")
+	}
+
+	if schemes, ok := databaseSchemes[svc.Type]; ok {
+		if u, err := url.Parse(svc.URL); err != nil || !containsString(schemes, u.Scheme) || u.Host == "" {
+			add("url", "must be a %s:// URL", strings.Join(schemes, ":// or "))
+		} else if svc.Type == "mysql" {
+			if _, err := mysqlDSN(svc.URL); err != nil {
+				add("url", "%v", err)
+			}
+		}
+	}
+
+	if svc.Type == "icmp" {
+		if strings.Contains(svc.URL, "/") || (strings.Contains(svc.URL, ":") && net.ParseIP(svc.URL) == nil) {
+			add("url", "must be a hostname or IP address")
+		}
+		if svc.PingCount < 0 {
+			add("ping_count", "must not be negative")
+		}
+		if svc.MaxPacketLoss < 0 || svc.MaxPacketLoss > 100 {
+			add("max_packet_loss", "must be between 0 and 100")
+		}
+	}
+
+	if svc.Type == "merged" {
+		if svc.MergeMode != "" && !containsString(mergeModes, svc.MergeMode) {
+			add("merge_mode", "must be one of %s", strings.Join(mergeModes, ", "))
+		}
+		if svc.Quorum < 0 {
+			add("quorum", "must not be negative")
+		}
+	}
+
+	if svc.Type == "tcp" && svc.URL != "" {
+		if addr, err := targetAddr(svc.URL, ""); err != nil || strings.HasSuffix(addr, ":") {
+			add("url", "must be host:port")
+		}
+	}
+
+	if svc.Interval <= 0 {
+		add("interval", "must be positive")
+	}
+	if svc.Timeout <= 0 {
+		add("timeout", "must be positive")
+	}
+	for i, s := range svc.SilenceSchedules {
+		errs = append(errs, s.Validate(fmt.Sprintf("%ssilence_schedules[%d].", prefix, i), false)...)
+	}
+	if svc.Retries < 0 || svc.Retries > maxRetries {
+		add("retries", "must be between 0 and %d", maxRetries)
+	}
+	if svc.RetryBackoff < 0 {
+		add("retry_backoff", "must not be negative")
+	}
+	if svc.FlapThreshold < 0 {
+		add("flap_threshold", "must not be negative")
+	}
+	if svc.FlapWindow < 0 {
+		add("flap_window", "must not be negative")
+	}
+	if svc.RenotifyInterval < 0 {
+		add("renotify_interval", "must not be negative")
+	}
+	if svc.Priority != "" && !containsString(checkPriorities, svc.Priority) {
+		add("priority", "must be one of %s", strings.Join(checkPriorities, ", "))
+	}
+	if svc.GracePeriod < 0 {
+		add("grace_period", "must not be negative")
+	}
+	if svc.GraceChecks < 0 {
+		add("grace_checks", "must not be negative")
+	}
+	if svc.FailureThreshold < 0 {
+		add("failure_threshold", "must not be negative")
+	}
+	if svc.SuccessThreshold < 0 {
+		add("success_threshold", "must not be negative")
+	}
+	if svc.Weight < 0 {
+		add("weight", "must not be negative")
+	}
+	if svc.SLO < 0 || svc.SLO >= 100 {
+		add("slo", "must be a percentage below 100")
+	}
+	if svc.MaxClockSkew < 0 {
+		add("max_clock_skew", "must not be negative")
+	}
+	if svc.AlertDownFor < 0 || svc.AlertLatencyWarning < 0 || svc.AlertLatencyCritical < 0 {
+		add("alert_*", "alert thresholds must not be negative")
+	}
+	if svc.AlertLatencyWarning > 0 && svc.AlertLatencyCritical > 0 && svc.AlertLatencyWarning >= svc.AlertLatencyCritical {
+		add("alert_latency_warning", "must be below alert_latency_critical")
+	}
+	return errs
+}
+
+// parseConfig decodes and validates a JSON or YAML config document. Fields the
+// schema doesn't know are returned apart from the errors, since decoding
+// ignores them; callers decide whether they fail the config.
+func parseConfig(data []byte) (cfg *Config, errs, unknown []ValidationError) {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] != '{' {
+		converted, err := yaml.YAMLToJSON(data)
+		if err != nil {
+			return nil, []ValidationError{{Message: err.Error()}}, nil
+		}
+		data = converted
+	}
+	for _, e := range validateSchema(data) {
+		if e.Message == unknownFieldMessage {
+			unknown = append(unknown, e)
+		} else {
+			errs = append(errs, e)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs, unknown
+	}
+
+	cfg = &Config{}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, []ValidationError{{Message: err.Error()}}, unknown
+	}
+	if errs := cfg.Validate(); len(errs) > 0 {
+		return nil, errs, unknown
+	}
+	return cfg, nil, unknown
+}
+
+// loadConfig reads and validates a configuration file
+func loadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, errs, unknown := parseConfig(data)
+	// Fields the checker doesn't know are ignored, as they were before the
+	// schema, so a config from a newer version or with a typo still loads
+	for _, e := range unknown {
+		slog.Warn("ignoring unknown config field", "path", path, "field", e.Field)
+	}
+	if len(errs) > 0 {
+		msgs := make([]string, len(errs))
+		for i, e := range errs {
+			msgs[i] = e.Error()
+		}
+		return nil, fmt.Errorf("%s: %s", path, strings.Join(msgs, "; "))
+	}
+	return cfg, nil
+}
+
+// DashboardConfig customizes the look of the HTML dashboard
+type DashboardConfig struct {
+	Title           string   `json:"title"`
+	LogoURL         string   `json:"logo_url,omitempty"`
+	Columns         int      `json:"columns"`
+	RefreshInterval Duration `json:"refresh_interval"`
+	Fields          []string `json:"fields,omitempty"`   // card fields to show; all when empty
+	Language        string   `json:"language,omitempty"` // en, de or ja; default the browser's
+	ReadOnly        bool     `json:"-"`                  // set by -read-only; hides operator controls
+	MetricsPath     string   `json:"-"`                  // set by -metrics-path
+}
+
+// dashboardFields are the card fields that can be shown or hidden
+var dashboardFields = []string{"url", "labels", "status", "response_time", "last_checked", "error", "warnings"}
+
+// defaultDashboard is used for settings the config and environment leave unset
+var defaultDashboard = DashboardConfig{
+	Title:           "Service Health Dashboard",
+	Columns:         1,
+	RefreshInterval: Duration(5 * time.Second),
+}
+
+// applyEnv overrides dashboard settings from HC_DASHBOARD_* environment variables
+func (d *DashboardConfig) applyEnv() error {
+	if v := os.Getenv("HC_DASHBOARD_TITLE"); v != "" {
+		d.Title = v
+	}
+	if v := os.Getenv("HC_DASHBOARD_LOGO_URL"); v != "" {
+		d.LogoURL = v
+	}
+	if v := os.Getenv("HC_DASHBOARD_COLUMNS"); v != "" {
+		columns, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("HC_DASHBOARD_COLUMNS: %w", err)
+		}
+		d.Columns = columns
+	}
+	if v := os.Getenv("HC_DASHBOARD_REFRESH_INTERVAL"); v != "" {
+		interval, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("HC_DASHBOARD_REFRESH_INTERVAL: %w", err)
+		}
+		d.RefreshInterval = Duration(interval)
+	}
+	if v := os.Getenv("HC_DASHBOARD_LANGUAGE"); v != "" {
+		d.Language = v
+	}
+	if v := os.Getenv("HC_DASHBOARD_FIELDS"); v != "" {
+		d.Fields = nil
+		for _, field := range strings.Split(v, ",") {
+			d.Fields = append(d.Fields, strings.TrimSpace(field))
+		}
+	}
+
+	errs := d.Validate()
+	if len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// Validate checks the dashboard settings
+func (d *DashboardConfig) Validate() []ValidationError {
+	var errs []ValidationError
+	if d.Columns < 1 || d.Columns > 12 {
+		errs = append(errs, ValidationError{Field: "dashboard.columns", Message: "must be between 1 and 12"})
+	}
+	if d.RefreshInterval < Duration(time.Second) {
+		errs = append(errs, ValidationError{Field: "dashboard.refresh_interval", Message: "must be at least 1s"})
+	}
+	if !validLanguage(d.Language) {
+		errs = append(errs, ValidationError{Field: "dashboard.language", Message: "must be one of " + strings.Join(languages, ", ")})
+	}
+	for i, field := range d.Fields {
+		if !containsString(dashboardFields, field) {
+			errs = append(errs, ValidationError{
+				Field:   fmt.Sprintf("dashboard.fields[%d]", i),
+				Message: fmt.Sprintf("unknown field %q, expected one of %s", field, strings.Join(dashboardFields, ", ")),
+			})
+		}
+	}
+	return errs
+}
@@ -0,0 +1,147 @@
+// cron.go
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSearchLimit bounds how far ahead cronSpec.next looks for a match, so an
+// impossible expression such as February 30th doesn't loop forever
+const cronSearchLimit = 5 * 366 * 24 * time.Hour
+
+// cronSpec is a parsed five-field cron expression: minute, hour, day of month,
+// month and day of week. Each field is a bit set of the values it matches.
+type cronSpec struct {
+	minute, hour, dom, month, dow uint64
+
+	// As in cron, when both days of month and of week are restricted a day
+	// matches if either does
+	domAny, dowAny bool
+}
+
+// cronFields are the bounds of each field, in order
+var cronFields = []struct {
+	name     string
+	min, max int
+}{{"minute", 0, 59}, {"hour", 0, 23}, {"day of month", 1, 31}, {"month", 1, 12}, {"day of week", 0, 7}}
+
+// parseCron parses an expression such as "30 2 * * 0" or "0 */6 1-15 * mon-fri".
+// Fields take *, values, ranges, lists and /steps; days of week also take
+// three-letter names, and 7 is Sunday like 0.
+func parseCron(expr string) (cronSpec, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return cronSpec{}, fmt.Errorf("cron expression %q must have 5 fields: minute hour day-of-month month day-of-week", expr)
+	}
+	var sets [5]uint64
+	for i, f := range fields {
+		set, err := parseCronField(f, cronFields[i].min, cronFields[i].max, i == 4)
+		if err != nil {
+			return cronSpec{}, fmt.Errorf("cron %s field %q: %v", cronFields[i].name, f, err)
+		}
+		sets[i] = set
+	}
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1 // 7 is Sunday too
+	}
+	return cronSpec{
+		minute: sets[0], hour: sets[1], dom: sets[2], month: sets[3], dow: sets[4],
+		domAny: fields[2] == "*", dowAny: fields[4] == "*",
+	}, nil
+}
+
+// parseCronField parses one comma-separated field into a bit set
+func parseCronField(field string, min, max int, weekdays bool) (uint64, error) {
+	value := func(s string) (int, error) {
+		if weekdays {
+			if day, err := parseWeekday(s); err == nil {
+				return int(day), nil
+			}
+			for day := time.Sunday; day <= time.Saturday; day++ {
+				if strings.EqualFold(s, day.String()[:3]) {
+					return int(day), nil
+				}
+			}
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < min || n > max {
+			return 0, fmt.Errorf("%q is not a value from %d to %d", s, min, max)
+		}
+		return n, nil
+	}
+
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepText); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step %q", stepText)
+			}
+		}
+		lo, hi := min, max
+		if rng != "*" {
+			from, to, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = value(from); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = value(to); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				hi = max // 5/15 means from 5 on, every 15
+			}
+			if hi < lo {
+				return 0, fmt.Errorf("range %q runs backwards", rng)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+// matchesDay reports whether the spec's day fields match t's date
+func (c cronSpec) matchesDay(t time.Time) bool {
+	dom := c.dom&(1<<t.Day()) != 0
+	dow := c.dow&(1<<int(t.Weekday())) != 0
+	switch {
+	case c.domAny:
+		return dow
+	case c.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+// next returns the first minute after t that the spec matches, in t's location,
+// or the zero time if there is none within cronSearchLimit
+func (c cronSpec) next(t time.Time) time.Time {
+	loc := t.Location()
+	limit := t.Add(cronSearchLimit)
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<int(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !c.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case c.hour&(1<<t.Hour()) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case c.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,85 @@
+// cron_test.go
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		expr    string
+		want    cronSpec
+		wantErr bool
+	}{
+		{expr: "30 2 * * 0", want: cronSpec{minute: 1 << 30, hour: 1 << 2, dom: 1<<32 - 2, month: 1<<13 - 2, dow: 1, domAny: true}},
+		{expr: "0 */6 1-3 * mon-fri", want: cronSpec{minute: 1, hour: 1 | 1<<6 | 1<<12 | 1<<18, dom: 1<<1 | 1<<2 | 1<<3, month: 1<<13 - 2, dow: 0b111110}},
+		{expr: "5/20 0 * 1,6 *", want: cronSpec{minute: 1<<5 | 1<<25 | 1<<45, hour: 1, dom: 1<<32 - 2, month: 1<<1 | 1<<6, dow: 1<<8 - 1, domAny: true, dowAny: true}},
+		{expr: "0 0 * * 7", want: cronSpec{minute: 1, hour: 1, dom: 1<<32 - 2, month: 1<<13 - 2, dow: 1 | 1<<7, domAny: true}},
+		{expr: "0 0 * * Sunday,SAT", want: cronSpec{minute: 1, hour: 1, dom: 1<<32 - 2, month: 1<<13 - 2, dow: 1 | 1<<6, domAny: true}},
+		{expr: "0 0 * *", wantErr: true},
+		{expr: "0 0 * * * *", wantErr: true},
+		{expr: "60 0 * * *", wantErr: true},
+		{expr: "0 24 * * *", wantErr: true},
+		{expr: "0 0 0 * *", wantErr: true},
+		{expr: "0 0 * 13 *", wantErr: true},
+		{expr: "0 0 * * funday", wantErr: true},
+		{expr: "*/0 0 * * *", wantErr: true},
+		{expr: "0 5-1 * * *", wantErr: true},
+		{expr: "0 0 * jan *", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseCron(tt.expr)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseCron(%q) succeeded, want an error", tt.expr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseCron(%q) = %+v, %v, want %+v", tt.expr, got, err, tt.want)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no time zone database:", err)
+	}
+	tests := []struct {
+		expr     string
+		from     time.Time
+		want     time.Time
+		zeroTime bool
+	}{
+		// Always strictly after the given time
+		{expr: "30 2 * * *", from: time.Date(2026, 10, 14, 2, 30, 0, 0, time.UTC), want: time.Date(2026, 10, 15, 2, 30, 0, 0, time.UTC)},
+		{expr: "*/15 * * * *", from: time.Date(2026, 10, 14, 8, 50, 16, 0, time.UTC), want: time.Date(2026, 10, 14, 9, 0, 0, 0, time.UTC)},
+		// 2026-10-14 is a Wednesday
+		{expr: "0 9 * * mon", from: time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC), want: time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)},
+		{expr: "0 0 1 */3 *", from: time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC), want: time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{expr: "0 0 29 2 *", from: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), want: time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// Restricting both day fields matches either: the 20th, or the Friday before it
+		{expr: "0 0 20 * fri", from: time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC), want: time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)},
+		// In the expression's location, which may not be UTC
+		{expr: "0 3 * * *", from: time.Date(2026, 10, 14, 12, 0, 0, 0, berlin), want: time.Date(2026, 10, 15, 3, 0, 0, 0, berlin)},
+		{expr: "0 0 30 2 *", from: time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC), zeroTime: true},
+	}
+	for _, tt := range tests {
+		spec, err := parseCron(tt.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := spec.next(tt.from)
+		if tt.zeroTime {
+			if !got.IsZero() {
+				t.Errorf("%q after %v = %v, want none", tt.expr, tt.from, got)
+			}
+			continue
+		}
+		if !got.Equal(tt.want) || got.Location() != tt.want.Location() {
+			t.Errorf("%q after %v = %v, want %v", tt.expr, tt.from, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+// dashboard.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DashboardHandler serves the HTML dashboard with the given branding and layout.
+// Its language is ?lang=, else the configured one, else the browser's.
+func DashboardHandler(settings DashboardConfig) http.HandlerFunc {
+	if len(settings.Fields) == 0 {
+		settings.Fields = dashboardFields
+	}
+
+	pages := make(map[string]string, len(languages))
+	for _, lang := range languages {
+		messages := dashboardCatalogs[lang]
+		if messages == nil {
+			messages = map[string]string{}
+		}
+		// encoding/json escapes <, > and & so the settings are safe inside <script>
+		data, _ := json.Marshal(map[string]interface{}{
+			"title":      settings.Title,
+			"logo_url":   settings.LogoURL,
+			"columns":    settings.Columns,
+			"refresh_ms": time.Duration(settings.RefreshInterval).Milliseconds(),
+			"fields":     settings.Fields,
+			"read_only":  settings.ReadOnly,
+			"metrics":    settings.MetricsPath,
+			"language":   lang,
+			"messages":   messages,
+		})
+		pages[lang] = strings.Replace(dashboardHTML, "/*SETTINGS*/null", string(data), 1)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		lang := r.URL.Query().Get("lang")
+		if !containsString(languages, lang) {
+			lang = settings.Language
+		}
+		if lang == "" {
+			lang = preferredLanguage(r.Header.Get("Accept-Language"))
+		}
+		if lang == "" {
+			lang = "en"
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Content-Language", lang)
+		w.Header().Add("Vary", "Accept-Language")
+		w.Write([]byte(pages[lang]))
+	}
+}
@@ -0,0 +1,19 @@
+// detail.go
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// ServiceDetailHandler serves the per-service detail page. The page loads its data
+// from the history, incidents and events APIs.
+func ServiceDetailHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html")
+	w.Write([]byte(strings.Replace(detailHTML, "<title>", "<title>"+templateEscape(r.PathValue("name"))+" - ", 1)))
+}
+
+// templateEscape escapes text for inclusion in HTML
+func templateEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;", "'", "&#39;").Replace(s)
+}
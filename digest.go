@@ -0,0 +1,266 @@
+// digest.go
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CheckCounts are the running totals of checks run for a service
+type CheckCounts struct {
+	Checks   int64 `json:"checks"`
+	Failures int64 `json:"failures"`
+	Panics   int64 `json:"panics,omitempty"`  // recovered panics in probes or the monitor
+	Retries  int64 `json:"retries,omitempty"` // failed attempts retried within a check
+
+	// Failed checks during maintenance windows, which aren't in Failures
+	MaintenanceFailures int64 `json:"maintenance_failures,omitempty"`
+
+	// Failed checks by kind of DNS failure, e.g. nxdomain
+	DNSFailures map[string]int64 `json:"dns_failures,omitempty"`
+
+	// Checks answered by a CDN's bot challenge instead of the service
+	BotChallenges int64 `json:"bot_challenges,omitempty"`
+
+	// Checks the target throttled, with throttling set
+	Throttled int64 `json:"throttled,omitempty"`
+
+	// Changes between up and down, after thresholds
+	Transitions int64 `json:"transitions,omitempty"`
+
+	// Changes of the addresses the service's host resolves to, with dns_watch on
+	IPChanges int64 `json:"ip_changes,omitempty"`
+
+	// How long checks took
+	Duration DurationHistogram `json:"duration"`
+}
+
+// Counts returns a snapshot of the check totals of every service
+func (hc *HealthChecker) Counts() map[string]CheckCounts {
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+
+	counts := make(map[string]CheckCounts, len(hc.counts))
+	for name, c := range hc.counts {
+		counts[name] = c
+	}
+	return counts
+}
+
+// DigestConfig schedules a periodic summary for a set of services, such as a team
+type DigestConfig struct {
+	Name     string            `json:"name"`
+	Schedule string            `json:"schedule"`          // daily or weekly
+	At       string            `json:"at,omitempty"`      // local time of day, default 09:00
+	Weekday  string            `json:"weekday,omitempty"` // weekly digests only, default monday
+	Notifier string            `json:"notifier"`
+	Tag      string            `json:"tag,omitempty"`    // only services with this tag
+	Labels   map[string]string `json:"labels,omitempty"` // only services with these labels, e.g. team
+}
+
+// digestNoisiest is the number of services listed as noisiest
+const digestNoisiest = 5
+
+// Validate checks a digest definition; prefix is prepended to field names
+func (d DigestConfig) Validate(prefix string) []ValidationError {
+	var errs []ValidationError
+	if strings.TrimSpace(d.Name) == "" {
+		errs = append(errs, ValidationError{Field: prefix + "name", Message: "name is required"})
+	}
+	if d.Schedule != "daily" && d.Schedule != "weekly" {
+		errs = append(errs, ValidationError{Field: prefix + "schedule", Message: "must be daily or weekly"})
+	}
+	if _, err := d.timeOfDay(); err != nil {
+		errs = append(errs, ValidationError{Field: prefix + "at", Message: "must be a time like 09:00"})
+	}
+	if _, err := d.weekday(); err != nil {
+		errs = append(errs, ValidationError{Field: prefix + "weekday", Message: err.Error()})
+	}
+	return errs
+}
+
+// timeOfDay returns the configured send time as an offset from midnight
+func (d DigestConfig) timeOfDay() (time.Duration, error) {
+	at := d.At
+	if at == "" {
+		at = "09:00"
+	}
+	t, err := time.Parse("15:04", at)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func (d DigestConfig) weekday() (time.Weekday, error) {
+	if d.Weekday == "" {
+		return time.Monday, nil
+	}
+	return parseWeekday(d.Weekday)
+}
+
+// next returns the first scheduled send time after now
+func (d DigestConfig) next(now time.Time) time.Time {
+	offset, _ := d.timeOfDay()
+	weekday, _ := d.weekday()
+
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	for day := 0; ; day++ {
+		date := midnight.AddDate(0, 0, day)
+		candidate := date.Add(offset)
+		if !candidate.After(now) {
+			continue
+		}
+		if d.Schedule == "weekly" && date.Weekday() != weekday {
+			continue
+		}
+		return candidate
+	}
+}
+
+// matches reports whether a service is covered by the digest
+func (d DigestConfig) matches(svc Service) bool {
+	for k, v := range d.Labels {
+		if svc.Labels[k] != v {
+			return false
+		}
+	}
+	return d.Tag == "" || containsString(svc.Tags, d.Tag)
+}
+
+// RunDigest queues the digest for its notifier on its schedule. It never returns.
+func (hc *HealthChecker) RunDigest(d DigestConfig, outbox *Outbox) {
+	since := time.Now()
+	baseline := hc.Counts()
+
+	for {
+		next := d.next(time.Now())
+		time.Sleep(time.Until(next))
+
+		now := time.Now()
+		counts := hc.Counts()
+		outbox.Enqueue(d.Notifier, hc.buildDigest(d, since, now, baseline, counts))
+		slog.Info("digest queued", "digest", d.Name, "notifier", d.Notifier)
+
+		since, baseline = now, counts
+	}
+}
+
+// digestService is the per-service summary of a digest period
+type digestService struct {
+	name      string
+	slo       float64
+	counts    CheckCounts
+	incidents int
+}
+
+// uptime returns the percentage of successful checks in the period, taking
+// failures during maintenance windows as successes
+func (s digestService) uptime() float64 {
+	if s.counts.Checks == 0 {
+		return 100
+	}
+	return 100 * float64(s.counts.Checks-s.counts.Failures) / float64(s.counts.Checks)
+}
+
+// buildDigest summarizes uptime, new incidents, the noisiest services and SLO burn
+// between from and to. Check totals are the difference between the two snapshots.
+func (hc *HealthChecker) buildDigest(d DigestConfig, from, to time.Time, before, after map[string]CheckCounts) Notification {
+	var services []*digestService
+	byName := make(map[string]*digestService)
+	for _, svc := range hc.Services() {
+		if !d.matches(svc) || svc.Labels[mergedIntoLabel] != "" {
+			continue
+		}
+		s := &digestService{
+			name: svc.Name,
+			slo:  svc.SLO,
+			counts: CheckCounts{
+				Checks:   after[svc.Name].Checks - before[svc.Name].Checks,
+				Failures: after[svc.Name].Failures - before[svc.Name].Failures,
+
+				MaintenanceFailures: after[svc.Name].MaintenanceFailures - before[svc.Name].MaintenanceFailures,
+			},
+		}
+		services = append(services, s)
+		byName[svc.Name] = s
+	}
+
+	var incidents []Event
+	for _, e := range hc.events.List("", 0) {
+		if e.Type != EventIncidentOpened || e.Time.Before(from) || !e.Time.Before(to) {
+			continue
+		}
+		if s, ok := byName[e.Service]; ok {
+			s.incidents++
+			incidents = append(incidents, e)
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Period: %s to %s\n", from.Format("Mon Jan 2 15:04"), to.Format("Mon Jan 2 15:04 MST"))
+
+	b.WriteString("\nUptime:\n")
+	if len(services) == 0 {
+		b.WriteString("  no matching services\n")
+	}
+	for _, s := range services {
+		fmt.Fprintf(&b, "  %s: %.2f%% (%d of %d checks failed", s.name, s.uptime(), s.counts.Failures, s.counts.Checks)
+		if s.counts.MaintenanceFailures > 0 {
+			fmt.Fprintf(&b, ", %d more during maintenance", s.counts.MaintenanceFailures)
+		}
+		b.WriteString(")\n")
+	}
+
+	fmt.Fprintf(&b, "\nNew incidents: %d\n", len(incidents))
+	for _, e := range incidents {
+		fmt.Fprintf(&b, "  %s at %s: %s\n", e.Service, e.Time.Format("Mon 15:04"), e.Message)
+	}
+
+	noisy := append([]*digestService(nil), services...)
+	sort.SliceStable(noisy, func(i, j int) bool {
+		if noisy[i].incidents != noisy[j].incidents {
+			return noisy[i].incidents > noisy[j].incidents
+		}
+		return noisy[i].counts.Failures > noisy[j].counts.Failures
+	})
+	b.WriteString("\nNoisiest services:\n")
+	listed := 0
+	for _, s := range noisy {
+		if listed == digestNoisiest || s.counts.Failures == 0 {
+			break
+		}
+		fmt.Fprintf(&b, "  %s: %d incidents, %d failed checks\n", s.name, s.incidents, s.counts.Failures)
+		listed++
+	}
+	if listed == 0 {
+		b.WriteString("  none\n")
+	}
+
+	b.WriteString("\nSLO burn:\n")
+	withSLO := 0
+	for _, s := range services {
+		if s.slo == 0 {
+			continue
+		}
+		withSLO++
+		budget := 100 - s.slo
+		burn := (100 - s.uptime()) / budget * 100
+		fmt.Fprintf(&b, "  %s: %.0f%% of the %.2f%% error budget used (target %g%%)\n", s.name, burn, budget, s.slo)
+	}
+	if withSLO == 0 {
+		b.WriteString("  no services with an slo\n")
+	}
+
+	title := "Daily"
+	if d.Schedule == "weekly" {
+		title = "Weekly"
+	}
+	return Notification{
+		Subject: fmt.Sprintf("%s health digest: %s", title, d.Name),
+		Text:    b.String(),
+	}
+}
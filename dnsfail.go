@@ -0,0 +1,52 @@
+// dnsfail.go
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+// Kinds of DNS failure, told apart so alerts can go to whoever handles each:
+// a deleted record needs the zone's owners, a slow resolver the network team
+const (
+	DNSNXDomain = "nxdomain" // the name doesn't exist, or has no records the resolver could use
+	DNSNoData   = "nodata"   // a DNS check got an empty answer for the record type asked for
+	DNSServFail = "servfail" // the server answered SERVFAIL or REFUSED, or with a malformed answer
+	DNSTimeout  = "timeout"  // no answer in time
+	DNSOther    = "error"    // any other lookup failure, e.g. the resolver refused the connection
+)
+
+// dnsFailureKinds are the values dns_error and the keys of dns_routes may take
+var dnsFailureKinds = []string{DNSNXDomain, DNSNoData, DNSServFail, DNSTimeout, DNSOther}
+
+// dnsFailure is a negative answer found by a DNS check, with its own message
+type dnsFailure struct {
+	kind string
+	msg  string
+}
+
+func (e *dnsFailure) Error() string { return e.msg }
+
+// classifyDNSError returns the kind of DNS failure behind a check error, or ""
+// when the check didn't fail on a lookup. HTTP and other checks that resolve
+// their target are classified too.
+func classifyDNSError(err error) string {
+	var failure *dnsFailure
+	if errors.As(err, &failure) {
+		return failure.kind
+	}
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) {
+		return ""
+	}
+	switch {
+	case dnsErr.IsNotFound:
+		return DNSNXDomain
+	case dnsErr.IsTimeout:
+		return DNSTimeout
+	case dnsErr.Err == "server misbehaving": // how the resolver reports SERVFAIL and REFUSED
+		return DNSServFail
+	default:
+		return DNSOther
+	}
+}
@@ -0,0 +1,168 @@
+// dnswatch.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	dnsWatchTimeout     = 5 * time.Second
+	dnsWatchConcurrency = 16
+
+	// ipChangeWindow is how long after an IP change an incident opening is
+	// marked as following it
+	ipChangeWindow = 15 * time.Minute
+)
+
+// DNSWatchConfig turns on resolving every service's host in the background,
+// so a change of the addresses it resolves to shows up next to the failures
+// it causes
+type DNSWatchConfig struct {
+	Interval Duration `json:"interval,omitempty"` // default 1m
+}
+
+// Validate checks the DNS watch settings
+func (c DNSWatchConfig) Validate() []ValidationError {
+	if c.Interval < 0 {
+		return []ValidationError{{Field: "dns_watch.interval", Message: "must not be negative"}}
+	}
+	return nil
+}
+
+// ResolvedHost is what a service's host last resolved to. A failed lookup
+// keeps the addresses from the last one that worked.
+type ResolvedHost struct {
+	Host        string     `json:"host"`
+	IPs         []string   `json:"ips,omitempty"`
+	ResolvedAt  *time.Time `json:"resolved_at,omitempty"` // last successful lookup
+	ChangedAt   *time.Time `json:"changed_at,omitempty"`  // when the addresses last changed
+	PreviousIPs []string   `json:"previous_ips,omitempty"`
+	Error       string     `json:"error,omitempty"` // of the last lookup, if it failed
+}
+
+// watchedHost returns the host a service's checks connect to, or "" when
+// there's nothing to resolve: the service has no host of its own, checks DNS
+// itself, or targets an IP address
+func watchedHost(svc Service) string {
+	switch svc.Type {
+	case "heartbeat", "agent", "merged", "exec", "dns":
+		return ""
+	}
+	host := targetHost(svc.URL)
+	if svc.ConnectTo != "" {
+		host = targetHost(svc.ConnectTo)
+	}
+	if net.ParseIP(host) != nil {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// RunDNSWatch resolves the hosts of all services every interval. It never returns.
+func (hc *HealthChecker) RunDNSWatch(c DNSWatchConfig) {
+	interval := orDefault(c.Interval, time.Minute)
+	for {
+		hc.resolveHosts(time.Now())
+		time.Sleep(interval)
+	}
+}
+
+// resolveHosts looks up every watched host at once, a few at a time, and
+// records the results on the services using them
+func (hc *HealthChecker) resolveHosts(now time.Time) {
+	hosts := make(map[string][]string) // host to the services using it
+	for _, svc := range hc.Services() {
+		if host := watchedHost(svc); host != "" {
+			hosts[host] = append(hosts[host], svc.Name)
+		}
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results := make(map[string][]string, len(hosts))
+	failures := make(map[string]error)
+	slots := make(chan struct{}, dnsWatchConcurrency)
+	for host := range hosts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			ctx, cancel := context.WithTimeout(context.Background(), dnsWatchTimeout)
+			defer cancel()
+			ips, err := net.DefaultResolver.LookupHost(ctx, host)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				failures[host] = err
+				return
+			}
+			slices.Sort(ips)
+			results[host] = slices.Compact(ips)
+		}()
+	}
+	wg.Wait()
+
+	for _, host := range slices.Sorted(maps.Keys(hosts)) {
+		for _, name := range hosts[host] {
+			hc.recordResolution(name, host, results[host], failures[host], now)
+		}
+	}
+}
+
+// recordResolution updates a service's resolved addresses, adding an
+// ip_changed event when they differ from the last ones
+func (hc *HealthChecker) recordResolution(name, host string, ips []string, err error, now time.Time) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	svc, ok := hc.services[name]
+	status := hc.statuses[name]
+	if !ok || watchedHost(svc) != host {
+		return // removed or changed since the lookup
+	}
+
+	// Replaced rather than modified so copies handed out by GetStatuses stay consistent
+	var next ResolvedHost
+	if status.DNS != nil && status.DNS.Host == host {
+		next = *status.DNS
+	}
+	next.Host = host
+	if err != nil {
+		next.Error = redactor.Redact(err.Error())
+		status.DNS = &next
+		return
+	}
+	next.Error = ""
+	if next.ResolvedAt != nil && !slices.Equal(next.IPs, ips) {
+		next.PreviousIPs, next.ChangedAt = next.IPs, &now
+		counts := hc.counts[name]
+		counts.IPChanges++
+		hc.counts[name] = counts
+
+		message := fmt.Sprintf("IP changed: %s -> %s", strings.Join(next.PreviousIPs, ", "), strings.Join(ips, ", "))
+		slog.Info("IP changed", "service", name, "host", host, "previous", strings.Join(next.PreviousIPs, ","), "ips", strings.Join(ips, ","))
+		hc.events.Add(Event{Time: now, Service: name, Type: EventIPChanged, Message: message})
+		hc.changed(name)
+	}
+	next.IPs, next.ResolvedAt = ips, &now
+	status.DNS = &next
+}
+
+// ipChangedBefore returns when the service's addresses last changed, if that
+// was within ipChangeWindow before now
+func ipChangedBefore(status *HealthStatus, now time.Time) *time.Time {
+	if status.DNS == nil || status.DNS.ChangedAt == nil || now.Sub(*status.DNS.ChangedAt) > ipChangeWindow {
+		return nil
+	}
+	return status.DNS.ChangedAt
+}
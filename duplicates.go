@@ -0,0 +1,182 @@
+// duplicates.go
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// What happens when a discovered or assigned service checks the same target as
+// one already monitored: static (default) keeps the existing service and skips
+// the duplicate, merge also adds the duplicate's labels to it, and error
+// refuses duplicates outright, failing validation when configured services
+// duplicate each other and API requests that would add one
+var duplicateModes = []string{"static", "merge", "error"}
+
+// Duplicate is a group of services checking the same target
+type Duplicate struct {
+	Target   string   `json:"target"`
+	Services []string `json:"services"`
+}
+
+// checkTarget identifies what a service checks: its type, its URL with scheme
+// and host lowercased and default ports dropped, and the request settings that
+// make two checks of one URL differ. It's "" for services that don't probe a
+// target of their own, such as heartbeats.
+func checkTarget(svc Service) string {
+	typ := svc.Type
+	switch typ {
+	case "heartbeat", "agent", "merged":
+		return ""
+	case "exec":
+		return "exec|" + strings.Join(svc.Command, "\x00")
+	case "":
+		typ = "http"
+	}
+
+	key := []string{typ, normalizeTarget(svc.URL)}
+	switch typ {
+	case "http", "canary":
+		method := strings.ToUpper(svc.Method)
+		if method == "" {
+			method = "GET"
+		}
+		key = append(key, method, svc.Body, strings.ToLower(svc.ConnectTo))
+		for _, name := range slices.Sorted(maps.Keys(svc.Headers)) {
+			key = append(key, strings.ToLower(name)+"="+svc.Headers[name])
+		}
+		if svc.Canary != nil {
+			key = append(key, normalizeTarget(svc.Canary.BaselineURL))
+		}
+	case "dns":
+		recordType := strings.ToUpper(svc.RecordType)
+		if recordType == "" {
+			recordType = "A"
+		}
+		key = append(key, recordType, strings.ToLower(svc.Resolver))
+	case "etcd":
+		key = append(key, strings.ToLower(svc.ConnectTo))
+	case "grpc":
+		key = append(key, svc.GRPCService)
+	}
+	return strings.Join(key, "|")
+}
+
+// normalizeTarget lowercases the scheme and host of a URL and drops a default
+// port; other targets, such as host:port, are only lowercased
+func normalizeTarget(target string) string {
+	u, err := url.Parse(target)
+	if err != nil || u.Host == "" {
+		return strings.ToLower(strings.TrimSuffix(target, "."))
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	host, port := strings.ToLower(strings.TrimSuffix(u.Hostname(), ".")), u.Port()
+	if port == "" || port == "80" && u.Scheme == "http" || port == "443" && u.Scheme == "https" {
+		u.Host = host
+		if strings.Contains(host, ":") {
+			u.Host = "[" + host + "]"
+		}
+	} else {
+		u.Host = host + ":" + port
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	return u.String()
+}
+
+// findDuplicates returns the groups of services checking the same target, in
+// the order they're listed
+func findDuplicates(services []Service) []Duplicate {
+	groups := make(map[string]int) // target to index in duplicates, or -1 before a second service
+	first := make(map[string]Service)
+	var duplicates []Duplicate
+	for _, svc := range services {
+		key := checkTarget(svc)
+		if key == "" {
+			continue
+		}
+		i, seen := groups[key]
+		switch {
+		case !seen:
+			groups[key] = -1
+			first[key] = svc
+		case i < 0:
+			groups[key] = len(duplicates)
+			duplicates = append(duplicates, Duplicate{Target: redactor.Redact(first[key].URL), Services: []string{first[key].Name, svc.Name}})
+		default:
+			duplicates[i].Services = append(duplicates[i].Services, svc.Name)
+		}
+	}
+	return duplicates
+}
+
+// validateDuplicates returns an error for every configured service checking the
+// same target as one listed before it
+func validateDuplicates(services []Service) []ValidationError {
+	var errs []ValidationError
+	firsts := make(map[string]int)
+	for i, svc := range services {
+		key := checkTarget(svc)
+		if key == "" {
+			continue
+		}
+		if j, seen := firsts[key]; seen {
+			errs = append(errs, ValidationError{Field: fmt.Sprintf("services[%d].url", i),
+				Message: fmt.Sprintf("checks the same target as services[%d] (%q)", j, services[j].Name)})
+			continue
+		}
+		firsts[key] = i
+	}
+	return errs
+}
+
+// duplicateOf returns a monitored service, other than svc itself, that checks
+// the same target as svc
+func (hc *HealthChecker) duplicateOf(svc Service) (Service, bool) {
+	key := checkTarget(svc)
+	if key == "" {
+		return Service{}, false
+	}
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+
+	for _, name := range slices.Sorted(maps.Keys(hc.services)) {
+		if other := hc.services[name]; name != svc.Name && checkTarget(other) == key {
+			return other, true
+		}
+	}
+	return Service{}, false
+}
+
+// resolveDuplicate applies the duplicates mode to a service from source, such
+// as mdns, that checks the same target as existing. The duplicate is never
+// monitored itself.
+func (hc *HealthChecker) resolveDuplicate(source string, svc, existing Service) {
+	switch hc.duplicates {
+	case "merge":
+		// discovered_by would have the existing service treated as discovered itself
+		labels := make(map[string]string, len(existing.Labels)+len(svc.Labels))
+		for k, v := range svc.Labels {
+			if k != "discovered_by" {
+				labels[k] = v
+			}
+		}
+		for k, v := range existing.Labels {
+			labels[k] = v
+		}
+		if !maps.Equal(labels, existing.Labels) {
+			existing.Labels = labels
+			hc.UpdateService(existing)
+		}
+		slog.Info("duplicate service skipped, labels merged", "source", source, "service", svc.Name, "duplicate_of", existing.Name)
+	case "error":
+		slog.Warn("duplicate service refused", "source", source, "service", svc.Name, "duplicate_of", existing.Name)
+	default:
+		slog.Info("duplicate service skipped", "source", source, "service", svc.Name, "duplicate_of", existing.Name)
+	}
+}
@@ -0,0 +1,271 @@
+// email.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"maps"
+	"mime"
+	"net"
+	"net/smtp"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// smtpTLSModes are the values smtp_tls may take
+var smtpTLSModes = []string{"starttls", "tls"}
+
+// EmailRoute sends the alerts of the services its selector matches to its own
+// recipients, e.g. each team's list for its services
+type EmailRoute struct {
+	Selector string   `json:"selector"` // e.g. team=payments
+	To       []string `json:"to"`
+	Language string   `json:"language,omitempty"` // of the alerts these recipients get; default the notifier's
+}
+
+// sampleAlert is what email templates are tried against when the config is validated
+var sampleAlert = Alert{
+	Service: "example-service", URL: "https://example.com/health", State: AlertDown,
+	Error: "HTTP 503", ResponseTime: 120, Labels: map[string]string{"team": "example"},
+	IncidentID: "example-service-1", StartedAt: time.Unix(0, 0), At: time.Unix(0, 0),
+}
+
+// validateEmail checks the TLS mode, recipient routes and templates of an email
+// notifier; prefix is prepended to field names
+func validateEmail(c NotifierConfig, prefix string) []ValidationError {
+	var errs []ValidationError
+	add := func(name, format string, args ...interface{}) {
+		errs = append(errs, ValidationError{Field: prefix + name, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if c.SMTPTLS != "" && !containsString(smtpTLSModes, c.SMTPTLS) {
+		add("smtp_tls", "must be one of %s", strings.Join(smtpTLSModes, ", "))
+	}
+	for i, r := range c.Recipients {
+		field := fmt.Sprintf("recipients[%d].", i)
+		if _, err := parseSelector(r.Selector); err != nil {
+			add(field+"selector", "%v", err)
+		}
+		if len(r.To) == 0 {
+			add(field+"to", "at least one recipient is required")
+		}
+		if !validLanguage(r.Language) {
+			add(field+"language", "must be one of %s", strings.Join(languages, ", "))
+		}
+	}
+	for _, t := range []struct{ name, text string }{{"subject_template", c.SubjectTemplate}, {"body_template", c.BodyTemplate}} {
+		if t.text == "" {
+			continue
+		}
+		if _, err := executeEmailTemplate(t.name, t.text, sampleAlert); err != nil {
+			add(t.name, "%v", err)
+		}
+	}
+	return errs
+}
+
+// executeEmailTemplate renders a subject or body template for an alert
+func executeEmailTemplate(name, text string, a Alert) (string, error) {
+	tmpl, err := template.New(name).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, a); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// emailNotifier sends plain text mail over SMTP. By default it uses STARTTLS
+// when the server offers it; smtp_tls can require STARTTLS or use implicit TLS.
+type emailNotifier struct {
+	config NotifierConfig
+}
+
+// recipients returns who an alert goes to, by the language they get it in:
+// the recipients of every route matching its service, in the route's language
+// or lang, or the notifier's own in lang when none does. An address several
+// routes match gets the alert once, in the first one's language.
+func (e *emailNotifier) recipients(a *Alert, lang string) map[string][]string {
+	if a == nil {
+		return map[string][]string{lang: e.config.To}
+	}
+	to := make(map[string][]string)
+	seen := make(map[string]bool)
+	for _, r := range e.config.Recipients {
+		sel, err := parseSelector(r.Selector)
+		if err != nil || !sel.Matches(a.Service, a.Labels, a.Tags) {
+			continue
+		}
+		routeLang := r.Language
+		if routeLang == "" {
+			routeLang = lang
+		}
+		for _, addr := range r.To {
+			if !seen[addr] {
+				seen[addr] = true
+				to[routeLang] = append(to[routeLang], addr)
+			}
+		}
+	}
+	if len(seen) == 0 {
+		return map[string][]string{lang: e.config.To}
+	}
+	return to
+}
+
+// render returns the subject and body of a notification, from the templates
+// for alerts when they're set
+func (e *emailNotifier) render(n Notification) (subject, body string, err error) {
+	subject, body = n.Subject, n.Text
+	if n.Alert == nil {
+		return subject, body, nil
+	}
+	if e.config.SubjectTemplate != "" {
+		if subject, err = executeEmailTemplate("subject_template", e.config.SubjectTemplate, *n.Alert); err != nil {
+			return "", "", err
+		}
+	}
+	if e.config.BodyTemplate != "" {
+		if body, err = executeEmailTemplate("body_template", e.config.BodyTemplate, *n.Alert); err != nil {
+			return "", "", err
+		}
+	}
+	return strings.TrimSpace(subject), body, nil
+}
+
+// Notify sends one mail per language the recipients get the alert in
+func (e *emailNotifier) Notify(ctx context.Context, n Notification) error {
+	groups := e.recipients(n.Alert, n.Language)
+	for _, lang := range slices.Sorted(maps.Keys(groups)) {
+		localized := n
+		if n.Alert != nil && lang != n.Language {
+			localized = alertNotification(*n.Alert, lang)
+		}
+		if err := e.send(ctx, groups[lang], localized); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// send mails a notification to the given recipients
+func (e *emailNotifier) send(ctx context.Context, to []string, n Notification) error {
+	subject, text, err := e.render(n)
+	if err != nil {
+		return err
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", e.config.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", "\n"), "\n", "\r\n"))
+
+	c, err := e.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if err := c.Mail(e.config.From); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err := c.Rcpt(addr); err != nil {
+			return fmt.Errorf("recipient %s: %w", addr, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg.Bytes()); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// auth returns the SMTP credentials, or nil when none are configured
+func (e *emailNotifier) auth() smtp.Auth {
+	if e.config.Username == "" {
+		return nil
+	}
+	host, _, _ := net.SplitHostPort(e.config.SMTPHost)
+	return smtp.PlainAuth("", e.config.Username, e.config.Password, host)
+}
+
+// connect opens a session with the SMTP server, secured as smtp_tls says and
+// logged in. ctx's deadline bounds the whole session.
+func (e *emailNotifier) connect(ctx context.Context) (*smtp.Client, error) {
+	host, _, _ := net.SplitHostPort(e.config.SMTPHost)
+	var conn net.Conn
+	var err error
+	if e.config.SMTPTLS == "tls" {
+		d := tls.Dialer{Config: clientTLSConfig(host)}
+		conn, err = d.DialContext(ctx, "tcp", e.config.SMTPHost)
+	} else {
+		var d net.Dialer
+		conn, err = d.DialContext(ctx, "tcp", e.config.SMTPHost)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := e.secure(c, host); err != nil {
+		c.Close()
+		return nil, err
+	}
+	if auth := e.auth(); auth != nil {
+		if err := c.Auth(auth); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// secure upgrades a plain connection with STARTTLS: always for smtp_tls
+// starttls, and by default when the server offers it
+func (e *emailNotifier) secure(c *smtp.Client, host string) error {
+	if e.config.SMTPTLS == "tls" {
+		return nil
+	}
+	ok, _ := c.Extension("STARTTLS")
+	if !ok {
+		if e.config.SMTPTLS == "starttls" {
+			return fmt.Errorf("%s does not offer STARTTLS", e.config.SMTPHost)
+		}
+		return nil
+	}
+	return c.StartTLS(clientTLSConfig(host))
+}
+
+// Check connects to the SMTP server, secures the connection and logs in,
+// without sending mail
+func (e *emailNotifier) Check(ctx context.Context) error {
+	c, err := e.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return c.Quit()
+}
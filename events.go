@@ -0,0 +1,152 @@
+// events.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// eventLogSize is the number of events kept across all services
+const eventLogSize = 5000
+
+// Event types
+const (
+	EventIncidentOpened   = "incident_opened"
+	EventIncidentResolved = "incident_resolved"
+	EventIncidentAcked    = "incident_acked"
+	EventCertChanged      = "cert_changed"
+	EventConfigChanged    = "config_changed"
+	EventAnnotation       = "annotation"
+	EventSchedulerStall   = "scheduler_stall"
+	EventFlappingStarted  = "flapping_started"
+	EventFlappingStopped  = "flapping_stopped"
+	EventIPChanged        = "ip_changed"
+
+	EventVersionSkewStarted = "version_skew_started"
+	EventVersionSkewEnded   = "version_skew_ended"
+
+	EventThrottled     = "throttled"
+	EventThrottleEnded = "throttle_ended"
+)
+
+// Event is something notable that happened to a service, such as an incident
+// transition or a user annotation like a deploy
+type Event struct {
+	ID      int64     `json:"id"`
+	Time    time.Time `json:"time"`
+	Service string    `json:"service"`
+	Type    string    `json:"type"`
+	Message string    `json:"message"`
+	Author  string    `json:"author,omitempty"`
+}
+
+// EventLog is a bounded, concurrency-safe log of events
+type EventLog struct {
+	events []Event
+	nextID int64
+	store  Store // also written to, when set
+	mu     sync.RWMutex
+}
+
+// NewEventLog creates an empty event log
+func NewEventLog() *EventLog {
+	return &EventLog{nextID: 1}
+}
+
+// Add records an event, filling in its ID and, when unset, its time
+func (l *EventLog) Add(e Event) Event {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e.ID = l.nextID
+	l.nextID++
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	l.events = append(l.events, e)
+	if len(l.events) > eventLogSize {
+		l.events = append(l.events[:0:0], l.events[len(l.events)-eventLogSize:]...)
+	}
+	if l.store != nil {
+		l.store.AddEvent(e)
+	}
+	return e
+}
+
+// restore replaces the log with stored events, oldest first, continuing their IDs
+func (l *EventLog) restore(events []Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.events = events
+	if len(events) > 0 {
+		l.nextID = events[len(events)-1].ID + 1
+	}
+}
+
+// List returns events for a service (all services when empty), oldest first,
+// limited to the most recent limit events when limit is positive. Events not tied
+// to a service, such as global annotations, are included for every service.
+func (l *EventLog) List(service string, limit int) []Event {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	var events []Event
+	for _, e := range l.events {
+		if service == "" || e.Service == service || e.Service == "" {
+			events = append(events, e)
+		}
+	}
+	if limit > 0 && len(events) > limit {
+		events = events[len(events)-limit:]
+	}
+	return events
+}
+
+// ListHandler returns events, filtered by ?service= and bounded by ?limit=
+func (l *EventLog) ListHandler(w http.ResponseWriter, r *http.Request) {
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	events := l.List(r.URL.Query().Get("service"), limit)
+	if events == nil {
+		events = []Event{}
+	}
+	writeJSON(w, http.StatusOK, events)
+}
+
+// AnnotateHandler records a user annotation such as a deploy marker
+func (hc *HealthChecker) AnnotateHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Service string    `json:"service"`
+		Message string    `json:"message"`
+		Author  string    `json:"author"`
+		Time    time.Time `json:"time"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody)).Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if strings.TrimSpace(body.Message) == "" {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "message is required"})
+		return
+	}
+	if body.Service != "" {
+		if _, ok := hc.GetService(body.Service); !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": errServiceNotFound.Error()})
+			return
+		}
+	}
+
+	event := hc.events.Add(Event{
+		Time:    body.Time,
+		Service: body.Service,
+		Type:    EventAnnotation,
+		Message: body.Message,
+		Author:  body.Author,
+	})
+	writeJSON(w, http.StatusCreated, event)
+}
@@ -0,0 +1,75 @@
+// exec.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// execOutputMax is how much of a failing command's output goes into the error
+const execOutputMax = 1024
+
+// errExecNotAllowed rejects exec checks from sources other than the config file,
+// since they run arbitrary commands on the host
+var errExecNotAllowed = errors.New("exec checks can only be defined in the config file")
+
+// probeExec runs Command without a shell and expects exit code 0. A failing
+// command's stdout and stderr are returned as the error.
+func probeExec(ctx context.Context, svc Service, result *CheckResult) error {
+	if len(svc.Command) == 0 {
+		return errors.New("no command configured")
+	}
+	cmd := exec.CommandContext(ctx, svc.Command[0], svc.Command[1:]...)
+	output := &cappedBuffer{max: execOutputMax}
+	cmd.Stdout = output
+	cmd.Stderr = output
+	cmd.WaitDelay = time.Second // don't wait on children that keep the output open
+
+	err := cmd.Run()
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s", time.Duration(svc.Timeout))
+	}
+
+	out := strings.TrimSpace(output.buf.String())
+	if output.truncated {
+		// The cap may have split the last character's UTF-8 sequence
+		for i := 0; i < utf8.UTFMax-1 && out != ""; i++ {
+			if r, size := utf8.DecodeLastRuneInString(out); r != utf8.RuneError || size != 1 {
+				break
+			}
+			out = out[:len(out)-1]
+		}
+		out += "..."
+	}
+	if out == "" {
+		return err
+	}
+	return fmt.Errorf("%w: %s", err, out)
+}
+
+// cappedBuffer keeps the first max bytes written to it and discards the rest,
+// so a command that writes without end can't exhaust memory
+type cappedBuffer struct {
+	buf       bytes.Buffer
+	max       int
+	truncated bool
+}
+
+// Write never fails, so the command isn't stopped by a broken pipe once the cap is hit
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if room := b.max - b.buf.Len(); n > room {
+		p, b.truncated = p[:room], true
+	}
+	b.buf.Write(p)
+	return n, nil
+}
@@ -0,0 +1,30 @@
+// exec_test.go
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestProbeExecCapsOutput(t *testing.T) {
+	// 3-byte characters, so the cap falls inside one
+	svc := Service{Name: "job", Type: "exec", Command: []string{"sh", "-c", `yes €€€ | head -c 100000; exit 1`}}
+	err := probeExec(context.Background(), svc, &CheckResult{})
+	if err == nil {
+		t.Fatal("probeExec() passed a command that exited 1")
+	}
+	msg := err.Error()
+	if !strings.HasSuffix(msg, "...") || len(msg) > execOutputMax+len("exit status 1: ...") {
+		t.Errorf("error is %d bytes, want the output cut to %d bytes and marked: %.80q", len(msg), execOutputMax, msg)
+	}
+	if !utf8.ValidString(msg) {
+		t.Errorf("error splits a UTF-8 sequence: %q", msg[len(msg)-10:])
+	}
+
+	svc.Command = []string{"sh", "-c", "echo disk full >&2; exit 1"}
+	if err := probeExec(context.Background(), svc, &CheckResult{}); err == nil || err.Error() != "exit status 1: disk full" {
+		t.Errorf("probeExec() = %v, want the short output in full", err)
+	}
+}
@@ -0,0 +1,122 @@
+// export.go
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// exportRow is one check result in an export
+type exportRow struct {
+	Service      string    `parquet:"service"`
+	Time         time.Time `parquet:"time,timestamp(millisecond)"`
+	Healthy      bool      `parquet:"healthy"`
+	ResponseTime int64     `parquet:"response_time_ms"`
+	Error        string    `parquet:"error"`
+	Maintenance  bool      `parquet:"maintenance"`
+}
+
+// parseWindow parses a look-back window such as "30d", "12h" or "90m"
+func parseWindow(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid window %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid window %q", s)
+	}
+	return d, nil
+}
+
+// exportRows returns the retained results of the services recorded since the
+// given time, ordered by service then time
+func (hc *HealthChecker) exportRows(services []Service, since time.Time) []exportRow {
+	var rows []exportRow
+	for _, svc := range services {
+		for _, rec := range hc.History(svc.Name, 0) {
+			if rec.Time.Before(since) {
+				continue
+			}
+			rows = append(rows, exportRow{
+				Service:      svc.Name,
+				Time:         rec.Time,
+				Healthy:      rec.Healthy,
+				ResponseTime: rec.ResponseTime,
+				Error:        rec.Error,
+				Maintenance:  rec.Maintenance,
+			})
+		}
+	}
+	return rows
+}
+
+// ExportHandler dumps raw check results as CSV or Parquet. ?format= is csv
+// (default) or parquet, ?window= bounds how far back to go (default 30d) and
+// ?service= limits the export to one service.
+func (hc *HealthChecker) ExportHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	window := 30 * 24 * time.Hour
+	if s := query.Get("window"); s != "" {
+		var err error
+		if window, err = parseWindow(s); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+	}
+
+	services := hc.Services()
+	if name := query.Get("service"); name != "" {
+		svc, ok := hc.GetService(name)
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": errServiceNotFound.Error()})
+			return
+		}
+		services = []Service{svc}
+	}
+
+	rows := hc.exportRows(services, time.Now().Add(-window))
+	filename := "health-export-" + time.Now().UTC().Format("20060102T150405Z")
+
+	switch format := query.Get("format"); format {
+	case "", "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.csv"`)
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"service", "time", "healthy", "response_time_ms", "error", "maintenance"})
+		for _, row := range rows {
+			cw.Write([]string{
+				row.Service,
+				row.Time.UTC().Format(time.RFC3339Nano),
+				strconv.FormatBool(row.Healthy),
+				strconv.FormatInt(row.ResponseTime, 10),
+				row.Error,
+				strconv.FormatBool(row.Maintenance),
+			})
+		}
+		cw.Flush()
+
+	case "parquet":
+		w.Header().Set("Content-Type", "application/vnd.apache.parquet")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.parquet"`)
+		pw := parquet.NewGenericWriter[exportRow](w)
+		if _, err := pw.Write(rows); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		pw.Close()
+
+	default:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("unknown format %q (want csv or parquet)", format)})
+	}
+}
@@ -0,0 +1,170 @@
+// exposition.go
+package main
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Content types of the two exposition formats /metrics serves
+const (
+	promTextContentType    = "text/plain; version=0.0.4; charset=utf-8"
+	openMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+// metricWriter writes metric families in the Prometheus text format, or in
+// OpenMetrics when the scraper asks for it, escaping HELP text and label values
+// so service names and URLs can't break the output
+type metricWriter struct {
+	w           io.Writer
+	openMetrics bool
+	families    int // families written so far
+
+	// Series limits, when set, and what the current family used of them
+	guard   *cardinalityGuard
+	current string
+	limits  familyLimits
+	dropped map[string]int // series dropped by family
+}
+
+// newMetricWriter picks the format from the request's Accept header and sets
+// the response's Content-Type to match
+func newMetricWriter(w http.ResponseWriter, r *http.Request, guard *cardinalityGuard) *metricWriter {
+	m := &metricWriter{w: w, openMetrics: acceptsOpenMetrics(r.Header.Get("Accept")), guard: guard, dropped: make(map[string]int)}
+	if m.openMetrics {
+		w.Header().Set("Content-Type", openMetricsContentType)
+	} else {
+		w.Header().Set("Content-Type", promTextContentType)
+	}
+	return m
+}
+
+// acceptsOpenMetrics reports whether an Accept header lists OpenMetrics
+// without refusing it (q=0)
+func acceptsOpenMetrics(accept string) bool {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, _ := strings.Cut(part, ";")
+		if strings.TrimSpace(mediaType) != "application/openmetrics-text" {
+			continue
+		}
+		for _, param := range strings.Split(params, ";") {
+			if k, v, ok := strings.Cut(strings.TrimSpace(param), "="); ok && k == "q" {
+				if q, err := strconv.ParseFloat(v, 64); err == nil && q == 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// family starts a metric family; its samples must follow before the next one.
+// In OpenMetrics a counter family is named without its samples' _total suffix.
+func (m *metricWriter) family(name, typ, help string) {
+	m.current = name
+	m.limits = familyLimits{series: make(map[string]bool), rejected: make(map[string]bool), values: make(map[string]map[string]bool)}
+	if m.openMetrics && typ == "counter" {
+		name = strings.TrimSuffix(name, "_total")
+	}
+	if m.families > 0 && !m.openMetrics {
+		io.WriteString(m.w, "\n")
+	}
+	m.families++
+	io.WriteString(m.w, "# HELP "+name+" "+m.escapeHelp(help)+"\n")
+	io.WriteString(m.w, "# TYPE "+name+" "+typ+"\n")
+}
+
+// sample writes one sample of the current family; labels are name, value pairs.
+// Samples of series over the guard's limits are dropped.
+func (m *metricWriter) sample(name string, value float64, labels ...string) {
+	if m.guard != nil && !m.admit(labels) {
+		return
+	}
+	var b strings.Builder
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(labels[i])
+			b.WriteString(`="`)
+			b.WriteString(escapeLabelValue(labels[i+1]))
+			b.WriteByte('"')
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(formatMetricValue(value))
+	b.WriteByte('\n')
+	io.WriteString(m.w, b.String())
+}
+
+// histogram writes the buckets, sum and count of a histogram sample. counts
+// are the observations per bucket of bounds, not cumulative, plus one more for
+// those above every bound.
+func (m *metricWriter) histogram(name string, bounds []float64, counts []int64, sum float64, labels ...string) {
+	var cumulative int64
+	for i, bound := range bounds {
+		cumulative += counts[i]
+		m.sample(name+"_bucket", float64(cumulative), append(labels[:len(labels):len(labels)], "le", m.formatBound(bound))...)
+	}
+	cumulative += counts[len(bounds)]
+	m.sample(name+"_bucket", float64(cumulative), append(labels[:len(labels):len(labels)], "le", "+Inf")...)
+	m.sample(name+"_sum", sum, labels...)
+	m.sample(name+"_count", float64(cumulative), labels...)
+}
+
+// Close ends the exposition; OpenMetrics requires a closing # EOF
+func (m *metricWriter) Close() {
+	if m.openMetrics {
+		io.WriteString(m.w, "# EOF\n")
+	}
+}
+
+var (
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	promHelpEscaper   = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabelValue(s string) string { return labelValueEscaper.Replace(s) }
+
+// escapeHelp escapes HELP text; OpenMetrics escapes quotes there as well
+func (m *metricWriter) escapeHelp(s string) string {
+	if m.openMetrics {
+		return labelValueEscaper.Replace(s)
+	}
+	return promHelpEscaper.Replace(s)
+}
+
+// formatMetricValue writes whole numbers without an exponent and everything
+// else in the shortest form that round-trips
+func formatMetricValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case v == math.Trunc(v) && math.Abs(v) < 1e15:
+		return strconv.FormatInt(int64(v), 10)
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+// formatBound formats a histogram bucket bound; OpenMetrics wants canonical
+// floats there, such as 1.0 rather than 1
+func (m *metricWriter) formatBound(bound float64) string {
+	s := strconv.FormatFloat(bound, 'g', -1, 64)
+	if m.openMetrics && !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+	return s
+}
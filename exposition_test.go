@@ -0,0 +1,63 @@
+// exposition_test.go
+package main
+
+import "testing"
+
+func TestAcceptsOpenMetrics(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   bool
+	}{
+		{"", false},
+		{"text/plain", false},
+		{"application/openmetrics-text", true},
+		{"application/openmetrics-text; version=1.0.0; charset=utf-8", true},
+		{"application/openmetrics-text;version=1.0.0;q=0.5,text/plain;version=0.0.4;q=0.3,*/*;q=0.1", true},
+		{"text/plain, application/openmetrics-text;q=0", false},
+		{"application/openmetrics-text; q=0.0", false},
+		{"application/openmetrics-textile", false},
+	}
+	for _, tt := range tests {
+		if got := acceptsOpenMetrics(tt.accept); got != tt.want {
+			t.Errorf("acceptsOpenMetrics(%q) = %v, want %v", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestEscapeLabelValue(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"api", "api"},
+		{`say "hi"`, `say \"hi\"`},
+		{`C:\checks`, `C:\\checks`},
+		{"two\nlines", `two\nlines`},
+		{`\"`, `\\\"`},
+	}
+	for _, tt := range tests {
+		if got := escapeLabelValue(tt.in); got != tt.want {
+			t.Errorf("escapeLabelValue(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFormatBound(t *testing.T) {
+	tests := []struct {
+		bound      float64
+		prom, open string
+	}{
+		{1, "1", "1.0"},
+		{0.25, "0.25", "0.25"},
+		{2500, "2500", "2500.0"},
+		{1e21, "1e+21", "1e+21"},
+	}
+	prom, open := &metricWriter{}, &metricWriter{openMetrics: true}
+	for _, tt := range tests {
+		if got := prom.formatBound(tt.bound); got != tt.prom {
+			t.Errorf("formatBound(%v) = %q, want %q", tt.bound, got, tt.prom)
+		}
+		if got := open.formatBound(tt.bound); got != tt.open {
+			t.Errorf("formatBound(%v) in OpenMetrics = %q, want %q", tt.bound, got, tt.open)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+// filter.go
+package main
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// StatusFilter selects and groups services for the status API and dashboard
+type StatusFilter struct {
+	Query   string            // case-insensitive text search
+	State   string            // healthy, unhealthy or warning
+	Labels  map[string]string // every label must match
+	Tag     string            // service must carry this tag
+	GroupBy string            // "tag" or a label key such as team or env
+}
+
+// parseStatusFilter reads a StatusFilter from query parameters
+func parseStatusFilter(values url.Values) StatusFilter {
+	f := StatusFilter{
+		Query:   strings.TrimSpace(values.Get("q")),
+		State:   values.Get("state"),
+		Tag:     values.Get("tag"),
+		GroupBy: values.Get("group_by"),
+	}
+	for _, selector := range values["label"] {
+		if k, v, ok := strings.Cut(selector, "="); ok {
+			if f.Labels == nil {
+				f.Labels = make(map[string]string)
+			}
+			f.Labels[k] = v
+		}
+	}
+	return f
+}
+
+// Match reports whether a status passes the filter
+func (f StatusFilter) Match(status *HealthStatus) bool {
+	switch f.State {
+	case "healthy":
+		if !status.Healthy {
+			return false
+		}
+	case "unhealthy":
+		if status.Healthy {
+			return false
+		}
+	case "warning":
+		if len(status.Warnings) == 0 {
+			return false
+		}
+	}
+
+	for k, v := range f.Labels {
+		if status.Labels[k] != v {
+			return false
+		}
+	}
+
+	if f.Tag != "" && !containsString(status.Tags, f.Tag) {
+		return false
+	}
+
+	if f.Query != "" {
+		return strings.Contains(strings.ToLower(searchText(status)), strings.ToLower(f.Query))
+	}
+	return true
+}
+
+// Apply returns the matching statuses, their names in display order and, when
+// grouping is requested, the names in each group
+func (f StatusFilter) Apply(statuses map[string]*HealthStatus) (map[string]*HealthStatus, []string, map[string][]string) {
+	matched := make(map[string]*HealthStatus)
+	order := make([]string, 0, len(statuses))
+	for name, status := range statuses {
+		if f.Match(status) {
+			matched[name] = status
+			order = append(order, name)
+		}
+	}
+	sort.Strings(order)
+
+	if f.GroupBy == "" {
+		return matched, order, nil
+	}
+
+	groups := make(map[string][]string)
+	for _, name := range order {
+		for _, group := range groupKeys(matched[name], f.GroupBy) {
+			groups[group] = append(groups[group], name)
+		}
+	}
+	return matched, order, groups
+}
+
+// groupKeys returns the groups a service belongs to; services with several tags appear in each
+func groupKeys(status *HealthStatus, groupBy string) []string {
+	if groupBy == "tag" {
+		if len(status.Tags) == 0 {
+			return []string{"(untagged)"}
+		}
+		return status.Tags
+	}
+
+	if v := status.Labels[groupBy]; v != "" {
+		return []string{v}
+	}
+	return []string{"(no " + groupBy + ")"}
+}
+
+// searchText is the text matched by free-text search
+func searchText(status *HealthStatus) string {
+	parts := []string{status.Name, status.URL, status.Error}
+	parts = append(parts, status.Tags...)
+	for k, v := range status.Labels {
+		parts = append(parts, k+"="+v)
+	}
+	return strings.Join(parts, " ")
+}
+
+// containsString reports whether list contains s
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+// flapping.go
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// defaultFlapWindow is the window state changes are counted over when flap_window is unset
+const defaultFlapWindow = 10 * time.Minute
+
+// trackFlapping records a state change at t, when there was one, and marks the
+// service flapping while it changed state more than FlapThreshold times within
+// FlapWindow. Must be called with hc.mu held.
+func (hc *HealthChecker) trackFlapping(svc Service, status *HealthStatus, transition bool, t time.Time) {
+	if svc.FlapThreshold <= 0 {
+		status.transitions, status.StateChanges, status.Flapping = nil, 0, false
+		return
+	}
+	window := time.Duration(svc.FlapWindow)
+	if window == 0 {
+		window = defaultFlapWindow
+	}
+
+	// Build a new slice: copies handed out by GetStatuses share the old one
+	var kept []time.Time
+	for _, changed := range status.transitions {
+		if t.Sub(changed) < window {
+			kept = append(kept, changed)
+		}
+	}
+	if transition {
+		kept = append(kept, t)
+	}
+	status.transitions = kept
+	status.StateChanges = len(kept)
+
+	flapping := len(kept) > svc.FlapThreshold
+	if flapping == status.Flapping {
+		return
+	}
+	status.Flapping = flapping
+	hc.changed(status.Name)
+	if flapping {
+		message := fmt.Sprintf("%d state changes in %s", len(kept), window)
+		slog.Warn("service flapping", "service", status.Name, "state_changes", len(kept), "window", window.String())
+		hc.events.Add(Event{Time: t, Service: status.Name, Type: EventFlappingStarted, Message: message})
+	} else {
+		slog.Info("service stopped flapping", "service", status.Name)
+		hc.events.Add(Event{Time: t, Service: status.Name, Type: EventFlappingStopped, Message: "stopped flapping"})
+	}
+}
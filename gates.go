@@ -0,0 +1,151 @@
+// gates.go
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultGateHealthyFor is how long services must have been healthy when healthy_for is unset
+const defaultGateHealthyFor = 10 * time.Minute
+
+// GateConfig defines a deployment gate: a group of services that must be in good
+// shape before a pipeline rolls out to them
+type GateConfig struct {
+	Name     string `json:"name"`
+	Selector string `json:"selector"` // services in the group, e.g. team=payments,tier=critical
+
+	// Every service must have passed every check for this long (default 10m)
+	HealthyFor Duration `json:"healthy_for,omitempty"`
+
+	// Pass even while a service has an open incident, e.g. to ship the fix for it
+	AllowOpenIncidents bool `json:"allow_open_incidents,omitempty"`
+}
+
+// Validate checks a gate definition; prefix is prepended to field names
+func (g GateConfig) Validate(prefix string) []ValidationError {
+	var errs []ValidationError
+	add := func(name, format string, args ...interface{}) {
+		errs = append(errs, ValidationError{Field: prefix + name, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if strings.TrimSpace(g.Name) == "" || strings.Contains(g.Name, "/") {
+		add("name", "name is required and must not contain /")
+	}
+	if _, err := parseSelector(g.Selector); err != nil {
+		add("selector", "%v", err)
+	}
+	if g.HealthyFor < 0 {
+		add("healthy_for", "must not be negative")
+	}
+	return errs
+}
+
+// gateService is one service's part in a gate result
+type gateService struct {
+	Name         string     `json:"name"`
+	Pass         bool       `json:"pass"`
+	HealthySince *time.Time `json:"healthy_since,omitempty"`
+	Reason       string     `json:"reason,omitempty"`
+}
+
+// GateResult reports whether a gate passes and why not
+type GateResult struct {
+	Gate      string        `json:"gate"`
+	Pass      bool          `json:"pass"`
+	Reasons   []string      `json:"reasons,omitempty"`
+	Services  []gateService `json:"services"`
+	CheckedAt time.Time     `json:"checked_at"`
+}
+
+// healthySince returns when a service's current run of successful checks began,
+// reporting false when its last check failed or it has none. A run that covers
+// all of the kept history began at the oldest kept check.
+func healthySince(history []CheckRecord) (time.Time, bool) {
+	if len(history) == 0 || !history[len(history)-1].Healthy {
+		return time.Time{}, false
+	}
+	since := history[0].Time
+	for i := len(history) - 1; i > 0; i-- {
+		if !history[i-1].Healthy {
+			since = history[i].Time
+			break
+		}
+	}
+	return since, true
+}
+
+// EvaluateGate checks a gate's conditions against the services it selects.
+// Paused services are left out.
+func (hc *HealthChecker) EvaluateGate(g GateConfig, now time.Time) GateResult {
+	result := GateResult{Gate: g.Name, Pass: true, Services: []gateService{}, CheckedAt: now}
+	healthyFor := time.Duration(g.HealthyFor)
+	if healthyFor == 0 {
+		healthyFor = defaultGateHealthyFor
+	}
+
+	sel, _ := parseSelector(g.Selector)
+	statuses := hc.GetStatuses()
+	names := make([]string, 0, len(statuses))
+	for name, status := range statuses {
+		if !status.Paused && sel.Matches(name, status.Labels, status.Tags) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		result.Pass = false
+		result.Reasons = append(result.Reasons, "no services match "+sel.String())
+	}
+
+	for _, name := range names {
+		status := statuses[name]
+		gs := gateService{Name: name, Pass: true}
+		since, healthy := healthySince(hc.History(name, 0))
+		if healthy {
+			gs.HealthySince = &since
+		}
+		switch {
+		case status.Incident != nil && !g.AllowOpenIncidents:
+			gs.Pass, gs.Reason = false, "open incident "+status.Incident.ID
+		case status.Pending:
+			gs.Pass, gs.Reason = false, "not checked yet"
+		case status.Stale:
+			gs.Pass, gs.Reason = false, "checks are stale"
+		case !healthy:
+			gs.Pass, gs.Reason = false, "last check failed"
+		case now.Sub(since) < healthyFor:
+			gs.Pass, gs.Reason = false, fmt.Sprintf("healthy for %s, needs %s", now.Sub(since).Round(time.Second), healthyFor)
+		}
+		if !gs.Pass {
+			result.Pass = false
+			result.Reasons = append(result.Reasons, name+": "+gs.Reason)
+		}
+		result.Services = append(result.Services, gs)
+	}
+	return result
+}
+
+// GateHandler answers whether it's safe to deploy to a gate's services:
+// 200 when the gate passes, 412 when it doesn't, with the reasons
+func (hc *HealthChecker) GateHandler(gates []GateConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("group")
+		for _, g := range gates {
+			if g.Name != name {
+				continue
+			}
+			result := hc.EvaluateGate(g, time.Now())
+			code := http.StatusOK
+			if !result.Pass {
+				code = http.StatusPreconditionFailed
+			}
+			writeJSON(w, code, result)
+			return
+		}
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("unknown gate %q", name)})
+	}
+}
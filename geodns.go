@@ -0,0 +1,163 @@
+// geodns.go
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// geoTarget is one address a GeoDNS check probes, with the resolvers that returned it
+type geoTarget struct {
+	ip        string
+	resolvers []string
+	err       error
+	result    CheckResult
+}
+
+// urlPort returns the port of an http or https URL, defaulting by scheme
+func urlPort(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return port
+	}
+	if u.Scheme == "https" {
+		return "443"
+	}
+	return "80"
+}
+
+// pinnedTransport returns a transport that connects to addr for requests to
+// target (host:port) and dials other hosts, such as redirect targets, as usual.
+// Keep-alives are off, so each check makes a fresh connection. A nil tlsConfig
+// keeps the default settings.
+func pinnedTransport(target, addr string, tlsConfig *tls.Config) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DisableKeepAlives = true
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig.Clone()
+	}
+	dial := transport.DialContext
+	transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		if address == target {
+			address = addr
+		}
+		return dial(ctx, network, address)
+	}
+	return transport
+}
+
+// probeGeoHTTP resolves the URL's host through each of GeoResolvers and checks
+// every distinct address returned, keeping the URL's host for Host and SNI. Any
+// resolver that can't resolve the host, or any address that fails the check,
+// fails the service, catching a broken member of an anycast or GeoDNS pool that
+// a single resolution would only hit some of the time.
+func probeGeoHTTP(ctx context.Context, svc Service, result *CheckResult) error {
+	u, err := url.Parse(svc.URL)
+	if err != nil {
+		return err
+	}
+	port := urlPort(u)
+	tlsConfig, err := serviceTLSConfig(svc)
+	if err != nil {
+		return err
+	}
+	network := "ip4"
+	if strings.EqualFold(svc.RecordType, "AAAA") {
+		network = "ip6"
+	}
+
+	var resolverFailures []string
+	targets := make(map[string]*geoTarget)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, server := range svc.GeoResolvers {
+		wg.Add(1)
+		go func(server string) {
+			defer wg.Done()
+			ips, err := resolveVia(ctx, server, network, u.Hostname())
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				resolverFailures = append(resolverFailures, fmt.Sprintf("resolver %s: %v", server, err))
+				return
+			}
+			for _, ip := range ips {
+				if targets[ip] == nil {
+					targets[ip] = &geoTarget{ip: ip}
+				}
+				targets[ip].resolvers = append(targets[ip].resolvers, server)
+			}
+		}(server)
+	}
+	wg.Wait()
+
+	for _, t := range targets {
+		wg.Add(1)
+		go func(t *geoTarget) {
+			defer wg.Done()
+			transport := pinnedTransport(net.JoinHostPort(u.Hostname(), port), net.JoinHostPort(t.ip, port), tlsConfig)
+			t.err = checkHTTP(ctx, probeClient(transport), svc, &t.result)
+		}(t)
+	}
+	wg.Wait()
+
+	sort.Strings(resolverFailures)
+	failures := resolverFailures
+	ordered := make([]*geoTarget, 0, len(targets))
+	for _, t := range targets {
+		sort.Strings(t.resolvers)
+		ordered = append(ordered, t)
+	}
+	sort.Slice(ordered, func(i, j int) bool { return ordered[i].ip < ordered[j].ip })
+
+	for _, t := range ordered {
+		if t.err != nil {
+			failures = append(failures, fmt.Sprintf("%s (via %s): %v", t.ip, strings.Join(t.resolvers, ", "), t.err))
+			continue
+		}
+		if result.TLS == nil {
+			result.TLS = t.result.TLS
+			result.ClockSkew = t.result.ClockSkew
+		}
+		for _, warning := range t.result.Warnings {
+			result.Warnings = append(result.Warnings, t.ip+": "+warning)
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("%d of %d resolvers and addresses failing: %s",
+			len(failures), len(svc.GeoResolvers)+len(ordered), strings.Join(failures, "; "))
+	}
+	if len(ordered) == 0 {
+		return errors.New("no addresses to check")
+	}
+	return nil
+}
+
+// resolveVia looks up host's addresses through one resolver
+func resolveVia(ctx context.Context, server, network, host string) ([]string, error) {
+	resolver, err := resolverFor(server)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := resolver.LookupIP(ctx, network, host)
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return nil, fmt.Errorf("NXDOMAIN: %s", host)
+	}
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]string, len(ips))
+	for i, ip := range ips {
+		addrs[i] = ip.String()
+	}
+	return addrs, nil
+}
@@ -0,0 +1,27 @@
+// grace.go
+package main
+
+import "time"
+
+// graceUntil returns when a service's warm-up grace period ends: grace_period,
+// or grace_checks intervals if that's longer, after its checks last started,
+// i.e. since it was added, updated or resumed, or the checker started. It
+// returns the zero time for services without one. Must be called with hc.mu held.
+func (hc *HealthChecker) graceUntil(name string) time.Time {
+	svc := hc.services[name]
+	grace := time.Duration(svc.GracePeriod)
+	if checks := time.Duration(svc.GraceChecks) * time.Duration(svc.Interval); checks > grace {
+		grace = checks
+	}
+	started, ok := hc.monitorStarts[name]
+	if grace <= 0 || !ok {
+		return time.Time{}
+	}
+	return started.Add(grace)
+}
+
+// inGracePeriod reports whether a service is still warming up at t. Must be
+// called with hc.mu held.
+func (hc *HealthChecker) inGracePeriod(name string, t time.Time) bool {
+	return t.Before(hc.graceUntil(name))
+}
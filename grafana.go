@@ -0,0 +1,235 @@
+// grafana.go
+package main
+
+import (
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// grafanaPanel is the subset of a Grafana panel definition the generator uses
+type grafanaPanel map[string]interface{}
+
+// addGrafanaPanel appends a panel at the given grid position, numbered after the others
+func addGrafanaPanel(panels []grafanaPanel, p grafanaPanel, x, y, w, h int) []grafanaPanel {
+	p["id"] = len(panels) + 1
+	p["datasource"] = "${datasource}"
+	p["gridPos"] = map[string]int{"x": x, "y": y, "w": w, "h": h}
+	return append(panels, p)
+}
+
+// grafanaTarget returns a Prometheus query target
+func grafanaTarget(expr, legend string) map[string]interface{} {
+	return map[string]interface{}{
+		"expr":         expr,
+		"legendFormat": legend,
+		"refId":        "A",
+	}
+}
+
+// generateGrafanaDashboard builds an importable Grafana dashboard for the
+// services, or for their groups when metrics are grouped by a label
+func generateGrafanaDashboard(services []Service, metrics MetricsConfig, title string) map[string]interface{} {
+	if metrics.GroupBy != "" {
+		return generateGroupGrafanaDashboard(services, metrics.GroupBy, title)
+	}
+	names := make([]string, 0, len(services))
+	for _, svc := range services {
+		names = append(names, svc.Name)
+	}
+	servicesVar := `{service=~"$service"}`
+
+	var panels []grafanaPanel
+	add := func(p grafanaPanel, x, y, w, h int) { panels = addGrafanaPanel(panels, p, x, y, w, h) }
+
+	add(grafanaPanel{
+		"type":  "stat",
+		"title": "Service Status Overview",
+		"fieldConfig": map[string]interface{}{
+			"defaults": map[string]interface{}{
+				"mappings": []interface{}{map[string]interface{}{
+					"type": "value",
+					"options": map[string]interface{}{
+						"0": map[string]string{"text": "DOWN", "color": "red"},
+						"1": map[string]string{"text": "UP", "color": "green"},
+					},
+				}},
+				"thresholds": grafanaThresholds("red", map[string]interface{}{"color": "green", "value": 1}),
+			},
+		},
+		"options": map[string]interface{}{"colorMode": "background", "graphMode": "none", "reduceOptions": map[string]interface{}{"calcs": []string{"lastNotNull"}}},
+		"targets": []interface{}{grafanaTarget("service_up"+servicesVar, "{{ service }}")},
+	}, 0, 0, 24, 6)
+
+	add(grafanaPanel{
+		"type":        "timeseries",
+		"title":       "Response Time",
+		"fieldConfig": map[string]interface{}{"defaults": map[string]interface{}{"unit": "ms"}},
+		"targets":     []interface{}{grafanaTarget("service_response_time_ms"+servicesVar, "{{ service }}")},
+	}, 0, 6, 12, 8)
+
+	add(grafanaPanel{
+		"type":        "timeseries",
+		"title":       "Availability (1h)",
+		"fieldConfig": map[string]interface{}{"defaults": map[string]interface{}{"unit": "percentunit", "min": 0, "max": 1}},
+		"targets":     []interface{}{grafanaTarget("avg_over_time(service_up"+servicesVar+"[1h])", "{{ service }}")},
+	}, 12, 6, 12, 8)
+
+	add(grafanaPanel{
+		"type":    "timeseries",
+		"title":   "Warnings",
+		"targets": []interface{}{grafanaTarget("service_warnings"+servicesVar, "{{ service }}")},
+	}, 0, 14, 12, 8)
+
+	add(grafanaPanel{
+		"type":        "timeseries",
+		"title":       "Clock Skew",
+		"fieldConfig": map[string]interface{}{"defaults": map[string]interface{}{"unit": "s"}},
+		"targets":     []interface{}{grafanaTarget("service_clock_skew_seconds"+servicesVar, "{{ service }}")},
+	}, 12, 14, 12, 8)
+
+	// One latency panel per configured service, with its alert thresholds drawn in
+	y := 22
+	add(grafanaPanel{"type": "row", "title": "Services", "collapsed": false}, 0, y, 24, 1)
+	y++
+	for i, svc := range services {
+		warn := orDefault(svc.AlertLatencyWarning, defaultAlertLatencyWarning).Milliseconds()
+		crit := orDefault(svc.AlertLatencyCritical, defaultAlertLatencyCritical).Milliseconds()
+		selector := "{service=" + promLabelValue(svc.Name) + "}"
+		add(grafanaPanel{
+			"type":        "timeseries",
+			"title":       svc.Name,
+			"description": svc.URL,
+			"fieldConfig": map[string]interface{}{
+				"defaults": map[string]interface{}{
+					"unit":   "ms",
+					"custom": map[string]interface{}{"thresholdsStyle": map[string]string{"mode": "line"}},
+					"thresholds": grafanaThresholds("green",
+						map[string]interface{}{"color": "orange", "value": warn},
+						map[string]interface{}{"color": "red", "value": crit},
+					),
+				},
+			},
+			"targets": []interface{}{grafanaTarget("service_response_time_ms"+selector, "response time")},
+		}, (i%3)*8, y+(i/3)*7, 8, 7)
+	}
+
+	return grafanaDashboard(title, panels, "service", "Service", names)
+}
+
+// generateGroupGrafanaDashboard builds a dashboard over the service_group_*
+// series written when metrics are grouped by the groupBy label
+func generateGroupGrafanaDashboard(services []Service, groupBy, title string) map[string]interface{} {
+	groups := make(map[string]bool)
+	for _, svc := range services {
+		if group := svc.Labels[groupBy]; group != "" { // services without the label show under All
+			groups[group] = true
+		}
+	}
+	groupVar := `group=~"$group"`
+
+	var panels []grafanaPanel
+	add := func(p grafanaPanel, x, y, w, h int) { panels = addGrafanaPanel(panels, p, x, y, w, h) }
+
+	add(grafanaPanel{
+		"type":  "stat",
+		"title": "Services Down by " + groupBy,
+		"fieldConfig": map[string]interface{}{
+			"defaults": map[string]interface{}{
+				"thresholds": grafanaThresholds("green", map[string]interface{}{"color": "red", "value": 1}),
+			},
+		},
+		"options": map[string]interface{}{"colorMode": "background", "graphMode": "none", "reduceOptions": map[string]interface{}{"calcs": []string{"lastNotNull"}}},
+		"targets": []interface{}{grafanaTarget(`service_group_services{`+groupVar+`, state="down"}`, "{{ group }}")},
+	}, 0, 0, 24, 6)
+
+	add(grafanaPanel{
+		"type":        "timeseries",
+		"title":       "Weighted Health",
+		"fieldConfig": map[string]interface{}{"defaults": map[string]interface{}{"unit": "percent", "min": 0, "max": 100}},
+		"targets":     []interface{}{grafanaTarget(`service_group_weighted_health{`+groupVar+`}`, "{{ group }}")},
+	}, 0, 6, 12, 8)
+
+	add(grafanaPanel{
+		"type":    "timeseries",
+		"title":   "Services by State",
+		"targets": []interface{}{grafanaTarget(`sum by(state) (service_group_services{`+groupVar+`})`, "{{ state }}")},
+	}, 12, 6, 12, 8)
+
+	add(grafanaPanel{
+		"type":        "timeseries",
+		"title":       "Check Duration (p95)",
+		"fieldConfig": map[string]interface{}{"defaults": map[string]interface{}{"unit": "s"}},
+		"targets": []interface{}{grafanaTarget(
+			`histogram_quantile(0.95, sum by(group, le) (rate(service_group_check_duration_seconds_bucket{`+groupVar+`}[5m])))`, "{{ group }}")},
+	}, 0, 14, 12, 8)
+
+	add(grafanaPanel{
+		"type":        "timeseries",
+		"title":       "Failed Checks",
+		"fieldConfig": map[string]interface{}{"defaults": map[string]interface{}{"unit": "percentunit", "min": 0, "max": 1}},
+		"targets": []interface{}{grafanaTarget(
+			`sum by(group) (rate(service_group_checks_total{`+groupVar+`, result="failure"}[5m])) / sum by(group) (rate(service_group_checks_total{`+groupVar+`}[5m]))`, "{{ group }}")},
+	}, 12, 14, 12, 8)
+
+	return grafanaDashboard(title, panels, "group", groupBy, slices.Sorted(maps.Keys(groups)))
+}
+
+// grafanaDashboard wraps panels in a dashboard with a data source variable and
+// a multi-value variable over values
+func grafanaDashboard(title string, panels []grafanaPanel, variable, label string, values []string) map[string]interface{} {
+	options := []map[string]interface{}{{"text": "All", "value": "$__all", "selected": true}}
+	for _, v := range values {
+		options = append(options, map[string]interface{}{"text": v, "value": v, "selected": false})
+	}
+
+	return map[string]interface{}{
+		"title":         title,
+		"uid":           "sre-health-generated",
+		"tags":          []string{"sre", "health-check", "generated"},
+		"schemaVersion": 36,
+		"editable":      true,
+		"refresh":       "30s",
+		"time":          map[string]string{"from": "now-6h", "to": "now"},
+		"panels":        panels,
+		"templating": map[string]interface{}{
+			"list": []interface{}{
+				map[string]interface{}{
+					"name":  "datasource",
+					"label": "Data source",
+					"type":  "datasource",
+					"query": "prometheus",
+				},
+				map[string]interface{}{
+					"name":       variable,
+					"label":      label,
+					"type":       "custom",
+					"query":      strings.Join(values, ","),
+					"multi":      true,
+					"includeAll": true,
+					"allValue":   ".*",
+					"current":    map[string]interface{}{"text": "All", "value": []string{"$__all"}},
+					"options":    options,
+				},
+			},
+		},
+	}
+}
+
+// grafanaThresholds returns absolute thresholds starting at the base color
+func grafanaThresholds(base string, steps ...map[string]interface{}) map[string]interface{} {
+	all := []interface{}{map[string]interface{}{"color": base, "value": nil}}
+	for _, s := range steps {
+		all = append(all, s)
+	}
+	return map[string]interface{}{"mode": "absolute", "steps": all}
+}
+
+// GrafanaDashboardHandler serves a Grafana dashboard generated for the current services
+func (hc *HealthChecker) GrafanaDashboardHandler(title string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dashboard := generateGrafanaDashboard(hc.Services(), MetricsConfig{GroupBy: hc.metricsGroupBy}, title)
+		writeJSON(w, http.StatusOK, dashboard)
+	}
+}
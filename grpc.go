@@ -0,0 +1,181 @@
+// grpc.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"golang.org/x/net/http2"
+)
+
+// grpcServingStatus names the values of grpc.health.v1.HealthCheckResponse.ServingStatus
+var grpcServingStatus = map[uint64]string{
+	0: "UNKNOWN",
+	1: "SERVING",
+	2: "NOT_SERVING",
+	3: "SERVICE_UNKNOWN",
+}
+
+// grpcClients hold one transport per scheme so connections are reused across checks
+var (
+	grpcPlaintextClient = &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}}
+	grpcTLSClient = &http.Client{Transport: &http2.Transport{}}
+)
+
+// probeGRPC calls grpc.health.v1.Health/Check on a grpc:// (plaintext) or
+// grpcs:// (TLS) URL and expects SERVING. GRPCService names the service to ask
+// about; empty asks about the server as a whole.
+func probeGRPC(ctx context.Context, svc Service, result *CheckResult) error {
+	u, err := url.Parse(svc.URL)
+	if err != nil {
+		return err
+	}
+	client, scheme := grpcPlaintextClient, "http"
+	if u.Scheme == "grpcs" {
+		client, scheme = grpcTLSClient, "https"
+		if svc.TLS != nil {
+			t, err := svc.TLS.transports()
+			if err != nil {
+				return fmt.Errorf("client TLS: %w", err)
+			}
+			client = &http.Client{Transport: t.grpc}
+		}
+	}
+
+	// HealthCheckRequest has one field: string service = 1
+	var msg []byte
+	if svc.GRPCService != "" {
+		msg = append([]byte{0x0a}, binary.AppendUvarint(nil, uint64(len(svc.GRPCService)))...)
+		msg = append(msg, svc.GRPCService...)
+	}
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	frame = append(frame, msg...)
+
+	endpoint := scheme + "://" + u.Host + "/grpc.health.v1.Health/Check"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(frame))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+
+	req, finish := traceRequest(req)
+	resp, err := client.Do(req)
+	finish(resp, err)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return err
+	}
+
+	if resp.TLS != nil && len(resp.TLS.PeerCertificates) > 0 {
+		result.TLS = newTLSInfo(resp.TLS.PeerCertificates[0])
+		if err := verifyCertPins(svc, resp.TLS.PeerCertificates[0], result.TLS); err != nil {
+			return err
+		}
+		if err := checkCertExpiry(svc, result.TLS, result); err != nil {
+			return err
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &httpStatusError{code: resp.StatusCode}
+	}
+
+	// Errors come in the trailers, or in the headers of a trailers-only response
+	code := resp.Trailer.Get("Grpc-Status")
+	message := resp.Trailer.Get("Grpc-Message")
+	if code == "" {
+		code, message = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	}
+	switch code {
+	case "0":
+	case "5":
+		return fmt.Errorf("gRPC service %q not found", svc.GRPCService)
+	case "12":
+		return errors.New("gRPC health service not implemented")
+	case "":
+		return errors.New("gRPC response without status")
+	default:
+		if message, err := url.PathUnescape(message); err == nil && message != "" {
+			return fmt.Errorf("gRPC status %s: %s", code, message)
+		}
+		return fmt.Errorf("gRPC status %s", code)
+	}
+
+	status, err := grpcHealthStatus(body)
+	if err != nil {
+		return err
+	}
+	if status != 1 {
+		name := grpcServingStatus[status]
+		if name == "" {
+			name = strconv.FormatUint(status, 10)
+		}
+		return fmt.Errorf("gRPC health %s", name)
+	}
+	return nil
+}
+
+// grpcHealthStatus decodes the status field of a framed HealthCheckResponse.
+// A message without the field carries the default value, UNKNOWN.
+func grpcHealthStatus(body []byte) (uint64, error) {
+	if len(body) < 5 {
+		return 0, errors.New("gRPC response without a message")
+	}
+	if body[0] != 0 {
+		return 0, errors.New("compressed gRPC responses are not supported")
+	}
+	size := binary.BigEndian.Uint32(body[1:5])
+	if uint64(len(body)-5) < uint64(size) {
+		return 0, errors.New("truncated gRPC message")
+	}
+	msg := body[5 : 5+size]
+
+	var status uint64
+	for len(msg) > 0 {
+		tag, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return 0, errors.New("malformed gRPC message")
+		}
+		msg = msg[n:]
+		switch tag & 7 {
+		case 0: // varint
+			v, n := binary.Uvarint(msg)
+			if n <= 0 {
+				return 0, errors.New("malformed gRPC message")
+			}
+			msg = msg[n:]
+			if tag>>3 == 1 {
+				status = v
+			}
+		case 2: // length-delimited
+			l, n := binary.Uvarint(msg)
+			if n <= 0 || uint64(len(msg)-n) < l {
+				return 0, errors.New("malformed gRPC message")
+			}
+			msg = msg[n+int(l):]
+		default:
+			return 0, fmt.Errorf("unexpected wire type %d in gRPC message", tag&7)
+		}
+	}
+	return status, nil
+}
@@ -0,0 +1,114 @@
+// handlers.go
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// StatusHandler provides JSON status endpoint. Query parameters q, state, label, tag
+// and group_by narrow and group the services; overall health reflects the selection.
+func (hc *HealthChecker) StatusHandler(w http.ResponseWriter, r *http.Request) {
+	// Read before the statuses, so a change in between is seen again rather than missed
+	revision, _ := hc.Revision()
+	statuses, order, groups := parseStatusFilter(r.URL.Query()).Apply(hc.GetStatuses())
+
+	// Calculate overall health; paused services don't count, and it isn't known
+	// until every selected service has completed a check
+	pending := pendingServices(statuses)
+	allHealthy := len(pending) == 0
+	for _, status := range statuses {
+		if (!status.Healthy || status.Stale) && !status.Paused && !status.Pending {
+			allHealthy = false
+			break
+		}
+	}
+
+	response := map[string]interface{}{
+		"healthy":      allHealthy,
+		"health_score": healthScore(statuses),
+		"services":     statuses,
+		"order":        order,
+		"incidents":    hc.ActiveIncidents(),
+		"revision":     revision,
+	}
+	if groups != nil {
+		response["groups"] = groups
+	}
+	if len(pending) > 0 {
+		response["status"] = "warming up"
+		response["pending"] = pending
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !allHealthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// pendingServices returns the names of unpaused services that haven't completed a check, sorted
+func pendingServices(statuses map[string]*HealthStatus) []string {
+	var pending []string
+	for name, status := range statuses {
+		if status.Pending && !status.Paused {
+			pending = append(pending, name)
+		}
+	}
+	sort.Strings(pending)
+	return pending
+}
+
+// healthScore is the weighted percentage (0-100) of healthy services, ignoring
+// paused, stale and not yet checked services. It is 100 when no service carries weight.
+func healthScore(statuses map[string]*HealthStatus) float64 {
+	var total, healthy float64
+	for _, status := range statuses {
+		if status.Paused || status.Pending || status.Stale {
+			continue
+		}
+		total += status.Weight
+		if status.Healthy {
+			healthy += status.Weight
+		}
+	}
+	if total == 0 {
+		return 100
+	}
+	return math.Round(1000*healthy/total) / 10
+}
+
+// ReadyHandler reports whether every service has completed its first check and
+// every startup check passes, for use as a readiness probe. Once every service
+// has been checked, services added later don't make it not ready again. When
+// not ready, each reason is on a line of its own.
+func (hc *HealthChecker) ReadyHandler(w http.ResponseWriter, r *http.Request) {
+	hc.mu.RLock()
+	var pending []string
+	if !hc.ready {
+		pending = pendingServices(hc.statuses)
+	}
+	reasons := hc.startupReasons()
+	hc.mu.RUnlock()
+
+	if len(pending) > 0 {
+		reasons = append([]string{"warming up: " + strings.Join(pending, ", ")}, reasons...)
+	}
+	if len(reasons) > 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(strings.Join(reasons, "\n")))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
+
+// HealthHandler provides a simple health check for the monitoring service itself
+func HealthHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
@@ -0,0 +1,82 @@
+// headers.go
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultSecurityHeaders are audited when a service enables HeaderAudit without RequiredHeaders
+var defaultSecurityHeaders = []string{
+	"Strict-Transport-Security",
+	"X-Content-Type-Options",
+	"Content-Security-Policy",
+}
+
+// auditSecurityHeaders returns a warning for every required header that is missing or weak
+func auditSecurityHeaders(svc Service, resp *http.Response) []string {
+	required := svc.RequiredHeaders
+	if len(required) == 0 {
+		required = defaultSecurityHeaders
+	}
+
+	var warnings []string
+	for _, name := range required {
+		name = http.CanonicalHeaderKey(name)
+
+		// HSTS is meaningless over plain HTTP, so only require it on TLS responses
+		if name == "Strict-Transport-Security" && resp.TLS == nil {
+			continue
+		}
+
+		value := resp.Header.Get(name)
+		if value == "" {
+			warnings = append(warnings, fmt.Sprintf("missing security header %s", name))
+			continue
+		}
+		if problem := weakHeaderValue(name, value); problem != "" {
+			warnings = append(warnings, fmt.Sprintf("weak security header %s: %s", name, problem))
+		}
+	}
+	return warnings
+}
+
+// weakHeaderValue describes why a present header value doesn't provide its protection
+func weakHeaderValue(name, value string) string {
+	switch name {
+	case "X-Content-Type-Options":
+		if !strings.EqualFold(strings.TrimSpace(value), "nosniff") {
+			return fmt.Sprintf("expected nosniff, got %q", value)
+		}
+	case "Strict-Transport-Security":
+		for _, directive := range strings.Split(value, ";") {
+			k, v, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if strings.EqualFold(k, "max-age") {
+				if age, err := strconv.Atoi(strings.Trim(v, `"`)); err != nil || age <= 0 {
+					return fmt.Sprintf("invalid max-age %q", v)
+				}
+				return ""
+			}
+		}
+		return "no max-age directive"
+	}
+	return ""
+}
+
+// clockSkew returns how far the response Date header is ahead of now.
+// Date has one-second resolution, so skew below a second is reported as zero.
+func clockSkew(resp *http.Response, now time.Time) (time.Duration, bool) {
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0, false
+	}
+
+	skew := date.Sub(now.Truncate(time.Second))
+	if skew.Abs() < time.Second {
+		skew = 0
+	}
+	return skew, true
+}
@@ -0,0 +1,68 @@
+// heartbeat.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// heartbeatRegistry records when each heartbeat service last pinged
+type heartbeatRegistry struct {
+	since time.Time // pings are expected from this time on
+	last  map[string]time.Time
+	mu    sync.RWMutex
+}
+
+// heartbeats holds the pings received by the heartbeat endpoint
+var heartbeats = &heartbeatRegistry{since: time.Now(), last: make(map[string]time.Time)}
+
+// Record notes a ping from a service
+func (h *heartbeatRegistry) Record(name string, at time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.last[name] = at
+}
+
+// Last returns the time of a service's most recent ping, or when pings started
+// being expected if it hasn't pinged yet
+func (h *heartbeatRegistry) Last(name string) (time.Time, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	last, ok := h.last[name]
+	if !ok {
+		return h.since, false
+	}
+	return last, true
+}
+
+// probeHeartbeat passes while the service has pinged within its interval plus its
+// timeout, which serves as a grace period
+func probeHeartbeat(ctx context.Context, svc Service, result *CheckResult) error {
+	last, pinged := heartbeats.Last(svc.Name)
+	limit := time.Duration(svc.Interval) + time.Duration(svc.Timeout)
+	age := time.Since(last)
+	if age <= limit {
+		return nil
+	}
+	if !pinged {
+		return &heartbeatMissed{msg: fmt.Sprintf("no heartbeat received (expected every %s)", time.Duration(svc.Interval))}
+	}
+	return &heartbeatMissed{msg: fmt.Sprintf("last heartbeat %s ago (expected every %s)", age.Round(time.Second), time.Duration(svc.Interval))}
+}
+
+// HeartbeatHandler records a ping from the heartbeat service in the path
+func (hc *HealthChecker) HeartbeatHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("service")
+	svc, ok := hc.GetService(name)
+	if !ok || svc.Type != "heartbeat" {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "no heartbeat service named " + name})
+		return
+	}
+
+	now := time.Now()
+	heartbeats.Record(name, now)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"service": name, "received_at": now})
+}
@@ -0,0 +1,102 @@
+// history.go
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// historySize is the number of check results kept per service
+const historySize = 1000
+
+// CheckRecord is a single past check result
+type CheckRecord struct {
+	Time         time.Time `json:"time"`
+	Healthy      bool      `json:"healthy"`
+	ResponseTime int64     `json:"response_time_ms"`
+	Error        string    `json:"error,omitempty"`
+	Maintenance  bool      `json:"maintenance,omitempty"` // checked during a maintenance window
+	TraceID      string    `json:"trace_id,omitempty"`
+	RequestID    string    `json:"request_id,omitempty"`
+}
+
+// recordHistory adds a result to a service's history in time order, dropping the
+// oldest entries beyond historySize. Must be called with hc.mu held.
+func (hc *HealthChecker) recordHistory(name string, record CheckRecord) {
+	records := append(hc.history[name], record)
+	if i := sort.Search(len(records)-1, func(i int) bool { return records[i].Time.After(record.Time) }); i < len(records)-1 {
+		copy(records[i+1:], records[i:])
+		records[i] = record
+	}
+	if len(records) > historySize {
+		records = append(records[:0:0], records[len(records)-historySize:]...)
+	}
+	hc.history[name] = records
+	hc.store.AddResult(name, record)
+}
+
+// History returns up to limit of the most recent results for a service, oldest first.
+// A limit of zero or less returns everything kept.
+func (hc *HealthChecker) History(name string, limit int) []CheckRecord {
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+
+	records := hc.history[name]
+	if limit > 0 && len(records) > limit {
+		records = records[len(records)-limit:]
+	}
+	return append([]CheckRecord{}, records...)
+}
+
+// parseSince parses a ?since= value: an RFC 3339 time, or a window such as 1h
+// or 7d meaning that long before now
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	window, err := parseWindow(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid since %q: use an RFC 3339 time or a window such as 1h or 7d", s)
+	}
+	return now.Add(-window), nil
+}
+
+// HistoryHandler returns recent check results for ?service=, optionally limited
+// to those after ?since= and to the last ?limit=, with how many of them failed
+func (hc *HealthChecker) HistoryHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	name := q.Get("service")
+	if _, ok := hc.GetService(name); !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": errServiceNotFound.Error()})
+		return
+	}
+
+	records := hc.History(name, 0)
+	if s := q.Get("since"); s != "" {
+		since, err := parseSince(s, time.Now())
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		i := sort.Search(len(records), func(i int) bool { return !records[i].Time.Before(since) })
+		records = records[i:]
+	}
+	if limit, _ := strconv.Atoi(q.Get("limit")); limit > 0 && len(records) > limit {
+		records = records[len(records)-limit:]
+	}
+
+	failures := 0
+	for _, rec := range records {
+		if !rec.Healthy {
+			failures++
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"service":  name,
+		"results":  records,
+		"failures": failures,
+	})
+}
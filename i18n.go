@@ -0,0 +1,253 @@
+// i18n.go
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// languages are the languages alerts and the dashboard can be shown in
+var languages = []string{"en", "de", "ja"}
+
+// alertCatalogs translate the English fmt formats of alert notifications,
+// keyed by language and then by the English format. Translations can reorder
+// arguments with %[n]s. Messages missing from a catalog are sent in English.
+var alertCatalogs = map[string]map[string]string{
+	"de": {
+		"DOWN":                       "AUSGEFALLEN",
+		"RECOVERED":                  "WIEDERHERGESTELLT",
+		"WARNING":                    "WARNUNG",
+		" (still down)":              " (weiterhin ausgefallen)",
+		"%s is down.":                "%s ist ausgefallen.",
+		"%s is still down after %s.": "%s ist nach %s weiterhin ausgefallen.",
+		"%s has recovered after %s.": "%s ist nach %s wieder verfügbar.",
+		"%s is down":                 "%s ist ausgefallen",
+		"%s is still down":           "%s ist weiterhin ausgefallen",
+		"%s has recovered":           "%s ist wieder verfügbar",
+		"%s is down: %s":             "%s ist ausgefallen: %s",
+		"Error: %s":                  "Fehler: %s",
+		"DNS failure: %s":            "DNS-Fehler: %s",
+		"Response time: %dms":        "Antwortzeit: %dms",
+		"Incident: %s":               "Vorfall: %s",
+		"Error":                      "Fehler",
+		"DNS failure":                "DNS-Fehler",
+		"Response time":              "Antwortzeit",
+		"Down for":                   "Ausfalldauer",
+		"Incident":                   "Vorfall",
+	},
+	"ja": {
+		"DOWN":                       "ダウン",
+		"RECOVERED":                  "復旧",
+		"WARNING":                    "警告",
+		" (still down)":              "（ダウン継続中）",
+		"%s is down.":                "%s がダウンしています。",
+		"%s is still down after %s.": "%[1]s は %[2]s 経過後もダウンしています。",
+		"%s has recovered after %s.": "%[1]s は %[2]s 後に復旧しました。",
+		"%s is down":                 "%s がダウンしています",
+		"%s is still down":           "%s はダウンしたままです",
+		"%s has recovered":           "%s が復旧しました",
+		"%s is down: %s":             "%s がダウンしています: %s",
+		"Error: %s":                  "エラー: %s",
+		"DNS failure: %s":            "DNS 障害: %s",
+		"Response time: %dms":        "応答時間: %dms",
+		"Incident: %s":               "インシデント: %s",
+		"Error":                      "エラー",
+		"DNS failure":                "DNS 障害",
+		"Response time":              "応答時間",
+		"Down for":                   "ダウン時間",
+		"Incident":                   "インシデント",
+	},
+}
+
+// translate formats an alert message in lang, falling back to the English format
+func translate(lang, format string, args ...interface{}) string {
+	if translated, ok := alertCatalogs[lang][format]; ok {
+		format = translated
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// dashboardCatalogs translate the dashboard's English text, keyed by language
+// and then by the English message. {0}, {1}... are filled in by the page.
+var dashboardCatalogs = map[string]map[string]string{
+	"de": {
+		"Service Health Dashboard":              "Service-Statusübersicht",
+		"Refresh Now":                           "Jetzt aktualisieren",
+		"ACKED by {0}":                          "BESTÄTIGT von {0}",
+		"Acknowledged {0}":                      "Bestätigt {0}",
+		"INCIDENT {0}":                          "VORFALL {0}",
+		"{0} active incident(s)":                "{0} aktive(r) Vorfall/Vorfälle",
+		"{0} down since {1} ({2})":              "{0} ausgefallen seit {1} ({2})",
+		"Operator token:":                       "Operator-Token:",
+		"READ-ONLY":                             "NUR LESEN",
+		"Changes are disabled on this instance": "Änderungen sind auf dieser Instanz deaktiviert",
+		"Add Service":                           "Service hinzufügen",
+		"Edit {0}":                              "{0} bearbeiten",
+		"Log Out":                               "Abmelden",
+		"Operator Login":                        "Operator-Anmeldung",
+		"Acknowledge incident for {0} as:":      "Vorfall für {0} bestätigen als:",
+		"Stop monitoring {0}?":                  "Überwachung von {0} beenden?",
+		"PAUSED":                                "PAUSIERT",
+		"STALE":                                 "VERALTET",
+		"No check has completed recently; status may be outdated": "Seit einiger Zeit wurde keine Prüfung abgeschlossen; der Status ist eventuell veraltet",
+		"SILENCED":                               "STUMM",
+		"Alerts muted until {0}":                 "Alarme stummgeschaltet bis {0}",
+		"MAINTENANCE":                            "WARTUNG",
+		"Failures don't count against uptime":    "Ausfälle zählen nicht zur Verfügbarkeit",
+		"WARMING UP":                             "AUFWÄRMPHASE",
+		"THROTTLED":                              "GEDROSSELT",
+		"Target asked the checker to slow down":  "Das Ziel bittet, seltener zu prüfen",
+		"Target asked to wait until {0}":         "Das Ziel bittet, bis {0} zu warten",
+		"Failures don't alert until {0}":         "Ausfälle alarmieren erst ab {0}",
+		"Status: {0}":                            "Status: {0}",
+		"Waiting for first check":                "Warte auf erste Prüfung",
+		"[OK] Healthy":                           "[OK] Verfügbar",
+		"[FAIL] Unhealthy":                       "[FEHLER] Gestört",
+		"Response Time: {0}ms":                   "Antwortzeit: {0}ms",
+		"Last Checked: {0}":                      "Zuletzt geprüft: {0}",
+		"Error: {0}":                             "Fehler: {0}",
+		"Warning: {0}":                           "Warnung: {0}",
+		"Silence scheduled: {0} - {1}":           "Stummschaltung geplant: {0} - {1}",
+		"Acknowledge":                            "Bestätigen",
+		"Edit":                                   "Bearbeiten",
+		"Resume":                                 "Fortsetzen",
+		"Pause":                                  "Pausieren",
+		"Delete":                                 "Löschen",
+		"No services match the current filters.": "Keine Services entsprechen den aktuellen Filtern.",
+		"Warming up - waiting for first checks of {0} service(s)": "Aufwärmphase - warte auf erste Prüfungen von {0} Service(s)",
+		"[OK] All Services Healthy":                               "[OK] Alle Services verfügbar",
+		"[WARNING] Some Services Down":                            "[WARNUNG] Einige Services ausgefallen",
+		"{0} - health score {1}":                                  "{0} - Gesundheitswert {1}",
+		"Latency by Region":                                       "Latenz nach Region",
+		"Service":                                                 "Service",
+		"Median":                                                  "Median",
+		"DOWN":                                                    "AUSGEFALLEN",
+		"Version {0}":                                             "Version {0}",
+		"modified":                                                "geändert",
+		"Update available:":                                       "Update verfügbar:",
+		"{0} is out, this checker runs {1}":                       "{0} ist erschienen, dieser Checker läuft mit {1}",
+		"release notes":                                           "Versionshinweise",
+		"Name":                                                    "Name",
+		"Type":                                                    "Typ",
+		"URL":                                                     "URL",
+		"Interval":                                                "Intervall",
+		"Timeout":                                                 "Timeout",
+		"Labels":                                                  "Labels",
+		"Tags":                                                    "Tags",
+		"default":                                                 "Standard",
+		"Save":                                                    "Speichern",
+		"Cancel":                                                  "Abbrechen",
+		"Search services...":                                      "Services suchen...",
+		"All states":                                              "Alle Zustände",
+		"Unhealthy only":                                          "Nur gestörte",
+		"Healthy only":                                            "Nur verfügbare",
+		"With warnings":                                           "Mit Warnungen",
+		"No grouping":                                             "Keine Gruppierung",
+		"Group by tag":                                            "Nach Tag gruppieren",
+		"Group by team":                                           "Nach Team gruppieren",
+		"Group by env":                                            "Nach Umgebung gruppieren",
+		"API Endpoints:":                                          "API-Endpunkte:",
+		"JSON status of all services (supports ?q=, ?state=, ?group_by=, ?label=)": "JSON-Status aller Services (unterstützt ?q=, ?state=, ?group_by=, ?label=)",
+		"Prometheus metrics":            "Prometheus-Metriken",
+		"Health check for this service": "Statusprüfung dieses Dienstes",
+	},
+	"ja": {
+		"Service Health Dashboard":              "サービス稼働状況ダッシュボード",
+		"Refresh Now":                           "今すぐ更新",
+		"ACKED by {0}":                          "{0} が確認済み",
+		"Acknowledged {0}":                      "確認日時 {0}",
+		"INCIDENT {0}":                          "インシデント {0}",
+		"{0} active incident(s)":                "対応中のインシデント {0} 件",
+		"{0} down since {1} ({2})":              "{0} は {1} からダウン ({2})",
+		"Operator token:":                       "オペレーター トークン:",
+		"READ-ONLY":                             "読み取り専用",
+		"Changes are disabled on this instance": "このインスタンスでは変更が無効です",
+		"Add Service":                           "サービスを追加",
+		"Edit {0}":                              "{0} を編集",
+		"Log Out":                               "ログアウト",
+		"Operator Login":                        "オペレーター ログイン",
+		"Acknowledge incident for {0} as:":      "{0} のインシデントを確認する担当者:",
+		"Stop monitoring {0}?":                  "{0} の監視を停止しますか?",
+		"PAUSED":                                "一時停止中",
+		"STALE":                                 "更新なし",
+		"No check has completed recently; status may be outdated": "最近完了したチェックがありません。状態が古い可能性があります",
+		"SILENCED":                               "通知停止中",
+		"Alerts muted until {0}":                 "{0} までアラートを停止",
+		"MAINTENANCE":                            "メンテナンス中",
+		"Failures don't count against uptime":    "障害は稼働率に計上されません",
+		"WARMING UP":                             "ウォームアップ中",
+		"THROTTLED":                              "スロットリング中",
+		"Target asked the checker to slow down":  "対象がチェック頻度を下げるよう要求しています",
+		"Target asked to wait until {0}":         "対象が {0} まで待つよう要求しています",
+		"Failures don't alert until {0}":         "{0} まで障害をアラートしません",
+		"Status: {0}":                            "状態: {0}",
+		"Waiting for first check":                "初回チェック待ち",
+		"[OK] Healthy":                           "[OK] 正常",
+		"[FAIL] Unhealthy":                       "[FAIL] 異常",
+		"Response Time: {0}ms":                   "応答時間: {0}ms",
+		"Last Checked: {0}":                      "最終チェック: {0}",
+		"Error: {0}":                             "エラー: {0}",
+		"Warning: {0}":                           "警告: {0}",
+		"Silence scheduled: {0} - {1}":           "通知停止の予定: {0} - {1}",
+		"Acknowledge":                            "確認",
+		"Edit":                                   "編集",
+		"Resume":                                 "再開",
+		"Pause":                                  "一時停止",
+		"Delete":                                 "削除",
+		"No services match the current filters.": "現在のフィルターに一致するサービスはありません。",
+		"Warming up - waiting for first checks of {0} service(s)": "ウォームアップ中 - {0} 件のサービスの初回チェック待ち",
+		"[OK] All Services Healthy":                               "[OK] すべてのサービスが正常",
+		"[WARNING] Some Services Down":                            "[警告] 一部のサービスがダウン",
+		"{0} - health score {1}":                                  "{0} - ヘルススコア {1}",
+		"Latency by Region":                                       "リージョン別レイテンシ",
+		"Service":                                                 "サービス",
+		"Median":                                                  "中央値",
+		"DOWN":                                                    "ダウン",
+		"Version {0}":                                             "バージョン {0}",
+		"modified":                                                "変更あり",
+		"Update available:":                                       "アップデートがあります:",
+		"{0} is out, this checker runs {1}":                       "{0} が公開されています。このチェッカーは {1} です",
+		"release notes":                                           "リリースノート",
+		"Name":                                                    "名前",
+		"Type":                                                    "種類",
+		"URL":                                                     "URL",
+		"Interval":                                                "間隔",
+		"Timeout":                                                 "タイムアウト",
+		"Labels":                                                  "ラベル",
+		"Tags":                                                    "タグ",
+		"default":                                                 "デフォルト",
+		"Save":                                                    "保存",
+		"Cancel":                                                  "キャンセル",
+		"Search services...":                                      "サービスを検索...",
+		"All states":                                              "すべての状態",
+		"Unhealthy only":                                          "異常のみ",
+		"Healthy only":                                            "正常のみ",
+		"With warnings":                                           "警告あり",
+		"No grouping":                                             "グループ化なし",
+		"Group by tag":                                            "タグでグループ化",
+		"Group by team":                                           "チームでグループ化",
+		"Group by env":                                            "環境でグループ化",
+		"API Endpoints:":                                          "API エンドポイント:",
+		"JSON status of all services (supports ?q=, ?state=, ?group_by=, ?label=)": "全サービスの状態 (JSON、?q=・?state=・?group_by=・?label= に対応)",
+		"Prometheus metrics":            "Prometheus メトリクス",
+		"Health check for this service": "このサービス自体のヘルスチェック",
+	},
+}
+
+// validLanguage reports whether lang is empty or one of languages
+func validLanguage(lang string) bool {
+	return lang == "" || containsString(languages, lang)
+}
+
+// preferredLanguage returns the first of languages an Accept-Language header
+// asks for, ignoring quality values, or "" when it asks for none of them
+func preferredLanguage(header string) string {
+	for _, part := range strings.Split(header, ",") {
+		tag, _, _ := strings.Cut(strings.TrimSpace(part), ";")
+		base, _, _ := strings.Cut(strings.ToLower(tag), "-")
+		if containsString(languages, base) {
+			return base
+		}
+	}
+	return ""
+}
@@ -0,0 +1,116 @@
+// idempotency.go
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// idempotencyTTL is how long the response to a create is kept for replays of its key
+const idempotencyTTL = 24 * time.Hour
+
+// etagKey keys service ETags, so they change with every field, secrets included,
+// without revealing anything about the secrets. It's kept in durable storage, so
+// ETags survive a restart; without it they change on restart.
+var etagKey = func() []byte {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+}()
+
+// serviceETag identifies a version of a service definition for If-Match
+func serviceETag(svc Service) string {
+	data, _ := json.Marshal(svc)
+	mac := hmac.New(sha256.New, etagKey)
+	mac.Write(data)
+	return `"` + hex.EncodeToString(mac.Sum(nil)[:16]) + `"`
+}
+
+// etagMatches reports whether an If-Match header value matches etag: either "*"
+// or one of a comma-separated list. Weak validators never match.
+func etagMatches(ifMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// idempotentResponse is the stored outcome of a create made with an Idempotency-Key
+type idempotentResponse struct {
+	Request string          `json:"request"` // hash of the request body, to catch a key reused for another request
+	Status  int             `json:"status"`
+	Body    json.RawMessage `json:"body"`
+	ETag    string          `json:"etag"`
+	Expires time.Time       `json:"expires"`
+}
+
+// idempotencyStore remembers create responses by key. Callers serialize access.
+type idempotencyStore map[string]*idempotentResponse
+
+// get returns the unexpired response stored for key, pruning expired ones
+func (s idempotencyStore) get(key string, now time.Time) *idempotentResponse {
+	for k, resp := range s {
+		if now.After(resp.Expires) {
+			delete(s, k)
+		}
+	}
+	return s[key]
+}
+
+// requestHash identifies a create request's body for idempotentResponse.Request
+func requestHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// serviceAPIState is what the service API keeps in durable storage
+type serviceAPIState struct {
+	ETagKey    []byte           `json:"etag_key"`
+	Idempotent idempotencyStore `json:"idempotent,omitempty"`
+}
+
+// restore loads the ETag key and the responses to replay for Idempotency-Keys
+// from durable storage, saving the current key when there's none yet. Must be
+// called before the API serves requests.
+func (api *ServiceAPI) restore() error {
+	if api.store == nil {
+		return nil
+	}
+	data, err := api.store.LoadAPIState()
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		return api.saveState()
+	}
+	var state serviceAPIState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	if len(state.ETagKey) == 0 {
+		return api.saveState()
+	}
+	etagKey, api.idempotent = state.ETagKey, state.Idempotent
+	return nil
+}
+
+// saveState writes the ETag key and idempotent responses to durable storage, if
+// any. Must be called with api.mu held, or before serving.
+func (api *ServiceAPI) saveState() error {
+	if api.store == nil {
+		return nil
+	}
+	data, err := json.Marshal(serviceAPIState{ETagKey: etagKey, Idempotent: api.idempotent})
+	if err != nil {
+		return err
+	}
+	return api.store.SaveAPIState(data)
+}
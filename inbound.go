@@ -0,0 +1,189 @@
+// inbound.go
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// inboundKeyHeader names the inbound key a signed request was signed with
+const inboundKeyHeader = "X-HC-Key"
+
+// inboundMaxAge is how old a signed request's timestamp may be
+const inboundMaxAge = 5 * time.Minute
+
+// InboundKey is a shared secret that heartbeat senders and deploy tooling use to
+// sign requests, optionally restricted to source addresses and services
+type InboundKey struct {
+	Name       string   `json:"name"`
+	Secret     string   `json:"secret"`
+	AllowedIPs []string `json:"allowed_ips,omitempty"` // addresses or CIDR ranges; any when empty
+	Services   []string `json:"services,omitempty"`    // services the key may act on; any when empty
+}
+
+// validateInboundKey checks a single inbound key; prefix is prepended to field names
+func validateInboundKey(k InboundKey, prefix string) []ValidationError {
+	var errs []ValidationError
+	if strings.TrimSpace(k.Name) == "" {
+		errs = append(errs, ValidationError{Field: prefix + "name", Message: "name is required"})
+	}
+	if len(k.Secret) < 16 {
+		errs = append(errs, ValidationError{Field: prefix + "secret", Message: "must be at least 16 characters"})
+	}
+	for i, ip := range k.AllowedIPs {
+		if _, err := parseIPRange(ip); err != nil {
+			errs = append(errs, ValidationError{Field: fmt.Sprintf("%sallowed_ips[%d]", prefix, i), Message: err.Error()})
+		}
+	}
+	return errs
+}
+
+// parseIPRange parses an address or CIDR range
+func parseIPRange(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid address %q", s)
+		}
+		bits := 8 * len(ip.To4())
+		if bits == 0 {
+			bits = 128
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, ipNet, err := net.ParseCIDR(s)
+	return ipNet, err
+}
+
+// InboundVerifier checks signatures on pushed requests and rejects replays
+type InboundVerifier struct {
+	keys map[string]InboundKey
+	seen map[string]time.Time // accepted signatures, kept until their timestamps expire
+	mu   sync.Mutex
+}
+
+// NewInboundVerifier creates a verifier for the configured keys
+func NewInboundVerifier(keys []InboundKey) *InboundVerifier {
+	v := &InboundVerifier{keys: make(map[string]InboundKey), seen: make(map[string]time.Time)}
+	for _, k := range keys {
+		v.keys[k.Name] = k
+	}
+	return v
+}
+
+// Enabled reports whether any inbound keys are configured
+func (v *InboundVerifier) Enabled() bool {
+	return len(v.keys) > 0
+}
+
+// Verify checks that a request body was signed by a known key within inboundMaxAge,
+// comes from an allowed address, targets a service the key may act on and hasn't
+// been seen before
+func (v *InboundVerifier) Verify(r *http.Request, body []byte, service string) error {
+	key, ok := v.keys[r.Header.Get(inboundKeyHeader)]
+	if !ok {
+		return errors.New("unknown or missing " + inboundKeyHeader)
+	}
+
+	if len(key.AllowedIPs) > 0 {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		ip := net.ParseIP(host)
+		allowed := false
+		for _, s := range key.AllowedIPs {
+			if ipNet, err := parseIPRange(s); err == nil && ip != nil && ipNet.Contains(ip) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("address %s not allowed for key %q", host, key.Name)
+		}
+	}
+
+	if len(key.Services) > 0 && !containsString(key.Services, service) {
+		return fmt.Errorf("key %q may not act on service %q", key.Name, service)
+	}
+
+	timestamp, err := strconv.ParseInt(r.Header.Get(webhookTimestampHeader), 10, 64)
+	if err != nil {
+		return errors.New("missing or invalid " + webhookTimestampHeader)
+	}
+	now := time.Now()
+	if age := now.Sub(time.Unix(timestamp, 0)); age > inboundMaxAge || age < -inboundMaxAge {
+		return errors.New("timestamp too far from current time")
+	}
+
+	signature := r.Header.Get(webhookSignatureHeader)
+	if !hmac.Equal([]byte(signature), []byte(signWebhook(key.Secret, timestamp, body))) {
+		return errors.New("invalid signature")
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for sig, at := range v.seen {
+		if now.Sub(at) > 2*inboundMaxAge {
+			delete(v.seen, sig)
+		}
+	}
+	if _, replayed := v.seen[signature]; replayed {
+		return errors.New("request already received")
+	}
+	v.seen[signature] = now
+	return nil
+}
+
+// RequireSigned only runs next for requests that pass Verify. serviceOf names the
+// service the request acts on. The body is restored for next to read.
+func (v *InboundVerifier) RequireSigned(serviceOf func(r *http.Request, body []byte) string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBody))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		if err := v.Verify(r, body, serviceOf(r, body)); err != nil {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": err.Error()})
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next(w, r)
+	}
+}
+
+// operatorOrSigned accepts requests carrying the operator token or, when inbound
+// keys are configured and no Authorization header is sent, a signed request
+func operatorOrSigned(token string, v *InboundVerifier, serviceOf func(*http.Request, []byte) string, next http.HandlerFunc) http.HandlerFunc {
+	operator := requireOperator(token, next)
+	signed := v.RequireSigned(serviceOf, next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if v.Enabled() && r.Header.Get("Authorization") == "" {
+			signed(w, r)
+			return
+		}
+		operator(w, r)
+	}
+}
+
+// pathService names the service in the request path
+func pathService(r *http.Request, body []byte) string {
+	return r.PathValue("service")
+}
+
+// bodyService names the service in a JSON request body's "service" field
+func bodyService(r *http.Request, body []byte) string {
+	var v struct {
+		Service string `json:"service"`
+	}
+	json.Unmarshal(body, &v)
+	return v.Service
+}
@@ -0,0 +1,176 @@
+// incidents.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Incident tracks a period during which a service was unhealthy
+type Incident struct {
+	ID          string      `json:"id"`
+	Service     string      `json:"service"`
+	StartedAt   time.Time   `json:"started_at"`
+	ResolvedAt  *time.Time  `json:"resolved_at,omitempty"`
+	Error       string      `json:"error"` // error that opened the incident
+	ErrorInfo   *CheckError `json:"error_info,omitempty"`
+	DNSError    string      `json:"dns_error,omitempty"`     // kind of DNS failure that opened it, if any
+	IPChangedAt *time.Time  `json:"ip_changed_at,omitempty"` // when the host's addresses changed, if shortly before
+	AckedBy     string      `json:"acked_by,omitempty"`
+	AckedAt     *time.Time  `json:"acked_at,omitempty"`
+	Alerted     bool        `json:"alerted,omitempty"`     // a down alert was sent
+	NotifiedAt  *time.Time  `json:"notified_at,omitempty"` // when the last down alert or reminder was sent
+	Reminders   int         `json:"reminders,omitempty"`   // reminders sent while still down
+}
+
+// incidentHistorySize is the number of resolved incidents kept per service
+const incidentHistorySize = 50
+
+// trackIncident opens an incident when a check fails and resolves it on recovery.
+// No incident is opened while the service is in its grace period, so a service
+// still down when it ends opens one then. Incidents are replaced rather than
+// modified so copies handed out by GetStatuses stay consistent. Must be called
+// with hc.mu held.
+func (hc *HealthChecker) trackIncident(status *HealthStatus, now time.Time) {
+	switch {
+	case !status.Healthy && status.Incident == nil && hc.inGracePeriod(status.Name, now):
+		slog.Warn("failing in grace period", "service", status.Name, "grace_until", hc.graceUntil(status.Name).Format(time.RFC3339))
+
+	case !status.Healthy && status.Incident == nil:
+		incident := &Incident{
+			ID:          fmt.Sprintf("%s-%d", status.Name, now.Unix()),
+			Service:     status.Name,
+			StartedAt:   now,
+			Error:       status.Error,
+			ErrorInfo:   status.ErrorInfo,
+			DNSError:    status.DNSError,
+			IPChangedAt: ipChangedBefore(status, now),
+		}
+		slog.Warn("incident opened", "service", status.Name, "incident", incident.ID, "error", status.Error)
+		hc.events.Add(Event{Time: now, Service: status.Name, Type: EventIncidentOpened, Message: status.Error})
+		if hc.alert(status, newAlert(status, AlertDown, incident, now), false) {
+			incident.Alerted, incident.NotifiedAt = true, &now
+		}
+		status.Incident = incident
+
+	case !status.Healthy && status.Incident != nil && !status.Incident.Alerted:
+		if incident, ok := hc.releaseHeld(status, now); ok {
+			status.Incident = incident
+		}
+
+	case !status.Healthy && status.Incident != nil:
+		if incident, ok := hc.remind(status, now); ok {
+			status.Incident = incident
+		}
+
+	case status.Healthy && status.Incident != nil:
+		resolved := *status.Incident
+		resolved.ResolvedAt = &now
+		duration := now.Sub(resolved.StartedAt).Round(time.Second)
+		slog.Info("incident resolved", "service", status.Name, "incident", resolved.ID, "duration", duration.String())
+		hc.events.Add(Event{Time: now, Service: status.Name, Type: EventIncidentResolved, Message: "resolved after " + duration.String()})
+		status.Incident = nil
+		hc.alert(status, newAlert(status, AlertRecovered, &resolved, now), resolved.Alerted)
+
+		past := append(hc.incidents[status.Name], &resolved)
+		if len(past) > incidentHistorySize {
+			past = past[len(past)-incidentHistorySize:]
+		}
+		hc.incidents[status.Name] = past
+	}
+}
+
+// Incidents returns a service's open incident, if any, followed by its resolved
+// incidents, newest first
+func (hc *HealthChecker) Incidents(service string) []*Incident {
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+
+	incidents := []*Incident{}
+	if status, ok := hc.statuses[service]; ok && status.Incident != nil {
+		incidents = append(incidents, status.Incident)
+	}
+	past := hc.incidents[service]
+	for i := len(past) - 1; i >= 0; i-- {
+		incidents = append(incidents, past[i])
+	}
+	return incidents
+}
+
+// IncidentsHandler returns the incident history of ?service=
+func (hc *HealthChecker) IncidentsHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("service")
+	if _, ok := hc.GetService(name); !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": errServiceNotFound.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, hc.Incidents(name))
+}
+
+// ActiveIncidents returns open incidents across all services, oldest first
+func (hc *HealthChecker) ActiveIncidents() []*Incident {
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+
+	var incidents []*Incident
+	for _, status := range hc.statuses {
+		if status.Incident != nil {
+			incidents = append(incidents, status.Incident)
+		}
+	}
+	sort.Slice(incidents, func(i, j int) bool {
+		return incidents[i].StartedAt.Before(incidents[j].StartedAt)
+	})
+	return incidents
+}
+
+// AckIncident marks a service's open incident as being handled
+func (hc *HealthChecker) AckIncident(service, by string) (*Incident, error) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	status, ok := hc.statuses[service]
+	if !ok {
+		return nil, fmt.Errorf("unknown service %q", service)
+	}
+	if status.Incident == nil {
+		return nil, fmt.Errorf("service %q has no open incident", service)
+	}
+
+	now := time.Now()
+	acked := *status.Incident
+	acked.AckedBy = by
+	acked.AckedAt = &now
+	status.Incident = &acked
+	hc.changed(service)
+
+	slog.Info("incident acknowledged", "service", service, "incident", acked.ID, "by", by)
+	hc.events.Add(Event{Time: now, Service: service, Type: EventIncidentAcked, Message: acked.ID, Author: by})
+	return &acked, nil
+}
+
+// AckIncidentHandler acknowledges the open incident of the service in the path
+func (hc *HealthChecker) AckIncidentHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		By string `json:"by"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody)).Decode(&body); err != nil && err != io.EOF {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if body.By == "" {
+		body.By = "anonymous"
+	}
+
+	incident, err := hc.AckIncident(r.PathValue("service"), body.By)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, incident)
+}
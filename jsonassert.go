@@ -0,0 +1,115 @@
+// jsonassert.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// JSONAssertion checks one value of a JSON response body. Path is a dotted path
+// such as status, checks.db.status or items.0.name; a leading $. and [n] indexes
+// are accepted as in JSONPath.
+type JSONAssertion struct {
+	Path   string      `json:"path"`
+	Equals interface{} `json:"equals,omitempty"` // omitted: the path only has to exist
+}
+
+// validate checks an assertion definition; prefix is prepended to field names
+func (a JSONAssertion) validate(prefix string) []ValidationError {
+	if _, err := parseJSONPath(a.Path); err != nil {
+		return []ValidationError{{Field: prefix + "path", Message: err.Error()}}
+	}
+	return nil
+}
+
+// parseJSONPath splits a path into its object keys and array indexes
+func parseJSONPath(path string) ([]string, error) {
+	p := strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	p = strings.NewReplacer("[", ".", "]", "").Replace(p)
+	if p == "" {
+		return nil, errors.New("path is required")
+	}
+	parts := strings.Split(p, ".")
+	for _, part := range parts {
+		if part == "" {
+			return nil, fmt.Errorf("invalid path %q", path)
+		}
+	}
+	return parts, nil
+}
+
+// checkJSON evaluates the assertions against a JSON document
+func checkJSON(assertions []JSONAssertion, data []byte) error {
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("decoding JSON body: %w", err)
+	}
+	for _, a := range assertions {
+		got, ok := lookupJSON(doc, a.Path)
+		if !ok {
+			return fmt.Errorf("%s not found in body", a.Path)
+		}
+		if a.Equals == nil {
+			continue
+		}
+		if want := normalizeJSON(a.Equals); !reflect.DeepEqual(got, want) {
+			return fmt.Errorf("%s is %s, want %s", a.Path, jsonString(got), jsonString(want))
+		}
+	}
+	return nil
+}
+
+// lookupJSON follows path through decoded JSON
+func lookupJSON(doc interface{}, path string) (interface{}, bool) {
+	parts, err := parseJSONPath(path)
+	if err != nil {
+		return nil, false
+	}
+	v := doc
+	for _, part := range parts {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			next, ok := node[part]
+			if !ok {
+				return nil, false
+			}
+			v = next
+		case []interface{}:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			v = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// normalizeJSON round-trips an expected value through JSON, so it compares equal
+// to the same value decoded from a response (numbers become float64)
+func normalizeJSON(v interface{}) interface{} {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var out interface{}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return v
+	}
+	return out
+}
+
+// jsonString formats a decoded value for error messages
+func jsonString(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
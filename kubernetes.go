@@ -0,0 +1,563 @@
+// kubernetes.go
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Where a pod finds its service account, and the API server, in a cluster
+const (
+	kubeTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	kubeCAFile    = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+)
+
+const (
+	kubeRequestTimeout = 30 * time.Second
+	kubeWatchTimeout   = 5 * time.Minute // the API server ends each watch after this
+	kubeRetryInterval  = 10 * time.Second
+)
+
+// Annotations read from discovered objects; healthcheck.io/path opts one in
+const (
+	kubePathAnnotation   = "healthcheck.io/path"
+	kubePortAnnotation   = "healthcheck.io/port"   // port number or name, default the first
+	kubeSchemeAnnotation = "healthcheck.io/scheme" // http or https, default http
+)
+
+// kubeResources are the kinds of object discovery can watch
+var kubeResources = []string{"services", "ingresses", "pods"}
+
+// KubernetesConfig enables watching a cluster for annotated Services,
+// Ingresses or pods, monitoring each while it exists
+type KubernetesConfig struct {
+	Resources     []string          `json:"resources,omitempty"`      // default services
+	Namespace     string            `json:"namespace,omitempty"`      // all namespaces when empty
+	LabelSelector string            `json:"label_selector,omitempty"` // such as app.kubernetes.io/part-of=shop
+	Labels        map[string]string `json:"labels,omitempty"`
+
+	// Out of a cluster, such as http://127.0.0.1:8001 for kubectl proxy; in one
+	// the service account is used
+	APIServer string `json:"api_server,omitempty"`
+	TokenFile string `json:"token_file,omitempty"`
+	CAFile    string `json:"ca_file,omitempty"`
+}
+
+// Validate checks the Kubernetes discovery settings
+func (c KubernetesConfig) Validate() []ValidationError {
+	var errs []ValidationError
+	add := func(name, format string, args ...interface{}) {
+		errs = append(errs, ValidationError{Field: "kubernetes." + name, Message: fmt.Sprintf(format, args...)})
+	}
+
+	for i, r := range c.Resources {
+		if !containsString(kubeResources, r) {
+			add(fmt.Sprintf("resources[%d]", i), "must be one of %s", strings.Join(kubeResources, ", "))
+		}
+	}
+	if c.APIServer != "" {
+		if u, err := url.Parse(c.APIServer); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add("api_server", "must be an http or https URL")
+		}
+	}
+	return errs
+}
+
+// resources returns the kinds to watch
+func (c KubernetesConfig) resources() []string {
+	if len(c.Resources) == 0 {
+		return []string{"services"}
+	}
+	return c.Resources
+}
+
+// kubeClient makes requests to the Kubernetes API
+type kubeClient struct {
+	server    string
+	tokenFile string // read on every request, since projected tokens rotate
+	client    *http.Client
+}
+
+// newKubeClient connects to api_server, or from inside a cluster to the API
+// server its service account is for
+func newKubeClient(c KubernetesConfig) (*kubeClient, error) {
+	k := &kubeClient{server: strings.TrimSuffix(c.APIServer, "/"), tokenFile: c.TokenFile}
+	caFile := c.CAFile
+	if k.server == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, errors.New("not running in a cluster; set kubernetes.api_server")
+		}
+		k.server = "https://" + net.JoinHostPort(host, port)
+		if k.tokenFile == "" {
+			k.tokenFile = kubeTokenFile
+		}
+		if caFile == "" {
+			caFile = kubeCAFile
+		}
+	}
+
+	tlsConfig := clientTLSConfig("")
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no certificates found", caFile)
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	k.client = &http.Client{Transport: transport}
+	return k, nil
+}
+
+// get requests path, returning the response for the caller to close
+func (k *kubeClient) get(ctx context.Context, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.server+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if k.tokenFile != "" {
+		token, err := os.ReadFile(k.tokenFile)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		if t := strings.TrimSpace(string(token)); t != "" {
+			redactor.Add(t)
+			req.Header.Set("Authorization", "Bearer "+t)
+		}
+	}
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var status struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&status)
+		return nil, &kubeError{path: path, code: resp.StatusCode, message: status.Message}
+	}
+	return resp, nil
+}
+
+// kubeError is a request the API server refused
+type kubeError struct {
+	path    string
+	code    int
+	message string
+}
+
+func (e *kubeError) Error() string {
+	if e.message == "" {
+		return fmt.Sprintf("%s: HTTP %d", e.path, e.code)
+	}
+	return fmt.Sprintf("%s: HTTP %d: %s", e.path, e.code, e.message)
+}
+
+// resourcePath is the API path listing resource in namespace, or in all
+// namespaces when it's empty
+func resourcePath(resource, namespace string) string {
+	group := "/api/v1"
+	if resource == "ingresses" {
+		group = "/apis/networking.k8s.io/v1"
+	}
+	if namespace == "" {
+		return group + "/" + resource
+	}
+	return group + "/namespaces/" + url.PathEscape(namespace) + "/" + resource
+}
+
+// kubeObject holds the fields of Services, Ingresses and pods discovery uses
+type kubeObject struct {
+	Metadata struct {
+		Name              string            `json:"name"`
+		Namespace         string            `json:"namespace"`
+		Annotations       map[string]string `json:"annotations"`
+		ResourceVersion   string            `json:"resourceVersion"`
+		DeletionTimestamp *time.Time        `json:"deletionTimestamp"`
+	} `json:"metadata"`
+	Spec struct {
+		Ports      []kubePort `json:"ports"` // Services
+		Containers []struct {
+			Ports []kubePort `json:"ports"`
+		} `json:"containers"` // pods
+		Rules []struct {
+			Host string `json:"host"`
+		} `json:"rules"` // Ingresses
+		TLS []struct {
+			Hosts []string `json:"hosts"`
+		} `json:"tls"`
+	} `json:"spec"`
+	Status struct {
+		Phase string `json:"phase"`
+		PodIP string `json:"podIP"`
+	} `json:"status"`
+}
+
+// kubePort is a Service port or a container port
+type kubePort struct {
+	Name          string `json:"name"`
+	Port          int    `json:"port"`
+	ContainerPort int    `json:"containerPort"`
+}
+
+func (o *kubeObject) key() string {
+	return o.Metadata.Namespace + "/" + o.Metadata.Name
+}
+
+// kubeWatcher keeps the services for one kind of object in step with the cluster
+type kubeWatcher struct {
+	hc       *HealthChecker
+	cfg      *Config
+	k        KubernetesConfig
+	client   *kubeClient
+	resource string
+
+	managed    map[string]string // object key to the name of the service added for it
+	duplicates map[string]bool   // objects duplicating a monitored service, reported once
+}
+
+// RunKubernetes watches the configured resources and adds, updates and
+// removes services as annotated objects come and go. It returns on shutdown.
+func (hc *HealthChecker) RunKubernetes(k KubernetesConfig, cfg *Config) {
+	client, err := newKubeClient(k)
+	if err != nil {
+		slog.Error("kubernetes discovery disabled", "error", err)
+		return
+	}
+	resources := k.resources()
+	done := make(chan struct{}, len(resources))
+	for _, resource := range resources {
+		w := &kubeWatcher{hc: hc, cfg: cfg, k: k, client: client, resource: resource,
+			managed: make(map[string]string), duplicates: make(map[string]bool)}
+		go func() {
+			w.run()
+			done <- struct{}{}
+		}()
+	}
+	for range resources {
+		<-done
+	}
+}
+
+// run lists and then watches the resource, listing again whenever the watch
+// ends, until shutdown
+func (w *kubeWatcher) run() {
+	for w.hc.ctx.Err() == nil {
+		version, err := w.list()
+		if err == nil {
+			err = w.watch(version)
+		}
+		if err != nil && w.hc.ctx.Err() == nil {
+			slog.Warn("kubernetes discovery failed, retrying", "resource", w.resource, "error", err, "retry_in", kubeRetryInterval.String())
+			select {
+			case <-time.After(kubeRetryInterval):
+			case <-w.hc.ctx.Done():
+			}
+		}
+	}
+}
+
+// query returns the query string of a list or watch request
+func (w *kubeWatcher) query(extra url.Values) string {
+	q := url.Values{}
+	if w.k.LabelSelector != "" {
+		q.Set("labelSelector", w.k.LabelSelector)
+	}
+	for k, v := range extra {
+		q[k] = v
+	}
+	if len(q) == 0 {
+		return ""
+	}
+	return "?" + q.Encode()
+}
+
+// list applies every object there is now, removes the services of objects
+// that are gone, and returns the version to watch from
+func (w *kubeWatcher) list() (string, error) {
+	ctx, cancel := context.WithTimeout(w.hc.ctx, kubeRequestTimeout)
+	defer cancel()
+	resp, err := w.client.get(ctx, resourcePath(w.resource, w.k.Namespace)+w.query(nil))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var list struct {
+		Metadata struct {
+			ResourceVersion string `json:"resourceVersion"`
+		} `json:"metadata"`
+		Items []kubeObject `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return "", fmt.Errorf("%s: %w", w.resource, err)
+	}
+
+	present := make(map[string]bool, len(list.Items))
+	for i := range list.Items {
+		present[list.Items[i].key()] = true
+		w.apply(&list.Items[i])
+	}
+	for key := range w.managed {
+		if !present[key] {
+			w.remove(key)
+		}
+	}
+	for key := range w.duplicates {
+		if !present[key] {
+			delete(w.duplicates, key)
+		}
+	}
+	return list.Metadata.ResourceVersion, nil
+}
+
+// watch applies changes from version on until the API server ends the watch
+func (w *kubeWatcher) watch(version string) error {
+	resp, err := w.client.get(w.hc.ctx, resourcePath(w.resource, w.k.Namespace)+w.query(url.Values{
+		"watch":           {"1"},
+		"resourceVersion": {version},
+		"timeoutSeconds":  {strconv.Itoa(int(kubeWatchTimeout.Seconds()))},
+	}))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for scanner.Scan() {
+		var event struct {
+			Type   string          `json:"type"`
+			Object json.RawMessage `json:"object"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return fmt.Errorf("%s watch: %w", w.resource, err)
+		}
+		switch event.Type {
+		case "ADDED", "MODIFIED", "DELETED":
+			var obj kubeObject
+			if err := json.Unmarshal(event.Object, &obj); err != nil {
+				return fmt.Errorf("%s watch: %w", w.resource, err)
+			}
+			if event.Type == "DELETED" {
+				w.remove(obj.key())
+				delete(w.duplicates, obj.key())
+			} else {
+				w.apply(&obj)
+			}
+		case "ERROR":
+			// Most often 410 Gone: the version is too old to watch from, so list again
+			var status struct {
+				Code    int    `json:"code"`
+				Message string `json:"message"`
+			}
+			json.Unmarshal(event.Object, &status)
+			if status.Code == http.StatusGone {
+				return nil
+			}
+			return fmt.Errorf("%s watch: %d: %s", w.resource, status.Code, status.Message)
+		}
+	}
+	if err := scanner.Err(); err != nil && w.hc.ctx.Err() == nil {
+		return fmt.Errorf("%s watch: %w", w.resource, err)
+	}
+	return nil
+}
+
+// apply monitors obj if it's annotated and ready to be checked, and stops
+// monitoring it otherwise
+func (w *kubeWatcher) apply(obj *kubeObject) {
+	key := obj.key()
+	svc, ok, err := w.service(obj)
+	if err == nil && ok {
+		err = w.cfg.Targets.Check(svc)
+	}
+	if err != nil {
+		slog.Warn("kubernetes object skipped", "resource", w.resource, "object", key, "error", err)
+	}
+	if err != nil || !ok {
+		w.remove(key)
+		return
+	}
+
+	name, tracked := w.managed[key]
+	if !tracked {
+		if existing, dup := w.hc.duplicateOf(svc); dup {
+			if !w.duplicates[key] {
+				w.hc.resolveDuplicate("kubernetes", svc, existing)
+				w.duplicates[key] = true
+			}
+			return
+		}
+		if err := w.hc.AddService(svc); err != nil {
+			// Don't take over services defined elsewhere
+			return
+		}
+		w.managed[key] = svc.Name
+		slog.Info("kubernetes service discovered", "resource", w.resource, "object", key, "service", svc.Name, "url", svc.URL)
+		return
+	}
+	if existing, ok := w.hc.GetService(name); ok && existing.URL != svc.URL {
+		w.hc.UpdateService(svc)
+	}
+}
+
+// remove stops monitoring the service added for the object with key
+func (w *kubeWatcher) remove(key string) {
+	name, ok := w.managed[key]
+	if !ok {
+		return
+	}
+	delete(w.managed, key)
+	w.hc.RemoveService(name)
+	slog.Info("kubernetes service removed", "resource", w.resource, "object", key, "service", name)
+}
+
+// service builds the service for an object on top of the config's defaults.
+// It reports false for objects that aren't annotated, are being deleted, or
+// have no address yet.
+func (w *kubeWatcher) service(obj *kubeObject) (Service, bool, error) {
+	annotations := obj.Metadata.Annotations
+	path, ok := annotations[kubePathAnnotation]
+	if !ok || obj.Metadata.DeletionTimestamp != nil {
+		return Service{}, false, nil
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	scheme := annotations[kubeSchemeAnnotation]
+	if scheme == "" {
+		scheme = "http"
+	}
+	if scheme != "http" && scheme != "https" {
+		return Service{}, false, fmt.Errorf("%s must be http or https", kubeSchemeAnnotation)
+	}
+	port := annotations[kubePortAnnotation]
+
+	var host, kind string
+	switch w.resource {
+	case "services":
+		kind = "service"
+		p, err := pickPort(obj.Spec.Ports, port, false)
+		if err != nil {
+			return Service{}, false, err
+		}
+		host = net.JoinHostPort(obj.Metadata.Name+"."+obj.Metadata.Namespace+".svc", strconv.Itoa(p))
+	case "pods":
+		kind = "pod"
+		if obj.Status.Phase != "Running" || obj.Status.PodIP == "" {
+			return Service{}, false, nil
+		}
+		var ports []kubePort
+		for _, c := range obj.Spec.Containers {
+			ports = append(ports, c.Ports...)
+		}
+		p, err := pickPort(ports, port, true)
+		if err != nil {
+			return Service{}, false, err
+		}
+		host = net.JoinHostPort(obj.Status.PodIP, strconv.Itoa(p))
+	case "ingresses":
+		kind = "ingress"
+		for _, rule := range obj.Spec.Rules {
+			if rule.Host != "" && !strings.HasPrefix(rule.Host, "*") {
+				host = rule.Host
+				break
+			}
+		}
+		if host == "" {
+			return Service{}, false, errors.New("no rule with a host")
+		}
+		if annotations[kubeSchemeAnnotation] == "" {
+			for _, tls := range obj.Spec.TLS {
+				if slices.Contains(tls.Hosts, host) {
+					scheme = "https"
+				}
+			}
+		}
+		if port != "" {
+			host = net.JoinHostPort(host, port)
+		}
+	}
+
+	labels := map[string]string{"discovered_by": "kubernetes", "namespace": obj.Metadata.Namespace}
+	for k, v := range w.k.Labels {
+		labels[k] = v
+	}
+	data, _ := json.Marshal(map[string]interface{}{
+		"name":   "k8s-" + kind + "-" + obj.Metadata.Namespace + "-" + obj.Metadata.Name,
+		"url":    scheme + "://" + host + path,
+		"labels": labels,
+	})
+	svc, err := w.cfg.NewService(data)
+	if err != nil {
+		return svc, false, err
+	}
+	if errs := validateService(svc, ""); len(errs) > 0 {
+		return svc, false, errs[0]
+	}
+	return svc, true, nil
+}
+
+// pickPort returns the port named or numbered want, or the first port when
+// want is empty. Container ports are in ContainerPort rather than Port.
+func pickPort(ports []kubePort, want string, container bool) (int, error) {
+	number := func(p kubePort) int {
+		if container {
+			return p.ContainerPort
+		}
+		return p.Port
+	}
+	if want == "" {
+		if len(ports) == 0 {
+			return 0, fmt.Errorf("no ports; set %s", kubePortAnnotation)
+		}
+		return number(ports[0]), nil
+	}
+	for _, p := range ports {
+		if p.Name == want {
+			return number(p), nil
+		}
+	}
+	if n, err := strconv.Atoi(want); err == nil && n > 0 && n < 65536 {
+		return n, nil
+	}
+	return 0, fmt.Errorf("%s: no port %q", kubePortAnnotation, want)
+}
+
+// check lists each resource, failing when the API server is unreachable or
+// the service account may not list them
+func (k KubernetesConfig) check(ctx context.Context) error {
+	client, err := newKubeClient(k)
+	if err != nil {
+		return err
+	}
+	for _, resource := range k.resources() {
+		resp, err := client.get(ctx, resourcePath(resource, k.Namespace)+"?limit=1")
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+// latency.go
+package main
+
+import (
+	"math"
+	"slices"
+)
+
+// latencyWindow is how many of a service's latest successful checks its
+// latency percentiles are computed over
+const latencyWindow = 100
+
+// latencyQuantiles are the percentiles exported for each service
+var latencyQuantiles = []float64{0.5, 0.95, 0.99}
+
+// LatencyQuantiles returns each service's response time percentiles in
+// milliseconds, in the order of latencyQuantiles, over its last latencyWindow
+// successful checks. Failed checks are left out: a refused connection is fast
+// and a timeout only measures the timeout. Services without successful checks
+// in history are left out.
+func (hc *HealthChecker) LatencyQuantiles() map[string][]float64 {
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+
+	quantiles := make(map[string][]float64, len(hc.history))
+	for name, records := range hc.history {
+		var samples []int64
+		for i := len(records) - 1; i >= 0 && len(samples) < latencyWindow; i-- {
+			if records[i].Healthy {
+				samples = append(samples, records[i].ResponseTime)
+			}
+		}
+		if len(samples) == 0 {
+			continue
+		}
+		slices.Sort(samples)
+		values := make([]float64, len(latencyQuantiles))
+		for i, q := range latencyQuantiles {
+			values[i] = float64(nearestRank(samples, q))
+		}
+		quantiles[name] = values
+	}
+	return quantiles
+}
+
+// nearestRank returns the q-quantile of sorted samples by the nearest-rank
+// method, so it is always a latency that was actually measured
+func nearestRank(sorted []int64, q float64) int64 {
+	rank := int(math.Ceil(q * float64(len(sorted))))
+	return sorted[max(rank, 1)-1]
+}
@@ -0,0 +1,47 @@
+// logging.go
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// logFormats are the formats logs can be written in
+var logFormats = []string{"text", "json"}
+
+// setupLogging writes log records from level (info when empty) up to stderr
+// as text (when empty) or JSON, with secrets masked
+func setupLogging(format, level string) error {
+	var lvl slog.Level
+	if level == "" {
+		level = "info"
+	}
+	if format == "" {
+		format = "text"
+	}
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("log level %q: must be debug, info, warn or error", level)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	out := redactor.Writer(os.Stderr)
+
+	var handler slog.Handler
+	switch format {
+	case "text":
+		handler = slog.NewTextHandler(out, opts)
+	case "json":
+		handler = slog.NewJSONHandler(out, opts)
+	default:
+		return fmt.Errorf("log format %q: must be one of %s", format, strings.Join(logFormats, ", "))
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// fatal logs an error and exits
+func fatal(msg string, args ...interface{}) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
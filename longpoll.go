@@ -0,0 +1,77 @@
+// longpoll.go
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Long-poll limits for GET /status/wait
+const (
+	defaultWaitTimeout = 30 * time.Second
+	maxWaitTimeout     = 2 * time.Minute
+)
+
+// changed records a change to a service's status: turning healthy or unhealthy,
+// its error or warnings changing, going stale, or being added, updated, removed,
+// paused, silenced or acknowledged. Results that only move the response time aren't changes.
+// Every change gets the next revision. Must be called with hc.mu held.
+func (hc *HealthChecker) changed(name string) {
+	hc.revision++
+	if status, ok := hc.statuses[name]; ok {
+		status.Revision = hc.revision
+	}
+	close(hc.changes)
+	hc.changes = make(chan struct{})
+}
+
+// Revision returns the current revision and a channel closed at the next change
+func (hc *HealthChecker) Revision() (uint64, <-chan struct{}) {
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+	return hc.revision, hc.changes
+}
+
+// StatusWaitHandler long-polls for status changes, for clients that can't hold
+// a streaming connection. It answers like /status as soon as the revision
+// differs from since, or with the unchanged status once timeout (default 30s)
+// passes or the checker stops. The revision field of the response is the since
+// for the next request.
+// A since ahead of the revision, from before a restart without a state file,
+// answers at once so the client resyncs.
+func (hc *HealthChecker) StatusWaitHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	since, err := strconv.ParseUint(q.Get("since"), 10, 64)
+	if err != nil && q.Get("since") != "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "since must be a revision number"})
+		return
+	}
+	timeout := defaultWaitTimeout
+	if v := q.Get("timeout"); v != "" {
+		if timeout, err = time.ParseDuration(v); err != nil || timeout <= 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "timeout must be a positive duration, e.g. 30s"})
+			return
+		}
+		timeout = min(timeout, maxWaitTimeout)
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		revision, changes := hc.Revision()
+		if revision != since {
+			break
+		}
+		select {
+		case <-changes:
+			continue
+		case <-timer.C:
+		case <-hc.ctx.Done(): // shutting down
+		case <-r.Context().Done():
+			return
+		}
+		break
+	}
+	hc.StatusHandler(w, r)
+}
@@ -3,320 +3,450 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
-	"sync"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
-// Service represents a service to monitor
-type Service struct {
-	Name     string        `json:"name"`
-	URL      string        `json:"url"`
-	Interval time.Duration `json:"interval"`
-	Timeout  time.Duration `json:"timeout"`
+// defaultServices are monitored when no config file is given
+var defaultServices = []Service{
+	{
+		Name:     "google",
+		URL:      "https://www.google.com",
+		Interval: Duration(30 * time.Second),
+		Timeout:  Duration(5 * time.Second),
+		Weight:   1,
+	},
+	{
+		Name:     "github",
+		URL:      "https://api.github.com",
+		Interval: Duration(30 * time.Second),
+		Timeout:  Duration(5 * time.Second),
+		Weight:   1,
+	},
+	{
+		Name:     "cloudflare-dns",
+		URL:      "https://1.1.1.1/dns-query",
+		Interval: Duration(60 * time.Second),
+		Timeout:  Duration(3 * time.Second),
+		Weight:   1,
+	},
 }
 
-// HealthStatus represents the health status of a service
-type HealthStatus struct {
-	Name         string    `json:"name"`
-	URL          string    `json:"url"`
-	Healthy      bool      `json:"healthy"`
-	ResponseTime int64     `json:"response_time_ms"`
-	LastChecked  time.Time `json:"last_checked"`
-	Error        string    `json:"error,omitempty"`
+// envOr returns the environment variable key, or fallback when it's unset
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
 }
 
-// HealthChecker manages health checks for multiple services
-type HealthChecker struct {
-	services []Service
-	statuses map[string]*HealthStatus
-	mu       sync.RWMutex
+// envDuration returns the duration in the environment variable key, or
+// fallback when it's unset; an invalid duration exits
+func envDuration(key string, fallback time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", key, err)
+		os.Exit(2)
+	}
+	return d
 }
 
-// NewHealthChecker creates a new health checker instance
-func NewHealthChecker(services []Service) *HealthChecker {
-	hc := &HealthChecker{
-		services: services,
-		statuses: make(map[string]*HealthStatus),
-	}
-	
-	// Initialize status for each service
-	for _, svc := range services {
-		hc.statuses[svc.Name] = &HealthStatus{
-			Name:    svc.Name,
-			URL:     svc.URL,
-			Healthy: false,
+func main() {
+	configPath := flag.String("config", os.Getenv("HC_CONFIG"), "path to a JSON or YAML config file, defaulting to the built-in service list (env HC_CONFIG)")
+	listenAddr := flag.String("listen", envOr("HC_LISTEN_ADDR", ":8080"), "address to serve the dashboard, API and metrics on (env HC_LISTEN_ADDR)")
+	metricsPath := flag.String("metrics-path", envOr("HC_METRICS_PATH", "/metrics"), "path to serve Prometheus metrics on (env HC_METRICS_PATH)")
+	defaultInterval := flag.Duration("default-interval", envDuration("HC_DEFAULT_INTERVAL", time.Duration(builtinDefaults.Interval)), "check interval of services that set none, unless the config's defaults do (env HC_DEFAULT_INTERVAL)")
+	defaultTimeout := flag.Duration("default-timeout", envDuration("HC_DEFAULT_TIMEOUT", time.Duration(builtinDefaults.Timeout)), "check timeout of services that set none, unless the config's defaults do (env HC_DEFAULT_TIMEOUT)")
+	operatorToken := flag.String("operator-token", os.Getenv("HC_OPERATOR_TOKEN"), "bearer token required by management endpoints (env HC_OPERATOR_TOKEN)")
+	outboxFile := flag.String("outbox-file", os.Getenv("HC_OUTBOX_FILE"), "file to persist undelivered notifications across restarts (env HC_OUTBOX_FILE)")
+	stateFile := flag.String("state-file", os.Getenv("HC_STATE_FILE"), "file to persist last-known statuses and incidents across restarts (env HC_STATE_FILE)")
+	servicesFile := flag.String("services-file", os.Getenv("HC_SERVICES_FILE"), "file to save services added or changed through the API, replacing the configured list on startup (env HC_SERVICES_FILE)")
+	aggregatorURL := flag.String("aggregator", os.Getenv("HC_AGGREGATOR_URL"), "run as an agent, uploading results to this aggregator URL (env HC_AGGREGATOR_URL)")
+	agentToken := flag.String("agent-token", os.Getenv("HC_AGENT_TOKEN"), "token identifying this agent to the aggregator (env HC_AGENT_TOKEN)")
+	agentProtocol := flag.String("agent-protocol", envOr("HC_AGENT_PROTOCOL", "http"), "how an agent uploads results: http, one JSON request per batch, or grpc, protobuf over a gRPC stream (env HC_AGENT_PROTOCOL)")
+	agentBuffer := flag.String("agent-buffer", os.Getenv("HC_AGENT_BUFFER"), "file to buffer results in while the aggregator is unreachable (env HC_AGENT_BUFFER)")
+	readOnly := flag.Bool("read-only", os.Getenv("HC_READ_ONLY") == "true", "disable every endpoint that changes state, whatever the credentials (env HC_READ_ONLY=true)")
+	updateCheck := flag.Bool("update-check", os.Getenv("HC_UPDATE_CHECK") == "true", "look up the latest release on GitHub daily and report whether this build is outdated (env HC_UPDATE_CHECK=true)")
+	logFormat := flag.String("log-format", envOr("HC_LOG_FORMAT", "text"), "log output format: text or json (env HC_LOG_FORMAT)")
+	logLevel := flag.String("log-level", envOr("HC_LOG_LEVEL", "info"), "lowest level logged: debug, info, warn or error; passing checks are logged at debug (env HC_LOG_LEVEL)")
+	startupCheckMode := flag.String("startup-checks", envOr("HC_STARTUP_CHECKS", "degrade"), "what a failed startup check of storage, notifiers or discovery does: degrade to report not ready, exit, or warn (env HC_STARTUP_CHECKS)")
+	watchConfig := flag.Bool("watch-config", os.Getenv("HC_WATCH_CONFIG") == "true", "reload the config file's services whenever the file changes, as well as on SIGHUP (env HC_WATCH_CONFIG=true)")
+	shutdownTimeout := flag.Duration("shutdown-timeout", envDuration("HC_SHUTDOWN_TIMEOUT", 15*time.Second), "how long to wait on shutdown for HTTP requests in progress to finish (env HC_SHUTDOWN_TIMEOUT)")
+	initConfig := flag.Bool("init", false, "write a starter config to the -config path, or config.json, and exit")
+	tlsPolicy := flag.String("tls-policy", os.Getenv("HC_TLS_POLICY"), "TLS settings for outgoing connections and the HTTPS listener: default, or fips to allow only FIPS 140-3 approved settings (env HC_TLS_POLICY)")
+	tlsCert := flag.String("tls-cert", os.Getenv("HC_TLS_CERT"), "PEM certificate file to serve HTTPS with, together with -tls-key (env HC_TLS_CERT)")
+	tlsKey := flag.String("tls-key", os.Getenv("HC_TLS_KEY"), "PEM private key file of -tls-cert (env HC_TLS_KEY)")
+	flag.Parse()
+	if err := setupLogging(*logFormat, *logLevel); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if *initConfig {
+		path := *configPath
+		if path == "" {
+			path = "config.json"
+		}
+		if err := writeStarterConfig(path); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
 		}
+		fmt.Printf("wrote a starter config to %s and its schema beside it; run with -config %s\n", path, path)
+		return
 	}
-	
-	return hc
-}
-
-// Start begins monitoring all services
-func (hc *HealthChecker) Start() {
-	for _, svc := range hc.services {
-		go hc.monitorService(svc)
+	if !containsString(startupCheckModes, *startupCheckMode) {
+		fmt.Fprintf(os.Stderr, "startup checks %q: must be one of %s\n", *startupCheckMode, strings.Join(startupCheckModes, ", "))
+		os.Exit(2)
 	}
-}
-
-// monitorService continuously checks a single service
-func (hc *HealthChecker) monitorService(svc Service) {
-	ticker := time.NewTicker(svc.Interval)
-	defer ticker.Stop()
-	
-	// Check immediately
-	hc.checkService(svc)
-	
-	for range ticker.C {
-		hc.checkService(svc)
+	if !containsString(agentProtocols, *agentProtocol) {
+		fmt.Fprintf(os.Stderr, "agent protocol %q: must be one of %s\n", *agentProtocol, strings.Join(agentProtocols, ", "))
+		os.Exit(2)
+	}
+	if *defaultInterval <= 0 || *defaultTimeout <= 0 {
+		fmt.Fprintln(os.Stderr, "default interval and timeout must be positive")
+		os.Exit(2)
+	}
+	if (*tlsCert == "") != (*tlsKey == "") {
+		fmt.Fprintln(os.Stderr, "-tls-cert and -tls-key must be set together")
+		os.Exit(2)
+	}
+	if !strings.HasPrefix(*metricsPath, "/") {
+		fmt.Fprintf(os.Stderr, "metrics path %q: must start with /\n", *metricsPath)
+		os.Exit(2)
+	}
+	builtinDefaults.Interval, builtinDefaults.Timeout = Duration(*defaultInterval), Duration(*defaultTimeout)
+	redactor.Add(*operatorToken, *agentToken)
+	if err := applyTLSPolicy(*tlsPolicy); err != nil {
+		fatal("applying TLS policy", "error", err)
+	}
+	if activeTLSConfig != nil {
+		slog.Info("TLS policy applied: outgoing connections use TLS 1.2+ with approved cipher suites and curves", "policy", *tlsPolicy)
+		if *tlsCert == "" {
+			slog.Warn("serving plain HTTP under the TLS policy; pass -tls-cert and -tls-key, or terminate TLS in front of the checker", "policy", *tlsPolicy)
+		}
 	}
-}
 
-// checkService performs a single health check
-func (hc *HealthChecker) checkService(svc Service) {
-	start := time.Now()
-	
-	ctx, cancel := context.WithTimeout(context.Background(), svc.Timeout)
-	defer cancel()
-	
-	req, err := http.NewRequestWithContext(ctx, "GET", svc.URL, nil)
-	if err != nil {
-		hc.updateStatus(svc.Name, false, 0, err.Error())
-		return
+	// Define services to monitor
+	cfg := &Config{Defaults: builtinDefaults, Services: defaultServices, Dashboard: defaultDashboard}
+	if *configPath != "" {
+		var err error
+		if cfg, err = loadConfig(*configPath); err != nil {
+			fatal("loading config", "error", err)
+		}
+		slog.Info("loaded services", "services", len(cfg.Services), "path", *configPath)
+	}
+	// An agent without its own config checks only what the aggregator assigns it
+	if *aggregatorURL != "" && *configPath == "" {
+		cfg.Services = nil
 	}
-	
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	responseTime := time.Since(start).Milliseconds()
-	
+	activeTargets = cfg.Targets
+	store, err := OpenStore(cfg.Storage)
 	if err != nil {
-		hc.updateStatus(svc.Name, false, responseTime, err.Error())
-		return
+		fatal("opening storage", "error", err)
+	}
+	if *servicesFile != "" {
+		found, err := loadServicesFile(*servicesFile, cfg)
+		if err != nil {
+			fatal("loading services", "error", err)
+		}
+		if found {
+			slog.Info("loaded services", "services", len(cfg.Services), "path", *servicesFile)
+		}
+	} else if cfg.Storage.durable() {
+		found, err := loadStoredServices(store, cfg)
+		if err != nil {
+			fatal("loading services", "error", err)
+		}
+		if found {
+			slog.Info("loaded services from storage", "services", len(cfg.Services), "storage", cfg.Storage.Type)
+		}
 	}
-	defer resp.Body.Close()
-	
-	healthy := resp.StatusCode >= 200 && resp.StatusCode < 300
-	errorMsg := ""
-	if !healthy {
-		errorMsg = fmt.Sprintf("HTTP %d", resp.StatusCode)
+	redactor.Add(cfg.secrets()...)
+	if err := cfg.Dashboard.applyEnv(); err != nil {
+		fatal("applying dashboard settings", "error", err)
 	}
-	
-	hc.updateStatus(svc.Name, healthy, responseTime, errorMsg)
-}
 
-// updateStatus updates the status of a service
-func (hc *HealthChecker) updateStatus(name string, healthy bool, responseTime int64, errorMsg string) {
-	hc.mu.Lock()
-	defer hc.mu.Unlock()
-	
-	if status, exists := hc.statuses[name]; exists {
-		status.Healthy = healthy
-		status.ResponseTime = responseTime
-		status.LastChecked = time.Now()
-		status.Error = errorMsg
-		
-		// Log status changes
-		if healthy {
-			log.Printf("[OK] %s - %dms", name, responseTime)
-		} else {
-			log.Printf("[FAIL] %s - %s", name, errorMsg)
+	// "rules" prints Prometheus rules for the configured services and exits
+	if flag.Arg(0) == "rules" {
+		fmt.Print(generateRules(cfg.Services, cfg.Metrics))
+		return
+	}
+
+	// "test-notifier <name>" sends a synthetic alert through a notifier and exits
+	if flag.Arg(0) == "test-notifier" {
+		outbox, err := NewOutbox("", cfg.Notifiers)
+		if err != nil {
+			fatal("loading notifiers", "error", err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), outboxSendTimeout)
+		defer cancel()
+		if err := outbox.SendTest(ctx, flag.Arg(1)); err != nil {
+			fatal("test alert failed", "notifier", flag.Arg(1), "error", err)
 		}
+		fmt.Printf("Test alert delivered through %q\n", flag.Arg(1))
+		return
 	}
-}
 
-// GetStatuses returns current status of all services
-func (hc *HealthChecker) GetStatuses() map[string]*HealthStatus {
-	hc.mu.RLock()
-	defer hc.mu.RUnlock()
-	
-	// Create a copy to avoid race conditions
-	result := make(map[string]*HealthStatus)
-	for k, v := range hc.statuses {
-		status := *v
-		result[k] = &status
-	}
-	return result
-}
+	// Create and start health checker
+	checker := NewHealthChecker(cfg.Services)
+	checker.silences.SetSchedules(cfg.SilenceSchedules)
+	if err := checker.UseStore(store); err != nil {
+		fatal("restoring from storage", "error", err)
+	}
+	if *stateFile != "" {
+		if err := checker.LoadState(*stateFile); err != nil {
+			slog.Warn("restoring state", "path", *stateFile, "error", err)
+		}
+		go checker.PersistState(*stateFile)
+	} else if cfg.Storage.durable() {
+		if err := checker.LoadStoreState(); err != nil {
+			slog.Warn("restoring state from storage", "error", err)
+		}
+		go checker.PersistStoreState()
+	}
 
-// MetricsHandler provides Prometheus-style metrics
-func (hc *HealthChecker) MetricsHandler(w http.ResponseWriter, r *http.Request) {
-	statuses := hc.GetStatuses()
-	
-	w.Header().Set("Content-Type", "text/plain")
-	
-	// Write metrics in Prometheus format
-	fmt.Fprintf(w, "# HELP service_up Whether the service is up (1) or down (0)\n")
-	fmt.Fprintf(w, "# TYPE service_up gauge\n")
-	
-	for name, status := range statuses {
-		up := 0
-		if status.Healthy {
-			up = 1
+	// As an agent, every local result is also uploaded to the aggregator
+	var forwarder *Forwarder
+	if *aggregatorURL != "" {
+		var err error
+		if forwarder, err = NewForwarder(*aggregatorURL, *agentToken, *agentBuffer, checker); err != nil {
+			fatal("loading agent buffer", "error", err)
 		}
-		fmt.Fprintf(w, "service_up{service=\"%s\",url=\"%s\"} %d\n", name, status.URL, up)
+		forwarder.targets = cfg.Targets
+		forwarder.protocol = *agentProtocol
+		checker.onResult = forwarder.Add
+		go forwarder.Run()
+		go forwarder.RunAssignments()
+		slog.Info("uploading results to aggregator", "aggregator", *aggregatorURL, "protocol", *agentProtocol)
 	}
-	
-	fmt.Fprintf(w, "\n# HELP service_response_time_ms Response time in milliseconds\n")
-	fmt.Fprintf(w, "# TYPE service_response_time_ms gauge\n")
-	
-	for name, status := range statuses {
-		fmt.Fprintf(w, "service_response_time_ms{service=\"%s\",url=\"%s\"} %d\n", 
-			name, status.URL, status.ResponseTime)
+	outbox, err := NewOutbox(*outboxFile, cfg.Notifiers)
+	if err != nil {
+		fatal("loading outbox", "error", err)
 	}
-}
-
-// StatusHandler provides JSON status endpoint
-func (hc *HealthChecker) StatusHandler(w http.ResponseWriter, r *http.Request) {
-	statuses := hc.GetStatuses()
-	
-	// Calculate overall health
-	allHealthy := true
-	for _, status := range statuses {
-		if !status.Healthy {
-			allHealthy = false
-			break
+	checker.renotifyInterval = time.Duration(cfg.Alerting.RenotifyInterval)
+	checker.duplicates = cfg.Duplicates
+	checker.metricsGroupBy = cfg.Metrics.GroupBy
+	for _, d := range findDuplicates(cfg.Services) {
+		slog.Warn("services check the same target", "services", strings.Join(d.Services, ","), "target", d.Target)
+	}
+	if len(cfg.UptimeWindows) > 0 {
+		checker.uptimeWindows = cfg.UptimeWindows
+	}
+	if cfg.MaxConcurrentChecks > 0 {
+		checker.pool = newCheckPool(cfg.MaxConcurrentChecks)
+	}
+	build := buildVersion()
+	propagation = cfg.Propagation
+	if cfg.Tracing != nil {
+		tracer = NewTracer(*cfg.Tracing, build.Version)
+		go tracer.Run()
+		slog.Info("tracing checks", "collector", tracer.url)
+	}
+	languages := make(map[string]string, len(cfg.Notifiers))
+	for _, n := range cfg.Notifiers {
+		languages[n.Name] = n.Language
+		if n.Language == "" {
+			languages[n.Name] = cfg.Alerting.Language
 		}
 	}
-	
-	response := map[string]interface{}{
-		"healthy":  allHealthy,
-		"services": statuses,
+	checker.AddAlerter(&outboxAlerter{outbox: outbox, notifiers: cfg.Alerting.Notifiers, dnsRoutes: cfg.Alerting.DNSRoutes, languages: languages})
+	if err := checker.RunStartupChecks(*startupCheckMode, startupChecks(store, outbox, forwarder, cfg.MDNS, cfg.Kubernetes)); err != nil {
+		fatal("starting up", "error", err)
+	}
+	checker.Start()
+
+	outbox.Run()
+	outbox.RunSelfChecks()
+	for _, digest := range cfg.Digests {
+		go checker.RunDigest(digest, outbox)
 	}
-	
-	w.Header().Set("Content-Type", "application/json")
-	if !allHealthy {
-		w.WriteHeader(http.StatusServiceUnavailable)
+	if cfg.MDNS != nil {
+		go checker.RunMDNS(*cfg.MDNS, cfg)
 	}
-	
-	json.NewEncoder(w).Encode(response)
-}
+	if cfg.Kubernetes != nil {
+		go checker.RunKubernetes(*cfg.Kubernetes, cfg)
+	}
+	if cfg.DNSWatch != nil {
+		go checker.RunDNSWatch(*cfg.DNSWatch)
+	}
+	services := &ServiceAPI{checker: checker, config: cfg, path: *servicesFile}
+	if cfg.Storage.durable() {
+		services.store = store
+	}
+	if err := services.restore(); err != nil {
+		fatal("restoring service API state", "error", err)
+	}
+	var reloader *configReloader
+	if *configPath != "" {
+		reloader = newConfigReloader(*configPath, cfg, checker, services)
+		go reloader.Run(*watchConfig)
+	}
+	aggregator := NewAggregator(checker, cfg.Agents, cfg.Assignments)
+	operator := func(h http.HandlerFunc) http.HandlerFunc { return requireOperator(*operatorToken, h) }
 
-// HealthHandler provides a simple health check for the monitoring service itself
-func HealthHandler(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("OK"))
-}
+	// Pushed heartbeats must be signed once inbound keys are configured
+	inbound := NewInboundVerifier(cfg.InboundKeys)
+	heartbeat := checker.HeartbeatHandler
+	if inbound.Enabled() {
+		heartbeat = inbound.RequireSigned(pathService, heartbeat)
+	}
+
+	annotate := operatorOrSigned(*operatorToken, inbound, bodyService, checker.AnnotateHandler)
+	if *readOnly {
+		// Heartbeats and agent uploads stay open: they feed checks rather than change them
+		operator = func(http.HandlerFunc) http.HandlerFunc { return rejectReadOnly }
+		annotate = rejectReadOnly
+		cfg.Dashboard.ReadOnly = true
+		slog.Info("read-only mode: management endpoints are disabled")
+	}
+	cfg.Dashboard.MetricsPath = *metricsPath
+
+	var updates *updateChecker
+	if *updateCheck {
+		updates = newUpdateChecker(build.Version)
+		go updates.Run()
+	}
 
-func main() {
-	// Define services to monitor
-	services := []Service{
-		{
-			Name:     "google",
-			URL:      "https://www.google.com",
-			Interval: 30 * time.Second,
-			Timeout:  5 * time.Second,
-		},
-		{
-			Name:     "github",
-			URL:      "https://api.github.com",
-			Interval: 30 * time.Second,
-			Timeout:  5 * time.Second,
-		},
-		{
-			Name:     "cloudflare-dns",
-			URL:      "https://1.1.1.1/dns-query",
-			Interval: 60 * time.Second,
-			Timeout:  3 * time.Second,
-		},
-	}
-	
-	// Create and start health checker
-	checker := NewHealthChecker(services)
-	checker.Start()
-	
 	// Setup HTTP routes
 	http.HandleFunc("/health", HealthHandler)
+	http.HandleFunc("/ready", checker.ReadyHandler)
+	http.HandleFunc("/readyz", checker.ReadyHandler)
 	http.HandleFunc("/status", checker.StatusHandler)
-	http.HandleFunc("/metrics", checker.MetricsHandler)
-	
-	// Simple dashboard
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		html := `
-<!DOCTYPE html>
-<html>
-<head>
-    <title>Service Health Dashboard</title>
-    <style>
-        body { font-family: Arial, sans-serif; margin: 20px; background: #f5f5f5; }
-        h1 { color: #333; }
-        .service { background: white; padding: 15px; margin: 10px 0; border-radius: 5px; box-shadow: 0 2px 4px rgba(0,0,0,0.1); }
-        .healthy { border-left: 5px solid #4CAF50; }
-        .unhealthy { border-left: 5px solid #f44336; }
-        .name { font-weight: bold; font-size: 18px; }
-        .url { color: #666; font-size: 14px; }
-        .status { margin-top: 10px; }
-        .response-time { color: #2196F3; }
-        .error { color: #f44336; margin-top: 5px; }
-        .refresh { margin: 20px 0; }
-    </style>
-    <script>
-        function refreshStatus() {
-            fetch('/status')
-                .then(response => response.json())
-                .then(data => {
-                    const container = document.getElementById('services');
-                    container.innerHTML = '';
-                    
-                    for (const [name, status] of Object.entries(data.services)) {
-                        const div = document.createElement('div');
-                        div.className = 'service ' + (status.healthy ? 'healthy' : 'unhealthy');
-                        
-                        let html = '<div class="name">' + status.name + '</div>';
-                        html += '<div class="url">' + status.url + '</div>';
-                        html += '<div class="status">Status: ' + (status.healthy ? '[OK] Healthy' : '[FAIL] Unhealthy') + '</div>';
-                        html += '<div class="response-time">Response Time: ' + status.response_time_ms + 'ms</div>';
-                        html += '<div>Last Checked: ' + new Date(status.last_checked).toLocaleString() + '</div>';
-                        
-                        if (status.error) {
-                            html += '<div class="error">Error: ' + status.error + '</div>';
-                        }
-                        
-                        div.innerHTML = html;
-                        container.appendChild(div);
-                    }
-                    
-                    document.getElementById('overall').textContent = data.healthy ? '[OK] All Services Healthy' : '[WARNING] Some Services Down';
-                });
-        }
-        
-        // Refresh every 5 seconds
-        setInterval(refreshStatus, 5000);
-        
-        // Initial load
-        window.onload = refreshStatus;
-    </script>
-</head>
-<body>
-    <h1>Service Health Dashboard</h1>
-    <div class="refresh">
-        <button onclick="refreshStatus()">Refresh Now</button>
-        <span id="overall"></span>
-    </div>
-    <div id="services"></div>
-    <div style="margin-top: 30px; padding-top: 20px; border-top: 1px solid #ddd;">
-        <h3>API Endpoints:</h3>
-        <ul>
-            <li><a href="/status">/status</a> - JSON status of all services</li>
-            <li><a href="/metrics">/metrics</a> - Prometheus metrics</li>
-            <li><a href="/health">/health</a> - Health check for this service</li>
-        </ul>
-    </div>
-</body>
-</html>
-`
-		w.Header().Set("Content-Type", "text/html")
-		w.Write([]byte(html))
+	http.HandleFunc("GET /status/wait", checker.StatusWaitHandler)
+	guard := newCardinalityGuard(cfg.Metrics)
+	http.HandleFunc(*metricsPath, func(w http.ResponseWriter, r *http.Request) {
+		m := newMetricWriter(w, r, guard)
+		defer m.Close()
+		WriteVersionMetrics(m, build, updates)
+		if reloader != nil {
+			reloader.WriteMetrics(m)
+		}
+		checker.WriteMetrics(m)
+		checker.WriteRegionMetrics(m)
+		sessions.WriteMetrics(m)
+		outbox.WriteMetrics(m)
+		aggregator.WriteMetrics(m)
+		if forwarder != nil {
+			forwarder.WriteMetrics(m)
+		}
+		if tracer != nil {
+			tracer.WriteMetrics(m)
+		}
+		guard.WriteMetrics(m)
 	})
-	
-	log.Println("Starting health checker on :8080")
-	log.Println("Dashboard: http://localhost:8080")
-	log.Println("Status API: http://localhost:8080/status")
-	log.Println("Metrics: http://localhost:8080/metrics")
-	
-	if err := http.ListenAndServe(":8080", nil); err != nil {
-		log.Fatal(err)
-	}
-}
\ No newline at end of file
+	http.HandleFunc("GET /api/v1/version", VersionHandler(build, updates))
+	http.HandleFunc("POST /api/v1/config/validate", ValidateConfigHandler)
+	http.HandleFunc("GET /api/v1/config/schema", ConfigSchemaHandler)
+	http.HandleFunc("POST /api/v1/incidents/{service}/ack", operator(checker.AckIncidentHandler))
+	http.HandleFunc("GET /api/v1/incidents", checker.IncidentsHandler)
+	http.HandleFunc("GET /api/history", checker.HistoryHandler)
+	http.HandleFunc("GET /api/uptime", checker.UptimeHandler)
+	http.HandleFunc("GET /api/v1/search", checker.SearchHandler)
+	http.HandleFunc("GET /api/v1/events", checker.events.ListHandler)
+	http.HandleFunc("POST /api/v1/events", annotate)
+	http.HandleFunc("POST /api/v1/heartbeat/{service}", heartbeat)
+	http.HandleFunc("GET /services/{name}", ServiceDetailHandler)
+	http.HandleFunc("GET /wallboard", WallboardHandler(cfg.Dashboard))
+	http.HandleFunc("GET /api/v1/prometheus/rules", checker.RulesHandler)
+	http.HandleFunc("GET /api/v1/grafana/dashboard", checker.GrafanaDashboardHandler(cfg.Dashboard.Title))
+	http.HandleFunc("GET /api/v1/export", checker.ExportHandler)
+	http.HandleFunc("GET /api/v1/outbox", outbox.ListHandler)
+	http.HandleFunc("GET /api/v1/notifiers", outbox.NotifiersHandler)
+	http.HandleFunc("POST /api/v1/notifiers/{name}/test", operator(outbox.TestHandler))
+	http.HandleFunc("POST /api/v1/outbox/{id}/retry", operator(outbox.RetryHandler))
+	http.HandleFunc("GET /api/v1/agents", aggregator.ListHandler)
+	http.HandleFunc("POST /api/v1/agents/results", aggregator.ResultsHandler)
+	http.HandleFunc("POST "+agentStreamPath, aggregator.StreamHandler)
+	http.HandleFunc("GET /api/v1/agents/config", aggregator.ConfigHandler)
+	http.HandleFunc("GET /api/v1/regions", checker.RegionsHandler)
+
+	// Runtime service management
+	http.HandleFunc("GET /api/services", services.ListHandler)
+	http.HandleFunc("GET /api/services/{name}", services.GetHandler)
+	http.HandleFunc("POST /api/services", operator(services.CreateHandler))
+	http.HandleFunc("PUT /api/services/{name}", operator(services.UpdateHandler))
+	http.HandleFunc("DELETE /api/services/{name}", operator(services.DeleteHandler))
+	http.HandleFunc("POST /api/services/{name}/pause", operator(services.PauseHandler(true)))
+	http.HandleFunc("POST /api/services/{name}/resume", operator(services.PauseHandler(false)))
+	http.HandleFunc("POST /api/v1/services:pause", operator(services.BulkPauseHandler(true)))
+	http.HandleFunc("POST /api/v1/services:resume", operator(services.BulkPauseHandler(false)))
+	http.HandleFunc("POST /api/v1/services:silence", operator(services.BulkSilenceHandler))
+	http.HandleFunc("POST /api/v1/services:unsilence", operator(services.BulkUnsilenceHandler))
+	http.HandleFunc("GET /api/silences", checker.SilencesHandler)
+	http.HandleFunc("POST /api/silences", operator(checker.CreateSilenceHandler))
+	http.HandleFunc("GET /api/silences/{id}", checker.SilenceHandler)
+	http.HandleFunc("DELETE /api/silences/{id}", operator(checker.DeleteSilenceHandler))
+	http.HandleFunc("GET /api/silences/upcoming", checker.UpcomingSilencesHandler)
+	http.HandleFunc("GET /api/v1/calendar.ics", checker.CalendarHandler(cfg.Dashboard.Title))
+	http.HandleFunc("GET /api/v1/gates/{group}", checker.GateHandler(cfg.Gates))
+
+	// Simple dashboard
+	http.HandleFunc("/", DashboardHandler(cfg.Dashboard))
+
+	slog.Info("starting health checker", "version", build.Version, "addr", *listenAddr, "metrics_path", *metricsPath, "tls", *tlsCert != "")
+
+	// Cleartext HTTP/2 as well as HTTP/1.1, for agents uploading over gRPC. Over
+	// TLS, HTTP/2 is negotiated instead.
+	server := &http.Server{Addr: *listenAddr, Handler: h2c.NewHandler(http.DefaultServeMux, &http2.Server{})}
+	if *tlsCert != "" {
+		server.Handler, server.TLSConfig = http.DefaultServeMux, serverTLSConfig()
+	}
+	go func() {
+		var err error
+		if *tlsCert != "" {
+			err = server.ListenAndServeTLS(*tlsCert, *tlsKey)
+		} else {
+			err = server.ListenAndServe()
+		}
+		if !errors.Is(err, http.ErrServerClosed) {
+			fatal("serving HTTP", "error", err)
+		}
+	}()
+
+	// Shut down on SIGINT or SIGTERM; a second signal exits at once
+	interrupted, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	<-interrupted.Done()
+	stopSignals()
+	slog.Info("shutting down", "timeout", shutdownTimeout.String())
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+
+	// Stopping the checker first also answers long-polls, so they don't hold up the drain
+	checker.Stop()
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Warn("HTTP requests still in progress at shutdown timeout", "error", err)
+		server.Close()
+	}
+	if *stateFile != "" {
+		if err := checker.SaveState(*stateFile); err != nil {
+			slog.Warn("saving state", "path", *stateFile, "error", err)
+		}
+	} else if cfg.Storage.durable() {
+		if err := checker.SaveStoreState(); err != nil {
+			slog.Warn("saving state to storage", "error", err)
+		}
+	}
+	if tracer != nil {
+		if err := tracer.Flush(ctx); err != nil {
+			slog.Warn("exporting queued spans", "error", err)
+		}
+	}
+	if err := store.Close(); err != nil {
+		slog.Warn("closing storage", "error", err)
+	}
+	slog.Info("stopped")
+}
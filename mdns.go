@@ -0,0 +1,279 @@
+// mdns.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// mdnsAddr is the IPv4 multicast group mDNS responders listen on
+var mdnsAddr = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// mdnsListenWindow is how long each browse waits for responses
+const mdnsListenWindow = 2 * time.Second
+
+// mdnsMaxMissed is how many browses in a row a discovered service may be absent
+// from before it is removed
+const mdnsMaxMissed = 3
+
+// MDNSConfig enables browsing the local network for DNS-SD services to monitor
+type MDNSConfig struct {
+	Service  string            `json:"service"`  // DNS-SD service type, default _http._tcp
+	Domain   string            `json:"domain"`   // default local
+	Interval Duration          `json:"interval"` // browse period, default 1m
+	Path     string            `json:"path"`     // health path when the TXT record has none, default /
+	Labels   map[string]string `json:"labels,omitempty"`
+}
+
+// Validate checks the mDNS settings; prefix is prepended to field names
+func (m MDNSConfig) Validate(prefix string) []ValidationError {
+	var errs []ValidationError
+	if m.Service != "" && !strings.HasSuffix(m.Service, "._tcp") && !strings.HasSuffix(m.Service, "._udp") {
+		errs = append(errs, ValidationError{Field: prefix + "service", Message: "must look like _http._tcp"})
+	}
+	if m.Interval < 0 {
+		errs = append(errs, ValidationError{Field: prefix + "interval", Message: "must not be negative"})
+	}
+	if m.Path != "" && !strings.HasPrefix(m.Path, "/") {
+		errs = append(errs, ValidationError{Field: prefix + "path", Message: "must start with '/'"})
+	}
+	return errs
+}
+
+// query returns the fully qualified name browsed for, such as _http._tcp.local.
+func (m MDNSConfig) query() string {
+	service, domain := m.Service, m.Domain
+	if service == "" {
+		service = "_http._tcp"
+	}
+	if domain == "" {
+		domain = "local"
+	}
+	return service + "." + strings.TrimSuffix(domain, ".") + "."
+}
+
+// mdnsInstance is a service instance announced on the network
+type mdnsInstance struct {
+	name   string // instance label, such as "Printer Status"
+	target string // host the SRV record points at
+	port   uint16
+	path   string // from the TXT record's path= key
+	ip     net.IP
+}
+
+// RunMDNS periodically browses for services and keeps the discovered ones
+// monitored, removing those that stop answering
+func (hc *HealthChecker) RunMDNS(m MDNSConfig, cfg *Config) {
+	interval := orDefault(m.Interval, time.Minute)
+	missed := make(map[string]int)      // discovered service name -> browses since last seen
+	duplicates := make(map[string]bool) // discovered services duplicating a monitored one, reported once
+	for {
+		instances, err := browseMDNS(m.query(), mdnsListenWindow)
+		if err != nil {
+			slog.Warn("mdns browse failed", "query", m.query(), "error", err)
+		}
+
+		seen := make(map[string]bool)
+		for _, inst := range instances {
+			svc, err := m.service(inst, cfg)
+			if err == nil {
+				err = cfg.Targets.Check(svc)
+			}
+			if err != nil {
+				slog.Warn("mdns instance skipped", "instance", inst.name, "error", err)
+				continue
+			}
+			seen[svc.Name] = true
+			if _, tracked := missed[svc.Name]; !tracked {
+				if existing, dup := hc.duplicateOf(svc); dup {
+					if !duplicates[svc.Name] {
+						hc.resolveDuplicate("mdns", svc, existing)
+						duplicates[svc.Name] = true
+					}
+					continue
+				}
+			}
+			if _, tracked := missed[svc.Name]; tracked {
+				if existing, ok := hc.GetService(svc.Name); ok && existing.URL != svc.URL {
+					hc.UpdateService(svc)
+				}
+			} else if err := hc.AddService(svc); err != nil {
+				// Don't take over services defined elsewhere
+				continue
+			}
+			missed[svc.Name] = 0
+		}
+
+		for name := range duplicates {
+			if !seen[name] {
+				delete(duplicates, name)
+			}
+		}
+		for name := range missed {
+			if seen[name] {
+				continue
+			}
+			if missed[name]++; missed[name] >= mdnsMaxMissed {
+				hc.RemoveService(name)
+				delete(missed, name)
+			}
+		}
+		time.Sleep(interval)
+	}
+}
+
+// service builds the service definition for a discovered instance on top of the
+// config's defaults
+func (m MDNSConfig) service(inst mdnsInstance, cfg *Config) (Service, error) {
+	if inst.ip == nil || inst.port == 0 {
+		return Service{}, fmt.Errorf("no address for %s", inst.target)
+	}
+	path := inst.path
+	if path == "" {
+		path = m.Path
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	labels := map[string]string{"discovered_by": "mdns"}
+	for k, v := range m.Labels {
+		labels[k] = v
+	}
+	data, _ := json.Marshal(map[string]interface{}{
+		"name":   mdnsServiceName(inst.name),
+		"url":    "http://" + net.JoinHostPort(inst.ip.String(), strconv.Itoa(int(inst.port))) + path,
+		"labels": labels,
+	})
+	svc, err := cfg.NewService(data)
+	if err != nil {
+		return svc, err
+	}
+	if errs := validateService(svc, ""); len(errs) > 0 {
+		return svc, errs[0]
+	}
+	return svc, nil
+}
+
+// mdnsServiceName turns an instance label into a service name
+func mdnsServiceName(instance string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r == ' ' || r == '/' || r == '?' || r == '#':
+			return '-'
+		}
+		return r
+	}, strings.ToLower(instance))
+	return "mdns-" + name
+}
+
+// browseMDNS sends a one-shot query for the service type and collects the
+// instances that answer within window
+func browseMDNS(query string, window time.Duration) ([]mdnsInstance, error) {
+	name, err := dnsmessage.NewName(query)
+	if err != nil {
+		return nil, err
+	}
+	msg := dnsmessage.Message{Questions: []dnsmessage.Question{{Name: name, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET}}}
+	packet, err := msg.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	// Queries from a port other than 5353 get unicast replies (RFC 6762 section 6.7)
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if _, err := conn.WriteToUDP(packet, mdnsAddr); err != nil {
+		return nil, err
+	}
+
+	records := newMDNSRecords(query)
+	conn.SetReadDeadline(time.Now().Add(window))
+	buf := make([]byte, 9000)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			break // deadline reached
+		}
+		records.add(buf[:n])
+	}
+	return records.instances(), nil
+}
+
+// mdnsRecords accumulates the records of several responses, since the PTR, SRV,
+// TXT and address records for an instance may arrive separately
+type mdnsRecords struct {
+	query string          // lowercased name browsed for
+	ptr   map[string]bool // instance names
+	srv   map[string]dnsmessage.SRVResource
+	txt   map[string][]string
+	addrs map[string]net.IP
+}
+
+func newMDNSRecords(query string) *mdnsRecords {
+	return &mdnsRecords{
+		query: strings.ToLower(query),
+		ptr:   make(map[string]bool),
+		srv:   make(map[string]dnsmessage.SRVResource),
+		txt:   make(map[string][]string),
+		addrs: make(map[string]net.IP),
+	}
+}
+
+// add records the answers and additional records of one response
+func (m *mdnsRecords) add(packet []byte) {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(packet); err != nil || !msg.Response {
+		return
+	}
+	for _, rr := range append(msg.Answers, msg.Additionals...) {
+		owner := strings.ToLower(rr.Header.Name.String())
+		switch body := rr.Body.(type) {
+		case *dnsmessage.PTRResource:
+			if owner == m.query {
+				m.ptr[strings.ToLower(body.PTR.String())] = true
+			}
+		case *dnsmessage.SRVResource:
+			m.srv[owner] = *body
+		case *dnsmessage.TXTResource:
+			m.txt[owner] = body.TXT
+		case *dnsmessage.AResource:
+			m.addrs[owner] = net.IP(body.A[:])
+		}
+	}
+}
+
+// instances joins the records into one entry per announced instance
+func (m *mdnsRecords) instances() []mdnsInstance {
+	var list []mdnsInstance
+	for instance := range m.ptr {
+		srv, ok := m.srv[instance]
+		if !ok {
+			continue
+		}
+		target := strings.ToLower(srv.Target.String())
+		inst := mdnsInstance{
+			name:   strings.TrimSuffix(instance, "."+m.query),
+			target: target,
+			port:   srv.Port,
+			ip:     m.addrs[target],
+		}
+		for _, kv := range m.txt[instance] {
+			if k, v, ok := strings.Cut(kv, "="); ok && strings.EqualFold(k, "path") {
+				inst.path = v
+			}
+		}
+		list = append(list, inst)
+	}
+	return list
+}
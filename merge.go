@@ -0,0 +1,75 @@
+// merge.go
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// mergedIntoLabel marks an agent's copy of a service whose results are merged into
+// the service of that name
+const mergedIntoLabel = "merged_into"
+
+// mergeModes are the ways a merged service combines its members
+var mergeModes = []string{"worst", "quorum"}
+
+// mergeMembers combines the current results of a merged service's members, the
+// agent copies that report and aren't stale. In worst mode one failing probe
+// fails the service; in quorum mode it takes Quorum failing probes, or a
+// majority when Quorum is unset. With fewer probes reporting than Quorum, all
+// of them failing is enough. Must be called with hc.mu held.
+func (hc *HealthChecker) mergeMembers(parent string) CheckResult {
+	svc := hc.services[parent]
+
+	var reporting, failing []*HealthStatus
+	for name, member := range hc.services {
+		status := hc.statuses[name]
+		if member.Labels[mergedIntoLabel] != parent || status.Pending || status.Stale || status.Paused {
+			continue
+		}
+		reporting = append(reporting, status)
+		if !status.Healthy {
+			failing = append(failing, status)
+		}
+	}
+	if len(reporting) == 0 {
+		return CheckResult{Error: "no probes reporting",
+			ErrorInfo: &CheckError{Category: ErrCategoryOther, Code: "no_probes_reporting", Message: "no probes reporting", Retryable: true}}
+	}
+	sort.Slice(reporting, func(i, j int) bool { return reporting[i].ResponseTime < reporting[j].ResponseTime })
+	sort.Slice(failing, func(i, j int) bool { return failing[i].Name < failing[j].Name })
+
+	needed := 1
+	result := CheckResult{ResponseTime: reporting[len(reporting)-1].ResponseTime}
+	if svc.MergeMode == "quorum" {
+		needed = svc.Quorum
+		if needed <= 0 {
+			needed = len(reporting)/2 + 1
+		}
+		if needed > len(reporting) {
+			needed = len(reporting)
+			result.Warnings = []string{fmt.Sprintf("%d of a quorum of %d probes reporting", len(reporting), svc.Quorum)}
+		}
+		result.ResponseTime = reporting[len(reporting)/2].ResponseTime
+	}
+
+	if len(failing) < needed {
+		result.Healthy = true
+		if len(failing) > 0 {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("failing from %d of %d probes, below the %d needed", len(failing), len(reporting), needed))
+		}
+		return result
+	}
+
+	details := make([]string, len(failing))
+	for i, status := range failing {
+		details[i] = status.Labels["agent"] + ": " + status.Error
+	}
+	result.Error = fmt.Sprintf("failing from %d of %d probes (%s)", len(failing), len(reporting), strings.Join(details, "; "))
+	if info := failing[0].ErrorInfo; info != nil {
+		// Classified as the first failing probe's error
+		result.ErrorInfo = &CheckError{Category: info.Category, Code: info.Code, Message: result.Error, Retryable: info.Retryable}
+	}
+	return result
+}
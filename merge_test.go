@@ -0,0 +1,60 @@
+// merge_test.go
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMergeMembers(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     string
+		quorum   int
+		probes   []bool // healthy, per reporting probe
+		healthy  bool
+		warnings int
+		noProbes bool
+	}{
+		{name: "worst, all passing", mode: "worst", probes: []bool{true, true, true}, healthy: true},
+		{name: "worst, one failing", mode: "worst", probes: []bool{true, false, true}},
+		{name: "majority, one of three failing", mode: "quorum", probes: []bool{true, false, true}, healthy: true, warnings: 1},
+		{name: "majority, two of three failing", mode: "quorum", probes: []bool{false, false, true}},
+		{name: "majority, two of four failing", mode: "quorum", probes: []bool{false, false, true, true}, healthy: true, warnings: 1},
+		{name: "quorum reached", mode: "quorum", quorum: 2, probes: []bool{false, false, true}},
+		{name: "below quorum", mode: "quorum", quorum: 3, probes: []bool{false, false, true, true}, healthy: true, warnings: 1},
+		// Fewer probes reporting than the quorum still go down when all fail
+		{name: "quorum above reporting, all failing", mode: "quorum", quorum: 3, probes: []bool{false, false}, warnings: 1},
+		{name: "quorum above reporting, one failing", mode: "quorum", quorum: 3, probes: []bool{false, true}, healthy: true, warnings: 2},
+		{name: "quorum above reporting, all passing", mode: "quorum", quorum: 3, probes: []bool{true}, healthy: true, warnings: 1},
+		{name: "no probes", mode: "quorum", quorum: 2, noProbes: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			services := []Service{{Name: "checkout", Type: "merged", MergeMode: tt.mode, Quorum: tt.quorum}}
+			for i := range tt.probes {
+				services = append(services, Service{Name: fmt.Sprintf("checkout@probe-%d", i), URL: "https://checkout.example.com",
+					Labels: map[string]string{mergedIntoLabel: "checkout", "agent": fmt.Sprintf("probe-%d", i)}})
+			}
+			// A member that hasn't reported yet doesn't count
+			services = append(services, Service{Name: "checkout@pending", URL: "https://checkout.example.com",
+				Labels: map[string]string{mergedIntoLabel: "checkout", "agent": "pending"}})
+			hc := NewHealthChecker(services)
+			for i, healthy := range tt.probes {
+				status := hc.statuses[fmt.Sprintf("checkout@probe-%d", i)]
+				status.Pending, status.Healthy, status.ResponseTime = false, healthy, int64(10*(i+1))
+				if !healthy {
+					status.Error = "HTTP 503"
+				}
+			}
+
+			got := hc.mergeMembers("checkout")
+			if got.Healthy != tt.healthy || len(got.Warnings) != tt.warnings {
+				t.Errorf("healthy = %v, warnings = %q, want %v and %d warnings", got.Healthy, got.Warnings, tt.healthy, tt.warnings)
+			}
+			if tt.noProbes && got.Error != "no probes reporting" {
+				t.Errorf("error = %q, want no probes reporting", got.Error)
+			}
+		})
+	}
+}
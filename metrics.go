@@ -0,0 +1,348 @@
+// metrics.go
+package main
+
+import "fmt"
+
+// checkDurationBuckets are the upper bounds, in seconds, of the
+// service_check_duration_seconds histogram buckets
+var checkDurationBuckets = [...]float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// DurationHistogram counts check durations per bucket of checkDurationBuckets.
+// Buckets aren't cumulative here; the last holds checks slower than every bound.
+// It's an array, so copies of CheckCounts never share it.
+type DurationHistogram struct {
+	Buckets [len(checkDurationBuckets) + 1]int64 `json:"buckets"`
+	Count   int64                                `json:"count"`
+	SumMs   int64                                `json:"sum_ms"`
+}
+
+// observe adds a check that took ms milliseconds
+func (h *DurationHistogram) observe(ms int64) {
+	i := 0
+	for i < len(checkDurationBuckets) && float64(ms)/1000 > checkDurationBuckets[i] {
+		i++
+	}
+	h.Buckets[i]++
+	h.Count++
+	h.SumMs += ms
+}
+
+// WriteMetrics writes the checker's service metrics
+func (hc *HealthChecker) WriteMetrics(m *metricWriter) {
+	if hc.metricsGroupBy != "" {
+		hc.writeGroupMetrics(m)
+		return
+	}
+	statuses := hc.GetStatuses()
+
+	m.family("service_up", "gauge", "Whether the service is up (1) or down (0)")
+
+	// Services that haven't been checked yet, or whose checks stalled, are left out
+	// rather than reporting a missing or frozen value
+	for name, status := range statuses {
+		if status.Pending || status.Stale {
+			continue
+		}
+		up := 0
+		if status.Healthy {
+			up = 1
+		}
+		m.sample("service_up", float64(up), "service", name, "url", status.URL)
+	}
+
+	m.family("service_stale", "gauge", "Whether checks for the service stopped completing (1) or not (0)")
+
+	for name, status := range statuses {
+		stale := 0
+		if status.Stale {
+			stale = 1
+		}
+		m.sample("service_stale", float64(stale), "service", name, "url", status.URL)
+	}
+
+	m.family("service_throttled", "gauge", "Whether the target throttled the service's last check (1) or not (0), with throttling set")
+
+	for name, status := range statuses {
+		throttled := 0
+		if status.Throttled {
+			throttled = 1
+		}
+		m.sample("service_throttled", float64(throttled), "service", name, "url", status.URL)
+	}
+
+	m.family("service_silenced", "gauge", "Whether alerts for the service are silenced (1) or not (0)")
+
+	for name, status := range statuses {
+		silenced := 0
+		if status.Silenced != nil {
+			silenced = 1
+		}
+		m.sample("service_silenced", float64(silenced), "service", name, "url", status.URL)
+	}
+
+	m.family("service_in_maintenance", "gauge", "Whether the service is in a maintenance window (1) or not (0)")
+
+	for name, status := range statuses {
+		maintenance := 0
+		if status.Maintenance {
+			maintenance = 1
+		}
+		m.sample("service_in_maintenance", float64(maintenance), "service", name, "url", status.URL)
+	}
+
+	m.family("service_flapping", "gauge", "Whether the service is changing state too often (1) or not (0)")
+
+	for name, status := range statuses {
+		flapping := 0
+		if status.Flapping {
+			flapping = 1
+		}
+		m.sample("service_flapping", float64(flapping), "service", name, "url", status.URL)
+	}
+
+	m.family("service_consecutive_failures", "gauge", "Failed checks in a row, before failure_threshold applies")
+
+	for name, status := range statuses {
+		if status.Pending {
+			continue
+		}
+		m.sample("service_consecutive_failures", float64(status.ConsecutiveFailures), "service", name, "url", status.URL)
+	}
+
+	m.family("service_last_check_timestamp_seconds", "gauge", "When the service was last checked")
+
+	for name, status := range statuses {
+		if status.Pending {
+			continue
+		}
+		m.sample("service_last_check_timestamp_seconds", float64(status.LastChecked.Unix()), "service", name, "url", status.URL)
+	}
+
+	totals := hc.Counts()
+
+	m.family("service_checks_total", "counter", "Checks run, by result; failures during maintenance windows included")
+
+	for name, c := range totals {
+		if status, ok := statuses[name]; ok {
+			failures := c.Failures + c.MaintenanceFailures
+			m.sample("service_checks_total", float64(c.Checks-failures), "service", name, "url", status.URL, "result", "success")
+			m.sample("service_checks_total", float64(failures), "service", name, "url", status.URL, "result", "failure")
+		}
+	}
+
+	m.family("service_check_duration_seconds", "histogram", "How long checks took")
+
+	for name, c := range totals {
+		status, ok := statuses[name]
+		if !ok {
+			continue
+		}
+		h := c.Duration
+		m.histogram("service_check_duration_seconds", checkDurationBuckets[:], h.Buckets[:], float64(h.SumMs)/1000,
+			"service", name, "url", status.URL)
+	}
+
+	m.family("service_state_transitions_total", "counter", "Changes between up and down, after failure and success thresholds")
+
+	for name, c := range totals {
+		if status, ok := statuses[name]; ok {
+			m.sample("service_state_transitions_total", float64(c.Transitions), "service", name, "url", status.URL)
+		}
+	}
+
+	m.family("service_check_retries_total", "counter", "Failed check attempts retried within the same check")
+
+	for name, counts := range totals {
+		if status, ok := statuses[name]; ok {
+			m.sample("service_check_retries_total", float64(counts.Retries), "service", name, "url", status.URL)
+		}
+	}
+
+	m.family("service_check_panics_total", "counter", "Panics recovered while checking the service")
+
+	for name, counts := range totals {
+		if status, ok := statuses[name]; ok {
+			m.sample("service_check_panics_total", float64(counts.Panics), "service", name, "url", status.URL)
+		}
+	}
+
+	m.family("service_dns_failures_total", "counter", "Failed checks by kind of DNS failure")
+
+	for name, counts := range totals {
+		status, ok := statuses[name]
+		if !ok {
+			continue
+		}
+		for _, kind := range dnsFailureKinds {
+			if n, ok := counts.DNSFailures[kind]; ok {
+				m.sample("service_dns_failures_total", float64(n), "service", name, "url", status.URL, "kind", kind)
+			}
+		}
+	}
+
+	m.family("service_bot_challenges_total", "counter", "Checks answered by a CDN's bot challenge instead of the service")
+
+	for name, counts := range totals {
+		if status, ok := statuses[name]; ok {
+			m.sample("service_bot_challenges_total", float64(counts.BotChallenges), "service", name, "url", status.URL)
+		}
+	}
+
+	m.family("service_throttled_total", "counter", "Checks the target throttled with 429, or 503 and Retry-After, for services with throttling set")
+
+	for name, counts := range totals {
+		if status, ok := statuses[name]; ok && counts.Throttled > 0 {
+			m.sample("service_throttled_total", float64(counts.Throttled), "service", name, "url", status.URL)
+		}
+	}
+
+	m.family("service_ip_changes_total", "counter", "Changes of the addresses the service's host resolves to")
+
+	for name, counts := range totals {
+		if status, ok := statuses[name]; ok && status.DNS != nil {
+			m.sample("service_ip_changes_total", float64(counts.IPChanges), "service", name, "url", status.URL)
+		}
+	}
+
+	m.family("service_ip_changed_timestamp_seconds", "gauge", "When the addresses the service's host resolves to last changed")
+
+	for name, status := range statuses {
+		if status.DNS == nil || status.DNS.ChangedAt == nil {
+			continue
+		}
+		m.sample("service_ip_changed_timestamp_seconds", float64(status.DNS.ChangedAt.Unix()), "service", name, "url", status.URL)
+	}
+
+	m.family("service_uptime_ratio", "gauge", "Fraction of checks that didn't fail within the window; failures during maintenance count as up")
+
+	for name, counts := range hc.configuredUptime() {
+		status, ok := statuses[name]
+		if !ok {
+			continue
+		}
+		for i, c := range counts {
+			if c.Checks > 0 && i < len(hc.uptimeWindows) {
+				m.sample("service_uptime_ratio", c.Ratio(), "service", name, "url", status.URL, "window", hc.uptimeWindows[i])
+			}
+		}
+	}
+
+	m.family("service_weight", "gauge", "Business impact weight of the service in the health score")
+
+	for name, status := range statuses {
+		m.sample("service_weight", status.Weight, "service", name, "url", status.URL)
+	}
+
+	m.family("service_weighted_health", "gauge", "Weighted percentage (0-100) of services that are up")
+	m.sample("service_weighted_health", healthScore(statuses))
+
+	m.family("service_response_time_ms", "gauge", "Response time in milliseconds")
+
+	for name, status := range statuses {
+		if status.Pending {
+			continue
+		}
+		m.sample("service_response_time_ms", float64(status.ResponseTime), "service", name, "url", status.URL)
+	}
+
+	m.family("service_response_time_quantile_ms", "gauge", fmt.Sprintf("Response time percentiles over the last %d successful checks, in milliseconds", latencyWindow))
+
+	for name, values := range hc.LatencyQuantiles() {
+		status, ok := statuses[name]
+		if !ok {
+			continue
+		}
+		for i, q := range latencyQuantiles {
+			m.sample("service_response_time_quantile_ms", values[i], "service", name, "url", status.URL, "quantile", fmt.Sprint(q))
+		}
+	}
+
+	m.family("service_warnings", "gauge", "Number of warnings reported by the last check")
+
+	for name, status := range statuses {
+		if status.Pending {
+			continue
+		}
+		m.sample("service_warnings", float64(len(status.Warnings)), "service", name, "url", status.URL)
+	}
+
+	m.family("service_clock_skew_seconds", "gauge", "Remote Date header minus local time")
+
+	for name, status := range statuses {
+		if status.ClockSkew == nil {
+			continue
+		}
+		m.sample("service_clock_skew_seconds", *status.ClockSkew, "service", name, "url", status.URL)
+	}
+
+	m.family("service_versions", "gauge", "Distinct versions the service's instances served in the last check (with version_skew)")
+
+	for name, status := range statuses {
+		if status.Versions == nil {
+			continue
+		}
+		m.sample("service_versions", float64(len(distinctVersions(status.Versions))), "service", name, "url", status.URL)
+	}
+
+	m.family("service_instance_version_info", "gauge", "Version each of the service's instances served in the last check; always 1")
+
+	for name, status := range statuses {
+		for instance, version := range status.Versions {
+			m.sample("service_instance_version_info", 1, "service", name, "instance", instance, "version", version)
+		}
+	}
+
+	m.family("service_ping_packet_loss_percent", "gauge", "Echo requests lost by the last ICMP check")
+
+	for name, status := range statuses {
+		if status.Ping == nil {
+			continue
+		}
+		m.sample("service_ping_packet_loss_percent", status.Ping.LossPercent, "service", name, "url", status.URL)
+	}
+
+	m.family("service_ping_rtt_ms", "gauge", "Round-trip time of the last ICMP check in milliseconds")
+
+	for name, status := range statuses {
+		if status.Ping == nil || status.Ping.Received == 0 {
+			continue
+		}
+		for _, stat := range []struct {
+			name  string
+			value float64
+		}{{"min", status.Ping.RTTMinMs}, {"avg", status.Ping.RTTAvgMs}, {"max", status.Ping.RTTMaxMs}} {
+			m.sample("service_ping_rtt_ms", stat.value, "service", name, "url", status.URL, "stat", stat.name)
+		}
+	}
+
+	m.family("service_canary_latency_delta_ms", "gauge", "How much slower the canary answered than the baseline in the last canary check")
+
+	for name, status := range statuses {
+		if status.Canary == nil {
+			continue
+		}
+		m.sample("service_canary_latency_delta_ms", float64(status.Canary.LatencyDeltaMs), "service", name, "url", status.URL)
+	}
+
+	m.family("service_tls_cert_days_remaining", "gauge", "Days until the certificate served by the service expires")
+
+	for name, status := range statuses {
+		if status.CertDays == nil {
+			continue
+		}
+		m.sample("service_tls_cert_days_remaining", *status.CertDays, "service", name, "url", status.URL)
+	}
+
+	m.family("service_tls_cert_changed_timestamp_seconds", "gauge", "When the certificate issuer or public key last changed")
+
+	for name, status := range statuses {
+		if status.TLS == nil || status.TLS.ChangedAt == nil {
+			continue
+		}
+		m.sample("service_tls_cert_changed_timestamp_seconds", float64(status.TLS.ChangedAt.Unix()), "service", name, "url", status.URL, "issuer", status.TLS.Issuer)
+	}
+
+	if hc.pool != nil {
+		hc.pool.writeMetrics(m)
+	}
+}
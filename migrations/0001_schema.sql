@@ -0,0 +1,26 @@
+-- Check results, events and saved documents
+CREATE TABLE IF NOT EXISTS results (
+	service          TEXT    NOT NULL,
+	time             INTEGER NOT NULL, -- Unix nanoseconds
+	healthy          INTEGER NOT NULL,
+	response_time_ms INTEGER NOT NULL,
+	error            TEXT    NOT NULL DEFAULT '',
+	maintenance      INTEGER NOT NULL DEFAULT 0,
+	trace_id         TEXT    NOT NULL DEFAULT '', -- IDs the check sent its target
+	request_id       TEXT    NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS results_service_time ON results (service, time);
+CREATE TABLE IF NOT EXISTS events (
+	id      INTEGER PRIMARY KEY,
+	time    INTEGER NOT NULL,
+	service TEXT    NOT NULL,
+	type    TEXT    NOT NULL,
+	message TEXT    NOT NULL,
+	author  TEXT    NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS events_time ON events (time);
+CREATE TABLE IF NOT EXISTS documents (
+	name     TEXT    PRIMARY KEY, -- state or services
+	data     BLOB    NOT NULL,
+	saved_at INTEGER NOT NULL
+);
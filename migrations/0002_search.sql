@@ -0,0 +1,31 @@
+-- Distinct errors and full-text indexes for search
+CREATE TABLE IF NOT EXISTS failures ( -- distinct errors checks failed with
+	id      INTEGER PRIMARY KEY,
+	service TEXT    NOT NULL,
+	error   TEXT    NOT NULL,
+	first   INTEGER NOT NULL,
+	last    INTEGER NOT NULL,
+	count   INTEGER NOT NULL,
+	UNIQUE (service, error)
+);
+
+-- Full-text indexes for search, kept in step by triggers
+CREATE VIRTUAL TABLE IF NOT EXISTS events_fts USING fts5 (service, type, message, author, content='events', content_rowid='id');
+CREATE TRIGGER IF NOT EXISTS events_fts_insert AFTER INSERT ON events BEGIN
+	INSERT INTO events_fts (rowid, service, type, message, author) VALUES (new.id, new.service, new.type, new.message, new.author);
+END;
+CREATE TRIGGER IF NOT EXISTS events_fts_delete AFTER DELETE ON events BEGIN
+	INSERT INTO events_fts (events_fts, rowid, service, type, message, author) VALUES ('delete', old.id, old.service, old.type, old.message, old.author);
+END;
+CREATE VIRTUAL TABLE IF NOT EXISTS failures_fts USING fts5 (service, error, content='failures', content_rowid='id');
+CREATE TRIGGER IF NOT EXISTS failures_fts_insert AFTER INSERT ON failures BEGIN
+	INSERT INTO failures_fts (rowid, service, error) VALUES (new.id, new.service, new.error);
+END;
+CREATE TRIGGER IF NOT EXISTS failures_fts_delete AFTER DELETE ON failures BEGIN
+	INSERT INTO failures_fts (failures_fts, rowid, service, error) VALUES ('delete', old.id, old.service, old.error);
+END;
+
+-- Index what was written before search was added
+INSERT INTO failures (service, error, first, last, count)
+	SELECT service, error, MIN(time), MAX(time), COUNT(*) FROM results WHERE error != '' GROUP BY service, error;
+INSERT INTO events_fts (events_fts) VALUES ('rebuild');
@@ -0,0 +1,185 @@
+// mtls.go
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// ClientTLSConfig holds the client certificate and CA bundle for checks against
+// services that require mTLS. Files are PEM encoded and reread when they change,
+// so rotated certificates are picked up without a restart.
+type ClientTLSConfig struct {
+	CertFile string `json:"cert_file,omitempty"`
+	KeyFile  string `json:"key_file,omitempty"`
+	CAFile   string `json:"ca_file,omitempty"` // verifies the server instead of the system roots
+}
+
+// tlsSchemes are the URL schemes a ClientTLSConfig applies to, by check type
+var tlsSchemes = map[string]string{"": "https", "http": "https", "etcd": "https", "grpc": "grpcs", "redis": "rediss"}
+
+// load builds the TLS settings from the files, on top of the -tls-policy settings
+func (c ClientTLSConfig) load() (*tls.Config, error) {
+	cfg := clientTLSConfig("")
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	if c.CAFile != "" {
+		pool, err := loadCABundle(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+	return cfg, nil
+}
+
+// loadCABundle reads a file of PEM certificates into a pool
+func loadCABundle(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no PEM certificates in %s", path)
+	}
+	return pool, nil
+}
+
+// modTimes returns when each configured file last changed
+func (c ClientTLSConfig) modTimes() ([3]time.Time, error) {
+	var times [3]time.Time
+	for i, path := range []string{c.CertFile, c.KeyFile, c.CAFile} {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return times, err
+		}
+		times[i] = info.ModTime()
+	}
+	return times, nil
+}
+
+// validate checks a client TLS definition; prefix is prepended to field names.
+// The files must exist and parse.
+func (c ClientTLSConfig) validate(prefix string) []ValidationError {
+	var errs []ValidationError
+	add := func(name, format string, args ...interface{}) {
+		errs = append(errs, ValidationError{Field: prefix + name, Message: fmt.Sprintf(format, args...)})
+	}
+
+	switch {
+	case c.CertFile == "" && c.KeyFile == "" && c.CAFile == "":
+		add("cert_file", "give cert_file and key_file, ca_file, or both")
+	case c.CertFile != "" && c.KeyFile == "":
+		add("key_file", "key_file is required with cert_file")
+	case c.KeyFile != "" && c.CertFile == "":
+		add("cert_file", "cert_file is required with key_file")
+	case c.CertFile != "":
+		if _, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile); err != nil {
+			add("cert_file", "%v", err)
+		}
+	}
+	if c.CAFile != "" {
+		if _, err := loadCABundle(c.CAFile); err != nil {
+			add("ca_file", "%v", err)
+		}
+	}
+	return errs
+}
+
+// clientTransports hold one transport per client TLS definition, shared by the
+// services that use it so connections are reused across checks
+type clientTransports struct {
+	http    *http.Transport
+	grpc    *http2.Transport
+	tls     *tls.Config
+	modTime [3]time.Time
+}
+
+var (
+	mtlsTransports   = make(map[ClientTLSConfig]*clientTransports)
+	mtlsTransportsMu sync.Mutex
+)
+
+// transports returns the transports for c, rebuilding them when a file changed
+func (c ClientTLSConfig) transports() (*clientTransports, error) {
+	modTime, err := c.modTimes()
+	if err != nil {
+		return nil, err
+	}
+
+	mtlsTransportsMu.Lock()
+	defer mtlsTransportsMu.Unlock()
+	t := mtlsTransports[c]
+	if t != nil && t.modTime == modTime {
+		return t, nil
+	}
+	cfg, err := c.load()
+	if err != nil {
+		return nil, err
+	}
+	if t != nil {
+		t.http.CloseIdleConnections()
+		t.grpc.CloseIdleConnections()
+	}
+	httpTransport := http.DefaultTransport.(*http.Transport).Clone()
+	httpTransport.TLSClientConfig = cfg
+	t = &clientTransports{http: httpTransport, grpc: &http2.Transport{TLSClientConfig: cfg}, tls: cfg, modTime: modTime}
+	mtlsTransports[c] = t
+	return t, nil
+}
+
+// checkTransport returns the transport for a service's HTTP requests: a
+// dedicated one when it has client TLS settings, else nil for the default
+func checkTransport(svc Service) (http.RoundTripper, error) {
+	if svc.TLS == nil {
+		return nil, nil
+	}
+	t, err := svc.TLS.transports()
+	if err != nil {
+		return nil, fmt.Errorf("client TLS: %w", err)
+	}
+	return t.http, nil
+}
+
+// serviceTLSConfig returns the TLS settings of a service's client TLS
+// definition, or nil when it has none
+func serviceTLSConfig(svc Service) (*tls.Config, error) {
+	if svc.TLS == nil {
+		return nil, nil
+	}
+	t, err := svc.TLS.transports()
+	if err != nil {
+		return nil, fmt.Errorf("client TLS: %w", err)
+	}
+	return t.tls, nil
+}
+
+// checkTLSConfig returns the TLS settings for a service's connection to serverName
+func checkTLSConfig(svc Service, serverName string) (*tls.Config, error) {
+	if svc.TLS == nil {
+		return clientTLSConfig(serverName), nil
+	}
+	t, err := svc.TLS.transports()
+	if err != nil {
+		return nil, fmt.Errorf("client TLS: %w", err)
+	}
+	cfg := t.tls.Clone()
+	cfg.ServerName = serverName
+	return cfg, nil
+}
@@ -0,0 +1,169 @@
+// notifier_health.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// defaultNotifierCheckInterval is how often notifiers are self-checked by default
+const defaultNotifierCheckInterval = time.Hour
+
+// notifierStats are the delivery totals and last self-check result of a notifier
+type notifierStats struct {
+	Delivered      int64      `json:"delivered"`
+	Failed         int64      `json:"failed"` // failed attempts, including retries
+	DeadLettered   int64      `json:"dead_lettered"`
+	Healthy        *bool      `json:"healthy,omitempty"` // nil until the first self-check
+	LastCheck      *time.Time `json:"last_check,omitempty"`
+	LastCheckError string     `json:"last_check_error,omitempty"`
+}
+
+// RunSelfChecks starts verifying each notifier in the background on its check
+// interval; the startup checks verify them first
+func (o *Outbox) RunSelfChecks() {
+	for name, c := range o.configs {
+		interval := orDefault(c.CheckInterval, defaultNotifierCheckInterval)
+		go func(name string, interval time.Duration) {
+			for {
+				time.Sleep(interval)
+				o.selfCheck(name)
+			}
+		}(name, interval)
+	}
+}
+
+// selfCheck runs one check of a notifier and records the result
+func (o *Outbox) selfCheck(name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), outboxSendTimeout)
+	err := o.notifiers[name].Check(ctx)
+	cancel()
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	stats := o.stats[name]
+	now := time.Now()
+	healthy := err == nil
+	if !healthy {
+		slog.Error("notifier self-check failed", "notifier", name, "error", err)
+		stats.LastCheckError = err.Error()
+	} else {
+		if stats.Healthy != nil && !*stats.Healthy {
+			slog.Info("notifier self-check passing again", "notifier", name)
+		}
+		stats.LastCheckError = ""
+	}
+	stats.Healthy = &healthy
+	stats.LastCheck = &now
+	return err
+}
+
+// testNotification is the synthetic alert sent by notifier tests
+func testNotification(notifier string) Notification {
+	return Notification{
+		Subject: "[TEST] Service example-service is down",
+		Text: fmt.Sprintf("This is a test alert sent through notifier %q at %s.\n"+
+			"Service: example-service\nURL: https://example.com/health\nError: synthetic failure for notifier testing\n"+
+			"No action is needed.", notifier, time.Now().Format(time.RFC1123)),
+	}
+}
+
+// SendTest delivers a synthetic alert through the named notifier right away,
+// bypassing the outbox so the result is known immediately
+func (o *Outbox) SendTest(ctx context.Context, name string) error {
+	notifier, ok := o.notifiers[name]
+	if !ok {
+		return fmt.Errorf("unknown notifier %q", name)
+	}
+	err := notifier.Notify(ctx, testNotification(name))
+	if err != nil {
+		slog.Warn("test alert failed", "notifier", name, "error", err)
+	} else {
+		slog.Info("test alert delivered", "notifier", name)
+	}
+	return err
+}
+
+// TestHandler sends a synthetic alert through the notifier in the path
+func (o *Outbox) TestHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if _, ok := o.notifiers[name]; !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("unknown notifier %q", name)})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), outboxSendTimeout)
+	defer cancel()
+	if err := o.SendTest(ctx, name); err != nil {
+		writeJSON(w, http.StatusBadGateway, map[string]interface{}{"delivered": false, "error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"delivered": true})
+}
+
+// NotifiersHandler lists the configured notifiers with their delivery totals and
+// self-check status
+func (o *Outbox) NotifiersHandler(w http.ResponseWriter, r *http.Request) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	type notifierInfo struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+		notifierStats
+	}
+	list := []notifierInfo{}
+	for name, c := range o.configs {
+		list = append(list, notifierInfo{Name: name, Type: c.Type, notifierStats: *o.stats[name]})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	writeJSON(w, http.StatusOK, list)
+}
+
+// WriteMetrics writes notifier delivery and self-check metrics
+func (o *Outbox) WriteMetrics(m *metricWriter) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	names := make([]string, 0, len(o.stats))
+	for name := range o.stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	m.family("notifier_deliveries_total", "counter", "Notification delivery attempts by result")
+
+	for _, name := range names {
+		stats := o.stats[name]
+		m.sample("notifier_deliveries_total", float64(stats.Delivered), "notifier", name, "result", "success")
+		m.sample("notifier_deliveries_total", float64(stats.Failed), "notifier", name, "result", "failure")
+	}
+
+	m.family("notifier_dead_letters_total", "counter", "Notifications that gave up after repeated failures")
+
+	for _, name := range names {
+		m.sample("notifier_dead_letters_total", float64(o.stats[name].DeadLettered), "notifier", name)
+	}
+
+	m.family("notifier_up", "gauge", "Whether the notifier's last self-check passed (1) or not (0)")
+
+	for _, name := range names {
+		stats := o.stats[name]
+		if stats.Healthy == nil {
+			continue
+		}
+		up := 0
+		if *stats.Healthy {
+			up = 1
+		}
+		m.sample("notifier_up", float64(up), "notifier", name)
+	}
+
+	m.family("notifier_outbox_pending", "gauge", "Notifications waiting to be delivered")
+	m.sample("notifier_outbox_pending", float64(len(o.pending)))
+}
@@ -0,0 +1,251 @@
+// notify.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Notification is a message delivered through a notifier
+type Notification struct {
+	Subject string `json:"subject"`
+	Text    string `json:"text"` // plain text body
+
+	// The state change behind an alert notification, for notifiers that send it
+	// as structured data
+	Alert *Alert `json:"alert,omitempty"`
+
+	// Language Subject and Text are in, for notifiers that format the alert themselves
+	Language string `json:"language,omitempty"`
+}
+
+// Notifier delivers notifications to a channel such as Slack or email
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+
+	// Check verifies the channel is reachable and its credentials work, without
+	// delivering a message where the channel allows it
+	Check(ctx context.Context) error
+}
+
+// NotifierConfig configures a named notification channel
+type NotifierConfig struct {
+	Name          string   `json:"name"`
+	Type          string   `json:"type"`                     // slack, email, webhook, pagerduty
+	CheckInterval Duration `json:"check_interval,omitempty"` // self-check period, default 1h
+
+	// Slack and webhook
+	WebhookURL string `json:"webhook_url,omitempty"`
+
+	// Webhook: shared secret for signing payloads
+	Secret string `json:"secret,omitempty"`
+
+	// Language of alerts: en, de or ja; default alerting.language
+	Language string `json:"language,omitempty"`
+
+	// PagerDuty: the Events v2 integration key, and the severity of the incidents
+	// it triggers (critical, error, warning or info; default critical).
+	// webhook_url overrides the Events API endpoint, e.g. for the EU region.
+	RoutingKey string `json:"routing_key,omitempty"`
+	Severity   string `json:"severity,omitempty"`
+
+	// Email
+	SMTPHost string   `json:"smtp_host,omitempty"` // host:port
+	SMTPTLS  string   `json:"smtp_tls,omitempty"`  // starttls or tls; default STARTTLS when the server offers it
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+	From     string   `json:"from,omitempty"`
+	To       []string `json:"to,omitempty"` // alerts no recipient route matches, and other mail
+
+	// Email: recipients for the alerts of the services each selector matches
+	Recipients []EmailRoute `json:"recipients,omitempty"`
+
+	// Email: text/template overrides for alert mail, executed with the Alert
+	SubjectTemplate string `json:"subject_template,omitempty"`
+	BodyTemplate    string `json:"body_template,omitempty"`
+}
+
+// notifierTypes builds a notifier for each supported type
+var notifierTypes = map[string]func(NotifierConfig) Notifier{
+	"slack":   func(c NotifierConfig) Notifier { return &slackNotifier{url: c.WebhookURL} },
+	"email":   func(c NotifierConfig) Notifier { return &emailNotifier{config: c} },
+	"webhook": func(c NotifierConfig) Notifier { return &webhookNotifier{url: c.WebhookURL, secret: c.Secret} },
+	"pagerduty": func(c NotifierConfig) Notifier {
+		return &pagerDutyNotifier{url: c.WebhookURL, routingKey: c.RoutingKey, severity: c.Severity}
+	},
+}
+
+// newNotifiers builds the configured notifiers, keyed by name
+func newNotifiers(configs []NotifierConfig) map[string]Notifier {
+	notifiers := make(map[string]Notifier, len(configs))
+	for _, c := range configs {
+		notifiers[c.Name] = notifierTypes[c.Type](c)
+	}
+	return notifiers
+}
+
+// validateNotifier checks a single notifier definition; prefix is prepended to field names
+func validateNotifier(c NotifierConfig, prefix string) []ValidationError {
+	var errs []ValidationError
+	add := func(name, format string, args ...interface{}) {
+		errs = append(errs, ValidationError{Field: prefix + name, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if strings.TrimSpace(c.Name) == "" {
+		add("name", "name is required")
+	}
+	if c.CheckInterval < 0 {
+		add("check_interval", "must not be negative")
+	}
+	if !validLanguage(c.Language) {
+		add("language", "must be one of %s", strings.Join(languages, ", "))
+	}
+
+	switch c.Type {
+	case "slack":
+		if u, err := url.Parse(c.WebhookURL); err != nil || u.Scheme != "https" || u.Host == "" {
+			add("webhook_url", "must be an https URL")
+		}
+	case "webhook":
+		if u, err := url.Parse(c.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add("webhook_url", "must be an absolute http or https URL")
+		}
+	case "pagerduty":
+		if strings.TrimSpace(c.RoutingKey) == "" {
+			add("routing_key", "routing_key is required")
+		}
+		if c.Severity != "" && !containsString(pagerDutySeverities, c.Severity) {
+			add("severity", "must be one of %s", strings.Join(pagerDutySeverities, ", "))
+		}
+		if c.WebhookURL != "" {
+			if u, err := url.Parse(c.WebhookURL); err != nil || u.Scheme != "https" || u.Host == "" {
+				add("webhook_url", "must be an https URL")
+			}
+		}
+	case "email":
+		if _, _, err := net.SplitHostPort(c.SMTPHost); err != nil {
+			add("smtp_host", "must be host:port")
+		}
+		if c.From == "" {
+			add("from", "from is required")
+		}
+		if len(c.To) == 0 {
+			add("to", "at least one recipient is required")
+		}
+		errs = append(errs, validateEmail(c, prefix)...)
+	default:
+		add("type", "unknown notifier type %q", c.Type)
+	}
+	return errs
+}
+
+// slackNotifier posts to a Slack incoming webhook
+type slackNotifier struct {
+	url string
+}
+
+func (s *slackNotifier) Notify(ctx context.Context, n Notification) error {
+	body, _ := json.Marshal(slackMessage(n))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("slack returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// slackEscape escapes the characters Slack treats as markup in message text
+var slackEscape = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// slackColors color the bar beside alert messages by state
+var slackColors = map[string]string{AlertDown: "#d32f2f", AlertRecovered: "#2e7d32", AlertWarning: "#f9a825"}
+
+// slackMessage formats a notification for an incoming webhook. Alerts become an
+// attachment colored by state, with the error, response time and incident as
+// fields; other notifications are bold subject and plain text.
+func slackMessage(n Notification) map[string]interface{} {
+	a := n.Alert
+	if a == nil {
+		return map[string]interface{}{"text": "*" + slackEscape.Replace(n.Subject) + "*\n" + slackEscape.Replace(n.Text)}
+	}
+
+	type field struct {
+		Title string `json:"title"`
+		Value string `json:"value"`
+		Short bool   `json:"short"`
+	}
+	lang := n.Language
+	title := ":red_circle: " + translate(lang, "%s is down", a.Service)
+	fields := []field{{Title: translate(lang, "Response time"), Value: fmt.Sprintf("%dms", a.ResponseTime), Short: true}}
+	switch {
+	case a.State == AlertRecovered:
+		title = ":large_green_circle: " + translate(lang, "%s has recovered", a.Service)
+	case a.State == AlertWarning:
+		title = ":warning: " + a.Service + ": " + a.Warning
+	case a.Reminder > 0:
+		title = ":red_circle: " + translate(lang, "%s is still down", a.Service)
+	}
+	if a.State == AlertRecovered || a.Reminder > 0 {
+		fields = append(fields, field{Title: translate(lang, "Down for"), Value: a.At.Sub(a.StartedAt).Round(time.Second).String(), Short: true})
+	}
+	if a.IncidentID != "" {
+		fields = append(fields, field{Title: translate(lang, "Incident"), Value: a.IncidentID, Short: true})
+	}
+	if a.DNSError != "" {
+		fields = append(fields, field{Title: translate(lang, "DNS failure"), Value: a.DNSError, Short: true})
+	}
+	if a.Error != "" {
+		fields = append(fields, field{Title: translate(lang, "Error"), Value: "```" + slackEscape.Replace(a.Error) + "```"})
+	}
+	return map[string]interface{}{
+		"text": slackEscape.Replace(n.Subject), // shown in push notifications and clients without attachments
+		"attachments": []map[string]interface{}{{
+			"color":      slackColors[a.State],
+			"title":      slackEscape.Replace(title),
+			"title_link": a.URL,
+			"fields":     fields,
+			"mrkdwn_in":  []string{"fields"},
+			"footer":     "sre-health-checker",
+			"ts":         a.At.Unix(),
+		}},
+	}
+}
+
+// Check posts an empty message, which a valid webhook rejects with "no_text"
+// while an invalid or revoked one returns a different error
+func (s *slackNotifier) Check(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, strings.NewReader("{}"))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode == http.StatusBadRequest && strings.TrimSpace(string(body)) == "no_text" {
+		return nil
+	}
+	return fmt.Errorf("slack webhook returned %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+}
@@ -0,0 +1,364 @@
+// outbox.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Outbox retry policy
+const (
+	outboxBaseBackoff   = 10 * time.Second
+	outboxMaxBackoff    = 15 * time.Minute
+	outboxMaxAttempts   = 10
+	outboxSendTimeout   = 30 * time.Second
+	outboxDeadLetterMax = 500
+)
+
+// OutboxEntry is a notification waiting to be delivered, or one that gave up
+type OutboxEntry struct {
+	ID           int64        `json:"id"`
+	Notifier     string       `json:"notifier"`
+	Notification Notification `json:"notification"`
+	CreatedAt    time.Time    `json:"created_at"`
+	Attempts     int          `json:"attempts"`
+	NextAttempt  time.Time    `json:"next_attempt"`
+	LastError    string       `json:"last_error,omitempty"`
+}
+
+// Outbox delivers notifications with retries and exponential backoff, moving
+// entries that keep failing to a dead-letter list. Each notifier is delivered
+// to by a loop of its own, so one that hangs doesn't hold up the others. When
+// it has a path, a writer apart from the deliveries saves the queue to disk
+// whenever it changes, so notifications survive a restart.
+type Outbox struct {
+	path      string
+	notifiers map[string]Notifier
+	configs   map[string]NotifierConfig
+	stats     map[string]*notifierStats
+	pending   []*OutboxEntry
+	dead      []*OutboxEntry
+	nextID    int64
+	dirty     bool                     // changed since last written to disk
+	wake      map[string]chan struct{} // per notifier, to its delivery loop
+	save      chan struct{}            // to the writer, when dirty
+	mu        sync.Mutex
+}
+
+// outboxFile is the on-disk form of the outbox
+type outboxFile struct {
+	NextID  int64          `json:"next_id"`
+	Pending []*OutboxEntry `json:"pending"`
+	Dead    []*OutboxEntry `json:"dead_letter"`
+}
+
+// NewOutbox creates an outbox for the configured notifiers, restoring undelivered
+// entries from path if it exists. An empty path keeps the outbox in memory only.
+func NewOutbox(path string, configs []NotifierConfig) (*Outbox, error) {
+	o := &Outbox{
+		path:      path,
+		notifiers: newNotifiers(configs),
+		configs:   make(map[string]NotifierConfig),
+		stats:     make(map[string]*notifierStats),
+		nextID:    1,
+		wake:      make(map[string]chan struct{}),
+		save:      make(chan struct{}, 1),
+	}
+	for _, c := range configs {
+		o.configs[c.Name] = c
+		o.stats[c.Name] = &notifierStats{}
+	}
+	for name := range o.notifiers {
+		o.wake[name] = make(chan struct{}, 1)
+	}
+	if path == "" {
+		return o, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return o, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var saved outboxFile
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, err
+	}
+	o.dead = saved.Dead
+	for _, e := range saved.Pending {
+		if _, ok := o.notifiers[e.Notifier]; ok {
+			o.pending = append(o.pending, e)
+		} else {
+			o.deadLetterUnknown(e)
+			o.changed()
+		}
+	}
+	if saved.NextID > o.nextID {
+		o.nextID = saved.NextID
+	}
+	if len(o.pending) > 0 {
+		slog.Info("restored undelivered notifications", "notifications", len(o.pending), "path", path)
+	}
+	return o, nil
+}
+
+// persist writes the outbox to disk if it changed since it was last written.
+// Only the writer calls it, so writes never overtake each other, and the file
+// is written without o.mu held so Enqueue doesn't wait on the disk.
+func (o *Outbox) persist() {
+	o.mu.Lock()
+	if o.path == "" || !o.dirty {
+		o.mu.Unlock()
+		return
+	}
+	data, err := json.Marshal(outboxFile{NextID: o.nextID, Pending: o.pending, Dead: o.dead})
+	o.dirty = false
+	o.mu.Unlock()
+
+	if err == nil {
+		err = writeFileAtomic(o.path, data)
+	}
+	if err != nil {
+		slog.Warn("saving outbox", "path", o.path, "error", err)
+	}
+}
+
+// Enqueue queues a notification for delivery through the named notifier.
+// Notifications for a notifier that isn't configured are dead-lettered at once.
+func (o *Outbox) Enqueue(notifier string, n Notification) {
+	o.mu.Lock()
+	now := time.Now()
+	e := &OutboxEntry{
+		ID:           o.nextID,
+		Notifier:     notifier,
+		Notification: n,
+		CreatedAt:    now,
+		NextAttempt:  now,
+	}
+	o.nextID++
+	if _, ok := o.notifiers[notifier]; ok {
+		o.pending = append(o.pending, e)
+	} else {
+		o.deadLetterUnknown(e)
+	}
+	o.changed()
+	o.mu.Unlock()
+
+	o.wakeNotifier(notifier)
+}
+
+// Run starts the writer and a delivery loop per notifier, and returns once they
+// have started
+func (o *Outbox) Run() {
+	go o.runWriter()
+	for name := range o.notifiers {
+		go o.runNotifier(name)
+	}
+}
+
+// runWriter writes the outbox to disk whenever it changes, however long the
+// deliveries in progress take
+func (o *Outbox) runWriter() {
+	for range o.save {
+		o.persist()
+	}
+}
+
+// runNotifier delivers the due entries of one notifier, oldest first, until
+// the process exits
+func (o *Outbox) runNotifier(name string) {
+	timer := time.NewTimer(0)
+	for {
+		select {
+		case <-timer.C:
+		case <-o.wake[name]:
+			timer.Stop()
+		}
+
+		for {
+			entry := o.nextDue(name, time.Now())
+			if entry == nil {
+				break
+			}
+			o.deliver(entry)
+		}
+		timer.Reset(o.untilNext(name))
+	}
+}
+
+// changed marks the outbox for the writer to save. Must be called with o.mu held.
+func (o *Outbox) changed() {
+	o.dirty = true
+	select {
+	case o.save <- struct{}{}:
+	default:
+	}
+}
+
+// wakeNotifier tells a notifier's delivery loop that an entry may be due
+func (o *Outbox) wakeNotifier(name string) {
+	select {
+	case o.wake[name] <- struct{}{}:
+	default:
+	}
+}
+
+// nextDue returns a notifier's oldest entry whose next attempt is due, if any
+func (o *Outbox) nextDue(notifier string, now time.Time) *OutboxEntry {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for _, e := range o.pending {
+		if e.Notifier == notifier && !e.NextAttempt.After(now) {
+			return e
+		}
+	}
+	return nil
+}
+
+// untilNext returns how long until a notifier's next entry is due
+func (o *Outbox) untilNext(notifier string) time.Duration {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	wait := outboxMaxBackoff
+	for _, e := range o.pending {
+		if d := time.Until(e.NextAttempt); e.Notifier == notifier && d < wait {
+			wait = d
+		}
+	}
+	if wait < 0 {
+		wait = 0
+	}
+	return wait
+}
+
+// deadLetterUnknown dead-letters an entry for a notifier that isn't
+// configured. Must be called with o.mu held.
+func (o *Outbox) deadLetterUnknown(e *OutboxEntry) {
+	e.Attempts++
+	e.LastError = "unknown notifier " + strconv.Quote(e.Notifier)
+	slog.Error("notification dead-lettered", "notifier", e.Notifier, "subject", e.Notification.Subject, "error", e.LastError)
+	o.addDead(e)
+}
+
+// deliver attempts one delivery and reschedules, removes or dead-letters the entry
+func (o *Outbox) deliver(e *OutboxEntry) {
+	ctx, cancel := context.WithTimeout(context.Background(), outboxSendTimeout)
+	err := o.notifiers[e.Notifier].Notify(ctx, e.Notification)
+	cancel()
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	e.Attempts++
+	stats := o.stats[e.Notifier]
+	switch {
+	case err == nil:
+		slog.Info("notification delivered", "notifier", e.Notifier, "subject", e.Notification.Subject)
+		stats.Delivered++
+		o.remove(e)
+	case e.Attempts >= outboxMaxAttempts:
+		e.LastError = err.Error()
+		stats.Failed++
+		stats.DeadLettered++
+		slog.Error("notification dead-lettered", "notifier", e.Notifier, "subject", e.Notification.Subject, "attempts", e.Attempts, "error", err)
+		o.remove(e)
+		o.addDead(e)
+	default:
+		e.LastError = err.Error()
+		stats.Failed++
+		backoff := outboxBaseBackoff << (e.Attempts - 1)
+		if backoff > outboxMaxBackoff {
+			backoff = outboxMaxBackoff
+		}
+		e.NextAttempt = time.Now().Add(backoff)
+		slog.Warn("notification delivery failed, retrying", "notifier", e.Notifier, "subject", e.Notification.Subject, "attempt", e.Attempts, "retry_in", backoff.String(), "error", err)
+	}
+	o.changed()
+}
+
+// remove drops an entry from the pending queue. Must be called with o.mu held.
+func (o *Outbox) remove(e *OutboxEntry) {
+	for i, p := range o.pending {
+		if p == e {
+			o.pending = append(o.pending[:i], o.pending[i+1:]...)
+			return
+		}
+	}
+}
+
+// addDead appends to the dead-letter list, dropping the oldest entries beyond
+// outboxDeadLetterMax. Must be called with o.mu held.
+func (o *Outbox) addDead(e *OutboxEntry) {
+	o.dead = append(o.dead, e)
+	if len(o.dead) > outboxDeadLetterMax {
+		o.dead = append(o.dead[:0:0], o.dead[len(o.dead)-outboxDeadLetterMax:]...)
+	}
+}
+
+// Retry moves a dead-lettered entry back onto the queue with a fresh attempt
+// count. Entries for a notifier that isn't configured stay dead-lettered.
+func (o *Outbox) Retry(id int64) bool {
+	o.mu.Lock()
+	var found *OutboxEntry
+	for i, e := range o.dead {
+		if _, ok := o.notifiers[e.Notifier]; ok && e.ID == id {
+			o.dead = append(o.dead[:i], o.dead[i+1:]...)
+			e.Attempts = 0
+			e.NextAttempt = time.Now()
+			o.pending = append(o.pending, e)
+			found = e
+			break
+		}
+	}
+	if found != nil {
+		o.changed()
+	}
+	o.mu.Unlock()
+
+	if found != nil {
+		o.wakeNotifier(found.Notifier)
+	}
+	return found != nil
+}
+
+// ListHandler returns the pending and dead-lettered entries
+func (o *Outbox) ListHandler(w http.ResponseWriter, r *http.Request) {
+	o.mu.Lock()
+	pending, dead := copyEntries(o.pending), copyEntries(o.dead)
+	o.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"pending":     pending,
+		"dead_letter": dead,
+	})
+}
+
+// copyEntries copies entries, which deliver updates in place, so they can be
+// used without o.mu. Must be called with o.mu held.
+func copyEntries(entries []*OutboxEntry) []OutboxEntry {
+	copied := make([]OutboxEntry, len(entries))
+	for i, e := range entries {
+		copied[i] = *e
+	}
+	return copied
+}
+
+// RetryHandler requeues the dead-lettered entry in the path
+func (o *Outbox) RetryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || !o.Retry(id) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "no dead-lettered entry with that id"})
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
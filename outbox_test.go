@@ -0,0 +1,160 @@
+// outbox_test.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newWebhookOutbox returns an outbox with one webhook notifier per named receiver
+func newWebhookOutbox(t *testing.T, path string, receivers map[string]*httptest.Server) *Outbox {
+	t.Helper()
+	var configs []NotifierConfig
+	for name, srv := range receivers {
+		configs = append(configs, NotifierConfig{Name: name, Type: "webhook", WebhookURL: srv.URL})
+	}
+	o, err := NewOutbox(path, configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+// statusServer answers every request with the given status
+func statusServer(t *testing.T, status int) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestOutboxBacksOffAndDeadLetters(t *testing.T) {
+	o := newWebhookOutbox(t, "", map[string]*httptest.Server{"hook": statusServer(t, http.StatusBadGateway)})
+	o.Enqueue("hook", Notification{Subject: "api is down"})
+
+	entry := o.nextDue("hook", time.Now())
+	if entry == nil {
+		t.Fatal("enqueued notification isn't due")
+	}
+	for attempt := 1; attempt < outboxMaxAttempts; attempt++ {
+		before := time.Now()
+		o.deliver(entry)
+		want := outboxBaseBackoff << (attempt - 1)
+		if want > outboxMaxBackoff {
+			want = outboxMaxBackoff
+		}
+		if wait := entry.NextAttempt.Sub(before); wait < want || wait > want+time.Second {
+			t.Fatalf("after attempt %d the retry is in %v, want %v", attempt, wait, want)
+		}
+		if o.nextDue("hook", time.Now()) != nil {
+			t.Fatalf("after attempt %d the notification is due again straight away", attempt)
+		}
+	}
+
+	o.deliver(entry)
+	if len(o.pending) != 0 || len(o.dead) != 1 {
+		t.Fatalf("after %d attempts pending = %d, dead = %d, want the notification dead-lettered", outboxMaxAttempts, len(o.pending), len(o.dead))
+	}
+	if got := o.dead[0]; got.Attempts != outboxMaxAttempts || got.LastError != "webhook returned 502" {
+		t.Errorf("dead-lettered entry = %+v", got)
+	}
+	if stats := o.stats["hook"]; stats.Failed != outboxMaxAttempts || stats.DeadLettered != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	if !o.Retry(entry.ID) || len(o.dead) != 0 || entry.Attempts != 0 || o.nextDue("hook", time.Now()) != entry {
+		t.Errorf("retrying didn't requeue the notification with a fresh attempt count")
+	}
+}
+
+func TestOutboxDeadLettersUnknownNotifier(t *testing.T) {
+	o := newWebhookOutbox(t, "", map[string]*httptest.Server{"hook": statusServer(t, http.StatusOK)})
+	o.Enqueue("gone", Notification{Subject: "api is down"})
+
+	if len(o.pending) != 0 || len(o.dead) != 1 || o.dead[0].LastError != `unknown notifier "gone"` {
+		t.Fatalf("pending = %+v, dead = %+v, want the notification dead-lettered", o.pending, o.dead)
+	}
+	if o.Retry(o.dead[0].ID) {
+		t.Error("retried a notification for a notifier that isn't configured")
+	}
+}
+
+func TestOutboxRestoresFromDisk(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.json")
+	receivers := map[string]*httptest.Server{"hook": statusServer(t, http.StatusBadGateway)}
+	o := newWebhookOutbox(t, path, receivers)
+	o.Enqueue("hook", Notification{Subject: "api is down"})
+	o.Enqueue("hook", Notification{Subject: "db is down"})
+	o.Enqueue("gone", Notification{Subject: "cache is down"})
+	o.deliver(o.nextDue("hook", time.Now()))
+	o.persist()
+
+	restored := newWebhookOutbox(t, path, receivers)
+	if len(restored.pending) != 2 || len(restored.dead) != 1 {
+		t.Fatalf("restored pending = %d, dead = %d, want 2 and 1", len(restored.pending), len(restored.dead))
+	}
+	if got := restored.pending[0]; got.Notification.Subject != "api is down" || got.Attempts != 1 || !got.NextAttempt.Equal(o.pending[0].NextAttempt) {
+		t.Errorf("restored entry = %+v, want its attempts and backoff kept", got)
+	}
+	restored.Enqueue("hook", Notification{Subject: "web is down"})
+	if got := restored.pending[2].ID; got != 4 {
+		t.Errorf("next ID after a restore = %d, want 4", got)
+	}
+}
+
+func TestOutboxWritesEnqueuesWhileDelivering(t *testing.T) {
+	// Not t.TempDir: the writer keeps running after the test, and may still be
+	// saving the delivery that finishes when the hanging receiver is released
+	dir, err := os.MkdirTemp("", "outbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "outbox.json")
+	release := make(chan struct{})
+	hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer hanging.Close()
+	defer close(release)
+
+	delivered := make(chan struct{}, 1)
+	working := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delivered <- struct{}{}
+	}))
+	defer working.Close()
+
+	o := newWebhookOutbox(t, path, map[string]*httptest.Server{"slow": hanging, "pager": working})
+	o.Run()
+	o.Enqueue("slow", Notification{Subject: "api is down"})
+	o.Enqueue("pager", Notification{Subject: "api is down"})
+
+	// One notifier hanging doesn't hold up another
+	select {
+	case <-delivered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("pager wasn't delivered to while the other notifier hung")
+	}
+
+	// Nor does it hold up writing the queue
+	o.Enqueue("pager", Notification{Subject: "db is down"})
+	<-delivered
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var saved outboxFile
+		data, err := os.ReadFile(path)
+		if err == nil && json.Unmarshal(data, &saved) == nil && len(saved.Pending) == 1 && saved.NextID == 4 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the outbox file wasn't written while a delivery hung")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
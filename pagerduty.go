@@ -0,0 +1,177 @@
+// pagerduty.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// pagerDutyEventsURL is the Events API v2 endpoint used unless webhook_url overrides it
+const pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+// pagerDutySummaryMax is the longest summary PagerDuty accepts
+const pagerDutySummaryMax = 1024
+
+// pagerDutySeverities are the severities PagerDuty events can have
+var pagerDutySeverities = []string{"critical", "error", "warning", "info"}
+
+// pagerDutyNotifier triggers PagerDuty incidents when services go down and
+// resolves them on recovery through the Events API v2
+type pagerDutyNotifier struct {
+	url        string
+	routingKey string
+	severity   string
+}
+
+// pagerDutyEvent is an Events API v2 request body
+type pagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"` // trigger or resolve
+	DedupKey    string            `json:"dedup_key,omitempty"`
+	Payload     *pagerDutyPayload `json:"payload,omitempty"` // trigger only
+	Links       []pagerDutyLink   `json:"links,omitempty"`
+}
+
+type pagerDutyPayload struct {
+	Summary       string                 `json:"summary"`
+	Source        string                 `json:"source"`
+	Severity      string                 `json:"severity"`
+	Timestamp     time.Time              `json:"timestamp"`
+	Component     string                 `json:"component,omitempty"`
+	CustomDetails map[string]interface{} `json:"custom_details,omitempty"`
+}
+
+type pagerDutyLink struct {
+	Href string `json:"href"`
+	Text string `json:"text"`
+}
+
+// pagerDutyDedupKey is the dedup key of a service's incidents, so a recovery
+// resolves the incident its down alert triggered and repeated down alerts don't
+// open more than one
+func pagerDutyDedupKey(service string) string {
+	return "sre-health-checker/" + service
+}
+
+// truncateSummary cuts s to PagerDuty's summary limit without splitting a UTF-8 sequence
+func truncateSummary(s string) string {
+	if len(s) <= pagerDutySummaryMax {
+		return s
+	}
+	cut := pagerDutySummaryMax - len("...")
+	for cut > 0 && s[cut]&0xC0 == 0x80 {
+		cut--
+	}
+	return s[:cut] + "..."
+}
+
+// event builds the Events API request for a notification. Other notifications
+// than alerts, such as notifier tests, trigger an info incident of their own.
+func (p *pagerDutyNotifier) event(n Notification) pagerDutyEvent {
+	severity := p.severity
+	if severity == "" {
+		severity = "critical"
+	}
+	a := n.Alert
+	if a == nil {
+		return pagerDutyEvent{RoutingKey: p.routingKey, EventAction: "trigger", Payload: &pagerDutyPayload{
+			Summary:       truncateSummary(n.Subject),
+			Source:        "sre-health-checker",
+			Severity:      "info",
+			Timestamp:     time.Now().UTC(),
+			CustomDetails: map[string]interface{}{"text": n.Text},
+		}}
+	}
+
+	source := a.URL
+	if source == "" { // heartbeat and exec checks have no URL
+		source = a.Service
+	}
+	if a.State == AlertWarning {
+		// Keyed apart from the service's incident, which a warning mustn't resolve
+		return pagerDutyEvent{RoutingKey: p.routingKey, EventAction: "trigger", DedupKey: pagerDutyDedupKey(a.Service) + "/" + a.Reason,
+			Payload: &pagerDutyPayload{
+				Summary:       truncateSummary(a.Service + ": " + a.Warning),
+				Source:        source,
+				Severity:      "warning",
+				Timestamp:     a.At.UTC(),
+				Component:     a.Service,
+				CustomDetails: map[string]interface{}{"reason": a.Reason, "warning": a.Warning, "labels": a.Labels},
+			}}
+	}
+	e := pagerDutyEvent{RoutingKey: p.routingKey, EventAction: "resolve", DedupKey: pagerDutyDedupKey(a.Service)}
+	if a.State == AlertDown {
+		e.EventAction = "trigger"
+		e.Payload = &pagerDutyPayload{
+			Summary:   truncateSummary(translate(n.Language, "%s is down: %s", a.Service, a.Error)),
+			Source:    source,
+			Severity:  severity,
+			Timestamp: a.At.UTC(),
+			Component: a.Service,
+			CustomDetails: map[string]interface{}{
+				"error":            a.Error,
+				"response_time_ms": a.ResponseTime,
+				"incident_id":      a.IncidentID,
+				"dns_error":        a.DNSError,
+				"labels":           a.Labels,
+			},
+		}
+		if strings.HasPrefix(a.URL, "http://") || strings.HasPrefix(a.URL, "https://") {
+			e.Links = []pagerDutyLink{{Href: a.URL, Text: a.Service}}
+		}
+	}
+	return e
+}
+
+func (p *pagerDutyNotifier) Notify(ctx context.Context, n Notification) error {
+	body, _ := json.Marshal(p.event(n))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("pagerduty returned %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// endpoint returns the Events API URL
+func (p *pagerDutyNotifier) endpoint() string {
+	if p.url != "" {
+		return p.url
+	}
+	return pagerDutyEventsURL
+}
+
+// Check sends a HEAD request to the Events API. PagerDuty can't verify an
+// integration key without creating an event, so this only shows the API is
+// reachable; a wrong key shows up on the first alert.
+func (p *pagerDutyNotifier) Check(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, p.endpoint(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("pagerduty returned %d", resp.StatusCode)
+	}
+	return nil
+}
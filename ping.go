@@ -0,0 +1,167 @@
+// ping.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"os"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// defaultPingCount is how many echo requests an ICMP check sends by default
+const defaultPingCount = 3
+
+// PingStats are the packet loss and round-trip times of an ICMP check
+type PingStats struct {
+	Sent        int     `json:"sent"`
+	Received    int     `json:"received"`
+	LossPercent float64 `json:"loss_percent"`
+	RTTMinMs    float64 `json:"rtt_min_ms,omitempty"`
+	RTTAvgMs    float64 `json:"rtt_avg_ms,omitempty"`
+	RTTMaxMs    float64 `json:"rtt_max_ms,omitempty"`
+}
+
+// listenICMP opens a raw ICMP socket, falling back to an unprivileged datagram
+// ("ping") socket when raw sockets aren't permitted
+func listenICMP(v6 bool) (*icmp.PacketConn, bool, error) {
+	raw, dgram, addr := "ip4:icmp", "udp4", "0.0.0.0"
+	if v6 {
+		raw, dgram, addr = "ip6:ipv6-icmp", "udp6", "::"
+	}
+	conn, err := icmp.ListenPacket(raw, addr)
+	if err == nil {
+		return conn, true, nil
+	}
+	if !errors.Is(err, os.ErrPermission) {
+		return nil, false, err
+	}
+	conn, err = icmp.ListenPacket(dgram, addr)
+	if err != nil {
+		return nil, false, fmt.Errorf("opening ICMP socket (raw sockets need CAP_NET_RAW; ping sockets need net.ipv4.ping_group_range): %w", err)
+	}
+	return conn, false, nil
+}
+
+// probeICMP sends PingCount echo requests to the host in the URL, spread over the
+// timeout. It fails when every request is lost, or when loss exceeds
+// MaxPacketLoss; smaller losses are reported as warnings.
+func probeICMP(ctx context.Context, svc Service, result *CheckResult) error {
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip", svc.URL)
+	if err != nil {
+		return err
+	}
+	ip := ips[0]
+	v6 := ip.To4() == nil
+
+	conn, privileged, err := listenICMP(v6)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var request icmp.Type = ipv4.ICMPTypeEcho
+	var reply icmp.Type = ipv4.ICMPTypeEchoReply
+	proto := 1 // ICMP
+	if v6 {
+		request, reply, proto = ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply, 58
+	}
+	var dst net.Addr = &net.IPAddr{IP: ip}
+	if !privileged {
+		dst = &net.UDPAddr{IP: ip}
+	}
+
+	count := svc.PingCount
+	if count <= 0 {
+		count = defaultPingCount
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(time.Duration(count) * time.Second)
+	}
+
+	// The kernel picks the ID of datagram sockets, so replies are matched on the
+	// sequence number; raw sockets see every reply and also check the ID
+	id := rand.Intn(0xffff)
+	stats := &PingStats{}
+	var total float64
+	buf := make([]byte, 1500)
+	for seq := 1; seq <= count; seq++ {
+		wait := time.Until(deadline) / time.Duration(count-seq+1)
+		if wait <= 0 {
+			break
+		}
+		msg := icmp.Message{Type: request, Body: &icmp.Echo{ID: id, Seq: seq, Data: []byte("sre-health-checker")}}
+		packet, err := msg.Marshal(nil)
+		if err != nil {
+			return err
+		}
+		sent := time.Now()
+		if _, err := conn.WriteTo(packet, dst); err != nil {
+			return err
+		}
+		stats.Sent++
+
+		conn.SetReadDeadline(sent.Add(wait))
+		for {
+			n, peer, err := conn.ReadFrom(buf)
+			if err != nil {
+				break // lost
+			}
+			m, err := icmp.ParseMessage(proto, buf[:n])
+			if err != nil || m.Type != reply {
+				continue
+			}
+			echo, ok := m.Body.(*icmp.Echo)
+			if !ok || echo.Seq != seq || (privileged && echo.ID != id) || !sameHost(peer, ip) {
+				continue
+			}
+			rtt := float64(time.Since(sent).Microseconds()) / 1000
+			if stats.Received == 0 || rtt < stats.RTTMinMs {
+				stats.RTTMinMs = rtt
+			}
+			stats.RTTMaxMs = math.Max(stats.RTTMaxMs, rtt)
+			total += rtt
+			stats.Received++
+			time.Sleep(time.Until(sent.Add(wait))) // keep requests evenly spread
+			break
+		}
+	}
+
+	if stats.Sent == 0 {
+		return errors.New("timeout too short to send a ping")
+	}
+	if stats.Received > 0 {
+		stats.RTTAvgMs = math.Round(1000*total/float64(stats.Received)) / 1000
+	}
+	stats.LossPercent = math.Round(1000*float64(stats.Sent-stats.Received)/float64(stats.Sent)) / 10
+	result.Ping = stats
+
+	switch {
+	case stats.Received == 0:
+		return fmt.Errorf("100%% packet loss (%d sent)", stats.Sent)
+	case svc.MaxPacketLoss > 0 && stats.LossPercent > svc.MaxPacketLoss:
+		return fmt.Errorf("%g%% packet loss exceeds %g%%", stats.LossPercent, svc.MaxPacketLoss)
+	case stats.LossPercent > 0:
+		result.Warnings = append(result.Warnings, fmt.Sprintf("%g%% packet loss", stats.LossPercent))
+	}
+	return nil
+}
+
+// sameHost reports whether a reply came from ip
+func sameHost(peer net.Addr, ip net.IP) bool {
+	switch a := peer.(type) {
+	case *net.IPAddr:
+		return a.IP.Equal(ip)
+	case *net.UDPAddr:
+		return a.IP.Equal(ip)
+	}
+	return false
+}
@@ -0,0 +1,179 @@
+// pool.go
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// checkPriorities are the values priority may take, most urgent first
+var checkPriorities = []string{"critical", "high", "normal", "low"}
+
+// priorityRank orders a priority for the check pool; unset is normal
+func priorityRank(priority string) int {
+	if priority == "" {
+		priority = "normal"
+	}
+	for i, p := range checkPriorities {
+		if p == priority {
+			return i
+		}
+	}
+	return len(checkPriorities)
+}
+
+// checkPool limits how many checks run at once. While it is full, due checks
+// wait and are let through by priority, oldest first within a priority. A check
+// that has waited a whole interval goes ahead of every priority, so no service
+// goes much longer than twice its interval between checks however busy the
+// pool is.
+type checkPool struct {
+	mu      sync.Mutex
+	limit   int
+	running int
+	waiting []*checkWaiter
+	stats   map[string]*priorityStats
+}
+
+// checkWaiter is a due check waiting for a slot
+type checkWaiter struct {
+	priority string
+	queued   time.Time
+	overdue  time.Time // promoted past every priority from then
+	ready    chan struct{}
+}
+
+// priorityStats counts the checks of one priority that had to wait
+type priorityStats struct {
+	queued   uint64
+	promoted uint64 // let through ahead of higher priorities for having waited too long
+	wait     time.Duration
+}
+
+func newCheckPool(limit int) *checkPool {
+	stats := make(map[string]*priorityStats)
+	for _, p := range checkPriorities {
+		stats[p] = &priorityStats{}
+	}
+	return &checkPool{limit: limit, stats: stats}
+}
+
+// acquire waits for a slot to check svc in and returns the function that frees
+// it. It returns an error if ctx ends first.
+func (p *checkPool) acquire(ctx context.Context, svc Service) (func(), error) {
+	priority := checkPriorities[min(priorityRank(svc.Priority), len(checkPriorities)-1)]
+
+	p.mu.Lock()
+	if p.running < p.limit && len(p.waiting) == 0 {
+		p.running++
+		p.mu.Unlock()
+		return p.release, nil
+	}
+	now := time.Now()
+	w := &checkWaiter{priority: priority, queued: now, overdue: now.Add(time.Duration(svc.Interval)), ready: make(chan struct{})}
+	p.waiting = append(p.waiting, w)
+	p.stats[priority].queued++
+	p.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return p.release, nil
+	case <-ctx.Done():
+		p.mu.Lock()
+		for i, other := range p.waiting {
+			if other == w {
+				p.waiting = append(p.waiting[:i], p.waiting[i+1:]...)
+				p.mu.Unlock()
+				return nil, ctx.Err()
+			}
+		}
+		p.mu.Unlock()
+		p.release() // granted a slot just as ctx ended
+		return nil, ctx.Err()
+	}
+}
+
+// release frees a slot and hands it to the next waiting check
+func (p *checkPool) release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.running--
+	if len(p.waiting) == 0 {
+		return
+	}
+	now := time.Now()
+	next := p.next(now)
+	w := p.waiting[next]
+	p.waiting = append(p.waiting[:next], p.waiting[next+1:]...)
+	p.running++
+	p.stats[w.priority].wait += now.Sub(w.queued)
+	close(w.ready)
+}
+
+// next returns the index of the waiting check to run next: the longest overdue
+// one, or else the oldest of the highest priority. Must be called with p.mu held.
+func (p *checkPool) next(now time.Time) int {
+	best, overdue := 0, -1
+	for i, w := range p.waiting {
+		if !now.Before(w.overdue) && (overdue < 0 || w.overdue.Before(p.waiting[overdue].overdue)) {
+			overdue = i
+		}
+		if priorityRank(w.priority) < priorityRank(p.waiting[best].priority) {
+			best = i
+		}
+	}
+	if overdue < 0 {
+		return best
+	}
+	if priorityRank(p.waiting[overdue].priority) > priorityRank(p.waiting[best].priority) {
+		p.stats[p.waiting[overdue].priority].promoted++
+	}
+	return overdue
+}
+
+// writeMetrics writes the pool's slot usage and queue behavior per priority
+func (p *checkPool) writeMetrics(m *metricWriter) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	waiting := make(map[string]int)
+	oldest := make(map[string]time.Duration)
+	for _, wt := range p.waiting {
+		waiting[wt.priority]++
+		oldest[wt.priority] = max(oldest[wt.priority], now.Sub(wt.queued))
+	}
+
+	m.family("check_pool_slots", "gauge", "Checks allowed to run at once (max_concurrent_checks)")
+	m.sample("check_pool_slots", float64(p.limit))
+
+	m.family("check_pool_running", "gauge", "Checks running now")
+	m.sample("check_pool_running", float64(p.running))
+
+	m.family("check_pool_waiting", "gauge", "Due checks waiting for a slot")
+	for _, priority := range checkPriorities {
+		m.sample("check_pool_waiting", float64(waiting[priority]), "priority", priority)
+	}
+
+	m.family("check_pool_oldest_wait_seconds", "gauge", "How long the longest waiting check has waited")
+	for _, priority := range checkPriorities {
+		m.sample("check_pool_oldest_wait_seconds", oldest[priority].Seconds(), "priority", priority)
+	}
+
+	m.family("check_pool_queued_total", "counter", "Checks that had to wait for a slot")
+	for _, priority := range checkPriorities {
+		m.sample("check_pool_queued_total", float64(p.stats[priority].queued), "priority", priority)
+	}
+
+	m.family("check_pool_wait_seconds_total", "counter", "Time checks spent waiting for a slot")
+	for _, priority := range checkPriorities {
+		m.sample("check_pool_wait_seconds_total", p.stats[priority].wait.Seconds(), "priority", priority)
+	}
+
+	m.family("check_pool_promotions_total", "counter", "Checks let through ahead of higher priorities after waiting a whole interval")
+	for _, priority := range checkPriorities {
+		m.sample("check_pool_promotions_total", float64(p.stats[priority].promoted), "priority", priority)
+	}
+}
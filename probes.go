@@ -0,0 +1,460 @@
+// probes.go
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// bodyAssertMax is how much of an HTTP response body assertions are evaluated against
+const bodyAssertMax = 64 << 10
+
+// probeFunc performs a single check against a service and returns nil when it is healthy.
+// Probes may record extra details about the check in result.
+type probeFunc func(ctx context.Context, svc Service, result *CheckResult) error
+
+// probes maps a service type to the function that checks it
+var probes = map[string]probeFunc{
+	"":          probeHTTP,
+	"http":      probeHTTP,
+	"memcached": probeMemcached,
+	"etcd":      probeEtcd,
+	"heartbeat": probeHeartbeat,
+	"tcp":       probeTCP,
+	"dns":       probeDNS,
+	"grpc":      probeGRPC,
+	"icmp":      probeICMP,
+	"exec":      probeExec,
+	"postgres":  probePostgres,
+	"mysql":     probeMySQL,
+	"redis":     probeRedis,
+	"canary":    probeCanary,
+}
+
+// probeHTTP sends the service's request and expects a 2xx response, or one of
+// ExpectedStatus. With GeoResolvers set, every address the host resolves to is
+// checked instead. With VersionSkew set, every instance's version is read after.
+func probeHTTP(ctx context.Context, svc Service, result *CheckResult) error {
+	if len(svc.GeoResolvers) > 0 {
+		return probeGeoHTTP(ctx, svc, result)
+	}
+	transport, err := serviceTransport(svc)
+	if err != nil {
+		return err
+	}
+	if err := checkHTTP(ctx, probeClient(transport), svc, result); err != nil {
+		return err
+	}
+	if svc.VersionSkew != nil {
+		return readVersions(ctx, svc, result)
+	}
+	return nil
+}
+
+// serviceTransport returns the transport for a service's HTTP requests: its
+// client TLS transport, one pinned to its ConnectTo address, or nil for the default
+func serviceTransport(svc Service) (http.RoundTripper, error) {
+	if svc.ConnectTo != "" {
+		return connectToTransport(svc)
+	}
+	return checkTransport(svc)
+}
+
+// connectToTransport returns a transport sending a service's requests to its
+// ConnectTo address, keeping the URL's host for Host and SNI
+func connectToTransport(svc Service) (http.RoundTripper, error) {
+	u, err := url.Parse(svc.URL)
+	if err != nil {
+		return nil, err
+	}
+	addr, err := targetAddr(svc.ConnectTo, urlPort(u))
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := serviceTLSConfig(svc)
+	if err != nil {
+		return nil, err
+	}
+	return pinnedTransport(net.JoinHostPort(u.Hostname(), urlPort(u)), addr, tlsConfig), nil
+}
+
+// checkHTTP is probeHTTP with the client to send the request through
+func checkHTTP(ctx context.Context, client *http.Client, svc Service, result *CheckResult) error {
+	if expectsRedirect(svc.ExpectedStatus) {
+		noRedirects := *client
+		noRedirects.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+		client = &noRedirects
+	}
+	resp, err := sendHTTP(ctx, client, svc)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if skew, ok := clockSkew(resp, time.Now()); ok {
+		seconds := skew.Seconds()
+		result.ClockSkew = &seconds
+		if maxSkew := time.Duration(svc.MaxClockSkew); maxSkew > 0 && skew.Abs() > maxSkew {
+			result.Warnings = append(result.Warnings,
+				fmt.Sprintf("clock skew %s exceeds %s", skew.Round(time.Second), maxSkew))
+		}
+	}
+
+	if resp.TLS != nil && len(resp.TLS.PeerCertificates) > 0 {
+		result.TLS = newTLSInfo(resp.TLS.PeerCertificates[0])
+		if err := verifyCertPins(svc, resp.TLS.PeerCertificates[0], result.TLS); err != nil {
+			return err
+		}
+		if err := checkCertExpiry(svc, result.TLS, result); err != nil {
+			return err
+		}
+	}
+
+	if !statusExpected(svc.ExpectedStatus, resp.StatusCode) {
+		if throttled := detectThrottling(resp, time.Now()); throttled != nil && svc.Throttling != "" && svc.Throttling != "fail" {
+			return throttled
+		}
+		if vendor := detectBotChallenge(resp); vendor != "" {
+			challenge := &botChallenge{vendor: vendor, status: resp.StatusCode}
+			if svc.BotChallenge != "warn" {
+				return challenge
+			}
+			result.BotChallenge = vendor
+			result.Warnings = append(result.Warnings, challenge.Error())
+			return nil
+		}
+		return &httpStatusError{code: resp.StatusCode}
+	}
+	if err := checkBody(svc, resp.Body); err != nil {
+		return err
+	}
+
+	if svc.HeaderAudit {
+		result.Warnings = append(result.Warnings, auditSecurityHeaders(svc, resp)...)
+	}
+	return nil
+}
+
+// checkBody evaluates the service's body and JSON assertions against the first
+// bodyAssertMax bytes of the response
+func checkBody(svc Service, body io.Reader) error {
+	if svc.BodyContains == "" && svc.BodyRegex == "" && len(svc.JSONAssertions) == 0 {
+		return nil
+	}
+	data, err := io.ReadAll(io.LimitReader(body, bodyAssertMax))
+	if err != nil {
+		return fmt.Errorf("reading body: %w", err)
+	}
+	if svc.BodyContains != "" && !bytes.Contains(data, []byte(svc.BodyContains)) {
+		return &assertionError{code: "body", err: fmt.Errorf("body does not contain %q", svc.BodyContains)}
+	}
+	if svc.BodyRegex != "" {
+		re, err := regexp.Compile(svc.BodyRegex)
+		if err != nil {
+			return err
+		}
+		if !re.Match(data) {
+			return &assertionError{code: "body", err: fmt.Errorf("body does not match %q", svc.BodyRegex)}
+		}
+	}
+	if len(svc.JSONAssertions) > 0 {
+		if err := checkJSON(svc.JSONAssertions, data); err != nil {
+			return &assertionError{code: "json", err: err}
+		}
+	}
+	return nil
+}
+
+// sendHTTP sends the check request. For services with a Login it carries the
+// cached session, and a 401 on a reused session logs in again and retries once.
+func sendHTTP(ctx context.Context, client *http.Client, svc Service) (*http.Response, error) {
+	var sess *session
+	fresh := true
+	if svc.Login != nil {
+		var err error
+		if sess, fresh, err = sessions.get(ctx, svc, false); err != nil {
+			return nil, err
+		}
+	}
+
+	for {
+		req, err := newCheckRequest(ctx, svc)
+		if err != nil {
+			return nil, err
+		}
+		if sess != nil {
+			sess.apply(req)
+		}
+		req, finish := traceRequest(req)
+		resp, err := client.Do(req)
+		finish(resp, err)
+		if err != nil || resp.StatusCode != http.StatusUnauthorized || fresh {
+			return resp, err
+		}
+		resp.Body.Close()
+		if sess, fresh, err = sessions.get(ctx, svc, true); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// newCheckRequest builds the request an HTTP check sends from its method,
+// credentials, headers and body
+func newCheckRequest(ctx context.Context, svc Service) (*http.Request, error) {
+	method := svc.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	var body io.Reader
+	if svc.Body != "" {
+		body = strings.NewReader(svc.Body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, svc.URL, body)
+	if err != nil {
+		return nil, err
+	}
+	if svc.Body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if svc.Auth != nil {
+		svc.Auth.apply(req)
+	}
+	for name, value := range svc.Headers {
+		if strings.EqualFold(name, "Host") {
+			req.Host = value
+			continue
+		}
+		req.Header.Set(name, value)
+	}
+	return req, nil
+}
+
+// probeTCP only opens a connection, so the response time is the connect latency
+func probeTCP(ctx context.Context, svc Service, result *CheckResult) error {
+	conn, err := dialTarget(ctx, svc.URL, "")
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// resolverFor returns a resolver that queries the DNS server at host[:port]
+func resolverFor(server string) (*net.Resolver, error) {
+	addr, err := targetAddr(server, "53")
+	if err != nil {
+		return nil, err
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}, nil
+}
+
+// dnsRecordTypes are the record types DNS checks can resolve
+var dnsRecordTypes = []string{"A", "AAAA", "CNAME", "TXT"}
+
+// probeDNS resolves the hostname in the URL, failing on NXDOMAIN, an empty answer
+// or when an expected record is missing from the answer
+func probeDNS(ctx context.Context, svc Service, result *CheckResult) error {
+	resolver := net.DefaultResolver
+	if svc.Resolver != "" {
+		var err error
+		if resolver, err = resolverFor(svc.Resolver); err != nil {
+			return err
+		}
+	}
+
+	host := svc.URL
+	var records []string
+	var err error
+	switch strings.ToUpper(svc.RecordType) {
+	case "", "A", "AAAA":
+		network := "ip4"
+		if strings.EqualFold(svc.RecordType, "AAAA") {
+			network = "ip6"
+		}
+		var ips []net.IP
+		if ips, err = resolver.LookupIP(ctx, network, host); err == nil {
+			for _, ip := range ips {
+				records = append(records, ip.String())
+			}
+		}
+	case "CNAME":
+		var cname string
+		if cname, err = resolver.LookupCNAME(ctx, host); err == nil {
+			records = []string{strings.TrimSuffix(cname, ".")}
+		}
+	case "TXT":
+		records, err = resolver.LookupTXT(ctx, host)
+	default:
+		return fmt.Errorf("unsupported record type %q", svc.RecordType)
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return &dnsFailure{kind: DNSNXDomain, msg: "NXDOMAIN: " + host}
+	}
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return &dnsFailure{kind: DNSNoData, msg: fmt.Sprintf("no %s records for %s", strings.ToUpper(svc.RecordType), host)}
+	}
+
+	for _, want := range svc.ExpectedRecords {
+		if !containsFold(records, strings.TrimSuffix(want, ".")) {
+			return &assertionError{code: "records", err: fmt.Errorf("expected record %q not returned (got %s)", want, strings.Join(records, ", "))}
+		}
+	}
+	return nil
+}
+
+// containsFold reports whether list contains s, ignoring case
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// probeMemcached sends the "version" command and expects a VERSION reply
+func probeMemcached(ctx context.Context, svc Service, result *CheckResult) error {
+	conn, err := dialTarget(ctx, svc.URL, "11211")
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := io.WriteString(conn, "version\r\n"); err != nil {
+		return err
+	}
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("reading version reply: %w", err)
+	}
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "VERSION ") {
+		return fmt.Errorf("unexpected reply %q", line)
+	}
+	return nil
+}
+
+// probeEtcd checks the /health endpoint and verifies the member sees a leader
+func probeEtcd(ctx context.Context, svc Service, result *CheckResult) error {
+	base := strings.TrimRight(svc.URL, "/")
+	transport, err := serviceTransport(svc)
+	if err != nil {
+		return err
+	}
+	client := probeClient(transport)
+
+	var health struct {
+		Health string `json:"health"`
+		Reason string `json:"reason"`
+	}
+	if err := doJSON(ctx, client, "GET", base+"/health", nil, &health); err != nil {
+		return err
+	}
+	if health.Health != "true" {
+		if health.Reason != "" {
+			return fmt.Errorf("etcd unhealthy: %s", health.Reason)
+		}
+		return errors.New("etcd unhealthy")
+	}
+
+	// The v3 gateway reports the leader's member ID; "0" or empty means no leader
+	var status struct {
+		Leader string `json:"leader"`
+	}
+	if err := doJSON(ctx, client, "POST", base+"/v3/maintenance/status", strings.NewReader("{}"), &status); err != nil {
+		return err
+	}
+	if status.Leader == "" || status.Leader == "0" {
+		return errors.New("etcd has no leader")
+	}
+	return nil
+}
+
+// doJSON performs an HTTP request and decodes a 2xx JSON response into out
+func doJSON(ctx context.Context, client *http.Client, method, rawURL string, body io.Reader, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, body)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	req, finish := traceRequest(req)
+	resp, err := client.Do(req)
+	finish(resp, err)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("HTTP %d from %s", resp.StatusCode, req.URL.Path)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding %s: %w", req.URL.Path, err)
+	}
+	return nil
+}
+
+// dialTarget opens a TCP connection to a target given as host:port or scheme://host:port
+func dialTarget(ctx context.Context, target, defaultPort string) (net.Conn, error) {
+	addr, err := targetAddr(target, defaultPort)
+	if err != nil {
+		return nil, err
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else {
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+	}
+	return conn, nil
+}
+
+// targetAddr extracts host:port from a target, filling in defaultPort when none is given
+func targetAddr(target, defaultPort string) (string, error) {
+	host := target
+	if strings.Contains(target, "://") {
+		u, err := url.Parse(target)
+		if err != nil {
+			return "", err
+		}
+		host = u.Host
+	}
+	if host == "" {
+		return "", fmt.Errorf("no host in target %q", target)
+	}
+
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(strings.Trim(host, "[]"), defaultPort)
+	}
+	return host, nil
+}
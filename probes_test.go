@@ -0,0 +1,46 @@
+// probes_test.go
+package main
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProbeEtcdUsesServiceTransport(t *testing.T) {
+	etcd := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/health":
+			fmt.Fprint(w, `{"health":"true"}`)
+		case "/v3/maintenance/status":
+			fmt.Fprint(w, `{"leader":"8211f1d0f64f3269"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer etcd.Close()
+
+	// The test server's certificate is issued for example.com
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: etcd.Certificate().Raw})
+	if err := os.WriteFile(caFile, ca, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	addr, _ := url.Parse(etcd.URL)
+	svc := Service{Name: "etcd", Type: "etcd", URL: "https://example.com:" + addr.Port(),
+		ConnectTo: addr.Host, TLS: &ClientTLSConfig{CAFile: caFile}}
+
+	if err := probeEtcd(context.Background(), svc, &CheckResult{}); err != nil {
+		t.Errorf("probeEtcd through connect_to with a CA bundle: %v", err)
+	}
+	svc.TLS = nil
+	if err := probeEtcd(context.Background(), svc, &CheckResult{}); err == nil {
+		t.Error("probeEtcd trusted a server whose CA isn't configured")
+	}
+}
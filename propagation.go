@@ -0,0 +1,174 @@
+// propagation.go
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// defaultRequestIDHeader is the header of the request_id propagator when request_id_header is unset
+const defaultRequestIDHeader = "X-Request-Id"
+
+// PropagationConfig stamps every HTTP request a check sends with IDs the
+// target records, so a failed check can be looked up in the target's own
+// traces and logs by the trace_id and request_id on its status and history
+type PropagationConfig struct {
+	// Propagators to send, from traceparent, b3, request_id and baggage;
+	// default traceparent and request_id
+	Propagators []string `json:"propagators,omitempty"`
+
+	// Header of the request_id propagator, default X-Request-Id
+	RequestIDHeader string `json:"request_id_header,omitempty"`
+
+	// Who the requests are sent on behalf of, e.g. sre-health-checker@prod,
+	// passed in the baggage propagator so targets can tell checks from users
+	Principal string `json:"principal,omitempty"`
+}
+
+// Validate checks the propagation settings
+func (c PropagationConfig) Validate() []ValidationError {
+	var errs []ValidationError
+	add := func(name, format string, args ...interface{}) {
+		errs = append(errs, ValidationError{Field: "propagation." + name, Message: fmt.Sprintf(format, args...)})
+	}
+
+	for i, name := range c.Propagators {
+		if propagators[name] == nil {
+			add(fmt.Sprintf("propagators[%d]", i), "unknown propagator %q, expected one of traceparent, b3, request_id, baggage", name)
+		}
+	}
+	if c.RequestIDHeader != "" && !validHeaderName(c.RequestIDHeader) {
+		add("request_id_header", "must be a header name")
+	}
+	if c.Principal != "" && strings.TrimSpace(c.Principal) != c.Principal {
+		add("principal", "must not start or end with spaces")
+	}
+	return errs
+}
+
+// validHeaderName reports whether name is a non-empty HTTP token
+func validHeaderName(name string) bool {
+	for _, r := range name {
+		if r > '~' || r <= ' ' || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, r) {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// checkIDs are the IDs a check's requests carry. Every request and retry of
+// a check shares them, so one lookup finds all of them on the target.
+type checkIDs struct {
+	TraceID   string
+	RequestID string
+	Service   string
+}
+
+// propagator adds the IDs of a check to a request it sends; spanID is the
+// request's own span
+type propagator func(h http.Header, ids checkIDs, spanID string, c PropagationConfig)
+
+// propagators are the supported ways of passing IDs on to targets, by name
+var propagators = map[string]propagator{
+	// W3C Trace Context, marked sampled so the target records its side
+	"traceparent": func(h http.Header, ids checkIDs, spanID string, _ PropagationConfig) {
+		h.Set("traceparent", "00-"+ids.TraceID+"-"+spanID+"-01")
+	},
+	// Zipkin's single-header B3
+	"b3": func(h http.Header, ids checkIDs, spanID string, _ PropagationConfig) {
+		h.Set("b3", ids.TraceID+"-"+spanID+"-1")
+	},
+	"request_id": func(h http.Header, ids checkIDs, _ string, c PropagationConfig) {
+		header := c.RequestIDHeader
+		if header == "" {
+			header = defaultRequestIDHeader
+		}
+		h.Set(header, ids.RequestID)
+	},
+	// W3C Baggage with the service checked and the principal
+	"baggage": func(h http.Header, ids checkIDs, _ string, c PropagationConfig) {
+		entries := []string{"health_check.service=" + url.PathEscape(ids.Service)}
+		if c.Principal != "" {
+			entries = append(entries, "principal="+url.PathEscape(c.Principal))
+		}
+		h.Set("baggage", strings.Join(entries, ","))
+	},
+}
+
+// propagation is what main configures requests to carry. Without it, only
+// traced checks send a traceparent.
+var propagation *PropagationConfig
+
+type checkIDsKey struct{}
+
+// startPropagation gives a check the IDs its requests carry: the trace ID of
+// its span when it's traced, or a new one. It returns the context unchanged
+// when propagation is off.
+func startPropagation(ctx context.Context, svc Service, s *span) (context.Context, *checkIDs) {
+	if propagation == nil {
+		return ctx, nil
+	}
+	ids := &checkIDs{TraceID: s.traceID(), RequestID: newRequestID(), Service: svc.Name}
+	if ids.TraceID == "" {
+		ids.TraceID = randomID(16)
+	}
+	return context.WithValue(ctx, checkIDsKey{}, ids), ids
+}
+
+// randomID returns n random bytes, hex encoded
+func randomID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// newRequestID returns a random UUID (version 4), the usual form of X-Request-Id
+func newRequestID() string {
+	id := []byte(randomID(16))
+	id[12] = '4'
+	id[16] = "89ab"[id[16]%4]
+	return string(id[0:8]) + "-" + string(id[8:12]) + "-" + string(id[12:16]) + "-" + string(id[16:20]) + "-" + string(id[20:])
+}
+
+// propagate adds the check's IDs to a request with the configured
+// propagators. A traced check passes spanID, the request's span; otherwise
+// each request gets a span ID of its own. It reports whether it set the
+// headers, so the caller can fall back to a plain traceparent.
+func propagate(req *http.Request, spanID string) bool {
+	ids, _ := req.Context().Value(checkIDsKey{}).(*checkIDs)
+	if ids == nil {
+		return false
+	}
+	if spanID == "" {
+		spanID = randomID(8)
+	}
+	names := propagation.Propagators
+	if len(names) == 0 {
+		names = []string{"traceparent", "request_id"}
+	}
+	for _, name := range names {
+		propagators[name](req.Header, *ids, spanID, *propagation)
+	}
+	return true
+}
+
+// requestID returns the request ID a check's requests carry, or "" when propagation is off
+func (ids *checkIDs) requestID() string {
+	if ids == nil {
+		return ""
+	}
+	return ids.RequestID
+}
+
+// traceID returns the trace ID a check's requests carry, or "" when propagation is off
+func (ids *checkIDs) traceID() string {
+	if ids == nil {
+		return ""
+	}
+	return ids.TraceID
+}
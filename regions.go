@@ -0,0 +1,126 @@
+// regions.go
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// regionSlowFactor is how many times the median latency across locations a
+// location must reach to be flagged as slow
+const regionSlowFactor = 2
+
+// LocationLatency is the last result one agent reported for a service
+type LocationLatency struct {
+	Region       string    `json:"region"` // the agent's region label, or its name when unlabelled
+	Agent        string    `json:"agent"`
+	Healthy      bool      `json:"healthy"`
+	ResponseTime int64     `json:"response_time_ms"`
+	LastChecked  time.Time `json:"last_checked"`
+	Stale        bool      `json:"stale,omitempty"`
+	Slow         bool      `json:"slow,omitempty"` // at least regionSlowFactor times the median
+}
+
+// RegionComparison lines up the latency of one service as seen from every agent
+// checking it
+type RegionComparison struct {
+	Service   string            `json:"service"`
+	MedianMs  int64             `json:"median_ms"`
+	Locations []LocationLatency `json:"locations"`
+}
+
+// RegionComparisons groups the agent copies of each service by the service they
+// check, with locations ordered by region and agent. Pending copies are left out.
+func (hc *HealthChecker) RegionComparisons() []RegionComparison {
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+
+	byService := make(map[string][]LocationLatency)
+	for name, svc := range hc.services {
+		status := hc.statuses[name]
+		agent := svc.Labels["agent"]
+		if svc.Type != "agent" || agent == "" || status.Pending {
+			continue
+		}
+		region := svc.Labels["region"]
+		if region == "" {
+			region = agent
+		}
+		service := strings.TrimSuffix(name, "@"+agent)
+		byService[service] = append(byService[service], LocationLatency{
+			Region:       region,
+			Agent:        agent,
+			Healthy:      status.Healthy,
+			ResponseTime: status.ResponseTime,
+			LastChecked:  status.LastChecked,
+			Stale:        status.Stale,
+		})
+	}
+
+	comparisons := make([]RegionComparison, 0, len(byService))
+	for service, locations := range byService {
+		times := make([]int64, len(locations))
+		for i, l := range locations {
+			times[i] = l.ResponseTime
+		}
+		sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+		median := times[len(times)/2]
+		if len(times)%2 == 0 {
+			median = (times[len(times)/2-1] + median) / 2
+		}
+
+		for i := range locations {
+			locations[i].Slow = len(locations) > 1 && median > 0 && locations[i].ResponseTime >= regionSlowFactor*median
+		}
+		sort.Slice(locations, func(i, j int) bool {
+			if locations[i].Region != locations[j].Region {
+				return locations[i].Region < locations[j].Region
+			}
+			return locations[i].Agent < locations[j].Agent
+		})
+		comparisons = append(comparisons, RegionComparison{Service: service, MedianMs: median, Locations: locations})
+	}
+	sort.Slice(comparisons, func(i, j int) bool { return comparisons[i].Service < comparisons[j].Service })
+	return comparisons
+}
+
+// RegionsHandler returns the per-location latency of every service checked by agents
+func (hc *HealthChecker) RegionsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, hc.RegionComparisons())
+}
+
+// WriteRegionMetrics writes the latency and health of each service per probe
+// location, labelled with the service's own name so locations can be compared
+// in a single query
+func (hc *HealthChecker) WriteRegionMetrics(m *metricWriter) {
+	comparisons := hc.RegionComparisons()
+	if len(comparisons) == 0 {
+		return
+	}
+
+	m.family("service_region_response_time_ms", "gauge", "Response time seen from each probe location in milliseconds")
+	for _, c := range comparisons {
+		for _, l := range c.Locations {
+			if l.Stale {
+				continue
+			}
+			m.sample("service_region_response_time_ms", float64(l.ResponseTime), "service", c.Service, "region", l.Region, "agent", l.Agent)
+		}
+	}
+
+	m.family("service_region_up", "gauge", "Whether the service is up (1) or down (0) from each probe location")
+	for _, c := range comparisons {
+		for _, l := range c.Locations {
+			if l.Stale {
+				continue
+			}
+			up := 0
+			if l.Healthy {
+				up = 1
+			}
+			m.sample("service_region_up", float64(up), "service", c.Service, "region", l.Region, "agent", l.Agent)
+		}
+	}
+}
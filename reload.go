@@ -0,0 +1,158 @@
+// reload.go
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// configWatchInterval is how often the config file is looked at with -watch-config
+const configWatchInterval = 5 * time.Second
+
+// configReloader applies changes to the config file's services and silence
+// schedules without a restart; other settings take effect on the next start.
+// Services left as they were keep their status, history and monitor.
+type configReloader struct {
+	path    string
+	checker *HealthChecker
+	api     *ServiceAPI
+
+	mu        sync.Mutex
+	data      []byte             // the config file as last loaded
+	services  map[string]Service // the services it defined
+	reloads   map[string]int64   // by result: success or failure
+	succeeded bool               // the last reload
+}
+
+func newConfigReloader(path string, cfg *Config, checker *HealthChecker, api *ServiceAPI) *configReloader {
+	data, _ := os.ReadFile(path)
+	r := &configReloader{path: path, checker: checker, api: api, data: data,
+		services: make(map[string]Service), reloads: make(map[string]int64), succeeded: true}
+	for _, svc := range cfg.Services {
+		r.services[svc.Name] = svc
+	}
+	return r
+}
+
+// Run reloads the config on SIGHUP and, with watch set, whenever the file
+// changes. It never returns.
+func (r *configReloader) Run(watch bool) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	var tick <-chan time.Time
+	if watch {
+		ticker := time.NewTicker(configWatchInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-hup:
+			r.reload(true)
+		case <-tick:
+			r.reload(false)
+		}
+	}
+}
+
+// reload loads the config file and applies its services if it changed, or
+// regardless when forced. A config that fails to load or validate changes nothing.
+func (r *configReloader) reload(force bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	data, err := os.ReadFile(r.path)
+	if err == nil && !force && bytes.Equal(data, r.data) {
+		return
+	}
+	var cfg *Config
+	if err == nil {
+		cfg, err = loadConfig(r.path)
+	}
+	if err != nil {
+		r.reloads["failure"]++
+		r.succeeded = false
+		r.data = data // retried once the file changes again
+		slog.Error("config reload failed, keeping the running config", "error", err)
+		return
+	}
+	r.data = data
+	r.reloads["success"]++
+	r.succeeded = true
+
+	redactor.Add(cfg.secrets()...)
+	r.checker.silences.SetSchedules(cfg.SilenceSchedules)
+
+	// With a services file or durable storage the API owns the service list
+	if r.api.path != "" || r.api.store != nil {
+		slog.Info("config reloaded; services are managed through the API and left as they are", "path", r.path)
+		return
+	}
+	r.api.mu.Lock()
+	defer r.api.mu.Unlock()
+	added, updated, removed := r.apply(cfg.Services)
+	slog.Info("config reloaded", "path", r.path, "added", added, "updated", updated, "removed", removed)
+}
+
+// apply adds, updates and removes services so the checker matches the config.
+// Services added through the API, discovered or assigned are left alone, as is
+// a new config service whose name one of them already has.
+func (r *configReloader) apply(services []Service) (added, updated, removed int) {
+	configured := make(map[string]Service, len(services))
+	for _, svc := range services {
+		configured[svc.Name] = svc
+		old, known := r.services[svc.Name]
+		switch {
+		case !known:
+			if err := r.checker.AddService(svc); err != nil {
+				slog.Warn("config reload skipped service", "service", svc.Name, "error", err)
+				delete(configured, svc.Name)
+				continue
+			}
+			added++
+		case !reflect.DeepEqual(old, svc):
+			err := r.checker.UpdateService(svc)
+			if err == errServiceNotFound {
+				// Removed through the API since; the config brings it back
+				err = r.checker.AddService(svc)
+			}
+			if err != nil {
+				slog.Warn("config reload skipped service", "service", svc.Name, "error", err)
+				continue
+			}
+			updated++
+		}
+	}
+	for name := range r.services {
+		if _, kept := configured[name]; !kept {
+			if r.checker.RemoveService(name) == nil {
+				removed++
+			}
+		}
+	}
+	r.services = configured
+	return added, updated, removed
+}
+
+// WriteMetrics writes reload totals and whether the last reload succeeded
+func (r *configReloader) WriteMetrics(m *metricWriter) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m.family("config_reloads_total", "counter", "Config reloads by result")
+	for _, result := range []string{"success", "failure"} {
+		m.sample("config_reloads_total", float64(r.reloads[result]), "result", result)
+	}
+	succeeded := 0
+	if r.succeeded {
+		succeeded = 1
+	}
+	m.family("config_last_reload_successful", "gauge", "Whether the last config reload succeeded (1) or not (0)")
+	m.sample("config_last_reload_successful", float64(succeeded))
+}
@@ -0,0 +1,325 @@
+// rulegen.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Alert thresholds used when a service doesn't set its own
+const (
+	defaultAlertDownFor          = 2 * time.Minute
+	defaultAlertLatencyWarning   = 5 * time.Second
+	defaultAlertLatencyCritical  = 10 * time.Second
+	defaultAlertLatencyWarnFor   = 5 * time.Minute
+	defaultAlertLatencyCritFor   = 2 * time.Minute
+	generatedRuleGroupInterval   = "30s"
+	generatedRecordingRuleWindow = "1h"
+)
+
+// orDefault returns d, or def when d is unset
+func orDefault(d Duration, def time.Duration) time.Duration {
+	if d > 0 {
+		return time.Duration(d)
+	}
+	return def
+}
+
+// promDuration formats a duration the way Prometheus expects (e.g. 2m, 90s)
+func promDuration(d time.Duration) string {
+	switch {
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	default:
+		return fmt.Sprintf("%ds", d/time.Second)
+	}
+}
+
+// promLabelValue quotes a string for use as a PromQL label value
+func promLabelValue(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+// unlessSilenced mutes a per-service alert expression while the service is silenced
+func unlessSilenced(expr string) string {
+	return "(" + expr + ") unless on(service) service_silenced == 1"
+}
+
+// yamlString quotes a string as a YAML scalar. JSON strings are valid YAML.
+func yamlString(s string) string {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// generateRules renders Prometheus recording and alerting rules for the services,
+// using each service's alert thresholds. With metrics grouped by a label, the
+// rules query the group series instead, which are all there is to query.
+func generateRules(services []Service, metrics MetricsConfig) string {
+	if metrics.GroupBy != "" {
+		return generateGroupRules(services, metrics.GroupBy)
+	}
+	var b strings.Builder
+	b.WriteString("# Generated by sre-health-checker. Do not edit; regenerate from the service config.\n")
+	b.WriteString("groups:\n")
+
+	b.WriteString("  - name: service_health_recording\n")
+	b.WriteString("    interval: " + generatedRuleGroupInterval + "\n")
+	b.WriteString("    rules:\n")
+	writeRecordingRule(&b, "service:availability:ratio_"+generatedRecordingRuleWindow, "avg_over_time(service_up["+generatedRecordingRuleWindow+"])")
+	writeRecordingRule(&b, "service:response_time_ms:avg_5m", "avg_over_time(service_response_time_ms[5m])")
+	writeRecordingRule(&b, "service:response_time_ms:max_5m", "max_over_time(service_response_time_ms[5m])")
+
+	b.WriteString("\n  - name: service_health_generated\n")
+	b.WriteString("    interval: " + generatedRuleGroupInterval + "\n")
+	b.WriteString("    rules:\n")
+
+	for _, svc := range services {
+		if svc.Paused {
+			continue
+		}
+		selector := "{service=" + promLabelValue(svc.Name) + "}"
+
+		downFor := orDefault(svc.AlertDownFor, defaultAlertDownFor)
+		writeAlertRule(&b, alertRule{
+			Alert:       "ServiceDown",
+			Expr:        unlessSilenced("service_up" + selector + " == 0 unless on(service) service_flapping == 1"),
+			For:         downFor,
+			Severity:    "critical",
+			Summary:     fmt.Sprintf("Service %s is down", svc.Name),
+			Description: fmt.Sprintf("%s at %s has been down for more than %s.", svc.Name, svc.URL, promDuration(downFor)),
+		})
+
+		warn := orDefault(svc.AlertLatencyWarning, defaultAlertLatencyWarning)
+		writeAlertRule(&b, alertRule{
+			Alert:       "HighResponseTime",
+			Expr:        unlessSilenced(fmt.Sprintf("service_response_time_ms%s > %d", selector, warn.Milliseconds())),
+			For:         defaultAlertLatencyWarnFor,
+			Severity:    "warning",
+			Summary:     fmt.Sprintf("High response time for %s", svc.Name),
+			Description: fmt.Sprintf("%s response time is {{ $value }}ms (threshold: %dms)", svc.Name, warn.Milliseconds()),
+		})
+
+		crit := orDefault(svc.AlertLatencyCritical, defaultAlertLatencyCritical)
+		writeAlertRule(&b, alertRule{
+			Alert:       "CriticalResponseTime",
+			Expr:        unlessSilenced(fmt.Sprintf("service_response_time_ms%s > %d", selector, crit.Milliseconds())),
+			For:         defaultAlertLatencyCritFor,
+			Severity:    "critical",
+			Summary:     fmt.Sprintf("Critical response time for %s", svc.Name),
+			Description: fmt.Sprintf("%s response time is {{ $value }}ms (critical threshold: %dms)", svc.Name, crit.Milliseconds()),
+		})
+
+		days := svc.CertWarningDays
+		if days == 0 {
+			days = defaultCertWarningDays
+		}
+		writeAlertRule(&b, alertRule{
+			Alert:       "CertificateExpiring",
+			Expr:        unlessSilenced(fmt.Sprintf("service_tls_cert_days_remaining%s < %d", selector, days)),
+			Severity:    "warning",
+			Summary:     fmt.Sprintf("Certificate for %s expires soon", svc.Name),
+			Description: fmt.Sprintf("%s certificate expires in {{ $value }} days (threshold: %d days)", svc.Name, days),
+		})
+
+		if svc.MaxClockSkew > 0 {
+			writeAlertRule(&b, alertRule{
+				Alert:       "ClockSkew",
+				Expr:        unlessSilenced(fmt.Sprintf("abs(service_clock_skew_seconds%s) > %g", selector, time.Duration(svc.MaxClockSkew).Seconds())),
+				For:         10 * time.Minute,
+				Severity:    "warning",
+				Summary:     fmt.Sprintf("Clock skew detected on %s", svc.Name),
+				Description: fmt.Sprintf("%s clock differs by {{ $value }}s (threshold: %s)", svc.Name, time.Duration(svc.MaxClockSkew)),
+			})
+		}
+	}
+
+	// Rules that don't depend on per-service thresholds
+	writeAlertRule(&b, alertRule{
+		Alert:       "ServiceWarnings",
+		Expr:        unlessSilenced("service_warnings > 0"),
+		For:         10 * time.Minute,
+		Severity:    "warning",
+		Summary:     "{{ $labels.service }} is reporting warnings",
+		Description: "{{ $labels.service }} reported {{ $value }} warning(s) on its last check. See /status for details.",
+	})
+	writeAlertRule(&b, alertRule{
+		Alert:       "ServiceFlapping",
+		Expr:        unlessSilenced("service_flapping == 1"),
+		Severity:    "warning",
+		Summary:     "{{ $labels.service }} is flapping",
+		Description: "{{ $labels.service }} keeps changing between healthy and unhealthy. ServiceDown is held back until it settles; see /status for the state changes.",
+	})
+	writeAlertRule(&b, alertRule{
+		Alert:       "SchedulerStall",
+		Expr:        unlessSilenced("service_stale == 1"),
+		Severity:    "critical",
+		Summary:     "Checks for {{ $labels.service }} have stopped",
+		Description: "No check of {{ $labels.service }} has completed within twice its interval; its last status is stale.",
+	})
+	writeAlertRule(&b, alertRule{
+		Alert:       "CertificateChanged",
+		Expr:        unlessSilenced("time() - service_tls_cert_changed_timestamp_seconds < 3600"),
+		Severity:    "warning",
+		Summary:     "Certificate changed for {{ $labels.service }}",
+		Description: "{{ $labels.service }} is now serving a certificate issued by {{ $labels.issuer }}. Verify the change was expected.",
+	})
+	writeCheckerRules(&b)
+	return b.String()
+}
+
+// generateGroupRules renders rules over the service_group_* series written when
+// metrics are grouped by the groupBy label. Each group alerts on the strictest
+// thresholds among its services. Silences and flapping aren't in the group
+// series, so these rules can't mute for them.
+func generateGroupRules(services []Service, groupBy string) string {
+	type thresholds struct{ downFor, warn, crit time.Duration }
+	groups := make(map[string]thresholds)
+	for _, svc := range services {
+		if svc.Paused {
+			continue
+		}
+		t := thresholds{
+			downFor: orDefault(svc.AlertDownFor, defaultAlertDownFor),
+			warn:    orDefault(svc.AlertLatencyWarning, defaultAlertLatencyWarning),
+			crit:    orDefault(svc.AlertLatencyCritical, defaultAlertLatencyCritical),
+		}
+		group := svc.Labels[groupBy]
+		if g, ok := groups[group]; ok {
+			t = thresholds{min(g.downFor, t.downFor), min(g.warn, t.warn), min(g.crit, t.crit)}
+		}
+		groups[group] = t
+	}
+
+	var b strings.Builder
+	b.WriteString("# Generated by sre-health-checker. Do not edit; regenerate from the service config.\n")
+	b.WriteString("# Service metrics are grouped by " + groupBy + ", so these rules alert per group.\n")
+	b.WriteString("groups:\n")
+
+	b.WriteString("  - name: service_health_recording\n")
+	b.WriteString("    interval: " + generatedRuleGroupInterval + "\n")
+	b.WriteString("    rules:\n")
+	writeRecordingRule(&b, "service_group:weighted_health:avg_"+generatedRecordingRuleWindow, "avg_over_time(service_group_weighted_health["+generatedRecordingRuleWindow+"])")
+	writeRecordingRule(&b, "service_group:check_duration_seconds:p95_5m", "histogram_quantile(0.95, sum by(group, le) (rate(service_group_check_duration_seconds_bucket[5m])))")
+
+	b.WriteString("\n  - name: service_health_generated\n")
+	b.WriteString("    interval: " + generatedRuleGroupInterval + "\n")
+	b.WriteString("    rules:\n")
+
+	for _, group := range slices.Sorted(maps.Keys(groups)) {
+		t := groups[group]
+		name := groupBy + "=" + group
+		if group == "" {
+			name = "without " + groupBy
+		}
+		label := "group=" + promLabelValue(group)
+
+		writeAlertRule(&b, alertRule{
+			Alert:       "ServiceGroupDown",
+			Expr:        `service_group_services{` + label + `, state="down"} > 0`,
+			For:         t.downFor,
+			Severity:    "critical",
+			Summary:     fmt.Sprintf("Services %s are down", name),
+			Description: fmt.Sprintf("{{ $value }} service(s) %s have been down for more than %s. See /status?group_by=%s for which.", name, promDuration(t.downFor), groupBy),
+		})
+
+		p95 := `histogram_quantile(0.95, sum by(group, le) (rate(service_group_check_duration_seconds_bucket{` + label + `}[5m])))`
+		writeAlertRule(&b, alertRule{
+			Alert:       "HighCheckDuration",
+			Expr:        fmt.Sprintf("%s > %g", p95, t.warn.Seconds()),
+			For:         defaultAlertLatencyWarnFor,
+			Severity:    "warning",
+			Summary:     fmt.Sprintf("Slow checks of services %s", name),
+			Description: fmt.Sprintf("95th percentile check duration %s is {{ $value }}s (threshold: %gs)", name, t.warn.Seconds()),
+		})
+		writeAlertRule(&b, alertRule{
+			Alert:       "CriticalCheckDuration",
+			Expr:        fmt.Sprintf("%s > %g", p95, t.crit.Seconds()),
+			For:         defaultAlertLatencyCritFor,
+			Severity:    "critical",
+			Summary:     fmt.Sprintf("Critically slow checks of services %s", name),
+			Description: fmt.Sprintf("95th percentile check duration %s is {{ $value }}s (critical threshold: %gs)", name, t.crit.Seconds()),
+		})
+	}
+
+	writeAlertRule(&b, alertRule{
+		Alert:       "SchedulerStall",
+		Expr:        `service_group_services{state="stale"} > 0`,
+		Severity:    "critical",
+		Summary:     "Checks have stopped for services in group {{ $labels.group }}",
+		Description: "No check of {{ $value }} service(s) in group {{ $labels.group }} has completed within twice its interval; their last status is stale.",
+	})
+	writeCheckerRules(&b)
+	return b.String()
+}
+
+// writeCheckerRules writes the alerting rules on the checker's own metrics,
+// which don't depend on how service metrics are written
+func writeCheckerRules(b *strings.Builder) {
+	writeAlertRule(b, alertRule{
+		Alert:       "NotifierDown",
+		Expr:        "notifier_up == 0",
+		Severity:    "warning",
+		Summary:     "Notifier {{ $labels.notifier }} is failing its self-check",
+		Description: "Alerts sent through {{ $labels.notifier }} may not be delivered. See /api/v1/notifiers for the error.",
+	})
+	writeAlertRule(b, alertRule{
+		Alert:       "NotificationsDeadLettered",
+		Expr:        "increase(notifier_dead_letters_total[15m]) > 0",
+		Severity:    "critical",
+		Summary:     "Notifications to {{ $labels.notifier }} are being dropped",
+		Description: "{{ $value }} notification(s) were dead-lettered. Inspect and retry them via /api/v1/outbox.",
+	})
+	writeAlertRule(b, alertRule{
+		Alert:       "AgentSilent",
+		Expr:        "time() - aggregator_agent_last_seen_timestamp_seconds > 300",
+		Severity:    "warning",
+		Summary:     "Agent {{ $labels.agent }} stopped uploading",
+		Description: "No results from agent {{ $labels.agent }} for over 5 minutes. Its services will be stale until it reconnects and replays its buffer.",
+	})
+}
+
+// alertRule is a single Prometheus alerting rule
+type alertRule struct {
+	Alert       string
+	Expr        string
+	For         time.Duration
+	Severity    string
+	Summary     string
+	Description string
+}
+
+func writeRecordingRule(b *strings.Builder, record, expr string) {
+	fmt.Fprintf(b, "      - record: %s\n", record)
+	fmt.Fprintf(b, "        expr: %s\n", yamlString(expr))
+}
+
+func writeAlertRule(b *strings.Builder, r alertRule) {
+	fmt.Fprintf(b, "      - alert: %s\n", r.Alert)
+	fmt.Fprintf(b, "        expr: %s\n", yamlString(r.Expr))
+	if r.For > 0 {
+		fmt.Fprintf(b, "        for: %s\n", promDuration(r.For))
+	}
+	b.WriteString("        labels:\n")
+	fmt.Fprintf(b, "          severity: %s\n", r.Severity)
+	b.WriteString("          component: application\n")
+	b.WriteString("        annotations:\n")
+	fmt.Fprintf(b, "          summary: %s\n", yamlString(r.Summary))
+	fmt.Fprintf(b, "          description: %s\n", yamlString(r.Description))
+}
+
+// RulesHandler serves Prometheus rules generated from the current services
+func (hc *HealthChecker) RulesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write([]byte(generateRules(hc.Services(), MetricsConfig{GroupBy: hc.metricsGroupBy})))
+}
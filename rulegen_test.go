@@ -0,0 +1,68 @@
+// rulegen_test.go
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+var groupedServices = []Service{
+	{Name: "checkout", URL: "https://shop.example.com/health", Labels: map[string]string{"team": "payments"}, AlertDownFor: Duration(5 * time.Minute)},
+	{Name: "billing", URL: "https://billing.example.com/health", Labels: map[string]string{"team": "payments"},
+		AlertDownFor: Duration(time.Minute), AlertLatencyWarning: Duration(2 * time.Second)},
+	{Name: "search", URL: "https://search.example.com/health", Labels: map[string]string{"team": "discovery"}},
+	{Name: "legacy", URL: "https://legacy.example.com/health"},
+}
+
+func TestGenerateRules(t *testing.T) {
+	rules := generateRules(groupedServices, MetricsConfig{})
+	for _, want := range []string{
+		`expr: "(service_up{service=\"checkout\"} == 0 unless on(service) service_flapping == 1) unless on(service) service_silenced == 1"`,
+		"for: 5m",
+		"alert: NotifierDown",
+	} {
+		if !strings.Contains(rules, want) {
+			t.Errorf("per-service rules are missing %q", want)
+		}
+	}
+}
+
+func TestGenerateGroupRules(t *testing.T) {
+	rules := generateRules(groupedServices, MetricsConfig{GroupBy: "team"})
+	if strings.Contains(rules, "service_up") || strings.Contains(rules, "service_response_time_ms") {
+		t.Error("group rules query per-service series, which aren't written with group_by")
+	}
+	for _, want := range []string{
+		// The strictest thresholds among the group's services
+		`expr: "service_group_services{group=\"payments\", state=\"down\"} > 0"
+        for: 1m`,
+		`summary: "Services team=payments are down"`,
+		`[5m]))) > 2"`,
+		`expr: "service_group_services{group=\"discovery\", state=\"down\"} > 0"
+        for: 2m`,
+		`expr: "service_group_services{group=\"\", state=\"down\"} > 0"`,
+		`summary: "Services without team are down"`,
+		"alert: SchedulerStall",
+		"alert: AgentSilent",
+	} {
+		if !strings.Contains(rules, want) {
+			t.Errorf("group rules are missing %q", want)
+		}
+	}
+}
+
+func TestGenerateGroupGrafanaDashboard(t *testing.T) {
+	data, err := json.Marshal(generateGrafanaDashboard(groupedServices, MetricsConfig{GroupBy: "team"}, "Health"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dashboard := string(data)
+	if strings.Contains(dashboard, "service_up") || strings.Contains(dashboard, "service_response_time_ms") {
+		t.Error("group dashboard queries per-service series")
+	}
+	if !strings.Contains(dashboard, `"query":"discovery,payments"`) || !strings.Contains(dashboard, "service_group_weighted_health") {
+		t.Errorf("group dashboard = %s, want a group variable and group panels", dashboard)
+	}
+}
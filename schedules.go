@@ -0,0 +1,326 @@
+// schedules.go
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SilenceSchedule mutes alerts during a recurring window, such as a nightly
+// backup or a weekly batch run. Times are in Timezone, or local when it's unset.
+// In the config's silence_schedules it applies to the services its selector
+// matches; in a service's own silence_schedules, to that service.
+type SilenceSchedule struct {
+	Selector string   `json:"selector,omitempty"` // config-level schedules only, e.g. team=billing
+	Schedule string   `json:"schedule"`           // daily, weekly or cron
+	At       string   `json:"at,omitempty"`       // daily and weekly: start time, e.g. 02:00
+	Weekday  string   `json:"weekday,omitempty"`  // weekly schedules only, e.g. sunday
+	Cron     string   `json:"cron,omitempty"`     // cron schedules only: when windows start, e.g. 0 2 * * sat,sun
+	Timezone string   `json:"timezone,omitempty"` // IANA name, e.g. Europe/Berlin
+	Duration Duration `json:"duration"`
+	Comment  string   `json:"comment,omitempty"`
+
+	// Maintenance windows also keep failed checks from counting against uptime
+	Maintenance bool `json:"maintenance,omitempty"`
+}
+
+// maxUpcomingWithin caps ?within= for the upcoming silences listing
+const maxUpcomingWithin = 31 * 24 * time.Hour
+
+// parseWeekday parses a weekday name such as sunday, ignoring case
+func parseWeekday(s string) (time.Weekday, error) {
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if strings.EqualFold(s, day.String()) {
+			return day, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown weekday %q", s)
+}
+
+// Validate checks a silence schedule; prefix is prepended to field names.
+// withSelector says whether the schedule must have a selector or must not.
+func (s SilenceSchedule) Validate(prefix string, withSelector bool) []ValidationError {
+	var errs []ValidationError
+	add := func(name, format string, args ...interface{}) {
+		errs = append(errs, ValidationError{Field: prefix + name, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if withSelector {
+		if _, err := parseSelector(s.Selector); err != nil {
+			add("selector", "%v", err)
+		}
+	} else if s.Selector != "" {
+		add("selector", "only used in the top-level silence_schedules")
+	}
+	maxDuration := 24 * time.Hour
+	switch s.Schedule {
+	case "daily", "weekly":
+		if s.Schedule == "weekly" {
+			maxDuration = 7 * 24 * time.Hour
+			if _, err := parseWeekday(s.Weekday); err != nil {
+				add("weekday", "%v", err)
+			}
+		} else if s.Weekday != "" {
+			add("weekday", "only used with weekly schedules")
+		}
+		if _, err := time.Parse("15:04", s.At); err != nil {
+			add("at", "must be a time like 02:00")
+		}
+		if s.Cron != "" {
+			add("cron", "only used with cron schedules")
+		}
+	case "cron":
+		maxDuration = 7 * 24 * time.Hour
+		if spec, err := parseCron(s.Cron); err != nil {
+			add("cron", "%v", err)
+		} else if spec.next(time.Now()).IsZero() {
+			add("cron", "never matches")
+		}
+		if s.At != "" || s.Weekday != "" {
+			add("at", "at and weekday aren't used with cron schedules")
+		}
+	default:
+		add("schedule", "must be daily, weekly or cron")
+	}
+	if _, err := time.LoadLocation(s.Timezone); err != nil {
+		add("timezone", "unknown time zone %q", s.Timezone)
+	}
+	if s.Duration <= 0 || time.Duration(s.Duration) > maxDuration {
+		add("duration", "must be positive and at most %s for %s schedules", maxDuration, s.Schedule)
+	}
+	return errs
+}
+
+// location returns the schedule's time zone
+func (s SilenceSchedule) location() *time.Location {
+	if s.Timezone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// window returns the first occurrence of the schedule that hasn't ended at now,
+// which may already have started
+func (s SilenceSchedule) window(now time.Time) (start, end time.Time) {
+	now = now.In(s.location())
+	if s.Schedule == "cron" {
+		return s.cronWindow(now)
+	}
+	at, _ := time.Parse("15:04", s.At)
+	weekday, _ := parseWeekday(s.Weekday)
+
+	// Start a week back so a window that began before today is found
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	for day := -7; ; day++ {
+		date := midnight.AddDate(0, 0, day)
+		if s.Schedule == "weekly" && date.Weekday() != weekday {
+			continue
+		}
+		// From the wall clock rather than an offset from midnight, which moves
+		// the window by an hour on the days clocks change
+		start = time.Date(date.Year(), date.Month(), date.Day(), at.Hour(), at.Minute(), 0, 0, date.Location())
+		end = start.Add(time.Duration(s.Duration))
+		if end.After(now) {
+			return start, end
+		}
+	}
+}
+
+// cronWindow is window for cron schedules. Windows start when the expression
+// matches; one that never matches again gives a window beyond any listing.
+func (s SilenceSchedule) cronWindow(now time.Time) (start, end time.Time) {
+	spec, _ := parseCron(s.Cron)
+	duration := time.Duration(s.Duration)
+	// The first start after now-duration is the earliest window still open at now
+	if start = spec.next(now.Add(-duration)); start.IsZero() {
+		start = now.Add(cronSearchLimit)
+	}
+	return start, start.Add(duration)
+}
+
+// windows returns the occurrences of the schedule that overlap [from, to)
+func (s SilenceSchedule) windows(from, to time.Time) [][2]time.Time {
+	var windows [][2]time.Time
+	for t := from; ; {
+		start, end := s.window(t)
+		if !start.Before(to) {
+			return windows
+		}
+		windows = append(windows, [2]time.Time{start, end})
+		t = end
+	}
+}
+
+// scheduledSilence is a config-level schedule with its selector parsed
+type scheduledSilence struct {
+	SilenceSchedule
+	selector Selector
+}
+
+// SetSchedules replaces the config-level silence schedules, skipping ones whose
+// selector doesn't parse
+func (st *SilenceStore) SetSchedules(schedules []SilenceSchedule) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.schedules = nil
+	for _, s := range schedules {
+		sel, err := parseSelector(s.Selector)
+		if err != nil {
+			continue
+		}
+		st.schedules = append(st.schedules, scheduledSilence{SilenceSchedule: s, selector: sel})
+	}
+}
+
+// UpcomingSilence is a silence that is active or starts within the listing's window
+type UpcomingSilence struct {
+	Selector    string    `json:"selector"`
+	Services    []string  `json:"services"`
+	StartsAt    time.Time `json:"starts_at"`
+	EndsAt      time.Time `json:"ends_at"`
+	Comment     string    `json:"comment,omitempty"`
+	Schedule    string    `json:"schedule,omitempty"` // daily, weekly or cron; empty for one-off silences
+	Maintenance bool      `json:"maintenance,omitempty"`
+	ID          string    `json:"id,omitempty"` // one-off silences only
+	CreatedBy   string    `json:"created_by,omitempty"`
+}
+
+// scheduleSilenced returns when the latest scheduled window covering a service
+// at t ends, or the zero time when none does. Must be called with hc.mu held.
+func (hc *HealthChecker) scheduleSilenced(name string, status *HealthStatus, t time.Time) time.Time {
+	var until time.Time
+	for _, s := range hc.schedulesFor(name, status) {
+		if start, end := s.window(t); !start.After(t) && end.After(until) {
+			until = end
+		}
+	}
+	return until
+}
+
+// nextScheduledSilence returns the next scheduled window for a service that
+// starts after t, if one starts within a week. Must be called with hc.mu held.
+func (hc *HealthChecker) nextScheduledSilence(name string, status *HealthStatus, t time.Time) *UpcomingSilence {
+	var next *UpcomingSilence
+	for _, s := range hc.schedulesFor(name, status) {
+		start, end := s.window(t)
+		if !start.After(t) {
+			start, end = s.window(end)
+		}
+		if start.Sub(t) <= 7*24*time.Hour && (next == nil || start.Before(next.StartsAt)) {
+			next = &UpcomingSilence{Selector: s.Selector, Services: []string{name},
+				StartsAt: start, EndsAt: end, Comment: s.Comment, Schedule: s.Schedule, Maintenance: s.Maintenance}
+		}
+	}
+	return next
+}
+
+// inMaintenance reports whether a maintenance window covers a service at t.
+// Must be called with hc.mu held.
+func (hc *HealthChecker) inMaintenance(name string, status *HealthStatus, t time.Time) bool {
+	for _, s := range hc.schedulesFor(name, status) {
+		if start, _ := s.window(t); s.Maintenance && !start.After(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// schedulesFor returns the schedules that apply to a service, its own with a
+// service= selector filled in. Must be called with hc.mu held.
+func (hc *HealthChecker) schedulesFor(name string, status *HealthStatus) []SilenceSchedule {
+	var schedules []SilenceSchedule
+	for _, s := range hc.services[name].SilenceSchedules {
+		s.Selector = "service=" + name
+		schedules = append(schedules, s)
+	}
+	hc.silences.mu.RLock()
+	defer hc.silences.mu.RUnlock()
+	for _, s := range hc.silences.schedules {
+		if s.selector.Matches(name, status.Labels, status.Tags) {
+			schedules = append(schedules, s.SilenceSchedule)
+		}
+	}
+	return schedules
+}
+
+// UpcomingSilences returns the one-off silences that haven't ended and the
+// scheduled windows that are active or start within the given time, soonest first
+func (hc *HealthChecker) UpcomingSilences(within time.Duration) []UpcomingSilence {
+	now := time.Now()
+	to := now.Add(within)
+	upcoming := []UpcomingSilence{}
+	for _, s := range hc.silences.Active() {
+		if s.StartsAt.Before(to) {
+			upcoming = append(upcoming, UpcomingSilence{Selector: s.Selector, Services: hc.matching(s.selector),
+				StartsAt: s.StartsAt, EndsAt: s.EndsAt, Comment: s.Comment, ID: s.ID, CreatedBy: s.CreatedBy})
+		}
+	}
+
+	hc.mu.RLock()
+	var schedules []SilenceSchedule
+	names := make([]string, 0, len(hc.services))
+	for name := range hc.services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, s := range hc.services[name].SilenceSchedules {
+			s.Selector = "service=" + name
+			schedules = append(schedules, s)
+		}
+	}
+	hc.mu.RUnlock()
+	hc.silences.mu.RLock()
+	for _, s := range hc.silences.schedules {
+		schedules = append(schedules, s.SilenceSchedule)
+	}
+	hc.silences.mu.RUnlock()
+
+	for _, s := range schedules {
+		sel, _ := parseSelector(s.Selector)
+		services := hc.matching(sel)
+		for _, w := range s.windows(now, to) {
+			upcoming = append(upcoming, UpcomingSilence{Selector: s.Selector, Services: services,
+				StartsAt: w[0], EndsAt: w[1], Comment: s.Comment, Schedule: s.Schedule, Maintenance: s.Maintenance})
+		}
+	}
+	sort.SliceStable(upcoming, func(i, j int) bool { return upcoming[i].StartsAt.Before(upcoming[j].StartsAt) })
+	return upcoming
+}
+
+// matching returns the sorted names of the services sel matches, never nil
+func (hc *HealthChecker) matching(sel Selector) []string {
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+	names := []string{}
+	for name, svc := range hc.services {
+		if sel.Matches(name, svc.Labels, svc.Tags) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// UpcomingSilencesHandler lists active and upcoming silences, one-off and
+// scheduled, within ?within= such as 24h or 14d (default 7d)
+func (hc *HealthChecker) UpcomingSilencesHandler(w http.ResponseWriter, r *http.Request) {
+	within := 7 * 24 * time.Hour
+	if v := r.URL.Query().Get("within"); v != "" {
+		d, err := parseWindow(v)
+		if err != nil || d > maxUpcomingWithin {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "within must be a window such as 24h or 7d, up to 31d"})
+			return
+		}
+		within = d
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"silences": hc.UpcomingSilences(within)})
+}
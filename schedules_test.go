@@ -0,0 +1,62 @@
+// schedules_test.go
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleWindow(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no time zone database:", err)
+	}
+	daily := SilenceSchedule{Schedule: "daily", At: "06:00", Timezone: "Europe/Berlin", Duration: Duration(time.Hour)}
+	weekly := SilenceSchedule{Schedule: "weekly", Weekday: "sunday", At: "23:30", Timezone: "Europe/Berlin", Duration: Duration(2 * time.Hour)}
+	tests := []struct {
+		name     string
+		schedule SilenceSchedule
+		now      time.Time
+		start    time.Time
+	}{
+		{"later today", daily, time.Date(2026, 10, 14, 3, 0, 0, 0, berlin), time.Date(2026, 10, 14, 6, 0, 0, 0, berlin)},
+		{"already started", daily, time.Date(2026, 10, 14, 6, 30, 0, 0, berlin), time.Date(2026, 10, 14, 6, 0, 0, 0, berlin)},
+		{"ended today", daily, time.Date(2026, 10, 14, 7, 0, 0, 0, berlin), time.Date(2026, 10, 15, 6, 0, 0, 0, berlin)},
+		// Clocks go back an hour on 2026-10-25 and forward on 2026-03-29
+		{"clocks go back", daily, time.Date(2026, 10, 25, 1, 0, 0, 0, berlin), time.Date(2026, 10, 25, 6, 0, 0, 0, berlin)},
+		{"clocks go forward", daily, time.Date(2026, 3, 29, 1, 0, 0, 0, berlin), time.Date(2026, 3, 29, 6, 0, 0, 0, berlin)},
+		{"weekly", weekly, time.Date(2026, 10, 14, 12, 0, 0, 0, berlin), time.Date(2026, 10, 18, 23, 30, 0, 0, berlin)},
+		{"weekly, running past midnight", weekly, time.Date(2026, 10, 19, 1, 0, 0, 0, berlin), time.Date(2026, 10, 18, 23, 30, 0, 0, berlin)},
+		{"weekly, on the day clocks go back", weekly, time.Date(2026, 10, 20, 0, 0, 0, 0, berlin), time.Date(2026, 10, 25, 23, 30, 0, 0, berlin)},
+		{"in the schedule's time zone", daily, time.Date(2026, 10, 14, 5, 0, 0, 0, time.UTC), time.Date(2026, 10, 15, 6, 0, 0, 0, berlin)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end := tt.schedule.window(tt.now)
+			if !start.Equal(tt.start) {
+				t.Errorf("window(%v) starts at %v, want %v", tt.now, start, tt.start)
+			}
+			if want := tt.start.Add(time.Duration(tt.schedule.Duration)); !end.Equal(want) {
+				t.Errorf("window(%v) ends at %v, want %v", tt.now, end, want)
+			}
+		})
+	}
+}
+
+func TestScheduleWindows(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no time zone database:", err)
+	}
+	daily := SilenceSchedule{Schedule: "daily", At: "06:00", Timezone: "Europe/Berlin", Duration: Duration(time.Hour)}
+	from := time.Date(2026, 10, 24, 6, 30, 0, 0, berlin)
+	windows := daily.windows(from, from.AddDate(0, 0, 3))
+	if len(windows) != 4 {
+		t.Fatalf("got %d windows, want 4: %v", len(windows), windows)
+	}
+	for i, w := range windows {
+		if want := time.Date(2026, 10, 24+i, 6, 0, 0, 0, berlin); !w[0].Equal(want) {
+			t.Errorf("window %d starts at %v, want %v", i, w[0], want)
+		}
+	}
+}
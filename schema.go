@@ -0,0 +1,175 @@
+// schema.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// jsonSchema is the part of JSON Schema that config.schema.json uses: types,
+// the properties of objects, their items and references to $defs
+type jsonSchema struct {
+	Ref        string                 `json:"$ref,omitempty"`
+	Type       schemaTypes            `json:"type,omitempty"`
+	Properties map[string]*jsonSchema `json:"properties,omitempty"`
+	Additional *additionalProperties  `json:"additionalProperties,omitempty"`
+	Items      *jsonSchema            `json:"items,omitempty"`
+	Defs       map[string]*jsonSchema `json:"$defs,omitempty"`
+}
+
+// schemaTypes is a type keyword, a single type or a list of them
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*t = schemaTypes{one}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(t))
+}
+
+func (t schemaTypes) MarshalJSON() ([]byte, error) {
+	if len(t) == 1 {
+		return json.Marshal(t[0])
+	}
+	return json.Marshal([]string(t))
+}
+
+// additionalProperties is false for objects without other fields, or the
+// schema of their values for maps
+type additionalProperties struct {
+	schema *jsonSchema
+}
+
+func (a *additionalProperties) UnmarshalJSON(data []byte) error {
+	if string(data) == "false" {
+		return nil
+	}
+	return json.Unmarshal(data, &a.schema)
+}
+
+func (a additionalProperties) MarshalJSON() ([]byte, error) {
+	if a.schema == nil {
+		return []byte("false"), nil
+	}
+	return json.Marshal(a.schema)
+}
+
+// configSchemaRoot is config.schema.json, parsed
+var configSchemaRoot = func() *jsonSchema {
+	var s jsonSchema
+	if err := json.Unmarshal(configSchema, &s); err != nil {
+		panic("config.schema.json: " + err.Error())
+	}
+	return &s
+}()
+
+// unknownFieldMessage is the message of errors for fields the schema doesn't have
+const unknownFieldMessage = "unknown field"
+
+// validateSchema checks a JSON config document against config.schema.json,
+// reporting unknown fields and values of the wrong type by their path
+func validateSchema(data []byte) []ValidationError {
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return []ValidationError{{Message: err.Error()}}
+	}
+	var errs []ValidationError
+	configSchemaRoot.check(configSchemaRoot, doc, "", &errs)
+	return errs
+}
+
+// check appends an error for every way v doesn't match s; root holds the $defs
+func (s *jsonSchema) check(root *jsonSchema, v interface{}, path string, errs *[]ValidationError) {
+	if s.Ref != "" {
+		def := root.Defs[strings.TrimPrefix(s.Ref, "#/$defs/")]
+		if def == nil {
+			panic("config.schema.json: unknown reference " + s.Ref)
+		}
+		def.check(root, v, path, errs)
+		return
+	}
+	if len(s.Type) > 0 && !s.Type.matches(v) {
+		*errs = append(*errs, ValidationError{Field: path, Message: "must be " + s.Type.describe()})
+		return
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			field := key
+			if path != "" {
+				field = path + "." + key
+			}
+			switch prop := s.Properties[key]; {
+			case prop != nil:
+				prop.check(root, v[key], field, errs)
+			case s.Additional != nil && s.Additional.schema != nil:
+				s.Additional.schema.check(root, v[key], field, errs)
+			case s.Additional != nil:
+				*errs = append(*errs, ValidationError{Field: field, Message: unknownFieldMessage})
+			}
+		}
+	case []interface{}:
+		if s.Items != nil {
+			for i, item := range v {
+				s.Items.check(root, item, fmt.Sprintf("%s[%d]", path, i), errs)
+			}
+		}
+	}
+}
+
+// matches reports whether v, as decoded by encoding/json, is one of the types.
+// null matches any type, as it leaves a field unset.
+func (t schemaTypes) matches(v interface{}) bool {
+	for _, typ := range t {
+		switch v := v.(type) {
+		case nil:
+			return true
+		case string:
+			if typ == "string" {
+				return true
+			}
+		case bool:
+			if typ == "boolean" {
+				return true
+			}
+		case float64:
+			if typ == "number" || typ == "integer" && v == math.Trunc(v) {
+				return true
+			}
+		case map[string]interface{}:
+			if typ == "object" {
+				return true
+			}
+		case []interface{}:
+			if typ == "array" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// describe names the types for an error message, e.g. "a string or a number"
+func (t schemaTypes) describe() string {
+	names := make([]string, len(t))
+	for i, typ := range t {
+		switch typ {
+		case "integer", "object", "array":
+			names[i] = "an " + typ
+		default:
+			names[i] = "a " + typ
+		}
+	}
+	return strings.Join(names, " or ")
+}
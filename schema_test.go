@@ -0,0 +1,169 @@
+// schema_test.go
+package main
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var updateSchema = flag.Bool("update-schema", false, "rewrite config.schema.json from the config types")
+
+// schemaFor describes the JSON form of t, adding the structs it uses to defs
+func schemaFor(t reflect.Type, defs map[string]*jsonSchema) *jsonSchema {
+	switch t {
+	case reflect.TypeOf(Duration(0)):
+		return &jsonSchema{Type: schemaTypes{"string", "number"}}
+	case reflect.TypeOf(StatusRange{}):
+		return &jsonSchema{Type: schemaTypes{"integer", "string"}}
+	case reflect.TypeOf(json.RawMessage{}):
+		return &jsonSchema{}
+	case reflect.TypeOf(time.Time{}):
+		return &jsonSchema{Type: schemaTypes{"string"}}
+	}
+	if t.Kind() != reflect.Pointer && reflect.PointerTo(t).Implements(reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()) &&
+		t != reflect.TypeOf(Config{}) {
+		panic(t.String() + " has its own JSON form; describe it in schemaFor")
+	}
+	if reflect.PointerTo(t).Implements(reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()) {
+		return &jsonSchema{Type: schemaTypes{"string"}}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schemaFor(t.Elem(), defs)
+	case reflect.Bool:
+		return &jsonSchema{Type: schemaTypes{"boolean"}}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &jsonSchema{Type: schemaTypes{"integer"}}
+	case reflect.Float32, reflect.Float64:
+		return &jsonSchema{Type: schemaTypes{"number"}}
+	case reflect.String:
+		return &jsonSchema{Type: schemaTypes{"string"}}
+	case reflect.Interface:
+		return &jsonSchema{}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &jsonSchema{Type: schemaTypes{"string"}} // base64
+		}
+		return &jsonSchema{Type: schemaTypes{"array"}, Items: schemaFor(t.Elem(), defs)}
+	case reflect.Map:
+		return &jsonSchema{Type: schemaTypes{"object"}, Additional: &additionalProperties{schema: schemaFor(t.Elem(), defs)}}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return structSchema(t, defs)
+		}
+		if _, ok := defs[t.Name()]; !ok {
+			defs[t.Name()] = nil // taken, for types that refer to themselves
+			defs[t.Name()] = structSchema(t, defs)
+		}
+		return &jsonSchema{Ref: "#/$defs/" + t.Name()}
+	}
+	panic("no JSON schema for " + t.String())
+}
+
+// structSchema describes a struct by its JSON fields, as encoding/json reads them
+func structSchema(t reflect.Type, defs map[string]*jsonSchema) *jsonSchema {
+	s := &jsonSchema{Type: schemaTypes{"object"}, Properties: map[string]*jsonSchema{}, Additional: &additionalProperties{}}
+	for _, f := range reflect.VisibleFields(t) {
+		if !f.IsExported() || f.Anonymous {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		s.Properties[name] = schemaFor(f.Type, defs)
+	}
+	return s
+}
+
+// generateConfigSchema builds config.schema.json from the config types
+func generateConfigSchema() []byte {
+	defs := map[string]*jsonSchema{}
+	schemaFor(reflect.TypeOf(Config{}), defs)
+	// Editors find the schema through the $schema field -init writes
+	defs["Config"].Properties["$schema"] = &jsonSchema{Type: schemaTypes{"string"}}
+
+	doc := struct {
+		Schema string                 `json:"$schema"`
+		Title  string                 `json:"title"`
+		Ref    string                 `json:"$ref"`
+		Defs   map[string]*jsonSchema `json:"$defs"`
+	}{"https://json-schema.org/draft/2020-12/schema", "sre-health-checker config", "#/$defs/Config", defs}
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	return append(data, '\n')
+}
+
+func TestConfigSchemaUpToDate(t *testing.T) {
+	want := generateConfigSchema()
+	if *updateSchema {
+		if err := os.WriteFile("config.schema.json", want, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	if !bytes.Equal(configSchema, want) {
+		t.Fatal("config.schema.json is out of date with the config types; run go test -run TestConfigSchemaUpToDate -update-schema")
+	}
+}
+
+func TestValidateSchema(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   []ValidationError
+	}{
+		{"starter config", string(starterConfig), nil},
+		{"unknown field", `{"services": [{"name": "a", "url": "https://a.example.com", "intervall": "30s"}]}`,
+			[]ValidationError{{Field: "services[0].intervall", Message: "unknown field"}}},
+		{"unknown top-level field", `{"service": []}`, []ValidationError{{Field: "service", Message: "unknown field"}}},
+		{"wrong type", `{"dashboard": {"columns": "3"}}`, []ValidationError{{Field: "dashboard.columns", Message: "must be an integer"}}},
+		{"duration as seconds", `{"defaults": {"interval": 30, "timeout": "5s"}}`, nil},
+		{"fractional integer", `{"max_concurrent_checks": 1.5}`, []ValidationError{{Field: "max_concurrent_checks", Message: "must be an integer"}}},
+		{"map values", `{"services": [{"name": "a", "url": "https://a.example.com", "labels": {"team": 1}}]}`,
+			[]ValidationError{{Field: "services[0].labels.team", Message: "must be a string"}}},
+		{"null leaves a field unset", `{"tracing": null}`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := validateSchema([]byte(tt.config))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("validateSchema() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnknownFieldsWarnOnLoad(t *testing.T) {
+	config := `{"services": [{"name": "a", "url": "https://a.example.com", "intervall": "30s"}]}`
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if cfg, err := loadConfig(path); err != nil || len(cfg.Services) != 1 {
+		t.Errorf("loadConfig() with an unknown field = %v, want it loaded", err)
+	}
+
+	rec := httptest.NewRecorder()
+	ValidateConfigHandler(rec, httptest.NewRequest(http.MethodPost, "/api/v1/config/validate", strings.NewReader(config)))
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "services[0].intervall") {
+		t.Errorf("validating a config with an unknown field = %d %s, want it rejected", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,198 @@
+// search.go
+package main
+
+import (
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultSearchLimit = 50
+	maxSearchLimit     = 500
+)
+
+// SearchQuery selects incidents, events and check errors by the words they
+// contain. Every term must match the start of a word, or with the in-memory
+// backend any part of one.
+type SearchQuery struct {
+	Terms   []string
+	Service string    // only this service, when set
+	Since   time.Time // only what happened after this, when set
+	Limit   int
+}
+
+// Kinds of search hit
+const (
+	SearchIncident = "incident"
+	SearchEvent    = "event"
+	SearchError    = "error"
+)
+
+// SearchHit is an incident, an event, or an error checks failed with
+type SearchHit struct {
+	Kind    string    `json:"kind"` // incident, event or error
+	Service string    `json:"service"`
+	Time    time.Time `json:"time"` // when it happened; for errors when last seen
+	Text    string    `json:"text"` // the incident's or check's error, or the event's message
+	ID      string    `json:"id,omitempty"`
+	Type    string    `json:"type,omitempty"`   // event type
+	Author  string    `json:"author,omitempty"` // event author
+
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"` // incidents
+	FirstSeen  *time.Time `json:"first_seen,omitempty"`  // errors
+	Count      int64      `json:"count,omitempty"`       // errors: failed checks with this error
+}
+
+// searchTerms splits a query into lowercase terms
+func searchTerms(q string) []string {
+	return strings.Fields(strings.ToLower(q))
+}
+
+// matches reports whether text contains every term, ignoring case
+func (q SearchQuery) matches(service string, text ...string) bool {
+	if q.Service != "" && service != q.Service {
+		return false
+	}
+	all := strings.ToLower(service + " " + strings.Join(text, " "))
+	for _, term := range q.Terms {
+		if !strings.Contains(all, term) {
+			return false
+		}
+	}
+	return true
+}
+
+// Search finds incidents, events and check errors matching q, newest first.
+// Events and errors come from the store when it indexes them, reaching back
+// as far as its retention, and otherwise from what's held in memory.
+func (hc *HealthChecker) Search(q SearchQuery) ([]SearchHit, error) {
+	hits, err := hc.store.Search(q)
+	if err != nil {
+		return nil, err
+	}
+	if hits == nil {
+		hits = append(hc.events.search(q), hc.searchErrors(q)...)
+	}
+
+	// An incident held in memory stands in for the event that opened it
+	incidents := hc.searchIncidents(q)
+	opened := make(map[string]bool, len(incidents))
+	for _, hit := range incidents {
+		opened[hit.Service+"@"+strconv.FormatInt(hit.Time.UnixNano(), 10)] = true
+	}
+	hits = slices.DeleteFunc(hits, func(hit SearchHit) bool {
+		return hit.Type == EventIncidentOpened && opened[hit.Service+"@"+strconv.FormatInt(hit.Time.UnixNano(), 10)]
+	})
+	hits = append(hits, incidents...)
+
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].Time.After(hits[j].Time) })
+	if len(hits) > q.Limit {
+		hits = hits[:q.Limit]
+	}
+	return hits, nil
+}
+
+// search returns the events matching q
+func (l *EventLog) search(q SearchQuery) []SearchHit {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	var hits []SearchHit
+	for _, e := range l.events {
+		if e.Time.Before(q.Since) || !q.matches(e.Service, e.Type, e.Message, e.Author) {
+			continue
+		}
+		hits = append(hits, SearchHit{Kind: SearchEvent, Service: e.Service, Time: e.Time, Text: e.Message,
+			ID: strconv.FormatInt(e.ID, 10), Type: e.Type, Author: e.Author})
+	}
+	return hits
+}
+
+// searchErrors returns the distinct errors in the check history matching q
+func (hc *HealthChecker) searchErrors(q SearchQuery) []SearchHit {
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+
+	var hits []SearchHit
+	for name, records := range hc.history {
+		byError := make(map[string]int)
+		for _, rec := range records {
+			if rec.Error == "" || rec.Time.Before(q.Since) || !q.matches(name, rec.Error) {
+				continue
+			}
+			i, seen := byError[rec.Error]
+			if !seen {
+				first := rec.Time
+				i = len(hits)
+				byError[rec.Error] = i
+				hits = append(hits, SearchHit{Kind: SearchError, Service: name, Text: rec.Error, FirstSeen: &first})
+			}
+			hits[i].Time = rec.Time
+			hits[i].Count++
+		}
+	}
+	return hits
+}
+
+// searchIncidents returns the open and resolved incidents matching q
+func (hc *HealthChecker) searchIncidents(q SearchQuery) []SearchHit {
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+
+	var incidents []*Incident
+	for name, status := range hc.statuses {
+		if status.Incident != nil {
+			incidents = append(incidents, status.Incident)
+		}
+		incidents = append(incidents, hc.incidents[name]...)
+	}
+
+	var hits []SearchHit
+	for _, incident := range incidents {
+		if incident.StartedAt.Before(q.Since) || !q.matches(incident.Service, incident.ID, incident.Error, incident.DNSError) {
+			continue
+		}
+		hits = append(hits, SearchHit{Kind: SearchIncident, Service: incident.Service, Time: incident.StartedAt,
+			Text: incident.Error, ID: incident.ID, ResolvedAt: incident.ResolvedAt})
+	}
+	return hits
+}
+
+// SearchHandler searches incidents, events and check errors for the words in
+// ?q=, optionally only those of ?service= and after ?since=, up to ?limit=
+func (hc *HealthChecker) SearchHandler(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	q := SearchQuery{Terms: searchTerms(params.Get("q")), Service: params.Get("service"), Limit: defaultSearchLimit}
+	if len(q.Terms) == 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "q is required"})
+		return
+	}
+	if s := params.Get("since"); s != "" {
+		since, err := parseSince(s, time.Now())
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		q.Since = since
+	}
+	if limit, _ := strconv.Atoi(params.Get("limit")); limit > 0 {
+		q.Limit = min(limit, maxSearchLimit)
+	}
+
+	hits, err := hc.Search(q)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	if hits == nil {
+		hits = []SearchHit{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"query":   params.Get("q"),
+		"results": hits,
+	})
+}
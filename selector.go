@@ -0,0 +1,77 @@
+// selector.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Selector picks services by label, like env=staging,team!=payments. Every term
+// must match. The keys service and tag match the service name and its tags
+// instead of a label.
+type Selector []selectorTerm
+
+type selectorTerm struct {
+	Key, Value string
+	Negate     bool
+}
+
+// parseSelector parses a comma-separated list of key=value and key!=value terms
+func parseSelector(s string) (Selector, error) {
+	var sel Selector
+	for _, term := range strings.Split(s, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		var t selectorTerm
+		key, value, ok := strings.Cut(term, "!=")
+		if ok {
+			t.Negate = true
+		} else if key, value, ok = strings.Cut(term, "="); !ok {
+			return nil, fmt.Errorf("invalid selector term %q, want key=value or key!=value", term)
+		}
+		t.Key, t.Value = strings.TrimSpace(key), strings.TrimSpace(strings.TrimPrefix(value, "="))
+		if t.Key == "" {
+			return nil, fmt.Errorf("invalid selector term %q: missing key", term)
+		}
+		sel = append(sel, t)
+	}
+	if len(sel) == 0 {
+		return nil, errors.New("selector is required, e.g. env=staging")
+	}
+	return sel, nil
+}
+
+// Matches reports whether a service with the given name, labels and tags is selected
+func (sel Selector) Matches(name string, labels map[string]string, tags []string) bool {
+	for _, t := range sel {
+		var match bool
+		switch t.Key {
+		case "service":
+			match = name == t.Value
+		case "tag":
+			match = containsString(tags, t.Value)
+		default:
+			match = labels[t.Key] == t.Value
+		}
+		if match == t.Negate {
+			return false
+		}
+	}
+	return true
+}
+
+// String formats the selector in its canonical form
+func (sel Selector) String() string {
+	terms := make([]string, len(sel))
+	for i, t := range sel {
+		op := "="
+		if t.Negate {
+			op = "!="
+		}
+		terms[i] = t.Key + op + t.Value
+	}
+	return strings.Join(terms, ",")
+}
@@ -0,0 +1,150 @@
+// selftest.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// What a failed startup check does: degrade (default) keeps running but
+// reports not ready until the check passes, exit stops the process, and warn
+// only logs it
+var startupCheckModes = []string{"degrade", "exit", "warn"}
+
+const (
+	startupCheckTimeout    = 15 * time.Second
+	startupRecheckInterval = 30 * time.Second
+)
+
+// startupCheck verifies a notifier, the storage backend or a discovery source
+// works before an incident depends on it
+type startupCheck struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+// startupChecks lists the checks for what's configured; forwarder, mdns and
+// kubernetes are nil when not in use
+func startupChecks(store Store, outbox *Outbox, forwarder *Forwarder, mdns *MDNSConfig, kubernetes *KubernetesConfig) []startupCheck {
+	checks := []startupCheck{{name: "storage", run: store.Check}}
+	for _, name := range slices.Sorted(maps.Keys(outbox.configs)) {
+		checks = append(checks, startupCheck{name: "notifier " + name, run: func(context.Context) error {
+			return outbox.selfCheck(name)
+		}})
+	}
+	if forwarder != nil {
+		// Fails when the aggregator is unreachable or refuses the agent's token
+		checks = append(checks, startupCheck{name: "aggregator", run: func(context.Context) error {
+			_, _, err := forwarder.fetchAssignment("")
+			return err
+		}})
+	}
+	if mdns != nil {
+		checks = append(checks, startupCheck{name: "mdns", run: func(context.Context) error {
+			_, err := browseMDNS(mdns.query(), 100*time.Millisecond)
+			return err
+		}})
+	}
+	if kubernetes != nil {
+		checks = append(checks, startupCheck{name: "kubernetes", run: kubernetes.check})
+	}
+	return checks
+}
+
+// runStartupChecks runs checks at once and returns the failures by check name
+func runStartupChecks(checks []startupCheck) map[string]error {
+	ctx, cancel := context.WithTimeout(context.Background(), startupCheckTimeout)
+	defer cancel()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	failures := make(map[string]error)
+	for _, c := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.run(ctx); err != nil {
+				mu.Lock()
+				failures[c.name] = err
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return failures
+}
+
+// RunStartupChecks verifies everything checks depends on and applies mode to
+// the failures. It returns an error only in exit mode.
+func (hc *HealthChecker) RunStartupChecks(mode string, checks []startupCheck) error {
+	failures := runStartupChecks(checks)
+	if len(failures) == 0 {
+		slog.Info("startup checks passed", "checks", len(checks))
+		return nil
+	}
+	names := slices.Sorted(maps.Keys(failures))
+	for _, name := range names {
+		slog.Error("startup check failed", "check", name, "error", failures[name])
+	}
+
+	switch mode {
+	case "exit":
+		return fmt.Errorf("startup checks failed: %s", strings.Join(names, ", "))
+	case "warn":
+		return nil
+	}
+
+	hc.mu.Lock()
+	hc.startupFailures = make(map[string]string, len(failures))
+	for name, err := range failures {
+		hc.startupFailures[name] = redactor.Redact(err.Error())
+	}
+	hc.mu.Unlock()
+
+	var failed []startupCheck
+	for _, c := range checks {
+		if failures[c.name] != nil {
+			failed = append(failed, c)
+		}
+	}
+	go hc.recheckStartup(failed)
+	return nil
+}
+
+// recheckStartup reruns failed startup checks every startupRecheckInterval
+// until they all pass, so readiness recovers without a restart
+func (hc *HealthChecker) recheckStartup(failed []startupCheck) {
+	for len(failed) > 0 {
+		time.Sleep(startupRecheckInterval)
+		failures := runStartupChecks(failed)
+
+		hc.mu.Lock()
+		for _, c := range failed {
+			if err := failures[c.name]; err != nil {
+				hc.startupFailures[c.name] = redactor.Redact(err.Error())
+				continue
+			}
+			delete(hc.startupFailures, c.name)
+			slog.Info("startup check passing", "check", c.name)
+		}
+		hc.mu.Unlock()
+
+		failed = slices.DeleteFunc(failed, func(c startupCheck) bool { return failures[c.name] == nil })
+	}
+}
+
+// startupReasons describes the startup checks still failing, sorted. Must be
+// called with hc.mu held.
+func (hc *HealthChecker) startupReasons() []string {
+	var reasons []string
+	for _, name := range slices.Sorted(maps.Keys(hc.startupFailures)) {
+		reasons = append(reasons, "startup check failed: "+name+": "+hc.startupFailures[name])
+	}
+	return reasons
+}
@@ -0,0 +1,322 @@
+// services_api.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ServiceAPI serves the runtime service management endpoints. Changes are
+// serialized, so an If-Match precondition holds until the change is applied.
+type ServiceAPI struct {
+	checker    *HealthChecker
+	config     *Config
+	path       string // file the service set is saved to after each change, if any
+	store      Store  // also saved to, when storage is durable, with the ETag key and idempotency keys
+	idempotent idempotencyStore
+	mu         sync.Mutex
+}
+
+// loadServicesFile replaces the configured services with the set saved by runtime
+// changes in a previous run. It reports whether a saved set was found.
+func loadServicesFile(path string, cfg *Config) (bool, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, decodeServices(path, data, cfg)
+}
+
+// loadStoredServices is loadServicesFile for the set saved to the store
+func loadStoredServices(store Store, cfg *Config) (bool, error) {
+	data, err := store.LoadServices()
+	if err != nil || data == nil {
+		return false, err
+	}
+	return true, decodeServices("storage", data, cfg)
+}
+
+// decodeServices replaces the configured services with a saved set, naming
+// source in errors
+func decodeServices(source string, data []byte, cfg *Config) error {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("%s: %w", source, err)
+	}
+	services := make([]Service, 0, len(raw))
+	var msgs []string
+	for i, rawSvc := range raw {
+		svc, err := cfg.NewService(rawSvc)
+		if err != nil {
+			return fmt.Errorf("%s: [%d]: %w", source, i, err)
+		}
+		prefix := fmt.Sprintf("[%d].", i)
+		for _, e := range append(validateService(svc, prefix), cfg.Targets.ValidateService(svc, prefix)...) {
+			msgs = append(msgs, e.Error())
+		}
+		services = append(services, svc)
+	}
+	if len(msgs) > 0 {
+		return fmt.Errorf("%s: %s", source, strings.Join(msgs, "; "))
+	}
+	cfg.Services = services
+	return nil
+}
+
+// persist saves the services managed through the config and this API. Services
+// registered by agents or discovery are left out; they are registered again.
+func (api *ServiceAPI) persist() {
+	if api.path == "" && api.store == nil {
+		return
+	}
+	services := []Service{}
+	for _, svc := range api.checker.Services() {
+		if svc.Type != "agent" && svc.Labels["discovered_by"] == "" {
+			services = append(services, svc)
+		}
+	}
+	data, err := json.MarshalIndent(services, "", "  ")
+	if err != nil {
+		slog.Warn("saving services", "error", err)
+		return
+	}
+	if api.path != "" {
+		if err := writeFileAtomic(api.path, data); err != nil {
+			slog.Warn("saving services", "path", api.path, "error", err)
+		}
+	}
+	if api.store != nil {
+		if err := api.store.SaveServices(data); err != nil {
+			slog.Warn("saving services to storage", "error", err)
+		}
+	}
+}
+
+// ListHandler returns all service definitions
+func (api *ServiceAPI) ListHandler(w http.ResponseWriter, r *http.Request) {
+	services := api.checker.Services()
+	for i, svc := range services {
+		services[i] = redactor.RedactService(svc)
+	}
+	writeJSON(w, http.StatusOK, services)
+}
+
+// GetHandler returns a single service definition, with an ETag for If-Match
+func (api *ServiceAPI) GetHandler(w http.ResponseWriter, r *http.Request) {
+	svc, ok := api.checker.GetService(r.PathValue("name"))
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": errServiceNotFound.Error()})
+		return
+	}
+	w.Header().Set("ETag", serviceETag(svc))
+	writeJSON(w, http.StatusOK, redactor.RedactService(svc))
+}
+
+// CreateHandler adds a service; fields it omits take the config defaults. A
+// request with an Idempotency-Key that already succeeded gets the same response
+// again instead of a conflict, so a sync can safely retry.
+func (api *ServiceAPI) CreateHandler(w http.ResponseWriter, r *http.Request) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	data, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+	key := r.Header.Get("Idempotency-Key")
+	request := requestHash(data)
+	if key != "" {
+		if api.idempotent == nil {
+			api.idempotent = make(idempotencyStore)
+		}
+		if prev := api.idempotent.get(key, time.Now()); prev != nil {
+			if prev.Request != request {
+				writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "Idempotency-Key was already used for a different request"})
+				return
+			}
+			w.Header().Set("ETag", prev.ETag)
+			w.Header().Set("Idempotent-Replayed", "true")
+			writeJSON(w, prev.Status, prev.Body)
+			return
+		}
+	}
+
+	svc, ok := api.decodeService(w, data, "")
+	if !ok {
+		return
+	}
+	if err := api.checker.AddService(svc); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	api.persist()
+
+	etag, body := serviceETag(svc), redactor.RedactService(svc)
+	if key != "" {
+		encoded, _ := json.Marshal(body)
+		api.idempotent[key] = &idempotentResponse{Request: request, Status: http.StatusCreated, Body: encoded, ETag: etag, Expires: time.Now().Add(idempotencyTTL)}
+		if err := api.saveState(); err != nil {
+			slog.Warn("saving idempotency keys to storage", "error", err)
+		}
+	}
+	w.Header().Set("ETag", etag)
+	writeJSON(w, http.StatusCreated, body)
+}
+
+// UpdateHandler replaces the service named in the path
+func (api *ServiceAPI) UpdateHandler(w http.ResponseWriter, r *http.Request) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	name := r.PathValue("name")
+	if !api.checkPrecondition(w, r, name) {
+		return
+	}
+	data, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+	svc, ok := api.decodeService(w, data, name)
+	if !ok {
+		return
+	}
+	if err := api.checker.UpdateService(svc); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	api.persist()
+	w.Header().Set("ETag", serviceETag(svc))
+	writeJSON(w, http.StatusOK, redactor.RedactService(svc))
+}
+
+// DeleteHandler stops monitoring the service named in the path
+func (api *ServiceAPI) DeleteHandler(w http.ResponseWriter, r *http.Request) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	name := r.PathValue("name")
+	if !api.checkPrecondition(w, r, name) {
+		return
+	}
+	if err := api.checker.RemoveService(name); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	api.persist()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PauseHandler returns a handler that pauses or resumes the service named in the path
+func (api *ServiceAPI) PauseHandler(paused bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		api.mu.Lock()
+		defer api.mu.Unlock()
+
+		name := r.PathValue("name")
+		if !api.checkPrecondition(w, r, name) {
+			return
+		}
+		if err := api.checker.SetPaused(name, paused); err != nil {
+			writeServiceError(w, err)
+			return
+		}
+		api.persist()
+		svc, _ := api.checker.GetService(name)
+		w.Header().Set("ETag", serviceETag(svc))
+		writeJSON(w, http.StatusOK, redactor.RedactService(svc))
+	}
+}
+
+// checkPrecondition enforces an If-Match header against the current version of
+// the named service, answering 412 with the current ETag when it doesn't match.
+// Requests without If-Match always pass. Must be called with api.mu held.
+func (api *ServiceAPI) checkPrecondition(w http.ResponseWriter, r *http.Request, name string) bool {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		return true
+	}
+	svc, ok := api.checker.GetService(name)
+	if !ok {
+		writeJSON(w, http.StatusPreconditionFailed, map[string]string{"error": errServiceNotFound.Error()})
+		return false
+	}
+	etag := serviceETag(svc)
+	if !etagMatches(ifMatch, etag) {
+		w.Header().Set("ETag", etag)
+		writeJSON(w, http.StatusPreconditionFailed, map[string]string{"error": "service was changed since it was read; fetch it again"})
+		return false
+	}
+	return true
+}
+
+// readBody reads a request body of up to maxRequestBody bytes
+func readBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBody))
+	if err != nil {
+		writeJSON(w, http.StatusRequestEntityTooLarge, map[string]string{"error": err.Error()})
+		return nil, false
+	}
+	return data, true
+}
+
+// decodeService parses and validates a service definition from a request body.
+// When name is set it must match the body's name, which may be omitted, and
+// secrets sent back masked keep the existing service's values.
+func (api *ServiceAPI) decodeService(w http.ResponseWriter, data []byte, name string) (Service, bool) {
+	svc, err := api.config.NewService(data)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return Service{}, false
+	}
+
+	if name != "" {
+		if svc.Name != "" && svc.Name != name {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "service name cannot be changed"})
+			return Service{}, false
+		}
+		svc.Name = name
+		if existing, ok := api.checker.GetService(name); ok {
+			svc = redactor.restoreSecrets(svc, existing)
+		}
+	}
+
+	errs := append(validateService(svc, ""), api.config.validateNotifierRefs(svc, "")...)
+	errs = append(errs, api.config.Targets.ValidateService(svc, "")...)
+	if svc.Type == "exec" {
+		errs = append(errs, ValidationError{Field: "type", Message: errExecNotAllowed.Error()})
+	}
+	if api.config.Duplicates == "error" {
+		if existing, dup := api.checker.duplicateOf(svc); dup {
+			errs = append(errs, ValidationError{Field: "url", Message: fmt.Sprintf("checks the same target as %q", existing.Name)})
+		}
+	}
+	if len(errs) > 0 {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{"errors": errs})
+		return Service{}, false
+	}
+	redactor.Add(svc.secrets()...)
+	return svc, true
+}
+
+// writeServiceError maps HealthChecker errors to HTTP responses
+func writeServiceError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, errServiceNotFound):
+		code = http.StatusNotFound
+	case errors.Is(err, errServiceExists):
+		code = http.StatusConflict
+	}
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
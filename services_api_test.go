@@ -0,0 +1,105 @@
+// services_api_test.go
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// serviceAPIMux routes the service API's create, get and update endpoints
+func serviceAPIMux(api *ServiceAPI) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/services/{name}", api.GetHandler)
+	mux.HandleFunc("POST /api/services", api.CreateHandler)
+	mux.HandleFunc("PUT /api/services/{name}", api.UpdateHandler)
+	return mux
+}
+
+// serve sends a request with the given headers through mux
+func serve(mux http.Handler, method, path, body string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestServiceIfMatch(t *testing.T) {
+	api := &ServiceAPI{checker: NewHealthChecker(nil), config: &Config{}}
+	mux := serviceAPIMux(api)
+	created := serve(mux, http.MethodPost, "/api/services", `{"name": "api", "url": "https://api.example.com"}`, nil)
+	if created.Code != http.StatusCreated {
+		t.Fatalf("create = %d %s", created.Code, created.Body)
+	}
+	etag := created.Header().Get("ETag")
+	if got := serve(mux, http.MethodGet, "/api/services/api", "", nil).Header().Get("ETag"); got != etag {
+		t.Fatalf("GET ETag = %s, want the create's %s", got, etag)
+	}
+
+	update := `{"url": "https://api.example.com/health"}`
+	stale := serve(mux, http.MethodPut, "/api/services/api", update, map[string]string{"If-Match": `"stale"`})
+	if stale.Code != http.StatusPreconditionFailed || stale.Header().Get("ETag") != etag {
+		t.Fatalf("PUT with a stale If-Match = %d, ETag %s, want 412 with %s", stale.Code, stale.Header().Get("ETag"), etag)
+	}
+	updated := serve(mux, http.MethodPut, "/api/services/api", update, map[string]string{"If-Match": etag})
+	if updated.Code != http.StatusOK || updated.Header().Get("ETag") == etag {
+		t.Fatalf("PUT with the current If-Match = %d, ETag %s, want 200 with a new ETag", updated.Code, updated.Header().Get("ETag"))
+	}
+	if again := serve(mux, http.MethodPut, "/api/services/api", update, map[string]string{"If-Match": etag}); again.Code != http.StatusPreconditionFailed {
+		t.Errorf("PUT with the ETag from before the update = %d, want 412", again.Code)
+	}
+	if anything := serve(mux, http.MethodPut, "/api/services/api", update, map[string]string{"If-Match": "*"}); anything.Code != http.StatusOK {
+		t.Errorf("PUT with If-Match * = %d, want 200", anything.Code)
+	}
+	if secrets := serve(mux, http.MethodPut, "/api/services/api", `{"url": "https://api.example.com/health", "headers": {"Authorization": "Bearer new"}}`, nil); secrets.Header().Get("ETag") == updated.Header().Get("ETag") {
+		t.Error("changing only a secret header kept the ETag")
+	}
+}
+
+func TestServiceIdempotencyKey(t *testing.T) {
+	store, err := openSQLiteStore(filepath.Join(t.TempDir(), "hc.db"), defaultStorageRetention)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	api := &ServiceAPI{checker: NewHealthChecker(nil), config: &Config{}, store: store}
+	if err := api.restore(); err != nil {
+		t.Fatal(err)
+	}
+	mux := serviceAPIMux(api)
+	body := `{"name": "api", "url": "https://api.example.com"}`
+	key := map[string]string{"Idempotency-Key": "sync-1"}
+
+	first := serve(mux, http.MethodPost, "/api/services", body, key)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("create = %d %s", first.Code, first.Body)
+	}
+	if conflict := serve(mux, http.MethodPost, "/api/services", body, nil); conflict.Code != http.StatusConflict {
+		t.Errorf("create again without a key = %d, want 409", conflict.Code)
+	}
+	if reused := serve(mux, http.MethodPost, "/api/services", `{"name": "db", "url": "https://db.example.com"}`, key); reused.Code != http.StatusUnprocessableEntity {
+		t.Errorf("key reused for another service = %d, want 422", reused.Code)
+	}
+
+	// After a restart the key is still replayed, with the same ETag
+	etagKey = []byte("another process's random key")
+	restarted := &ServiceAPI{checker: NewHealthChecker(nil), config: &Config{}, store: store}
+	if err := restarted.restore(); err != nil {
+		t.Fatal(err)
+	}
+	replay := serve(serviceAPIMux(restarted), http.MethodPost, "/api/services", body, key)
+	if replay.Code != http.StatusCreated || replay.Header().Get("Idempotent-Replayed") != "true" || replay.Body.String() != first.Body.String() {
+		t.Fatalf("retry after a restart = %d %s, want the original response replayed", replay.Code, replay.Body)
+	}
+	if replay.Header().Get("ETag") != first.Header().Get("ETag") {
+		t.Errorf("ETag after a restart = %s, want %s", replay.Header().Get("ETag"), first.Header().Get("ETag"))
+	}
+	if got := serviceETag(api.checker.services["api"]); got != first.Header().Get("ETag") {
+		t.Errorf("ETag of the same service after a restart = %s, want %s", got, first.Header().Get("ETag"))
+	}
+}
@@ -0,0 +1,163 @@
+// sessions.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultSessionTTL is how long a login session is reused when Login.TTL is unset
+const defaultSessionTTL = 15 * time.Minute
+
+// LoginConfig logs an HTTP check in before it runs. The session, the cookies the
+// login sets and optionally a bearer token from its JSON response, is reused
+// across checks until TTL passes or the target answers 401.
+type LoginConfig struct {
+	URL         string   `json:"url"`
+	Body        string   `json:"body,omitempty"`         // POSTed to URL
+	ContentType string   `json:"content_type,omitempty"` // default application/json
+	TokenField  string   `json:"token_field,omitempty"`  // response field holding a bearer token, e.g. access_token
+	TTL         Duration `json:"ttl,omitempty"`          // default 15m
+}
+
+// validate checks a login definition; prefix is prepended to field names
+func (l LoginConfig) validate(prefix string) []ValidationError {
+	var errs []ValidationError
+	if u, err := url.Parse(l.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, ValidationError{Field: prefix + "url", Message: "must be an absolute http or https URL"})
+	}
+	if l.TTL < 0 {
+		errs = append(errs, ValidationError{Field: prefix + "ttl", Message: "must not be negative"})
+	}
+	return errs
+}
+
+// session is a cached login
+type session struct {
+	login   LoginConfig // the definition it was made with
+	token   string
+	cookies []*http.Cookie
+	expires time.Time
+}
+
+// apply adds the session's credentials to a request
+func (s *session) apply(req *http.Request) {
+	for _, c := range s.cookies {
+		req.AddCookie(c)
+	}
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+}
+
+// sessionStore caches a session per service and counts the logins made
+type sessionStore struct {
+	sessions map[string]*session
+	logins   map[string]int64
+	mu       sync.Mutex
+}
+
+var sessions = &sessionStore{sessions: make(map[string]*session), logins: make(map[string]int64)}
+
+// get returns the service's cached session, logging in when there is none, it
+// expired, its login definition changed, or force is set. fresh reports whether
+// the session was just made.
+func (st *sessionStore) get(ctx context.Context, svc Service, force bool) (s *session, fresh bool, err error) {
+	st.mu.Lock()
+	s = st.sessions[svc.Name]
+	st.mu.Unlock()
+	if s != nil && !force && time.Now().Before(s.expires) && reflect.DeepEqual(s.login, *svc.Login) {
+		return s, false, nil
+	}
+
+	transport, err := checkTransport(svc)
+	if err != nil {
+		return nil, false, err
+	}
+	if s, err = login(ctx, *svc.Login, transport); err != nil {
+		st.mu.Lock()
+		delete(st.sessions, svc.Name)
+		st.mu.Unlock()
+		return nil, false, fmt.Errorf("login: %w", err)
+	}
+	st.mu.Lock()
+	st.sessions[svc.Name] = s
+	st.logins[svc.Name]++
+	st.mu.Unlock()
+	slog.Debug("logged in", "service", svc.Name, "session_expires", s.expires.Format(time.RFC3339))
+	return s, true, nil
+}
+
+// login performs the login request. Redirects aren't followed, so cookies set
+// alongside a redirect after login are kept. A nil transport uses the default.
+func login(ctx context.Context, l LoginConfig, transport http.RoundTripper) (*session, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, l.URL, strings.NewReader(l.Body))
+	if err != nil {
+		return nil, err
+	}
+	contentType := l.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	client := &http.Client{Transport: transport, CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	req, finish := traceRequest(req)
+	resp, err := client.Do(req)
+	finish(resp, err)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+
+	ttl := time.Duration(l.TTL)
+	if ttl == 0 {
+		ttl = defaultSessionTTL
+	}
+	s := &session{login: l, cookies: resp.Cookies(), expires: time.Now().Add(ttl)}
+	if l.TokenField != "" {
+		var body map[string]interface{}
+		if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+			return nil, fmt.Errorf("decoding response: %w", err)
+		}
+		token, _ := body[l.TokenField].(string)
+		if token == "" {
+			return nil, fmt.Errorf("no %q in response", l.TokenField)
+		}
+		s.token = token
+	}
+	return s, nil
+}
+
+// WriteMetrics writes login counts
+func (st *sessionStore) WriteMetrics(m *metricWriter) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if len(st.logins) == 0 {
+		return
+	}
+
+	names := make([]string, 0, len(st.logins))
+	for name := range st.logins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	m.family("service_logins_total", "counter", "Logins performed for authenticated checks")
+	for _, name := range names {
+		m.sample("service_logins_total", float64(st.logins[name]), "service", name)
+	}
+}
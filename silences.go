@@ -0,0 +1,278 @@
+// silences.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Silence mutes alerts for the services its selector matches until EndsAt.
+// Silenced services are still checked and shown; they export service_silenced 1,
+// which the alert rules exclude.
+type Silence struct {
+	ID        string    `json:"id"`
+	Selector  string    `json:"selector"`
+	StartsAt  time.Time `json:"starts_at"`
+	EndsAt    time.Time `json:"ends_at"`
+	Comment   string    `json:"comment,omitempty"`
+	CreatedBy string    `json:"created_by,omitempty"`
+
+	selector Selector
+}
+
+// active reports whether the silence is in effect at t
+func (s *Silence) active(t time.Time) bool {
+	return !t.Before(s.StartsAt) && t.Before(s.EndsAt)
+}
+
+// SilenceStore holds the silences, dropping them once they end
+type SilenceStore struct {
+	silences  []*Silence
+	schedules []scheduledSilence
+	nextID    int64
+	mu        sync.RWMutex
+}
+
+// NewSilenceStore creates an empty silence store
+func NewSilenceStore() *SilenceStore {
+	return &SilenceStore{}
+}
+
+// Add stores a silence for selector from now until now+duration
+func (st *SilenceStore) Add(sel Selector, duration time.Duration, comment, by string) *Silence {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	now := time.Now()
+	st.nextID++
+	s := &Silence{
+		ID:        fmt.Sprintf("%d-%d", now.Unix(), st.nextID),
+		Selector:  sel.String(),
+		StartsAt:  now,
+		EndsAt:    now.Add(duration),
+		Comment:   comment,
+		CreatedBy: by,
+		selector:  sel,
+	}
+	st.silences = append(st.silences, s)
+	slog.Info("silence added", "selector", s.Selector, "until", s.EndsAt.Format(time.RFC3339), "comment", comment)
+	return s
+}
+
+// Expire ends every active silence with exactly this selector and returns how many ended
+func (st *SilenceStore) Expire(sel Selector) int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	now := time.Now()
+	ended := 0
+	for i, s := range st.silences {
+		if s.Selector == sel.String() && s.active(now) {
+			expired := *s
+			expired.EndsAt = now
+			st.silences[i] = &expired
+			ended++
+		}
+	}
+	if ended > 0 {
+		slog.Info("silences ended early", "selector", sel, "ended", ended)
+	}
+	return ended
+}
+
+// ExpireID ends the active silence with the given ID, reporting whether there was one
+func (st *SilenceStore) ExpireID(id string) (*Silence, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	now := time.Now()
+	for i, s := range st.silences {
+		if s.ID == id && now.Before(s.EndsAt) {
+			expired := *s
+			expired.EndsAt = now
+			st.silences[i] = &expired
+			slog.Info("silence ended early", "selector", s.Selector, "silence", id)
+			return &expired, true
+		}
+	}
+	return nil, false
+}
+
+// Silenced returns when the latest active silence matching a service ends, or
+// the zero time when none does
+func (st *SilenceStore) Silenced(name string, labels map[string]string, tags []string, t time.Time) time.Time {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	var until time.Time
+	for _, s := range st.silences {
+		if s.active(t) && s.EndsAt.After(until) && s.selector.Matches(name, labels, tags) {
+			until = s.EndsAt
+		}
+	}
+	return until
+}
+
+// Active returns the silences that haven't ended, soonest ending first, pruning
+// the rest
+func (st *SilenceStore) Active() []*Silence {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	now := time.Now()
+	kept := st.silences[:0]
+	for _, s := range st.silences {
+		if now.Before(s.EndsAt) {
+			kept = append(kept, s)
+		}
+	}
+	st.silences = kept
+
+	active := append([]*Silence{}, kept...)
+	sort.Slice(active, func(i, j int) bool { return active[i].EndsAt.Before(active[j].EndsAt) })
+	return active
+}
+
+// restore loads saved silences, dropping ones that have ended or no longer parse
+func (st *SilenceStore) restore(saved []*Silence) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	now := time.Now()
+	for _, s := range saved {
+		sel, err := parseSelector(s.Selector)
+		if err != nil || !now.Before(s.EndsAt) {
+			continue
+		}
+		s.selector = sel
+		st.silences = append(st.silences, s)
+	}
+}
+
+// Silence mutes alerts for the services sel matches, and any added later, for duration
+func (hc *HealthChecker) Silence(sel Selector, duration time.Duration, comment, by string) *Silence {
+	silence := hc.silences.Add(sel, duration, comment, by)
+	hc.silencesChanged(sel)
+	return silence
+}
+
+// EndSilences ends the active silences created with sel and returns how many ended
+func (hc *HealthChecker) EndSilences(sel Selector) int {
+	ended := hc.silences.Expire(sel)
+	if ended > 0 {
+		hc.silencesChanged(sel)
+	}
+	return ended
+}
+
+// silencesChanged records a change for every service sel matches
+func (hc *HealthChecker) silencesChanged(sel Selector) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	for name, status := range hc.statuses {
+		if sel.Matches(name, status.Labels, status.Tags) {
+			hc.changed(name)
+		}
+	}
+}
+
+// EndSilence ends one silence by ID, reporting whether it was active
+func (hc *HealthChecker) EndSilence(id string) (*Silence, bool) {
+	silence, ok := hc.silences.ExpireID(id)
+	if ok {
+		hc.silencesChanged(silence.selector)
+	}
+	return silence, ok
+}
+
+// silenceView is a silence with the services it currently matches
+type silenceView struct {
+	*Silence
+	Services []string `json:"services"`
+}
+
+// view adds the matching services to a silence
+func (hc *HealthChecker) view(s *Silence) silenceView {
+	return silenceView{Silence: s, Services: hc.matching(s.selector)}
+}
+
+// SilencesHandler lists the silences that haven't ended, soonest ending first
+func (hc *HealthChecker) SilencesHandler(w http.ResponseWriter, r *http.Request) {
+	views := []silenceView{}
+	for _, s := range hc.silences.Active() {
+		views = append(views, hc.view(s))
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"silences": views})
+}
+
+// SilenceHandler returns one silence that hasn't ended by ID
+func (hc *HealthChecker) SilenceHandler(w http.ResponseWriter, r *http.Request) {
+	for _, s := range hc.silences.Active() {
+		if s.ID == r.PathValue("id") {
+			writeJSON(w, http.StatusOK, hc.view(s))
+			return
+		}
+	}
+	writeJSON(w, http.StatusNotFound, map[string]string{"error": "no active silence " + r.PathValue("id")})
+}
+
+// CreateSilenceHandler silences the alerts of a service, or of the services a
+// selector matches, for a duration (default 1h)
+func (hc *HealthChecker) CreateSilenceHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Service  string   `json:"service"`
+		Selector string   `json:"selector"`
+		Duration Duration `json:"duration"`
+		Comment  string   `json:"comment"`
+		By       string   `json:"by"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody)).Decode(&body); err != nil && err != io.EOF {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	var sel Selector
+	switch {
+	case body.Service != "" && body.Selector != "":
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "give service or selector, not both"})
+		return
+	case body.Service != "":
+		if _, ok := hc.GetService(body.Service); !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": errServiceNotFound.Error()})
+			return
+		}
+		sel = Selector{{Key: "service", Value: body.Service}}
+	default:
+		var err error
+		if sel, err = parseSelector(body.Selector); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+	}
+
+	duration := time.Duration(body.Duration)
+	if duration < 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "duration must be positive"})
+		return
+	}
+	if duration == 0 {
+		duration = defaultSilenceDuration
+	}
+	writeJSON(w, http.StatusCreated, hc.view(hc.Silence(sel, duration, body.Comment, body.By)))
+}
+
+// DeleteSilenceHandler ends a silence early
+func (hc *HealthChecker) DeleteSilenceHandler(w http.ResponseWriter, r *http.Request) {
+	silence, ok := hc.EndSilence(r.PathValue("id"))
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "no active silence " + r.PathValue("id")})
+		return
+	}
+	writeJSON(w, http.StatusOK, hc.view(silence))
+}
@@ -0,0 +1,55 @@
+// silences_test.go
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHeldDownAlertSentWhenSilenceEnds(t *testing.T) {
+	svc := Service{Name: "api", URL: "https://api.example.com"}
+	hc := NewHealthChecker([]Service{svc})
+	alerts := &recordingAlerter{}
+	hc.AddAlerter(alerts)
+
+	sel, err := parseSelector("service=api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	silence := hc.silences.Add(sel, 10*time.Minute, "deploy", "test")
+
+	hc.updateStatusAt("api", failing, now.Add(time.Minute))
+	hc.updateStatusAt("api", failing, now.Add(5*time.Minute))
+	if len(alerts.alerts) != 0 {
+		t.Fatalf("alerts while silenced = %+v, want none", alerts.alerts)
+	}
+
+	hc.updateStatusAt("api", failing, silence.EndsAt.Add(time.Minute))
+	hc.updateStatusAt("api", failing, silence.EndsAt.Add(2*time.Minute))
+	if len(alerts.alerts) != 1 || alerts.alerts[0].State != AlertDown {
+		t.Fatalf("alerts after the silence ended = %+v, want one down alert", alerts.alerts)
+	}
+}
+
+func TestSilencesRoutes(t *testing.T) {
+	hc := NewHealthChecker(nil)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/silences", hc.SilencesHandler)
+	mux.HandleFunc("GET /api/silences/{id}", hc.SilenceHandler)
+	mux.HandleFunc("GET /api/silences/upcoming", hc.UpcomingSilencesHandler)
+
+	for path, want := range map[string]int{
+		"/api/silences":          http.StatusOK,
+		"/api/silences/upcoming": http.StatusOK,
+		"/api/silences/nope":     http.StatusNotFound,
+	} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != want {
+			t.Errorf("GET %s = %d, want %d", path, rec.Code, want)
+		}
+	}
+}
@@ -0,0 +1,449 @@
+// sqlite.go
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const (
+	sqliteQueueSize     = 10000           // results and events waiting to be written
+	sqliteFlushInterval = time.Second     // how often queued writes are committed
+	sqlitePruneInterval = time.Hour       // how often results and events past retention are deleted
+	sqliteBusyTimeout   = 5 * time.Second // how long a write waits for a lock held elsewhere
+)
+
+// sqliteMigrations are applied in order of their number prefix, each once;
+// the last one applied is kept in the database's user_version
+//
+//go:embed migrations/*.sql
+var sqliteMigrations embed.FS
+
+// sqliteStore keeps everything in a single SQLite database file. Results and
+// events are queued and committed in batches by a background writer.
+type sqliteStore struct {
+	db        *sql.DB
+	retention time.Duration
+	queue     chan storeWrite
+	done      chan struct{}
+
+	mu      sync.Mutex
+	closed  bool
+	dropped int // writes dropped because the queue was full, since the last flush
+}
+
+// storeWrite is a queued result or event
+type storeWrite struct {
+	service string
+	record  *CheckRecord
+	event   *Event
+}
+
+func openSQLiteStore(path string, retention time.Duration) (*sqliteStore, error) {
+	// Recursive triggers, so events replaced by INSERT OR REPLACE leave the index too
+	dsn := path + fmt.Sprintf("?_pragma=busy_timeout(%d)&_pragma=journal_mode(WAL)&_pragma=recursive_triggers(1)", sqliteBusyTimeout.Milliseconds())
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// One connection, so writes never wait on each other's locks
+	db.SetMaxOpenConns(1)
+	if err := migrateSQLite(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	s := &sqliteStore{db: db, retention: retention, queue: make(chan storeWrite, sqliteQueueSize), done: make(chan struct{})}
+	s.prune(time.Now())
+	go s.run()
+	return s, nil
+}
+
+// migrateSQLite applies the migrations the database hasn't had yet
+func migrateSQLite(db *sql.DB) error {
+	var current int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&current); err != nil {
+		return err
+	}
+	if current == 0 {
+		// Databases from before migrations were numbered have the search tables
+		// when they're up to date
+		var indexed int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'failures'`).Scan(&indexed); err != nil {
+			return err
+		}
+		if indexed > 0 {
+			current = 2
+			if _, err := db.Exec(`PRAGMA user_version = 2`); err != nil {
+				return err
+			}
+		}
+	}
+
+	files, err := fs.Glob(sqliteMigrations, "migrations/*.sql")
+	if err != nil {
+		return err
+	}
+	latest := 0
+	for _, file := range files { // sorted by name, so by number
+		name := strings.TrimPrefix(file, "migrations/")
+		n, err := strconv.Atoi(strings.SplitN(name, "_", 2)[0])
+		if err != nil {
+			return fmt.Errorf("migration %s: no number prefix", name)
+		}
+		latest = n
+		if n <= current {
+			continue
+		}
+		script, err := sqliteMigrations.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(string(script)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %s: %w", name, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, n)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %s: %w", name, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("migration %s: %w", name, err)
+		}
+		slog.Info("applied storage migration", "migration", name)
+	}
+	if current > latest {
+		return fmt.Errorf("database is at migration %d, newer than this build's %d", current, latest)
+	}
+	return nil
+}
+
+func (s *sqliteStore) AddResult(service string, record CheckRecord) {
+	s.enqueue(storeWrite{service: service, record: &record})
+}
+
+func (s *sqliteStore) AddEvent(e Event) {
+	s.enqueue(storeWrite{event: &e})
+}
+
+// enqueue queues a write, dropping it if the writer has fallen too far behind
+// or the store is closed
+func (s *sqliteStore) enqueue(w storeWrite) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.queue <- w:
+	default:
+		s.dropped++
+	}
+}
+
+// run commits queued writes every sqliteFlushInterval and prunes old rows every
+// sqlitePruneInterval until the queue is closed
+func (s *sqliteStore) run() {
+	defer close(s.done)
+	flush := time.NewTicker(sqliteFlushInterval)
+	defer flush.Stop()
+	prune := time.NewTicker(sqlitePruneInterval)
+	defer prune.Stop()
+
+	var batch []storeWrite
+	for {
+		select {
+		case w, ok := <-s.queue:
+			if !ok {
+				s.write(batch)
+				return
+			}
+			batch = append(batch, w)
+		case <-flush.C:
+			s.write(batch)
+			batch = batch[:0]
+		case now := <-prune.C:
+			s.prune(now)
+		}
+	}
+}
+
+// write commits a batch of queued writes in one transaction
+func (s *sqliteStore) write(batch []storeWrite) {
+	s.mu.Lock()
+	dropped := s.dropped
+	s.dropped = 0
+	s.mu.Unlock()
+	if dropped > 0 {
+		slog.Warn("storage writer fell behind, dropped results and events", "dropped", dropped)
+	}
+	if len(batch) == 0 {
+		return
+	}
+
+	err := s.inTx(func(tx *sql.Tx) error {
+		for _, w := range batch {
+			var err error
+			if r := w.record; r != nil {
+				_, err = tx.Exec(`INSERT INTO results (service, time, healthy, response_time_ms, error, maintenance, trace_id, request_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+					w.service, r.Time.UnixNano(), r.Healthy, r.ResponseTime, r.Error, r.Maintenance, r.TraceID, r.RequestID)
+				if err == nil && r.Error != "" {
+					_, err = tx.Exec(`INSERT INTO failures (service, error, first, last, count) VALUES (?, ?, ?, ?, 1)
+						ON CONFLICT (service, error) DO UPDATE SET first = MIN(first, excluded.first), last = MAX(last, excluded.last), count = count + 1`,
+						w.service, r.Error, r.Time.UnixNano(), r.Time.UnixNano())
+				}
+			} else if e := w.event; e != nil {
+				_, err = tx.Exec(`INSERT OR REPLACE INTO events (id, time, service, type, message, author) VALUES (?, ?, ?, ?, ?, ?)`,
+					e.ID, e.Time.UnixNano(), e.Service, e.Type, e.Message, e.Author)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Warn("writing results and events to storage", "records", len(batch), "error", err)
+	}
+}
+
+// inTx runs fn in a transaction, committing it if fn succeeds
+func (s *sqliteStore) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// prune deletes results, events and errors older than the retention
+func (s *sqliteStore) prune(now time.Time) {
+	cutoff := now.Add(-s.retention).UnixNano()
+	for _, table := range []string{"results", "events"} {
+		if _, err := s.db.Exec(`DELETE FROM `+table+` WHERE time < ?`, cutoff); err != nil {
+			slog.Warn("pruning storage", "table", table, "error", err)
+		}
+	}
+	if _, err := s.db.Exec(`DELETE FROM failures WHERE last < ?`, cutoff); err != nil {
+		slog.Warn("pruning storage", "table", "failures", "error", err)
+	}
+}
+
+func (s *sqliteStore) LoadResults(perService int) (map[string][]CheckRecord, error) {
+	rows, err := s.db.Query(`SELECT service, time, healthy, response_time_ms, error, maintenance, trace_id, request_id FROM (
+		SELECT *, ROW_NUMBER() OVER (PARTITION BY service ORDER BY time DESC) AS n FROM results
+	) WHERE n <= ? ORDER BY service, time`, perService)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := make(map[string][]CheckRecord)
+	for rows.Next() {
+		var service string
+		var at int64
+		var r CheckRecord
+		if err := rows.Scan(&service, &at, &r.Healthy, &r.ResponseTime, &r.Error, &r.Maintenance, &r.TraceID, &r.RequestID); err != nil {
+			return nil, err
+		}
+		r.Time = time.Unix(0, at)
+		results[service] = append(results[service], r)
+	}
+	return results, rows.Err()
+}
+
+func (s *sqliteStore) CountResults(starts []time.Time) (map[string][]ResultCounts, error) {
+	results := make(map[string][]ResultCounts)
+	if len(starts) == 0 {
+		return results, nil
+	}
+
+	// One pass over the longest window, counting every window at once
+	var columns strings.Builder
+	var args []interface{}
+	earliest := starts[0]
+	for _, start := range starts {
+		columns.WriteString(`, COUNT(CASE WHEN time >= ? THEN 1 END)` +
+			`, COUNT(CASE WHEN time >= ? AND NOT healthy AND NOT maintenance THEN 1 END)` +
+			`, COUNT(CASE WHEN time >= ? AND NOT healthy AND maintenance THEN 1 END)` +
+			`, COALESCE(MIN(CASE WHEN time >= ? THEN time END), 0)`)
+		at := start.UnixNano()
+		args = append(args, at, at, at, at)
+		if start.Before(earliest) {
+			earliest = start
+		}
+	}
+	args = append(args, earliest.UnixNano())
+	rows, err := s.db.Query(`SELECT service`+columns.String()+` FROM results WHERE time >= ? GROUP BY service`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var service string
+		counts := make([]ResultCounts, len(starts))
+		firsts := make([]int64, len(starts))
+		dest := []interface{}{&service}
+		for i := range counts {
+			dest = append(dest, &counts[i].Checks, &counts[i].Failures, &counts[i].MaintenanceFailures, &firsts[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		for i, first := range firsts {
+			if first != 0 {
+				counts[i].First = time.Unix(0, first)
+			}
+		}
+		results[service] = counts
+	}
+	return results, rows.Err()
+}
+
+func (s *sqliteStore) LoadEvents(limit int) ([]Event, error) {
+	rows, err := s.db.Query(`SELECT id, time, service, type, message, author FROM (
+		SELECT * FROM events ORDER BY id DESC LIMIT ?
+	) ORDER BY id`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		var e Event
+		var at int64
+		if err := rows.Scan(&e.ID, &at, &e.Service, &e.Type, &e.Message, &e.Author); err != nil {
+			return nil, err
+		}
+		e.Time = time.Unix(0, at)
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// ftsQuery turns search terms into an FTS5 query matching words starting with
+// every term
+func ftsQuery(terms []string) string {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = `"` + strings.ReplaceAll(term, `"`, `""`) + `"*`
+	}
+	return strings.Join(quoted, " ")
+}
+
+func (s *sqliteStore) Search(q SearchQuery) ([]SearchHit, error) {
+	hits := []SearchHit{}
+	match := ftsQuery(q.Terms)
+	where, args := "", []interface{}{match, q.Since.UnixNano()}
+	if q.Service != "" {
+		where, args = " AND service = ?", append(args, q.Service)
+	}
+	args = append(args, q.Limit)
+
+	rows, err := s.db.Query(`SELECT id, time, service, type, message, author FROM events
+		WHERE id IN (SELECT rowid FROM events_fts WHERE events_fts MATCH ?) AND time >= ?`+where+` ORDER BY time DESC LIMIT ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, at int64
+		hit := SearchHit{Kind: SearchEvent}
+		if err := rows.Scan(&id, &at, &hit.Service, &hit.Type, &hit.Text, &hit.Author); err != nil {
+			return nil, err
+		}
+		hit.ID, hit.Time = strconv.FormatInt(id, 10), time.Unix(0, at)
+		hits = append(hits, hit)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = s.db.Query(`SELECT service, error, first, last, count FROM failures
+		WHERE id IN (SELECT rowid FROM failures_fts WHERE failures_fts MATCH ?) AND last >= ?`+where+` ORDER BY last DESC LIMIT ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var first, last int64
+		hit := SearchHit{Kind: SearchError}
+		if err := rows.Scan(&hit.Service, &hit.Text, &first, &last, &hit.Count); err != nil {
+			return nil, err
+		}
+		firstSeen := time.Unix(0, first)
+		hit.Time, hit.FirstSeen = time.Unix(0, last), &firstSeen
+		hits = append(hits, hit)
+	}
+	return hits, rows.Err()
+}
+
+func (s *sqliteStore) SaveState(data []byte) error    { return s.saveDocument("state", data) }
+func (s *sqliteStore) LoadState() ([]byte, error)     { return s.loadDocument("state") }
+func (s *sqliteStore) SaveServices(data []byte) error { return s.saveDocument("services", data) }
+func (s *sqliteStore) LoadServices() ([]byte, error)  { return s.loadDocument("services") }
+func (s *sqliteStore) SaveAPIState(data []byte) error { return s.saveDocument("api", data) }
+func (s *sqliteStore) LoadAPIState() ([]byte, error)  { return s.loadDocument("api") }
+
+func (s *sqliteStore) saveDocument(name string, data []byte) error {
+	_, err := s.db.Exec(`INSERT OR REPLACE INTO documents (name, data, saved_at) VALUES (?, ?, ?)`, name, data, time.Now().UnixNano())
+	return err
+}
+
+func (s *sqliteStore) loadDocument(name string) ([]byte, error) {
+	var data []byte
+	err := s.db.QueryRow(`SELECT data FROM documents WHERE name = ?`, name).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// Close stops the writer once the queue is written out and closes the database.
+// Results and events added after Close are lost.
+// Check takes the database's write lock and releases it again, which fails when
+// the file is read-only or another process holds the lock past the busy timeout
+func (s *sqliteStore) Check(ctx context.Context) error {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+		return err
+	}
+	_, err = conn.ExecContext(ctx, "ROLLBACK")
+	return err
+}
+
+func (s *sqliteStore) Close() error {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.mu.Unlock()
+	<-s.done
+	return s.db.Close()
+}
@@ -0,0 +1,73 @@
+// stale.go
+package main
+
+import (
+	"log/slog"
+	"time"
+)
+
+// staleCheckInterval is how often the watchdog looks for services whose checks stopped
+const staleCheckInterval = 5 * time.Second
+
+// staleAfter is how many intervals may pass without a completed check before a
+// service is marked stale
+const staleAfter = 2
+
+// watchStale marks services stale when their monitor stops reporting, until
+// the checker is stopped
+func (hc *HealthChecker) watchStale() {
+	ticker := time.NewTicker(staleCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-hc.ctx.Done():
+			return
+		case now := <-ticker.C:
+			hc.markStale(now)
+		}
+	}
+}
+
+// markStale flags every running service that hasn't completed a check within
+// staleAfter intervals of its last check or monitor start, or of when a
+// throttling target lets it be checked again, and warns its notifiers
+func (hc *HealthChecker) markStale(now time.Time) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	for name, svc := range hc.services {
+		status := hc.statuses[name]
+		if svc.Paused || status.Stale {
+			continue
+		}
+		started, running := hc.monitorStarts[name]
+		if !running {
+			continue
+		}
+
+		last := status.LastChecked
+		if started.After(last) {
+			last = started
+		}
+		if status.RetryAt != nil && status.RetryAt.After(last) {
+			last = *status.RetryAt // the monitor is waiting out a Retry-After
+		}
+		limit := staleAfter*time.Duration(svc.Interval) + maxCheckDuration(svc)
+		if svc.Type == "agent" || svc.Type == "merged" {
+			limit += agentFlushInterval // results arrive in batches
+		}
+		if now.Sub(last) <= limit {
+			continue
+		}
+
+		status.Stale = true
+		hc.changed(name)
+		message := "no check completed for " + now.Sub(last).Round(time.Second).String() + "; monitor may be stalled"
+		if svc.Type == "agent" || svc.Type == "merged" {
+			message = "no results from agents for " + now.Sub(last).Round(time.Second).String() + "; agents may be offline"
+		}
+		slog.Error("service stale", "service", name, "detail", message)
+		hc.events.Add(Event{Time: now, Service: name, Type: EventSchedulerStall, Message: message})
+		hc.warn(status, EventSchedulerStall, message, now)
+	}
+}
@@ -0,0 +1,36 @@
+// stale_test.go
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStaleServiceWarns(t *testing.T) {
+	svc := Service{Name: "api", URL: "https://api.example.com", Interval: Duration(30 * time.Second), Timeout: Duration(5 * time.Second)}
+	hc := NewHealthChecker([]Service{svc})
+	alerts := &recordingAlerter{}
+	hc.AddAlerter(alerts)
+
+	t0 := time.Now()
+	hc.updateStatusAt("api", passing, t0)
+	hc.monitorStarts["api"] = t0
+
+	hc.markStale(t0.Add(time.Minute))
+	if hc.statuses["api"].Stale || len(alerts.alerts) != 0 {
+		t.Fatalf("stale = %v, alerts = %+v within two intervals", hc.statuses["api"].Stale, alerts.alerts)
+	}
+
+	hc.markStale(t0.Add(2 * time.Minute))
+	hc.markStale(t0.Add(3 * time.Minute))
+	if !hc.statuses["api"].Stale {
+		t.Fatal("service not marked stale after two intervals without a check")
+	}
+	if len(alerts.alerts) != 1 {
+		t.Fatalf("alerts = %+v, want one warning", alerts.alerts)
+	}
+	if got := alerts.alerts[0]; got.State != AlertWarning || got.Reason != EventSchedulerStall || !strings.HasPrefix(got.Warning, "no check completed for 2m0s") {
+		t.Errorf("warning = %+v", got)
+	}
+}
@@ -0,0 +1,181 @@
+// state.go
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// stateSaveInterval is how often the last-known state is written to disk
+const stateSaveInterval = 15 * time.Second
+
+// savedState is the on-disk form of the last-known statuses, incidents and silences
+type savedState struct {
+	SavedAt   time.Time                `json:"saved_at"`
+	Statuses  map[string]*HealthStatus `json:"statuses"`
+	Incidents map[string][]*Incident   `json:"incidents"` // resolved incidents
+	Counts    map[string]CheckCounts   `json:"counts"`
+	Revision  uint64                   `json:"revision"`
+	Silences  []*Silence               `json:"silences"`
+	// State changes counted towards flapping, which /status doesn't show
+	Transitions map[string][]time.Time `json:"transitions,omitempty"`
+}
+
+// SaveState writes the current statuses and incidents to path, replacing it atomically
+func (hc *HealthChecker) SaveState(path string) error {
+	data, err := hc.marshalState()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// marshalState encodes the current state as saved by SaveState
+func (hc *HealthChecker) marshalState() ([]byte, error) {
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+	transitions := make(map[string][]time.Time)
+	for name, status := range hc.statuses {
+		if len(status.transitions) > 0 {
+			transitions[name] = status.transitions
+		}
+	}
+	return json.Marshal(savedState{
+		SavedAt:     time.Now(),
+		Statuses:    hc.statuses,
+		Incidents:   hc.incidents,
+		Counts:      hc.counts,
+		Revision:    hc.revision,
+		Silences:    hc.silences.Active(),
+		Transitions: transitions,
+	})
+}
+
+// writeFileAtomic writes data to a temporary file and renames it over path, so
+// readers never see a partially written file
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadState restores statuses and incidents saved by SaveState for services that are
+// still configured. Open incidents carry over, so they aren't reported again.
+// A missing file is not an error. Must be called before Start.
+func (hc *HealthChecker) LoadState(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return hc.restoreState(data)
+}
+
+// restoreState restores a state encoded by marshalState, as LoadState does
+func (hc *HealthChecker) restoreState(data []byte) error {
+	var state savedState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	restored := 0
+	for name, saved := range state.Statuses {
+		status, ok := hc.statuses[name]
+		if !ok || saved.Pending {
+			continue
+		}
+		status.Healthy = saved.Healthy
+		status.ResponseTime = saved.ResponseTime
+		status.LastChecked = saved.LastChecked
+		status.Error = saved.Error
+		status.ErrorInfo = saved.ErrorInfo
+		status.DNSError = saved.DNSError
+		status.BotChallenge = saved.BotChallenge
+		status.Throttled = saved.Throttled
+		status.RetryAt = saved.RetryAt
+		status.TLS = saved.TLS
+		status.CertDays = saved.CertDays
+		status.Warnings = saved.Warnings
+		status.ClockSkew = saved.ClockSkew
+		status.Ping = saved.Ping
+		status.Canary = saved.Canary
+		status.DNS = saved.DNS
+		status.Versions = saved.Versions
+		status.SkewSince = saved.SkewSince
+		status.ConsecutiveFailures = saved.ConsecutiveFailures
+		status.ConsecutiveSuccesses = saved.ConsecutiveSuccesses
+		status.Flapping = saved.Flapping
+		status.StateChanges = saved.StateChanges
+		status.transitions = state.Transitions[name]
+		status.Incident = saved.Incident
+		status.Revision = saved.Revision
+		status.Pending = false
+		restored++
+	}
+	// Revisions continue from the saved one, so clients never see them go back
+	hc.revision = state.Revision
+	hc.silences.restore(state.Silences)
+	for name, incidents := range state.Incidents {
+		if _, ok := hc.services[name]; ok {
+			hc.incidents[name] = incidents
+		}
+	}
+	for name, counts := range state.Counts {
+		if _, ok := hc.services[name]; ok {
+			hc.counts[name] = counts
+		}
+	}
+
+	slog.Info("restored state", "services", restored, "saved_ago", time.Since(state.SavedAt).Round(time.Second).String())
+	return nil
+}
+
+// PersistState saves the state to path every stateSaveInterval. It never returns.
+func (hc *HealthChecker) PersistState(path string) {
+	for range time.Tick(stateSaveInterval) {
+		if err := hc.SaveState(path); err != nil {
+			slog.Warn("saving state", "path", path, "error", err)
+		}
+	}
+}
+
+// PersistStoreState saves the state to the store every stateSaveInterval. It
+// never returns.
+func (hc *HealthChecker) PersistStoreState() {
+	for range time.Tick(stateSaveInterval) {
+		if err := hc.SaveStoreState(); err != nil {
+			slog.Warn("saving state to storage", "error", err)
+		}
+	}
+}
+
+// SaveStoreState saves the current state to the store
+func (hc *HealthChecker) SaveStoreState() error {
+	data, err := hc.marshalState()
+	if err != nil {
+		return err
+	}
+	return hc.store.SaveState(data)
+}
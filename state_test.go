@@ -0,0 +1,39 @@
+// state_test.go
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStateRestoresStreaksAndFlapping(t *testing.T) {
+	svc := Service{Name: "api", URL: "https://api.example.com", FailureThreshold: 3, FlapThreshold: 1}
+	hc := NewHealthChecker([]Service{svc})
+	t0 := time.Now().Add(-time.Minute)
+	for i, result := range []CheckResult{passing, failing, failing, failing, passing, failing, failing} {
+		hc.updateStatusAt("api", result, t0.Add(time.Duration(i)*time.Second))
+	}
+	before := *hc.statuses["api"]
+	if !before.Flapping || before.ConsecutiveFailures != 2 {
+		t.Fatalf("before saving: flapping = %v, failures = %d, want flapping with 2", before.Flapping, before.ConsecutiveFailures)
+	}
+
+	data, err := hc.marshalState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewHealthChecker([]Service{svc})
+	if err := restored.restoreState(data); err != nil {
+		t.Fatal(err)
+	}
+	status := restored.statuses["api"]
+	if status.ConsecutiveFailures != 2 || status.ConsecutiveSuccesses != 0 || !status.Flapping || status.StateChanges != before.StateChanges || len(status.transitions) != len(before.transitions) {
+		t.Fatalf("restored status = %+v, want the streaks and flap state of %+v", status, before)
+	}
+
+	// The restored streak still counts towards failure_threshold
+	restored.updateStatusAt("api", failing, t0.Add(10*time.Second))
+	if status := restored.statuses["api"]; status.Healthy || status.ConsecutiveFailures != 3 {
+		t.Errorf("after one more failure: healthy = %v, failures = %d, want down with 3", status.Healthy, status.ConsecutiveFailures)
+	}
+}
@@ -0,0 +1,101 @@
+// statuscodes.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// StatusRange is an inclusive range of HTTP status codes. In config it is a code
+// (401), a range ("200-399") or a class ("3xx").
+type StatusRange struct {
+	Min, Max int
+}
+
+// defaultExpectedStatus is used when a service sets no expected_status
+var defaultExpectedStatus = []StatusRange{{200, 299}}
+
+// parseStatusRange parses the string forms of a StatusRange
+func parseStatusRange(s string) (StatusRange, error) {
+	s = strings.TrimSpace(s)
+	if len(s) == 3 && strings.HasSuffix(strings.ToLower(s), "xx") && s[0] >= '1' && s[0] <= '5' {
+		class := int(s[0]-'0') * 100
+		return StatusRange{class, class + 99}, nil
+	}
+	from, to, isRange := strings.Cut(s, "-")
+	lo, err := strconv.Atoi(strings.TrimSpace(from))
+	if err != nil {
+		return StatusRange{}, fmt.Errorf("invalid status %q", s)
+	}
+	hi := lo
+	if isRange {
+		if hi, err = strconv.Atoi(strings.TrimSpace(to)); err != nil {
+			return StatusRange{}, fmt.Errorf("invalid status range %q", s)
+		}
+	}
+	if lo < 100 || hi > 599 || lo > hi {
+		return StatusRange{}, fmt.Errorf("status %q outside 100-599", s)
+	}
+	return StatusRange{lo, hi}, nil
+}
+
+// UnmarshalJSON accepts a status code number or a range string
+func (r *StatusRange) UnmarshalJSON(data []byte) error {
+	var code int
+	if err := json.Unmarshal(data, &code); err == nil {
+		data, _ = json.Marshal(strconv.Itoa(code))
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return errors.New(`expected status must be a code or a range such as "200-399"`)
+	}
+	parsed, err := parseStatusRange(s)
+	if err != nil {
+		return err
+	}
+	*r = parsed
+	return nil
+}
+
+// MarshalJSON writes a single code as a number and a range as "min-max"
+func (r StatusRange) MarshalJSON() ([]byte, error) {
+	if r.Min == r.Max {
+		return json.Marshal(r.Min)
+	}
+	return json.Marshal(r.String())
+}
+
+// String formats the range as it is written in config
+func (r StatusRange) String() string {
+	if r.Min == r.Max {
+		return strconv.Itoa(r.Min)
+	}
+	return fmt.Sprintf("%d-%d", r.Min, r.Max)
+}
+
+// statusExpected reports whether code is in one of the ranges, or 2xx when there are none
+func statusExpected(ranges []StatusRange, code int) bool {
+	if len(ranges) == 0 {
+		ranges = defaultExpectedStatus
+	}
+	for _, r := range ranges {
+		if code >= r.Min && code <= r.Max {
+			return true
+		}
+	}
+	return false
+}
+
+// expectsRedirect reports whether any range includes a 3xx code, in which case
+// the check looks at the redirect itself rather than following it
+func expectsRedirect(ranges []StatusRange) bool {
+	for _, r := range ranges {
+		if r.Min <= 399 && r.Max >= 300 {
+			return true
+		}
+	}
+	return false
+}
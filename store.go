@@ -0,0 +1,172 @@
+// store.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// Storage backends
+var storageTypes = []string{"memory", "sqlite"}
+
+// defaultStorageRetention is how long stored results and events are kept when
+// storage.retention is unset
+const defaultStorageRetention = 30 * 24 * time.Hour
+
+// StorageConfig selects where check results, events, state and runtime-managed
+// services are kept
+type StorageConfig struct {
+	Type      string   `json:"type,omitempty"`      // memory (default) or sqlite
+	Path      string   `json:"path,omitempty"`      // sqlite: the database file
+	Retention Duration `json:"retention,omitempty"` // how long results and events are kept, default 30d
+}
+
+// Validate checks the storage settings
+func (c StorageConfig) Validate() []ValidationError {
+	var errs []ValidationError
+	add := func(name, format string, args ...interface{}) {
+		errs = append(errs, ValidationError{Field: "storage." + name, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if c.Type != "" && !containsString(storageTypes, c.Type) {
+		add("type", "must be one of %s", strings.Join(storageTypes, ", "))
+	}
+	if c.Type == "sqlite" && c.Path == "" {
+		add("path", "is required for sqlite storage")
+	}
+	if c.Type != "sqlite" && c.Path != "" {
+		add("path", "only used with sqlite storage")
+	}
+	if c.Retention < 0 {
+		add("retention", "must not be negative")
+	}
+	return errs
+}
+
+// durable reports whether the backend keeps data across restarts
+func (c StorageConfig) durable() bool {
+	return c.Type != "" && c.Type != "memory"
+}
+
+// retention returns how long results and events are kept
+func (c StorageConfig) retention() time.Duration {
+	if c.Retention > 0 {
+		return time.Duration(c.Retention)
+	}
+	return defaultStorageRetention
+}
+
+// Store keeps check results, events, the last-known state and services added
+// through the API. Results and events are written in the background, so
+// AddResult and AddEvent never block a check; state, services and the service
+// API's ETag key and idempotency keys are JSON documents, stored as given.
+type Store interface {
+	AddResult(service string, record CheckRecord)
+	AddEvent(e Event)
+
+	// LoadResults returns up to perService of each service's most recent
+	// results, oldest first; LoadEvents the most recent limit events
+	LoadResults(perService int) (map[string][]CheckRecord, error)
+	LoadEvents(limit int) ([]Event, error)
+
+	// CountResults counts each service's results since every start time. It
+	// returns nil when the backend doesn't keep results.
+	CountResults(starts []time.Time) (map[string][]ResultCounts, error)
+
+	// Search returns the events and errors matching q, or nil when the backend
+	// doesn't index them
+	Search(q SearchQuery) ([]SearchHit, error)
+
+	// Load returns nil when nothing was saved
+	SaveState(data []byte) error
+	LoadState() ([]byte, error)
+	SaveServices(data []byte) error
+	LoadServices() ([]byte, error)
+	SaveAPIState(data []byte) error
+	LoadAPIState() ([]byte, error)
+
+	// Check verifies the backend can be written to
+	Check(ctx context.Context) error
+
+	// Close writes out what's still queued
+	Close() error
+}
+
+// OpenStore opens the configured backend
+func OpenStore(c StorageConfig) (Store, error) {
+	switch c.Type {
+	case "", "memory":
+		return memoryStore{}, nil
+	case "sqlite":
+		return openSQLiteStore(c.Path, c.retention())
+	default:
+		return nil, fmt.Errorf("unknown storage type %q", c.Type)
+	}
+}
+
+// UseStore writes results and events to s from now on, after restoring the
+// history of configured services and the event log from it. The last-known
+// state is restored separately, with LoadStoreState. Must be called before Start.
+func (hc *HealthChecker) UseStore(s Store) error {
+	results, err := s.LoadResults(historySize)
+	if err != nil {
+		return fmt.Errorf("loading results: %w", err)
+	}
+	events, err := s.LoadEvents(eventLogSize)
+	if err != nil {
+		return fmt.Errorf("loading events: %w", err)
+	}
+
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	restored := 0
+	for name, records := range results {
+		if _, ok := hc.services[name]; ok {
+			hc.history[name] = records
+			restored += len(records)
+		}
+	}
+	hc.events.restore(events)
+	hc.store = s
+	hc.events.store = s
+	if restored > 0 || len(events) > 0 {
+		slog.Info("restored from storage", "results", restored, "events", len(events))
+	}
+	return nil
+}
+
+// LoadStoreState restores the state last saved to the store by PersistStoreState,
+// as LoadState does from a file. Must be called before Start.
+func (hc *HealthChecker) LoadStoreState() error {
+	data, err := hc.store.LoadState()
+	if err != nil || data == nil {
+		return err
+	}
+	return hc.restoreState(data)
+}
+
+// memoryStore keeps nothing beyond what the checker holds in memory, so results
+// and events are lost on restart
+type memoryStore struct{}
+
+func (memoryStore) AddResult(string, CheckRecord) {}
+func (memoryStore) AddEvent(Event)                {}
+
+func (memoryStore) LoadResults(int) (map[string][]CheckRecord, error) { return nil, nil }
+func (memoryStore) LoadEvents(int) ([]Event, error)                   { return nil, nil }
+
+func (memoryStore) CountResults([]time.Time) (map[string][]ResultCounts, error) { return nil, nil }
+func (memoryStore) Search(SearchQuery) ([]SearchHit, error)                     { return nil, nil }
+
+func (memoryStore) SaveState([]byte) error        { return nil }
+func (memoryStore) LoadState() ([]byte, error)    { return nil, nil }
+func (memoryStore) SaveServices([]byte) error     { return nil }
+func (memoryStore) LoadServices() ([]byte, error) { return nil, nil }
+func (memoryStore) SaveAPIState([]byte) error     { return nil }
+func (memoryStore) LoadAPIState() ([]byte, error) { return nil, nil }
+func (memoryStore) Check(context.Context) error   { return nil }
+func (memoryStore) Close() error                  { return nil }
@@ -0,0 +1,84 @@
+// targetauth.go
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// AuthConfig holds the credentials an HTTP check sends: basic auth, or a token
+// sent as a bearer token or in a header of its own. Secrets can be given
+// directly or, to keep them out of the config, named by environment variable.
+type AuthConfig struct {
+	Username    string `json:"username,omitempty"`
+	Password    string `json:"password,omitempty"`
+	PasswordEnv string `json:"password_env,omitempty"`
+	Token       string `json:"token,omitempty"`
+	TokenEnv    string `json:"token_env,omitempty"`
+	Header      string `json:"header,omitempty"` // e.g. X-Api-Key; default Authorization: Bearer
+}
+
+// password returns the basic auth password, from PasswordEnv when set
+func (a AuthConfig) password() string {
+	if a.PasswordEnv != "" {
+		return os.Getenv(a.PasswordEnv)
+	}
+	return a.Password
+}
+
+// token returns the token, from TokenEnv when set
+func (a AuthConfig) token() string {
+	if a.TokenEnv != "" {
+		return os.Getenv(a.TokenEnv)
+	}
+	return a.Token
+}
+
+// apply adds the credentials to a request
+func (a AuthConfig) apply(req *http.Request) {
+	switch {
+	case a.Username != "":
+		req.SetBasicAuth(a.Username, a.password())
+	case a.Header != "":
+		req.Header.Set(a.Header, a.token())
+	default:
+		req.Header.Set("Authorization", "Bearer "+a.token())
+	}
+}
+
+// validate checks an auth definition; prefix is prepended to field names.
+// Referenced environment variables must be set.
+func (a AuthConfig) validate(prefix string) []ValidationError {
+	var errs []ValidationError
+	add := func(name, format string, args ...interface{}) {
+		errs = append(errs, ValidationError{Field: prefix + name, Message: fmt.Sprintf(format, args...)})
+	}
+
+	basic := a.Username != "" || a.Password != "" || a.PasswordEnv != ""
+	token := a.Token != "" || a.TokenEnv != ""
+	switch {
+	case basic && token:
+		add("token", "use either username and password or a token, not both")
+	case basic && a.Username == "":
+		add("username", "username is required for basic auth")
+	case !basic && !token:
+		add("token", "give username and password, or a token")
+	}
+	if a.Password != "" && a.PasswordEnv != "" {
+		add("password_env", "use either password or password_env, not both")
+	}
+	if a.Token != "" && a.TokenEnv != "" {
+		add("token_env", "use either token or token_env, not both")
+	}
+	if a.Header != "" && basic {
+		add("header", "only used with a token")
+	}
+	if a.PasswordEnv != "" && os.Getenv(a.PasswordEnv) == "" {
+		add("password_env", "environment variable %s is not set", a.PasswordEnv)
+	}
+	if a.TokenEnv != "" && os.Getenv(a.TokenEnv) == "" {
+		add("token_env", "environment variable %s is not set", a.TokenEnv)
+	}
+	return errs
+}
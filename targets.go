@@ -0,0 +1,184 @@
+// targets.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// TargetPolicy limits which hosts the checker may probe, so a typo or a
+// discovered service can't point checks at a third party or at something that
+// mustn't take extra load. Entries are hostnames, *.domain wildcards, IP
+// addresses or CIDR ranges. Hostnames are matched by name, not resolved, so
+// address entries only match targets given as IP addresses.
+type TargetPolicy struct {
+	// When set, every target must match an entry
+	Allow []string `json:"allow,omitempty"`
+
+	// Targets matching an entry are refused, even if allowed
+	Deny []string `json:"deny,omitempty"`
+}
+
+// activeTargets is the policy of the loaded config, which redirects followed by
+// checks are held to as well
+var activeTargets TargetPolicy
+
+// maxRedirects is how many redirects a check follows, as net/http does by default
+const maxRedirects = 10
+
+// probeClient returns a client for checks that follows redirects only to hosts
+// the target policy allows
+func probeClient(transport http.RoundTripper) *http.Client {
+	return &http.Client{Transport: transport, CheckRedirect: checkRedirect}
+}
+
+// checkRedirect refuses a redirect to a host the target policy refuses
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if err := activeTargets.Allowed(req.URL.Hostname()); err != nil {
+		return fmt.Errorf("redirect refused: %w", err)
+	}
+	if len(via) >= maxRedirects {
+		return errors.New("stopped after 10 redirects")
+	}
+	return nil
+}
+
+// Validate checks the policy's entries
+func (p TargetPolicy) Validate() []ValidationError {
+	var errs []ValidationError
+	for _, list := range []struct {
+		name    string
+		entries []string
+	}{{"allow", p.Allow}, {"deny", p.Deny}} {
+		for i, entry := range list.entries {
+			if err := validateTargetEntry(entry); err != nil {
+				errs = append(errs, ValidationError{Field: fmt.Sprintf("targets.%s[%d]", list.name, i), Message: err.Error()})
+			}
+		}
+	}
+	return errs
+}
+
+// validateTargetEntry checks that an entry is a CIDR range, an IP address, a
+// hostname or a *.domain wildcard
+func validateTargetEntry(entry string) error {
+	if strings.Contains(entry, "/") {
+		if _, _, err := net.ParseCIDR(entry); err != nil {
+			return fmt.Errorf("invalid CIDR range %q", entry)
+		}
+		return nil
+	}
+	name := strings.TrimPrefix(entry, "*.")
+	if name == "" || strings.ContainsAny(name, "*:/ ") && net.ParseIP(entry) == nil {
+		return fmt.Errorf("must be a hostname, *.domain, IP address or CIDR range, not %q", entry)
+	}
+	return nil
+}
+
+// matchTarget reports whether host matches a policy entry
+func matchTarget(entry, host string) bool {
+	if ip := net.ParseIP(host); ip != nil {
+		if _, cidr, err := net.ParseCIDR(entry); err == nil {
+			return cidr.Contains(ip)
+		}
+		return ip.Equal(net.ParseIP(entry))
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	entry = strings.ToLower(strings.TrimSuffix(entry, "."))
+	if domain, ok := strings.CutPrefix(entry, "*."); ok {
+		return strings.HasSuffix(host, "."+domain)
+	}
+	return host == entry
+}
+
+// Allowed returns why the policy refuses host, or nil when it may be probed
+func (p TargetPolicy) Allowed(host string) error {
+	for _, entry := range p.Deny {
+		if matchTarget(entry, host) {
+			return fmt.Errorf("host %q is denied by targets.deny entry %q", host, entry)
+		}
+	}
+	if len(p.Allow) == 0 {
+		return nil
+	}
+	for _, entry := range p.Allow {
+		if matchTarget(entry, host) {
+			return nil
+		}
+	}
+	return fmt.Errorf("host %q is not in targets.allow", host)
+}
+
+// targetHost returns the host of a URL, host:port or bare host
+func targetHost(target string) string {
+	if strings.Contains(target, "://") {
+		if u, err := url.Parse(target); err == nil {
+			return u.Hostname()
+		}
+		return ""
+	}
+	if host, _, err := net.SplitHostPort(target); err == nil {
+		return host
+	}
+	return strings.Trim(target, "[]")
+}
+
+// serviceTargets returns the hosts a service's checks connect to, by the field
+// naming each. The name a DNS check resolves isn't one; its resolver is.
+func serviceTargets(svc Service) map[string]string {
+	targets := make(map[string]string)
+	add := func(field, target string) {
+		if host := targetHost(target); host != "" {
+			targets[field] = host
+		}
+	}
+
+	switch svc.Type {
+	case "heartbeat", "exec", "agent", "merged":
+	case "dns":
+		add("resolver", svc.Resolver)
+	default:
+		add("url", svc.URL)
+	}
+	add("connect_to", svc.ConnectTo)
+	if svc.Canary != nil {
+		add("canary.baseline_url", svc.Canary.BaselineURL)
+	}
+	if svc.Login != nil {
+		add("login.url", svc.Login.URL)
+	}
+	for i, resolver := range svc.GeoResolvers {
+		add(fmt.Sprintf("geo_resolvers[%d]", i), resolver)
+	}
+	return targets
+}
+
+// ValidateService returns an error for each host of a service the policy
+// refuses; prefix is prepended to field names
+func (p TargetPolicy) ValidateService(svc Service, prefix string) []ValidationError {
+	if len(p.Allow) == 0 && len(p.Deny) == 0 {
+		return nil
+	}
+	var errs []ValidationError
+	targets := serviceTargets(svc)
+	for _, field := range slices.Sorted(maps.Keys(targets)) {
+		if err := p.Allowed(targets[field]); err != nil {
+			errs = append(errs, ValidationError{Field: prefix + field, Message: err.Error()})
+		}
+	}
+	return errs
+}
+
+// Check returns why the policy refuses a service, or nil when all its hosts may be probed
+func (p TargetPolicy) Check(svc Service) error {
+	if errs := p.ValidateService(svc, ""); len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
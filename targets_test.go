@@ -0,0 +1,95 @@
+// targets_test.go
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMatchTarget(t *testing.T) {
+	tests := []struct {
+		entry, host string
+		want        bool
+	}{
+		{"api.example.com", "api.example.com", true},
+		{"api.example.com", "API.Example.com.", true},
+		{"api.example.com", "www.example.com", false},
+		{"*.example.com", "api.example.com", true},
+		{"*.example.com", "a.b.example.com", true},
+		{"*.example.com", "example.com", false},
+		{"*.example.com", "badexample.com", false},
+		{"10.0.0.0/8", "10.1.2.3", true},
+		{"10.0.0.0/8", "192.168.1.1", false},
+		{"10.0.0.0/8", "ten.example.com", false}, // hostnames aren't resolved
+		{"2001:db8::/32", "2001:db8::1", true},
+		{"192.0.2.7", "192.0.2.7", true},
+		{"192.0.2.7", "192.0.2.8", false},
+		{"::1", "0:0:0:0:0:0:0:1", true},
+	}
+	for _, tt := range tests {
+		if got := matchTarget(tt.entry, tt.host); got != tt.want {
+			t.Errorf("matchTarget(%q, %q) = %v, want %v", tt.entry, tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestTargetPolicyAllowed(t *testing.T) {
+	policy := TargetPolicy{Allow: []string{"*.example.com", "10.0.0.0/8"}, Deny: []string{"admin.example.com", "10.9.0.0/16"}}
+	tests := []struct {
+		host string
+		want string // start of the error, empty when allowed
+	}{
+		{"api.example.com", ""},
+		{"10.1.2.3", ""},
+		{"admin.example.com", `host "admin.example.com" is denied by targets.deny entry "admin.example.com"`},
+		{"10.9.1.1", `host "10.9.1.1" is denied`},
+		{"api.other.com", `host "api.other.com" is not in targets.allow`},
+		{"192.168.1.1", `host "192.168.1.1" is not in targets.allow`},
+	}
+	for _, tt := range tests {
+		err := policy.Allowed(tt.host)
+		if tt.want == "" && err != nil || tt.want != "" && (err == nil || !strings.HasPrefix(err.Error(), tt.want)) {
+			t.Errorf("Allowed(%q) = %v, want %q", tt.host, err, tt.want)
+		}
+	}
+
+	if err := (TargetPolicy{Deny: []string{"admin.example.com"}}).Allowed("anything.test"); err != nil {
+		t.Errorf("a deny-only policy refused an unlisted host: %v", err)
+	}
+}
+
+func TestRedirectsFollowTargetPolicy(t *testing.T) {
+	defer func(saved TargetPolicy) { activeTargets = saved }(activeTargets)
+
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+	// Two hops: to localhost, then on to the target's address
+	redirector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			http.Redirect(w, r, strings.Replace(r.Host, "127.0.0.1", "http://localhost", 1)+"/next", http.StatusFound)
+			return
+		}
+		http.Redirect(w, r, target.URL+"/health", http.StatusFound)
+	}))
+	defer redirector.Close()
+	svc := Service{Name: "api", URL: redirector.URL + "/health"}
+
+	activeTargets = TargetPolicy{Allow: []string{"127.0.0.1", "localhost"}}
+	if err := probeHTTP(context.Background(), svc, &CheckResult{}); err != nil {
+		t.Errorf("redirects within the policy: %v", err)
+	}
+
+	activeTargets = TargetPolicy{Allow: []string{"127.0.0.1"}}
+	err := probeHTTP(context.Background(), svc, &CheckResult{})
+	if err == nil || !strings.Contains(err.Error(), `redirect refused: host "localhost" is not in targets.allow`) {
+		t.Errorf("redirect to a host outside the policy = %v, want it refused", err)
+	}
+
+	activeTargets = TargetPolicy{Deny: []string{"localhost"}}
+	if err := probeHTTP(context.Background(), svc, &CheckResult{}); err == nil {
+		t.Error("followed a redirect to a denied host")
+	}
+}
@@ -0,0 +1,54 @@
+// thresholds.go
+package main
+
+import "fmt"
+
+// countStreak extends the run of consecutive successes or failures
+func (s *HealthStatus) countStreak(healthy bool) {
+	if healthy {
+		s.ConsecutiveSuccesses++
+		s.ConsecutiveFailures = 0
+	} else {
+		s.ConsecutiveFailures++
+		s.ConsecutiveSuccesses = 0
+	}
+}
+
+// threshold returns n, or 1 when unset
+func threshold(n int) int {
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+// holdTransition keeps a service's status until its streak reaches the failure or
+// success threshold. A failure held back reports the service healthy with a
+// warning; a success held back keeps the previous error. Services that haven't
+// been checked yet take the first result as is. Must be called after countStreak.
+func holdTransition(svc Service, status *HealthStatus, result CheckResult) CheckResult {
+	if status.Pending || result.Healthy == status.Healthy {
+		return result
+	}
+	held := result
+	held.Healthy = status.Healthy
+	held.Warnings = append([]string{}, result.Warnings...)
+	if result.Healthy {
+		needed := threshold(svc.SuccessThreshold)
+		if status.ConsecutiveSuccesses >= needed {
+			return result
+		}
+		held.Error, held.ErrorInfo = status.Error, status.ErrorInfo
+		held.Warnings = append(held.Warnings,
+			fmt.Sprintf("recovering: %d of %d consecutive successes", status.ConsecutiveSuccesses, needed))
+		return held
+	}
+	needed := threshold(svc.FailureThreshold)
+	if status.ConsecutiveFailures >= needed {
+		return result
+	}
+	held.Error, held.ErrorInfo = "", nil
+	held.Warnings = append(held.Warnings,
+		fmt.Sprintf("failing: %d of %d consecutive failures: %s", status.ConsecutiveFailures, needed, result.Error))
+	return held
+}
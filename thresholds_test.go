@@ -0,0 +1,78 @@
+// thresholds_test.go
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestThresholdsHoldStatus(t *testing.T) {
+	svc := Service{Name: "api", URL: "https://api.example.com", FailureThreshold: 3, SuccessThreshold: 2}
+	hc := NewHealthChecker([]Service{svc})
+	alerts := &recordingAlerter{}
+	hc.AddAlerter(alerts)
+
+	steps := []struct {
+		result              CheckResult
+		healthy             bool
+		failures, successes int
+		warning             string
+	}{
+		{passing, true, 0, 1, ""},
+		{failing, true, 1, 0, "failing: 1 of 3 consecutive failures: HTTP 503"},
+		{failing, true, 2, 0, "failing: 2 of 3 consecutive failures: HTTP 503"},
+		// A success resets the failure streak
+		{passing, true, 0, 1, ""},
+		{failing, true, 1, 0, "failing: 1 of 3"},
+		{failing, true, 2, 0, "failing: 2 of 3"},
+		{failing, false, 3, 0, ""},
+		{passing, false, 0, 1, "recovering: 1 of 2 consecutive successes"},
+		{failing, false, 1, 0, ""},
+		{passing, false, 0, 1, "recovering: 1 of 2"},
+		{passing, true, 0, 2, ""},
+	}
+	t0 := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	for i, step := range steps {
+		hc.updateStatusAt("api", step.result, t0.Add(time.Duration(i)*time.Minute))
+		status := hc.statuses["api"]
+		if status.Healthy != step.healthy || status.ConsecutiveFailures != step.failures || status.ConsecutiveSuccesses != step.successes {
+			t.Fatalf("check %d: healthy = %v, streaks = %d failures and %d successes, want %v, %d and %d",
+				i, status.Healthy, status.ConsecutiveFailures, status.ConsecutiveSuccesses, step.healthy, step.failures, step.successes)
+		}
+		if !hasWarningPrefix(status.Warnings, step.warning) {
+			t.Errorf("check %d: warnings = %q, want one starting %q", i, status.Warnings, step.warning)
+		}
+		if !step.healthy && status.Error != "HTTP 503" {
+			t.Errorf("check %d: error = %q, want the failure kept while recovering", i, status.Error)
+		}
+	}
+
+	if len(alerts.alerts) != 2 || alerts.alerts[0].State != AlertDown || alerts.alerts[1].State != AlertRecovered {
+		t.Errorf("alerts = %+v, want one down and one recovered", alerts.alerts)
+	}
+}
+
+func TestThresholdsDontHoldFirstCheck(t *testing.T) {
+	svc := Service{Name: "api", URL: "https://api.example.com", FailureThreshold: 3}
+	hc := NewHealthChecker([]Service{svc})
+
+	hc.updateStatusAt("api", failing, time.Now())
+	if status := hc.statuses["api"]; status.Healthy || status.ConsecutiveFailures != 1 {
+		t.Errorf("first check failing: healthy = %v, failures = %d, want down straight away", status.Healthy, status.ConsecutiveFailures)
+	}
+}
+
+// hasWarningPrefix reports whether a warning starts with prefix, or there are
+// none when prefix is empty
+func hasWarningPrefix(warnings []string, prefix string) bool {
+	if prefix == "" {
+		return len(warnings) == 0
+	}
+	for _, w := range warnings {
+		if strings.HasPrefix(w, prefix) {
+			return true
+		}
+	}
+	return false
+}
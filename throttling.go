@@ -0,0 +1,117 @@
+// throttling.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// What an HTTP check does when the target throttles it, answering 429, or 503
+// with Retry-After: fail (default) as on any other status; backoff, which
+// fails the check as throttled and waits as long as Retry-After asks before
+// the next; or skip, which backs off the same way but keeps the service's
+// state, so throttling doesn't count toward failure_threshold
+var throttlingModes = []string{"fail", "backoff", "skip"}
+
+// maxRetryAfter caps how long a Retry-After can put off a service's next check
+const maxRetryAfter = time.Hour
+
+// throttledError is a 429 or 503 response asking the checker to slow down
+type throttledError struct {
+	status     int
+	retryAfter time.Duration // 0 when the response didn't say
+}
+
+func (e *throttledError) Error() string {
+	if e.retryAfter > 0 {
+		return fmt.Sprintf("throttled by target (HTTP %d), retry after %s", e.status, e.retryAfter)
+	}
+	return fmt.Sprintf("throttled by target (HTTP %d)", e.status)
+}
+
+// detectThrottling returns the throttling a response signals, or nil. A 503
+// only counts with Retry-After, since without it it's an outage.
+func detectThrottling(resp *http.Response, now time.Time) *throttledError {
+	retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After"), now)
+	if resp.StatusCode != http.StatusTooManyRequests && (resp.StatusCode != http.StatusServiceUnavailable || !ok) {
+		return nil
+	}
+	return &throttledError{status: resp.StatusCode, retryAfter: min(retryAfter, maxRetryAfter)}
+}
+
+// parseRetryAfter parses a Retry-After header, either seconds or an HTTP date,
+// into how long to wait from now
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(max(seconds, 0)) * time.Second, true
+	}
+	at, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	return max(at.Sub(now), 0).Round(time.Second), true
+}
+
+// classifyThrottling returns the throttling a check failed on, or nil
+func classifyThrottling(err error) *throttledError {
+	var throttled *throttledError
+	if errors.As(err, &throttled) {
+		return throttled
+	}
+	return nil
+}
+
+// holdThrottled keeps a service's state through a throttled check with
+// throttling set to skip, passing on the throttling as a warning. The
+// service's first check has no state to keep and is taken as is.
+func holdThrottled(svc Service, status *HealthStatus, result CheckResult) (CheckResult, bool) {
+	if svc.Throttling != "skip" || !result.Throttled || status.Pending {
+		return result, false
+	}
+	held := result
+	held.Healthy = status.Healthy
+	held.Error, held.ErrorInfo = status.Error, status.ErrorInfo
+	held.Warnings = append([]string{result.Error}, result.Warnings...)
+	return held, true
+}
+
+// trackThrottling records whether a service is throttled and until when,
+// with an event when throttling starts and ends. Must be called with hc.mu held.
+func (hc *HealthChecker) trackThrottling(status *HealthStatus, result CheckResult, at time.Time) {
+	was := status.Throttled
+	status.Throttled, status.RetryAt = result.Throttled, nil
+	if result.Throttled && result.RetryAfter > 0 {
+		until := at.Add(result.RetryAfter)
+		status.RetryAt = &until
+	}
+
+	switch {
+	case result.Throttled && !was:
+		slog.Warn("service throttled", "service", status.Name, "retry_after", result.RetryAfter.String())
+		hc.events.Add(Event{Time: at, Service: status.Name, Type: EventThrottled, Message: result.Error})
+	case !result.Throttled && was:
+		slog.Info("service no longer throttled", "service", status.Name)
+		hc.events.Add(Event{Time: at, Service: status.Name, Type: EventThrottleEnded, Message: "no longer throttled by target"})
+	}
+}
+
+// throttledFor returns how much longer a service's target has asked not to be
+// checked, or 0
+func (hc *HealthChecker) throttledFor(name string, now time.Time) time.Duration {
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+	status, ok := hc.statuses[name]
+	if !ok || status.RetryAt == nil {
+		return 0
+	}
+	return max(status.RetryAt.Sub(now), 0)
+}
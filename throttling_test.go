@@ -0,0 +1,64 @@
+// throttling_test.go
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"", 0, false},
+		{"120", 2 * time.Minute, true},
+		{" 5 ", 5 * time.Second, true},
+		{"0", 0, true},
+		{"-5", 0, true},
+		{"Wed, 14 Oct 2026 12:01:30 GMT", 90 * time.Second, true},
+		{"Wed, 14 Oct 2026 11:59:00 GMT", 0, true}, // already past
+		{"soon", 0, false},
+		{"1.5", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseRetryAfter(tt.value, now)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseRetryAfter(%q) = %v, %v, want %v, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestDetectThrottling(t *testing.T) {
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		status     int
+		retryAfter string
+		want       *throttledError
+	}{
+		{"429", http.StatusTooManyRequests, "", &throttledError{status: 429}},
+		{"429 with Retry-After", http.StatusTooManyRequests, "30", &throttledError{status: 429, retryAfter: 30 * time.Second}},
+		{"503 with Retry-After", http.StatusServiceUnavailable, "30", &throttledError{status: 503, retryAfter: 30 * time.Second}},
+		{"503 without Retry-After is an outage", http.StatusServiceUnavailable, "", nil},
+		{"503 with an unreadable Retry-After", http.StatusServiceUnavailable, "later", nil},
+		{"Retry-After is capped", http.StatusTooManyRequests, "86400", &throttledError{status: 429, retryAfter: maxRetryAfter}},
+		{"other statuses", http.StatusInternalServerError, "30", nil},
+		{"success", http.StatusOK, "30", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: tt.status, Header: http.Header{}}
+			if tt.retryAfter != "" {
+				resp.Header.Set("Retry-After", tt.retryAfter)
+			}
+			got := detectThrottling(resp, now)
+			if (got == nil) != (tt.want == nil) || got != nil && *got != *tt.want {
+				t.Errorf("detectThrottling() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+// tlsinfo.go
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"math"
+	"time"
+)
+
+// defaultCertWarningDays is how close to expiry a certificate gets before checks warn
+const defaultCertWarningDays = 14
+
+// TLSInfo describes the certificate identity observed on an HTTPS check
+type TLSInfo struct {
+	Issuer     string    `json:"issuer"`
+	SPKISHA256 string    `json:"spki_sha256"`
+	NotAfter   time.Time `json:"not_after"`
+
+	// Set when the issuer or public key differs from the previous check
+	ChangedAt          *time.Time `json:"changed_at,omitempty"`
+	PreviousIssuer     string     `json:"previous_issuer,omitempty"`
+	PreviousSPKISHA256 string     `json:"previous_spki_sha256,omitempty"`
+}
+
+// newTLSInfo captures the issuer and SPKI hash of a leaf certificate
+func newTLSInfo(cert *x509.Certificate) *TLSInfo {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return &TLSInfo{
+		Issuer:     cert.Issuer.String(),
+		SPKISHA256: base64.StdEncoding.EncodeToString(sum[:]),
+		NotAfter:   cert.NotAfter,
+	}
+}
+
+// certDaysRemaining is the time until a certificate expires in days, to one decimal
+func certDaysRemaining(notAfter, now time.Time) float64 {
+	return math.Floor(10*notAfter.Sub(now).Hours()/24) / 10
+}
+
+// checkCertExpiry fails the check when the certificate expires within
+// CertCriticalDays, and warns when it expires within CertWarningDays
+func checkCertExpiry(svc Service, info *TLSInfo, result *CheckResult) error {
+	days := certDaysRemaining(info.NotAfter, time.Now())
+	if svc.CertCriticalDays > 0 && days < float64(svc.CertCriticalDays) {
+		return &certError{code: "expiring", msg: fmt.Sprintf("certificate expires in %g days (%s), within %d days", days, info.NotAfter.Format(time.DateOnly), svc.CertCriticalDays)}
+	}
+	warning := svc.CertWarningDays
+	if warning == 0 {
+		warning = defaultCertWarningDays
+	}
+	if days < float64(warning) {
+		result.Warnings = append(result.Warnings, fmt.Sprintf("certificate expires in %g days (%s)", days, info.NotAfter.Format(time.DateOnly)))
+	}
+	return nil
+}
+
+// verifyCertPins fails when the certificate doesn't match the service's configured pins
+func verifyCertPins(svc Service, cert *x509.Certificate, info *TLSInfo) error {
+	if len(svc.ExpectedIssuers) > 0 && !issuerMatches(cert, svc.ExpectedIssuers) {
+		return &certError{code: "issuer_mismatch", msg: fmt.Sprintf("unexpected certificate issuer %q", info.Issuer)}
+	}
+
+	if len(svc.PinnedSPKI) > 0 {
+		for _, pin := range svc.PinnedSPKI {
+			if pin == info.SPKISHA256 {
+				return nil
+			}
+		}
+		return &certError{code: "pin_mismatch", msg: fmt.Sprintf("certificate public key %s does not match any pin", info.SPKISHA256)}
+	}
+	return nil
+}
+
+// issuerMatches reports whether the issuer DN, common name or organization is in expected
+func issuerMatches(cert *x509.Certificate, expected []string) bool {
+	for _, want := range expected {
+		if want == cert.Issuer.String() || want == cert.Issuer.CommonName {
+			return true
+		}
+		for _, org := range cert.Issuer.Organization {
+			if want == org {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// trackTLSChange compares a new observation against the previous one, recording and
+// logging an identity change. Change details persist until the next change.
+func trackTLSChange(name string, prev, next *TLSInfo, now time.Time) *TLSInfo {
+	if prev == nil {
+		return next
+	}
+
+	if prev.Issuer == next.Issuer && prev.SPKISHA256 == next.SPKISHA256 {
+		next.ChangedAt = prev.ChangedAt
+		next.PreviousIssuer = prev.PreviousIssuer
+		next.PreviousSPKISHA256 = prev.PreviousSPKISHA256
+		return next
+	}
+
+	changedAt := now
+	next.ChangedAt = &changedAt
+	next.PreviousIssuer = prev.Issuer
+	next.PreviousSPKISHA256 = prev.SPKISHA256
+
+	slog.Warn("certificate changed", "service", name, "issuer", next.Issuer, "previous_issuer", prev.Issuer,
+		"spki_sha256", next.SPKISHA256, "previous_spki_sha256", prev.SPKISHA256)
+	return next
+}
@@ -0,0 +1,508 @@
+// tracing.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"math/rand"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Span export limits
+const (
+	tracingFlushInterval = 5 * time.Second
+	tracingBatchSize     = 512  // spans per export
+	tracingQueueSize     = 4096 // finished spans waiting for export
+	tracingExportTimeout = 10 * time.Second
+)
+
+// OTLP span kinds and status codes
+const (
+	spanKindInternal = 1
+	spanKindClient   = 3
+
+	spanStatusError = 2
+)
+
+// TracingConfig exports a trace of every check to an OpenTelemetry collector
+// over OTLP/HTTP, so a failed probe can be looked up next to the traces of the
+// backend it hit
+type TracingConfig struct {
+	// The collector's OTLP/HTTP endpoint, e.g. http://otel-collector:4318;
+	// /v1/traces is appended unless the URL has a path
+	Endpoint string `json:"endpoint"`
+
+	// Sent with every export, e.g. the API key of a hosted backend
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// The service.name spans are reported under, default sre-health-checker
+	ServiceName string `json:"service_name,omitempty"`
+
+	// Share of checks traced, from 0 to 1; default 1, every check
+	SampleRatio float64 `json:"sample_ratio,omitempty"`
+}
+
+// Validate checks the tracing settings
+func (c TracingConfig) Validate() []ValidationError {
+	var errs []ValidationError
+	add := func(name, format string, args ...interface{}) {
+		errs = append(errs, ValidationError{Field: "tracing." + name, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if u, err := url.Parse(c.Endpoint); c.Endpoint == "" {
+		add("endpoint", "is required")
+	} else if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		add("endpoint", "must be an http or https URL")
+	}
+	if c.SampleRatio < 0 || c.SampleRatio > 1 {
+		add("sample_ratio", "must be between 0 and 1")
+	}
+	return errs
+}
+
+// tracesURL returns the URL spans are posted to
+func (c TracingConfig) tracesURL() string {
+	u, err := url.Parse(c.Endpoint)
+	if err != nil || u.Path != "" && u.Path != "/" {
+		return c.Endpoint
+	}
+	u.Path = "/v1/traces"
+	return u.String()
+}
+
+// Tracer records a span per check, with child spans for the phases of its
+// HTTP requests, and exports them in batches. A nil Tracer traces nothing.
+type Tracer struct {
+	url         string
+	headers     map[string]string
+	resource    []otlpKeyValue
+	sampleRatio float64
+	spans       chan *span
+	flushes     chan chan struct{} // closed once queued spans are exported
+
+	mu       sync.Mutex
+	exported int64
+	dropped  int64 // spans discarded because the queue was full or the export failed
+	online   bool
+}
+
+// tracer traces checks when main configures tracing
+var tracer *Tracer
+
+// NewTracer creates a tracer exporting to the configured collector
+func NewTracer(c TracingConfig, version string) *Tracer {
+	name := c.ServiceName
+	if name == "" {
+		name = "sre-health-checker"
+	}
+	ratio := c.SampleRatio
+	if ratio == 0 {
+		ratio = 1
+	}
+	return &Tracer{
+		url:         c.tracesURL(),
+		headers:     c.Headers,
+		resource:    []otlpKeyValue{stringAttr("service.name", name), stringAttr("service.version", version)},
+		sampleRatio: ratio,
+		spans:       make(chan *span, tracingQueueSize),
+		flushes:     make(chan chan struct{}),
+		online:      true,
+	}
+}
+
+// span is a finished or running span, in its OTLP/JSON form
+type span struct {
+	TraceID      string         `json:"traceId"`
+	SpanID       string         `json:"spanId"`
+	ParentSpanID string         `json:"parentSpanId,omitempty"`
+	Name         string         `json:"name"`
+	Kind         int            `json:"kind"`
+	Start        int64          `json:"startTimeUnixNano,string"`
+	End          int64          `json:"endTimeUnixNano,string"`
+	Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+	Status       struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	} `json:"status"`
+
+	tracer *Tracer
+	ended  bool
+}
+
+type otlpKeyValue struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue *string `json:"stringValue,omitempty"`
+		IntValue    *string `json:"intValue,omitempty"` // int64 as a decimal string
+		BoolValue   *bool   `json:"boolValue,omitempty"`
+	} `json:"value"`
+}
+
+func stringAttr(key, value string) otlpKeyValue {
+	kv := otlpKeyValue{Key: key}
+	kv.Value.StringValue = &value
+	return kv
+}
+
+func intAttr(key string, value int64) otlpKeyValue {
+	kv := otlpKeyValue{Key: key}
+	v := strconv.FormatInt(value, 10)
+	kv.Value.IntValue = &v
+	return kv
+}
+
+func boolAttr(key string, value bool) otlpKeyValue {
+	kv := otlpKeyValue{Key: key}
+	kv.Value.BoolValue = &value
+	return kv
+}
+
+type spanKey struct{}
+
+// spanFromContext returns the span a check runs in, or nil when it isn't traced
+func spanFromContext(ctx context.Context) *span {
+	s, _ := ctx.Value(spanKey{}).(*span)
+	return s
+}
+
+// startCheck starts the root span of a check, unless tracing is off or the
+// check isn't sampled
+func (t *Tracer) startCheck(ctx context.Context, svc Service) (context.Context, *span) {
+	if t == nil || t.sampleRatio < 1 && rand.Float64() >= t.sampleRatio {
+		return ctx, nil
+	}
+	typ := svc.Type
+	if typ == "" {
+		typ = "http"
+	}
+	s := &span{TraceID: randomID(16), SpanID: randomID(8), Name: "check " + svc.Name, Kind: spanKindInternal,
+		Start: time.Now().UnixNano(), tracer: t}
+	s.Attributes = []otlpKeyValue{
+		stringAttr("health_check.service", svc.Name),
+		stringAttr("health_check.type", typ),
+		stringAttr("health_check.target", redactor.Redact(svc.URL)),
+	}
+	for _, k := range slices.Sorted(maps.Keys(svc.Labels)) {
+		s.Attributes = append(s.Attributes, stringAttr("health_check.label."+k, svc.Labels[k]))
+	}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// finishCheck records the check's outcome on its root span and ends it
+func (s *span) finishCheck(result CheckResult, attempts int) {
+	if s == nil {
+		return
+	}
+	s.Attributes = append(s.Attributes,
+		boolAttr("health_check.healthy", result.Healthy),
+		intAttr("health_check.attempts", int64(attempts)),
+		intAttr("health_check.response_time_ms", result.ResponseTime))
+	if result.DNSError != "" {
+		s.Attributes = append(s.Attributes, stringAttr("health_check.dns_error", result.DNSError))
+	}
+	if result.BotChallenge != "" {
+		s.Attributes = append(s.Attributes, stringAttr("health_check.bot_challenge", result.BotChallenge))
+	}
+	if !result.Healthy {
+		s.fail(result.Error)
+	}
+	s.end(time.Now())
+}
+
+// child starts a span within s
+func (s *span) child(name string, kind int, start time.Time) *span {
+	return &span{TraceID: s.TraceID, SpanID: randomID(8), ParentSpanID: s.SpanID, Name: name, Kind: kind,
+		Start: start.UnixNano(), tracer: s.tracer}
+}
+
+// fail marks the span as failed
+func (s *span) fail(message string) {
+	s.Status.Code = spanStatusError
+	s.Status.Message = redactor.Redact(message)
+}
+
+// end ends the span and queues it for export, dropping it when the queue is full
+func (s *span) end(at time.Time) {
+	if s.ended {
+		return
+	}
+	s.ended = true
+	s.End = at.UnixNano()
+	select {
+	case s.tracer.spans <- s:
+	default:
+		s.tracer.mu.Lock()
+		s.tracer.dropped++
+		s.tracer.mu.Unlock()
+	}
+}
+
+// traceID returns the span's trace ID, or "" when the check isn't traced
+func (s *span) traceID() string {
+	if s == nil {
+		return ""
+	}
+	return s.TraceID
+}
+
+// traceRequest starts a client span for a request a traced check sends, with
+// child spans for its DNS lookup, connect, TLS handshake and the wait for the
+// first response byte, and passes the trace on to the service in a W3C
+// traceparent header, or the configured propagators. finish ends the spans
+// once the response or error is in.
+func traceRequest(req *http.Request) (_ *http.Request, finish func(*http.Response, error)) {
+	parent := spanFromContext(req.Context())
+	if parent == nil {
+		propagate(req, "")
+		return req, func(*http.Response, error) {}
+	}
+	s := parent.child(req.Method, spanKindClient, time.Now())
+	s.Attributes = []otlpKeyValue{
+		stringAttr("http.request.method", req.Method),
+		stringAttr("url.full", redactor.Redact(req.URL.Redacted())),
+		stringAttr("server.address", req.URL.Hostname()),
+	}
+
+	// Connection attempts to several addresses may run at once, and finish after the request
+	var mu sync.Mutex
+	var dns, handshake, wait *span
+	connects := make(map[string]*span)
+	done := false
+	phase := func(f func()) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !done {
+			f()
+		}
+	}
+	endPhase := func(p *span, err error) {
+		if p == nil {
+			return
+		}
+		if err != nil {
+			p.fail(err.Error())
+		}
+		p.end(time.Now())
+	}
+
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			phase(func() { s.Attributes = append(s.Attributes, boolAttr("http.connection.reused", info.Reused)) })
+		},
+		DNSStart: func(info httptrace.DNSStartInfo) {
+			phase(func() {
+				dns = s.child("DNS lookup", spanKindInternal, time.Now())
+				dns.Attributes = []otlpKeyValue{stringAttr("dns.question.name", info.Host)}
+			})
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			phase(func() { endPhase(dns, info.Err) })
+		},
+		ConnectStart: func(network, addr string) {
+			phase(func() {
+				c := s.child("connect", spanKindInternal, time.Now())
+				c.Attributes = []otlpKeyValue{stringAttr("network.transport", network), stringAttr("network.peer.address", addr)}
+				connects[addr] = c
+			})
+		},
+		ConnectDone: func(network, addr string, err error) {
+			phase(func() { endPhase(connects[addr], err) })
+		},
+		TLSHandshakeStart: func() {
+			phase(func() { handshake = s.child("TLS handshake", spanKindInternal, time.Now()) })
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			phase(func() { endPhase(handshake, err) })
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			phase(func() { wait = s.child("time to first byte", spanKindInternal, time.Now()) })
+		},
+		GotFirstResponseByte: func() {
+			phase(func() { endPhase(wait, nil) })
+		},
+	}
+	if !propagate(req, s.SpanID) {
+		req.Header.Set("traceparent", "00-"+s.TraceID+"-"+s.SpanID+"-01")
+	}
+
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace)), func(resp *http.Response, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		done = true
+
+		// Phases cut short by the error, such as a timeout waiting for the response
+		for _, p := range append([]*span{dns, handshake, wait}, slices.Collect(maps.Values(connects))...) {
+			if p != nil && !p.ended {
+				endPhase(p, err)
+			}
+		}
+		if err != nil {
+			s.fail(err.Error())
+		} else {
+			s.Attributes = append(s.Attributes, intAttr("http.response.status_code", int64(resp.StatusCode)))
+			if resp.StatusCode >= 400 {
+				s.fail(fmt.Sprintf("HTTP %d", resp.StatusCode))
+			}
+		}
+		s.end(time.Now())
+	}
+}
+
+// Run exports finished spans every tracingFlushInterval, or as soon as a batch fills up
+func (t *Tracer) Run() {
+	ticker := time.NewTicker(tracingFlushInterval)
+	defer ticker.Stop()
+
+	var batch []*span
+	for {
+		select {
+		case s := <-t.spans:
+			batch = append(batch, s)
+			if len(batch) < tracingBatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		case done := <-t.flushes:
+			for queued := true; queued; {
+				select {
+				case s := <-t.spans:
+					batch = append(batch, s)
+				default:
+					queued = false
+				}
+			}
+			for len(batch) > 0 {
+				n := min(len(batch), tracingBatchSize)
+				t.export(batch[:n])
+				batch = batch[n:]
+			}
+			close(done)
+			continue
+		}
+		t.export(batch)
+		batch = nil
+	}
+}
+
+// Flush exports every span queued so far, waiting until that's done or ctx
+// ends; Run must be running
+func (t *Tracer) Flush(ctx context.Context) error {
+	done := make(chan struct{})
+	select {
+	case t.flushes <- done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// export posts a batch of spans to the collector; a batch it refuses is dropped
+func (t *Tracer) export(batch []*span) {
+	err := t.post(batch)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err != nil {
+		t.dropped += int64(len(batch))
+		if t.online {
+			slog.Warn("exporting spans", "collector", t.url, "error", err)
+		}
+		t.online = false
+		return
+	}
+	t.exported += int64(len(batch))
+	if !t.online {
+		slog.Info("exporting spans again", "collector", t.url)
+	}
+	t.online = true
+}
+
+// otlpExport is an OTLP/JSON trace export request
+type otlpExport struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []*span `json:"spans"`
+}
+
+// post sends spans to the collector
+func (t *Tracer) post(batch []*span) error {
+	resource := otlpResourceSpans{ScopeSpans: []otlpScopeSpans{{Spans: batch}}}
+	resource.Resource.Attributes = t.resource
+	resource.ScopeSpans[0].Scope.Name = "github.com/b95702041/sre-health-checker"
+	request := otlpExport{ResourceSpans: []otlpResourceSpans{resource}}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), tracingExportTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range t.headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("collector returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// WriteMetrics writes span export metrics
+func (t *Tracer) WriteMetrics(m *metricWriter) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	online := 0
+	if t.online {
+		online = 1
+	}
+	m.family("tracing_collector_up", "gauge", "Whether the last span export to the collector succeeded (1) or not (0)")
+	m.sample("tracing_collector_up", float64(online))
+
+	m.family("tracing_spans_exported_total", "counter", "Spans accepted by the collector")
+	m.sample("tracing_spans_exported_total", float64(t.exported))
+
+	m.family("tracing_spans_dropped_total", "counter", "Spans discarded because the export queue was full or the collector refused them")
+	m.sample("tracing_spans_dropped_total", float64(t.dropped))
+}
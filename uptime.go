@@ -0,0 +1,189 @@
+// uptime.go
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultUptimeWindows are the windows uptime is reported over unless
+// uptime_windows or ?window= name others
+var defaultUptimeWindows = []string{"1h", "24h", "7d", "30d"}
+
+// uptimeCacheTTL is how long the uptime of the configured windows is reused,
+// so metric scrapes don't each count a month of results
+const uptimeCacheTTL = time.Minute
+
+// ResultCounts are the check results of a service within a window. Failures
+// during maintenance windows are counted apart and don't count against uptime.
+type ResultCounts struct {
+	Checks              int64
+	Failures            int64
+	MaintenanceFailures int64
+	First               time.Time // oldest result in the window
+}
+
+// Ratio returns the fraction of checks that didn't fail, or -1 without checks
+func (c ResultCounts) Ratio() float64 {
+	if c.Checks == 0 {
+		return -1
+	}
+	return float64(c.Checks-c.Failures) / float64(c.Checks)
+}
+
+// uptimeCache holds the last counts of the configured windows
+type uptimeCache struct {
+	mu     sync.Mutex
+	at     time.Time
+	counts map[string][]ResultCounts
+}
+
+// CountResults counts each service's results since every start time, from the
+// store when it keeps results and otherwise from the history held in memory,
+// which only goes back historySize results
+func (hc *HealthChecker) CountResults(starts []time.Time) map[string][]ResultCounts {
+	counts, err := hc.store.CountResults(starts)
+	if err != nil {
+		slog.Warn("counting results in storage", "error", err)
+	}
+	if counts != nil && err == nil {
+		hc.mu.RLock()
+		defer hc.mu.RUnlock()
+		for name := range counts {
+			if _, ok := hc.services[name]; !ok {
+				delete(counts, name)
+			}
+		}
+		return counts
+	}
+
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+
+	counts = make(map[string][]ResultCounts, len(hc.history))
+	for name, records := range hc.history {
+		windows := make([]ResultCounts, len(starts))
+		for _, rec := range records {
+			for i, start := range starts {
+				if rec.Time.Before(start) {
+					continue
+				}
+				c := &windows[i]
+				if c.Checks == 0 {
+					c.First = rec.Time
+				}
+				c.Checks++
+				switch {
+				case rec.Healthy:
+				case rec.Maintenance:
+					c.MaintenanceFailures++
+				default:
+					c.Failures++
+				}
+			}
+		}
+		counts[name] = windows
+	}
+	return counts
+}
+
+// uptimeStarts returns when each window starts, looking back from now
+func uptimeStarts(windows []string, now time.Time) ([]time.Time, error) {
+	starts := make([]time.Time, len(windows))
+	for i, w := range windows {
+		d, err := parseWindow(w)
+		if err != nil {
+			return nil, err
+		}
+		starts[i] = now.Add(-d)
+	}
+	return starts, nil
+}
+
+// configuredUptime returns the counts of the configured windows, recounting
+// them at most once every uptimeCacheTTL
+func (hc *HealthChecker) configuredUptime() map[string][]ResultCounts {
+	hc.uptime.mu.Lock()
+	defer hc.uptime.mu.Unlock()
+
+	now := time.Now()
+	if hc.uptime.counts == nil || now.Sub(hc.uptime.at) >= uptimeCacheTTL {
+		starts, err := uptimeStarts(hc.uptimeWindows, now)
+		if err != nil {
+			return nil // validated with the config
+		}
+		hc.uptime.counts = hc.CountResults(starts)
+		hc.uptime.at = now
+	}
+	return hc.uptime.counts
+}
+
+// uptimeWindow is one service's uptime over one window in the API
+type uptimeWindow struct {
+	Uptime              *float64   `json:"uptime_percent,omitempty"` // unset without checks
+	Checks              int64      `json:"checks"`
+	Failures            int64      `json:"failures"`
+	MaintenanceFailures int64      `json:"maintenance_failures,omitempty"`
+	Since               *time.Time `json:"since,omitempty"` // oldest result counted
+}
+
+// UptimeHandler returns the uptime of every service, or ?service=, over the
+// configured windows or those given as ?window= (repeated or comma-separated)
+func (hc *HealthChecker) UptimeHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	windows := hc.uptimeWindows
+	var counts map[string][]ResultCounts
+	if values := query["window"]; len(values) > 0 {
+		windows = nil
+		for _, v := range values {
+			windows = append(windows, strings.Split(v, ",")...)
+		}
+		starts, err := uptimeStarts(windows, time.Now())
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		counts = hc.CountResults(starts)
+	} else {
+		counts = hc.configuredUptime()
+	}
+
+	names := make([]string, 0, len(counts))
+	if name := query.Get("service"); name != "" {
+		if _, ok := hc.GetService(name); !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": errServiceNotFound.Error()})
+			return
+		}
+		names = append(names, name)
+	} else {
+		for _, svc := range hc.Services() {
+			names = append(names, svc.Name)
+		}
+	}
+
+	services := make(map[string]map[string]uptimeWindow, len(names))
+	for _, name := range names {
+		byWindow := make(map[string]uptimeWindow, len(windows))
+		for i, window := range windows {
+			var c ResultCounts
+			if i < len(counts[name]) {
+				c = counts[name][i]
+			}
+			u := uptimeWindow{Checks: c.Checks, Failures: c.Failures, MaintenanceFailures: c.MaintenanceFailures}
+			if c.Checks > 0 {
+				percent := 100 * c.Ratio()
+				first := c.First
+				u.Uptime, u.Since = &percent, &first
+			}
+			byWindow[window] = u
+		}
+		services[name] = byWindow
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"windows":  windows,
+		"services": services,
+	})
+}
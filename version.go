@@ -0,0 +1,236 @@
+// version.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// version is the release this binary was built from, set with
+// -ldflags "-X main.version=v1.2.3"; go install fills it from the module version
+var version = "dev"
+
+// releasesURL is where the update check looks up the latest release
+const releasesURL = "https://api.github.com/repos/b95702041/sre-health-checker/releases/latest"
+
+const (
+	updateCheckInterval = 24 * time.Hour
+	updateCheckTimeout  = 10 * time.Second
+)
+
+// VersionInfo describes the running build
+type VersionInfo struct {
+	Version    string      `json:"version"`
+	Commit     string      `json:"commit,omitempty"`
+	CommitTime *time.Time  `json:"commit_time,omitempty"`
+	Modified   bool        `json:"modified,omitempty"` // built from a tree with uncommitted changes
+	GoVersion  string      `json:"go_version"`
+	Update     *UpdateInfo `json:"update,omitempty"` // with -update-check only
+}
+
+// buildVersion reads the version and the VCS details Go stamps into the binary
+func buildVersion() VersionInfo {
+	info := VersionInfo{Version: version, GoVersion: runtime.Version()}
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	if info.Version == "dev" && build.Main.Version != "" && build.Main.Version != "(devel)" {
+		info.Version = build.Main.Version
+	}
+	for _, s := range build.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			info.Commit = s.Value
+		case "vcs.time":
+			if t, err := time.Parse(time.RFC3339, s.Value); err == nil {
+				info.CommitTime = &t
+			}
+		case "vcs.modified":
+			info.Modified = s.Value == "true"
+		}
+	}
+	return info
+}
+
+// UpdateInfo is the outcome of the last update check
+type UpdateInfo struct {
+	Latest    string     `json:"latest,omitempty"`
+	URL       string     `json:"url,omitempty"` // release notes of the latest release
+	Available bool       `json:"available"`     // the latest release is newer than this build
+	CheckedAt *time.Time `json:"checked_at,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// updateChecker periodically looks up the latest release, so outdated
+// checkers across a fleet show up on their dashboards and in metrics
+type updateChecker struct {
+	url     string
+	current string
+
+	mu   sync.Mutex
+	info UpdateInfo
+}
+
+func newUpdateChecker(current string) *updateChecker {
+	return &updateChecker{url: releasesURL, current: current}
+}
+
+// Run checks for a newer release now and every updateCheckInterval
+func (u *updateChecker) Run() {
+	for {
+		u.check()
+		time.Sleep(updateCheckInterval)
+	}
+}
+
+// check looks up the latest release
+func (u *updateChecker) check() {
+	latest, url, err := u.fetchLatest()
+	now := time.Now()
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.info.CheckedAt = &now
+	if err != nil {
+		// Keep what the last successful check found
+		u.info.Error = err.Error()
+		slog.Warn("update check failed", "error", err)
+		return
+	}
+	u.info = UpdateInfo{Latest: latest, URL: url, Available: newerVersion(latest, u.current), CheckedAt: &now}
+	if u.info.Available {
+		slog.Info("update available", "latest", latest, "running", u.current, "url", url)
+	}
+}
+
+// fetchLatest returns the tag and page of the latest release
+func (u *updateChecker) fetchLatest() (string, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), updateCheckTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.url, nil)
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("User-Agent", "sre-health-checker/"+u.current)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("%s: HTTP %d", u.url, resp.StatusCode)
+	}
+	var release struct {
+		TagName string `json:"tag_name"`
+		HTMLURL string `json:"html_url"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
+		return "", "", fmt.Errorf("%s: %w", u.url, err)
+	}
+	if release.TagName == "" {
+		return "", "", fmt.Errorf("%s: no tag_name in response", u.url)
+	}
+	if !strings.HasPrefix(release.HTMLURL, "https://") {
+		release.HTMLURL = "" // linked from the dashboard
+	}
+	return release.TagName, release.HTMLURL, nil
+}
+
+// Info returns the outcome of the last update check
+func (u *updateChecker) Info() UpdateInfo {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.info
+}
+
+// releaseNumbers parses a vMAJOR.MINOR.PATCH release tag; pre-release and
+// build suffixes are ignored
+func releaseNumbers(v string) ([3]int, bool) {
+	var numbers [3]int
+	v = strings.TrimPrefix(v, "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+	parts := strings.Split(v, ".")
+	if len(parts) == 0 || len(parts) > 3 {
+		return numbers, false
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return numbers, false
+		}
+		numbers[i] = n
+	}
+	return numbers, true
+}
+
+// pseudoVersion matches the timestamp and commit Go puts in the version of a
+// build from a checkout, e.g. v0.0.0-20240102150405-abcdef123456
+var pseudoVersion = regexp.MustCompile(`\d{14}-[0-9a-f]{12}`)
+
+// newerVersion reports whether release latest is newer than current. Builds
+// that aren't releases, such as dev or one from a checkout, are never
+// reported as outdated.
+func newerVersion(latest, current string) bool {
+	l, ok := releaseNumbers(latest)
+	if !ok {
+		return false
+	}
+	c, ok := releaseNumbers(current)
+	if !ok || pseudoVersion.MatchString(current) {
+		return false
+	}
+	for i := range l {
+		if l[i] != c[i] {
+			return l[i] > c[i]
+		}
+	}
+	return false
+}
+
+// VersionHandler returns the build's version, and the outcome of the last
+// update check when updates is set
+func VersionHandler(build VersionInfo, updates *updateChecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		info := build
+		if updates != nil {
+			update := updates.Info()
+			info.Update = &update
+		}
+		writeJSON(w, http.StatusOK, info)
+	}
+}
+
+// WriteVersionMetrics writes the build's version, and with updates set
+// whether a newer release is out
+func WriteVersionMetrics(m *metricWriter, build VersionInfo, updates *updateChecker) {
+	m.family("health_checker_build_info", "gauge", "The version this health checker was built from; always 1")
+	m.sample("health_checker_build_info", 1, "version", build.Version, "commit", build.Commit, "go_version", build.GoVersion)
+	if updates == nil {
+		return
+	}
+	info := updates.Info()
+	if info.Latest == "" {
+		return
+	}
+	available := 0
+	if info.Available {
+		available = 1
+	}
+	m.family("health_checker_update_available", "gauge", "Whether a newer release than this build is out (1) or not (0)")
+	m.sample("health_checker_update_available", float64(available), "latest", info.Latest)
+}
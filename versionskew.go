@@ -0,0 +1,208 @@
+// versionskew.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultVersionSkewWindow is how long instances may disagree when version_skew.window is unset
+const defaultVersionSkewWindow = 10 * time.Minute
+
+// VersionSkewConfig reads the version every instance of an HTTP service
+// serves, so a rollout stuck halfway shows up as instances that keep disagreeing
+type VersionSkewConfig struct {
+	// Addresses (host or host:port) of the instances, each sent the check's
+	// request with the URL's host kept for Host and SNI; default every address
+	// the URL's host resolves to
+	Instances []string `json:"instances,omitempty"`
+
+	Header   string `json:"header,omitempty"`    // response header holding the version, e.g. X-App-Version
+	JSONPath string `json:"json_path,omitempty"` // or the body's JSON field, e.g. build.version
+
+	// How long instances may disagree, as they do during a rollout, before the check warns; default 10m
+	Window Duration `json:"window,omitempty"`
+}
+
+// validate checks a version skew definition; prefix is prepended to field names
+func (c VersionSkewConfig) validate(prefix string) []ValidationError {
+	var errs []ValidationError
+	add := func(name, format string, args ...interface{}) {
+		errs = append(errs, ValidationError{Field: prefix + name, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if (c.Header == "") == (c.JSONPath == "") {
+		add("header", "set either header or json_path")
+	}
+	if c.JSONPath != "" {
+		if _, err := parseJSONPath(c.JSONPath); err != nil {
+			add("json_path", "%v", err)
+		}
+	}
+	for i, instance := range c.Instances {
+		if _, err := targetAddr(instance, "80"); err != nil || strings.Contains(instance, "://") {
+			add(fmt.Sprintf("instances[%d]", i), "must be host or host:port")
+		}
+	}
+	if c.Window < 0 {
+		add("window", "must not be negative")
+	}
+	return errs
+}
+
+// readVersions sends the check's request to every instance and returns the
+// version each served. Instances that can't be read are left out with a warning.
+func readVersions(ctx context.Context, svc Service, result *CheckResult) error {
+	u, err := url.Parse(svc.URL)
+	if err != nil {
+		return err
+	}
+	port := urlPort(u)
+	instances := svc.VersionSkew.Instances
+	if len(instances) == 0 {
+		if instances, err = net.DefaultResolver.LookupHost(ctx, u.Hostname()); err != nil {
+			return fmt.Errorf("resolving instances: %w", err)
+		}
+	}
+	tlsConfig, err := serviceTLSConfig(svc)
+	if err != nil {
+		return err
+	}
+
+	versions := make(map[string]string, len(instances))
+	failures := make(map[string]error)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, instance := range instances {
+		addr, err := targetAddr(instance, port)
+		if err != nil {
+			mu.Lock()
+			failures[instance] = err
+			mu.Unlock()
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			transport := pinnedTransport(net.JoinHostPort(u.Hostname(), port), addr, tlsConfig)
+			version, err := readVersion(ctx, probeClient(transport), svc)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				failures[addr] = err
+				return
+			}
+			versions[addr] = version
+		}()
+	}
+	wg.Wait()
+
+	for _, addr := range slices.Sorted(maps.Keys(failures)) {
+		result.Warnings = append(result.Warnings, fmt.Sprintf("version of %s: %v", addr, failures[addr]))
+	}
+	result.Versions = versions
+	return nil
+}
+
+// readVersion returns the version one instance serves
+func readVersion(ctx context.Context, client *http.Client, svc Service) (string, error) {
+	resp, err := sendHTTP(ctx, client, svc)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	c := svc.VersionSkew
+	if c.Header != "" {
+		if version := resp.Header.Get(c.Header); version != "" {
+			return version, nil
+		}
+		return "", fmt.Errorf("no %s header", c.Header)
+	}
+	var doc interface{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, bodyAssertMax)).Decode(&doc); err != nil {
+		return "", fmt.Errorf("reading body: %w", err)
+	}
+	v, ok := lookupJSON(doc, c.JSONPath)
+	if !ok {
+		return "", fmt.Errorf("%s not found in body", c.JSONPath)
+	}
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	return jsonString(v), nil
+}
+
+// distinctVersions returns the versions instances serve, sorted
+func distinctVersions(versions map[string]string) []string {
+	return slices.Compact(slices.Sorted(maps.Values(versions)))
+}
+
+// describeVersions lists the instances serving each version
+func describeVersions(versions map[string]string) string {
+	byVersion := make(map[string][]string)
+	for instance, version := range versions {
+		byVersion[version] = append(byVersion[version], instance)
+	}
+	var parts []string
+	for _, version := range slices.Sorted(maps.Keys(byVersion)) {
+		instances := byVersion[version]
+		slices.Sort(instances)
+		parts = append(parts, version+" on "+strings.Join(instances, ", "))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// trackVersionSkew records the versions a check read and warns once the
+// instances have disagreed for longer than the window, with an event when
+// that starts and ends. Must be called with hc.mu held, before the status takes
+// the result.
+func (hc *HealthChecker) trackVersionSkew(svc Service, status *HealthStatus, result *CheckResult, at time.Time) {
+	if svc.VersionSkew == nil {
+		status.Versions, status.SkewSince = nil, nil
+		return
+	}
+	if result.Versions == nil {
+		return // the check failed before versions were read; keep the last ones
+	}
+	window := orDefault(svc.VersionSkew.Window, defaultVersionSkewWindow)
+	reported := status.SkewSince != nil && status.LastChecked.Sub(*status.SkewSince) >= window
+	status.Versions = result.Versions
+
+	if versions := distinctVersions(result.Versions); len(versions) < 2 {
+		if reported {
+			message := "instances agree on version again"
+			if len(versions) == 1 {
+				message = "instances agree on version " + versions[0] + " again"
+			}
+			slog.Info("version skew ended", "service", status.Name, "versions", strings.Join(versions, ","))
+			hc.events.Add(Event{Time: at, Service: status.Name, Type: EventVersionSkewEnded, Message: message})
+		}
+		status.SkewSince = nil
+		return
+	}
+	if status.SkewSince == nil {
+		status.SkewSince = &at
+	}
+	if at.Sub(*status.SkewSince) < window {
+		return
+	}
+	message := fmt.Sprintf("instances disagree on version since %s: %s", status.SkewSince.UTC().Format(time.RFC3339), describeVersions(result.Versions))
+	if !reported {
+		slog.Warn("version skew", "service", status.Name, "versions", describeVersions(result.Versions))
+		hc.events.Add(Event{Time: at, Service: status.Name, Type: EventVersionSkewStarted,
+			Message: "instances disagree on version: " + describeVersions(result.Versions)})
+	}
+	result.Warnings = append(result.Warnings, message)
+}
@@ -0,0 +1,25 @@
+// wallboard.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// WallboardHandler serves the large-screen status view. Query parameters are
+// passed through to /status, plus per_page and rotate (seconds) for paging.
+func WallboardHandler(settings DashboardConfig) http.HandlerFunc {
+	data, _ := json.Marshal(map[string]interface{}{
+		"title":      settings.Title,
+		"logo_url":   settings.LogoURL,
+		"refresh_ms": time.Duration(settings.RefreshInterval).Milliseconds(),
+	})
+	page := strings.Replace(wallboardHTML, "/*SETTINGS*/null", string(data), 1)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(page))
+	}
+}
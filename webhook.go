@@ -0,0 +1,93 @@
+// webhook.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Headers set on signed webhook requests
+const (
+	webhookTimestampHeader = "X-HC-Timestamp"
+	webhookSignatureHeader = "X-HC-Signature"
+)
+
+// webhookNotifier POSTs notifications as JSON to a URL. With a secret, each request
+// is signed so the receiver can verify it came from the checker and reject replays.
+type webhookNotifier struct {
+	url    string
+	secret string
+}
+
+// webhookPayload is the JSON body of a webhook notification
+type webhookPayload struct {
+	Subject string    `json:"subject"`
+	Text    string    `json:"text"`
+	SentAt  time.Time `json:"sent_at"`
+	Alert   *Alert    `json:"alert,omitempty"` // set for down, recovery and warning alerts
+}
+
+// signWebhook returns the signature of a payload sent at timestamp: the hex
+// HMAC-SHA256 of "<timestamp>.<body>" keyed with the secret, prefixed "sha256="
+func signWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (wh *webhookNotifier) Notify(ctx context.Context, n Notification) error {
+	now := time.Now()
+	body, _ := json.Marshal(webhookPayload{Subject: n.Subject, Text: n.Text, SentAt: now.UTC(), Alert: n.Alert})
+	return wh.post(ctx, body, now)
+}
+
+// post sends a JSON body, signing it when a secret is configured
+func (wh *webhookNotifier) post(ctx context.Context, body []byte, now time.Time) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wh.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if wh.secret != "" {
+		timestamp := now.Unix()
+		req.Header.Set(webhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+		req.Header.Set(webhookSignatureHeader, signWebhook(wh.secret, timestamp, body))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Check sends a HEAD request; any response other than a server error means the
+// receiver is reachable
+func (wh *webhookNotifier) Check(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, wh.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("webhook returned %d", resp.StatusCode)
+	}
+	return nil
+}